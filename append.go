@@ -0,0 +1,297 @@
+package matroska
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Incompatibility describes a track of an appended file which does not
+// match the track of the first file at the same position.
+type Incompatibility struct {
+	// Input is the index of the appended file.
+	Input int
+	// TrackNumber is the number of the track in the appended file, or zero
+	// when the number of tracks differs.
+	TrackNumber uint
+	Reason      string
+}
+
+func (i Incompatibility) String() string {
+	if i.TrackNumber == 0 {
+		return fmt.Sprintf("input %d: %s", i.Input, i.Reason)
+	}
+	return fmt.Sprintf("input %d track %d: %s", i.Input, i.TrackNumber, i.Reason)
+}
+
+// IncompatibleError is returned by Append when the tracks of the files
+// cannot be appended to each other.
+type IncompatibleError struct {
+	Incompatibilities []Incompatibility
+}
+
+func (e *IncompatibleError) Error() string {
+	s := make([]string, len(e.Incompatibilities))
+	for i, in := range e.Incompatibilities {
+		s[i] = in.String()
+	}
+	return "matroska: incompatible tracks: " + strings.Join(s, "; ")
+}
+
+// CheckCompatibility returns the differences between the tracks of the
+// first file and the tracks of an appended file. Tracks are matched by
+// their position, and they must have the same TrackType, CodecID and
+// CodecPrivate.
+func CheckCompatibility(input int, first, other []TrackEntry) []Incompatibility {
+	var in []Incompatibility
+	if len(first) != len(other) {
+		in = append(in, Incompatibility{Input: input, Reason: fmt.Sprintf("has %d tracks instead of %d", len(other), len(first))})
+	}
+	for i := range min(len(first), len(other)) {
+		a, b := first[i], other[i]
+		report := func(format string, args ...any) {
+			in = append(in, Incompatibility{Input: input, TrackNumber: b.TrackNumber, Reason: fmt.Sprintf(format, args...)})
+		}
+		if a.TrackType != b.TrackType {
+			report("TrackType %d does not match %d of track %d", b.TrackType, a.TrackType, a.TrackNumber)
+		}
+		if a.CodecID != b.CodecID {
+			report("CodecID %s does not match %s of track %d", b.CodecID, a.CodecID, a.TrackNumber)
+		}
+		var ap, bp []byte
+		if a.CodecPrivate != nil {
+			ap = *a.CodecPrivate
+		}
+		if b.CodecPrivate != nil {
+			bp = *b.CodecPrivate
+		}
+		if !bytes.Equal(ap, bp) {
+			report("CodecPrivate does not match track %d", a.TrackNumber)
+		}
+	}
+	return in
+}
+
+// Append writes the Matroska files rs one after the other into w as a
+// single Segment. The timestamps of each file are offset by the durations
+// of the files before it.
+//
+// The tracks of every file must be compatible with the tracks of the first
+// file according to CheckCompatibility, otherwise an *IncompatibleError
+// is returned before anything is written. The tracks and the Info of the
+// first file are used. The chapters of the editions at the same position
+// are merged, and the tags and the attachments of every file are kept
+// without duplicates.
+func Append(w io.WriteSeeker, rs []io.ReadSeeker, opts WriterOptions) error {
+	if len(rs) == 0 {
+		return errors.New("matroska: no file to append")
+	}
+	ds := make([]*MatroskaDemuxer, len(rs))
+	for i, r := range rs {
+		d, err := NewMatroskaDemuxer(r)
+		if err != nil {
+			return fmt.Errorf("matroska: could not read input %d: %w", i, err)
+		}
+		ds[i] = d
+	}
+	tracks := ds[0].Tracks()
+	var in []Incompatibility
+	for i, d := range ds[1:] {
+		in = append(in, CheckCompatibility(i+1, tracks, d.Tracks())...)
+	}
+	if len(in) > 0 {
+		return &IncompatibleError{Incompatibilities: in}
+	}
+
+	info := *ds[0].Scanner().Info()
+	info.Duration, info.SegmentUUID, info.SegmentFilename, info.PrevFilename, info.NextFilename = nil, nil, nil, nil, nil
+	info.MuxingApp, info.WritingApp = "", ""
+	info.NextUUID = ds[len(ds)-1].Scanner().Info().NextUUID
+	mw, err := NewWriter(w, info, Tracks{TrackEntry: tracks}, opts)
+	if err != nil {
+		return err
+	}
+	var (
+		m      appendMerger
+		offset time.Duration
+	)
+	for i, d := range ds {
+		numbers := make(map[uint]uint)
+		uids := make(map[uint]uint)
+		for j, t := range d.Tracks() {
+			numbers[t.TrackNumber] = tracks[j].TrackNumber
+			uids[t.TrackUID] = tracks[j].TrackUID
+		}
+		var end time.Duration
+		for {
+			f, err := d.ReadFrame()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return fmt.Errorf("matroska: could not read frame of input %d: %w", i, err)
+			}
+			duration := f.Duration
+			if t := d.tracks[f.TrackNumber]; duration == 0 && t.DefaultDuration != nil {
+				duration = time.Duration(*t.DefaultDuration)
+			}
+			end = max(end, f.Timestamp+duration)
+			f.TrackNumber = numbers[f.TrackNumber]
+			f.Timestamp += offset
+			if err := mw.WriteFrame(f); err != nil {
+				return err
+			}
+		}
+		s := d.Scanner()
+		m.add(s, uids, offset)
+		if s.Info().Duration != nil {
+			end = time.Duration(*s.Info().Duration * float64(s.Info().TimestampScale))
+		}
+		offset += end
+	}
+	if len(m.chapters.EditionEntry) > 0 {
+		mw.SetChapters(&m.chapters)
+	}
+	if len(m.tags.Tag) > 0 {
+		mw.SetTags(&m.tags)
+	}
+	if len(m.attachments.AttachedFile) > 0 {
+		mw.SetAttachments(&m.attachments)
+	}
+	return mw.Close()
+}
+
+// appendMerger merges the chapters, the tags and the attachments of
+// appended files. UIDs already used by an earlier file are replaced.
+type appendMerger struct {
+	chapters    Chapters
+	tags        Tags
+	attachments Attachments
+
+	chapterUIDs map[uint]bool
+	fileUIDs    map[uint]bool
+}
+
+func (m *appendMerger) add(s *Scanner, trackUIDs map[uint]uint, offset time.Duration) {
+	if m.chapterUIDs == nil {
+		m.chapterUIDs, m.fileUIDs = make(map[uint]bool), make(map[uint]bool)
+	}
+	chapterUIDs := make(map[uint]uint)
+	editionUIDs := make(map[uint]uint)
+	fileUIDs := make(map[uint]uint)
+	if c := s.Chapters(); c != nil {
+		var shift func(atoms []ChapterAtom) []ChapterAtom
+		shift = func(atoms []ChapterAtom) []ChapterAtom {
+			shifted := make([]ChapterAtom, len(atoms))
+			for i, a := range atoms {
+				a.ChapterTimeStart += uint(offset)
+				if a.ChapterTimeEnd != nil {
+					end := *a.ChapterTimeEnd + uint(offset)
+					a.ChapterTimeEnd = &end
+				}
+				uid := a.ChapterUID
+				for m.chapterUIDs[a.ChapterUID] {
+					a.ChapterUID = newUID()
+				}
+				m.chapterUIDs[a.ChapterUID] = true
+				chapterUIDs[uid] = a.ChapterUID
+				if a.ChapterTrack != nil {
+					a.ChapterTrack = &ChapterTrack{ChapterTrackUID: mapUIDs(trackUIDs, a.ChapterTrack.ChapterTrackUID)}
+				}
+				a.ChapterAtom = shift(a.ChapterAtom)
+				shifted[i] = a
+			}
+			return shifted
+		}
+		for i, e := range c.EditionEntry {
+			atoms := shift(e.ChapterAtom)
+			if i < len(m.chapters.EditionEntry) {
+				merged := &m.chapters.EditionEntry[i]
+				merged.ChapterAtom = append(merged.ChapterAtom, atoms...)
+				if e.EditionUID != nil && merged.EditionUID != nil {
+					editionUIDs[*e.EditionUID] = *merged.EditionUID
+				}
+				continue
+			}
+			e.ChapterAtom = atoms
+			m.chapters.EditionEntry = append(m.chapters.EditionEntry, e)
+		}
+	}
+	if a := s.Attachments(); a != nil {
+		for _, f := range a.AttachedFile {
+			if uid, ok := m.attachmentUID(f); ok {
+				fileUIDs[f.FileUID] = uid
+				continue
+			}
+			uid := f.FileUID
+			for m.fileUIDs[f.FileUID] {
+				f.FileUID = newUID()
+			}
+			m.fileUIDs[f.FileUID] = true
+			fileUIDs[uid] = f.FileUID
+			m.attachments.AttachedFile = append(m.attachments.AttachedFile, f)
+		}
+	}
+	if t := s.Tags(); t != nil {
+		for _, tag := range t.Tag {
+			tag.Targets = MapTargets(tag.Targets, trackUIDs, editionUIDs, chapterUIDs, fileUIDs)
+			if !m.hasTag(tag) {
+				m.tags.Tag = append(m.tags.Tag, tag)
+			}
+		}
+	}
+}
+
+// attachmentUID returns the FileUID of an attachment which has the same
+// name and data as f.
+func (m *appendMerger) attachmentUID(f AttachedFile) (uint, bool) {
+	for _, g := range m.attachments.AttachedFile {
+		if g.FileName == f.FileName && bytes.Equal(g.FileData, f.FileData) {
+			return g.FileUID, true
+		}
+	}
+	return 0, false
+}
+
+func (m *appendMerger) hasTag(tag Tag) bool {
+	for _, t := range m.tags.Tag {
+		if reflect.DeepEqual(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// mapUID returns the UID uid is replaced by, or uid itself when it is not
+// replaced.
+func mapUID(uids map[uint]uint, uid uint) uint {
+	if v, ok := uids[uid]; ok {
+		return v
+	}
+	return uid
+}
+
+// MapTargets returns targets with the UIDs which are keys of the maps
+// replaced by their values, for example after the UIDs of merged files
+// are replaced to make them unique. A nil map keeps every UID of its kind.
+func MapTargets(targets Targets, tracks, editions, chapters, attachments map[uint]uint) Targets {
+	targets.TagTrackUID = mapUIDs(tracks, targets.TagTrackUID)
+	targets.TagEditionUID = mapUIDs(editions, targets.TagEditionUID)
+	targets.TagChapterUID = mapUIDs(chapters, targets.TagChapterUID)
+	targets.TagAttachmentUID = mapUIDs(attachments, targets.TagAttachmentUID)
+	return targets
+}
+
+func mapUIDs(uids map[uint]uint, s []uint) []uint {
+	if s == nil {
+		return nil
+	}
+	mapped := make([]uint, len(s))
+	for i, uid := range s {
+		mapped[i] = mapUID(uids, uid)
+	}
+	return mapped
+}
@@ -0,0 +1,87 @@
+package matroska
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestAppend(t *testing.T) {
+	track := NewTrackEntry(1, TrackTypeAudio, AudioCodecMP3)
+	var frames []Frame
+	for i := range 10 {
+		frames = append(frames, Frame{TrackNumber: 1, Timestamp: time.Duration(i) * 100 * time.Millisecond, Duration: 100 * time.Millisecond, Keyframe: true, Data: []byte{byte(i)}})
+	}
+	file := func(chapterUID uint, title string) []byte {
+		chapters := &Chapters{EditionEntry: []EditionEntry{{
+			ChapterAtom: []ChapterAtom{{ChapterUID: chapterUID, ChapterFlagEnabled: 1}},
+		}}}
+		tags := &Tags{Tag: []Tag{{
+			Targets:   Targets{TargetTypeValue: 50},
+			SimpleTag: []SimpleTag{{TagName: "TITLE", TagLanguage: "und", TagDefault: 1, TagString: &title}},
+		}}}
+		return matroskaFile(t, track, chapters, tags, frames...)
+	}
+
+	t.Run("Compatible", func(t *testing.T) {
+		var ws writeSeeker
+		rs := []io.ReadSeeker{bytes.NewReader(file(1, "a")), bytes.NewReader(file(1, "a")), bytes.NewReader(file(2, "b"))}
+		if err := Append(&ws, rs, WriterOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		d, err := NewMatroskaDemuxer(bytes.NewReader(ws.buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := *d.Scanner().Info().Duration; got != 3000 {
+			t.Errorf("Duration = %v, want 3000", got)
+		}
+		frames := readFrames(t, d)
+		if len(frames) != 30 {
+			t.Fatalf("got %d frames, want 30", len(frames))
+		}
+		for i, f := range frames {
+			if want := time.Duration(i) * 100 * time.Millisecond; f.Timestamp != want {
+				t.Errorf("frame %d Timestamp = %v, want %v", i, f.Timestamp, want)
+			}
+		}
+		atoms := d.Scanner().Chapters().EditionEntry[0].ChapterAtom
+		if len(atoms) != 3 {
+			t.Fatalf("got %d chapters, want 3", len(atoms))
+		}
+		if atoms[0].ChapterUID == atoms[1].ChapterUID {
+			t.Error("ChapterUIDs are not unique")
+		}
+		if got, want := atoms[2].ChapterTimeStart, uint(2*time.Second); got != want {
+			t.Errorf("ChapterTimeStart = %d, want %d", got, want)
+		}
+		if got := len(d.Scanner().Tags().Tag); got != 2 {
+			t.Errorf("got %d tags, want 2", got)
+		}
+	})
+	t.Run("Incompatible", func(t *testing.T) {
+		other := NewTrackEntry(1, TrackTypeAudio, AudioCodecAC3)
+		var b writeSeeker
+		w, err := NewWriter(&b, Info{}, Tracks{TrackEntry: []TrackEntry{other, NewTrackEntry(2, TrackTypeSubtitle, SubtitleCodecTEXTUTF8)}}, WriterOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var ws writeSeeker
+		err = Append(&ws, []io.ReadSeeker{bytes.NewReader(file(1, "a")), bytes.NewReader(b.buf)}, WriterOptions{})
+		var ie *IncompatibleError
+		if !errors.As(err, &ie) {
+			t.Fatalf("err = %v, want an IncompatibleError", err)
+		}
+		if len(ie.Incompatibilities) != 2 {
+			t.Errorf("got %v, want a track count and a CodecID difference", ie.Incompatibilities)
+		}
+		if len(ws.buf) != 0 {
+			t.Error("incompatible files were written")
+		}
+	})
+}
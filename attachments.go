@@ -0,0 +1,264 @@
+package matroska
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AttachmentFS is a read-only fs.FS view of an Attachments element.
+//
+// Every AttachedFile appears in the root directory under its FileName.
+// Files with a name which is not a valid single path element, or which
+// is used by a previous AttachedFile, are not accessible by name.
+//
+// The Sys method of the fs.FileInfo returns the *AttachedFile, so
+// FileMediaType, FileDescription and FileUID are reachable.
+type AttachmentFS struct {
+	files []*AttachedFile
+}
+
+var (
+	_ fs.ReadDirFS  = AttachmentFS{}
+	_ fs.ReadFileFS = AttachmentFS{}
+	_ fs.StatFS     = AttachmentFS{}
+)
+
+// NewAttachmentFS returns an AttachmentFS for a. A nil a results in an
+// empty file system.
+func NewAttachmentFS(a *Attachments) AttachmentFS {
+	var fsys AttachmentFS
+	if a == nil {
+		return fsys
+	}
+	seen := make(map[string]bool, len(a.AttachedFile))
+	for i := range a.AttachedFile {
+		f := &a.AttachedFile[i]
+		if !fs.ValidPath(f.FileName) || f.FileName == "." || strings.Contains(f.FileName, "/") || seen[f.FileName] {
+			continue
+		}
+		seen[f.FileName] = true
+		fsys.files = append(fsys.files, f)
+	}
+	return fsys
+}
+
+func (fsys AttachmentFS) find(op, name string) (*AttachedFile, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	i := slices.IndexFunc(fsys.files, func(f *AttachedFile) bool { return f.FileName == name })
+	if i == -1 {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return fsys.files[i], nil
+}
+
+// Open opens the named attachment or the root directory.
+func (fsys AttachmentFS) Open(name string) (fs.File, error) {
+	if name == "." {
+		return &attachmentDir{fsys: fsys}, nil
+	}
+	f, err := fsys.find("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &attachmentFile{Reader: bytes.NewReader(f.FileData), info: attachmentInfo{f: f}}, nil
+}
+
+// ReadDir reads the root directory and returns the attachments sorted
+// by name.
+func (fsys AttachmentFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if name != "." {
+		if _, err := fsys.find("readdir", name); err != nil {
+			return nil, err
+		}
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries := make([]fs.DirEntry, len(fsys.files))
+	for i, f := range fsys.files {
+		entries[i] = attachmentInfo{f: f}
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+// ReadFile returns a copy of the FileData of the named attachment.
+func (fsys AttachmentFS) ReadFile(name string) ([]byte, error) {
+	f, err := fsys.find("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(f.FileData), nil
+}
+
+// Stat returns a fs.FileInfo describing the named attachment.
+func (fsys AttachmentFS) Stat(name string) (fs.FileInfo, error) {
+	if name == "." {
+		return attachmentRootInfo{}, nil
+	}
+	f, err := fsys.find("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return attachmentInfo{f: f}, nil
+}
+
+// attachmentInfo implements fs.FileInfo and fs.DirEntry for an AttachedFile.
+type attachmentInfo struct {
+	f *AttachedFile
+}
+
+func (i attachmentInfo) Name() string               { return i.f.FileName }
+func (i attachmentInfo) Size() int64                { return int64(len(i.f.FileData)) }
+func (i attachmentInfo) Mode() fs.FileMode          { return 0444 }
+func (i attachmentInfo) ModTime() time.Time         { return time.Time{} }
+func (i attachmentInfo) IsDir() bool                { return false }
+func (i attachmentInfo) Sys() any                   { return i.f }
+func (i attachmentInfo) Type() fs.FileMode          { return 0 }
+func (i attachmentInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+type attachmentRootInfo struct{}
+
+func (attachmentRootInfo) Name() string       { return "." }
+func (attachmentRootInfo) Size() int64        { return 0 }
+func (attachmentRootInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (attachmentRootInfo) ModTime() time.Time { return time.Time{} }
+func (attachmentRootInfo) IsDir() bool        { return true }
+func (attachmentRootInfo) Sys() any           { return nil }
+
+type attachmentFile struct {
+	*bytes.Reader
+	info attachmentInfo
+}
+
+func (f *attachmentFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *attachmentFile) Close() error               { return nil }
+
+type attachmentDir struct {
+	fsys    AttachmentFS
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *attachmentDir) Stat() (fs.FileInfo, error) { return attachmentRootInfo{}, nil }
+func (d *attachmentDir) Close() error               { return nil }
+
+func (d *attachmentDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: ".", Err: errors.New("is a directory")}
+}
+
+func (d *attachmentDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		d.entries, _ = d.fsys.ReadDir(".")
+		d.read = true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// FindAttachment returns the first AttachedFile whose FileUID or FileName
+// matches key. A numeric key is compared to FileUID first.
+func FindAttachment(a *Attachments, key string) (*AttachedFile, bool) {
+	if a == nil {
+		return nil, false
+	}
+	if uid, err := strconv.ParseUint(key, 10, 64); err == nil {
+		for i := range a.AttachedFile {
+			if uint64(a.AttachedFile[i].FileUID) == uid {
+				return &a.AttachedFile[i], true
+			}
+		}
+	}
+	for i := range a.AttachedFile {
+		if a.AttachedFile[i].FileName == key {
+			return &a.AttachedFile[i], true
+		}
+	}
+	return nil, false
+}
+
+// AddAttachment appends an AttachedFile with the given name and data to a.
+// The FileUID is unique within a, and the FileMediaType is guessed by
+// DetectMediaType.
+func AddAttachment(a *Attachments, name string, data []byte) *AttachedFile {
+	a.AttachedFile = append(a.AttachedFile, AttachedFile{
+		FileName:      name,
+		FileMediaType: DetectMediaType(name, data),
+		FileData:      data,
+		FileUID:       newFileUID(a),
+	})
+	return &a.AttachedFile[len(a.AttachedFile)-1]
+}
+
+// ReplaceAttachment replaces the name, the data and the media type of the
+// AttachedFile found by FindAttachment. The FileUID is kept, so the tags
+// targeting the attachment still apply.
+func ReplaceAttachment(a *Attachments, key string, name string, data []byte) (*AttachedFile, bool) {
+	f, ok := FindAttachment(a, key)
+	if !ok {
+		return nil, false
+	}
+	f.FileName, f.FileData, f.FileMediaType = name, data, DetectMediaType(name, data)
+	return f, true
+}
+
+// RemoveAttachment removes the AttachedFile found by FindAttachment. It
+// reports whether an attachment was removed.
+func RemoveAttachment(a *Attachments, key string) bool {
+	f, ok := FindAttachment(a, key)
+	if !ok {
+		return false
+	}
+	for i := range a.AttachedFile {
+		if &a.AttachedFile[i] == f {
+			a.AttachedFile = slices.Delete(a.AttachedFile, i, i+1)
+			break
+		}
+	}
+	return true
+}
+
+// newFileUID returns a FileUID which is not used by the attachments of a.
+func newFileUID(a *Attachments) uint {
+	for {
+		uid := newUID()
+		if !slices.ContainsFunc(a.AttachedFile, func(f AttachedFile) bool { return f.FileUID == uid }) {
+			return uid
+		}
+	}
+}
+
+// DetectMediaType guesses the media type of an attachment from its
+// content. The extension of name is used when the content is not
+// recognized. Parameters such as the charset are not included.
+func DetectMediaType(name string, data []byte) string {
+	t := http.DetectContentType(data)
+	if t == "application/octet-stream" || strings.HasPrefix(t, "text/plain") {
+		if byExt := mime.TypeByExtension(strings.ToLower(path.Ext(name))); byExt != "" {
+			t = byExt
+		}
+	}
+	t, _, _ = strings.Cut(t, ";")
+	return strings.TrimSpace(t)
+}
@@ -0,0 +1,89 @@
+package matroska
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAttachmentFS(t *testing.T) {
+	description := "Cover art"
+	a := &Attachments{AttachedFile: []AttachedFile{
+		{FileName: "font.ttf", FileMediaType: "font/ttf", FileData: []byte("font data"), FileUID: 1},
+		{FileName: "cover.jpg", FileMediaType: "image/jpeg", FileDescription: &description, FileData: []byte("jpeg data"), FileUID: 2},
+		{FileName: "font.ttf", FileMediaType: "font/ttf", FileData: []byte("duplicate"), FileUID: 3},
+		{FileName: "../escape", FileMediaType: "text/plain", FileData: []byte("invalid"), FileUID: 4},
+	}}
+	fsys := NewAttachmentFS(a)
+	if err := fstest.TestFS(fsys, "font.ttf", "cover.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := fs.ReadFile(fsys, "font.ttf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("font data"); !bytes.Equal(b, want) {
+		t.Errorf("ReadFile() got %q, want %q", b, want)
+	}
+	info, err := fs.Stat(fsys, "cover.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := info.Sys().(*AttachedFile)
+	if !ok {
+		t.Fatalf("Sys() got %T, want *AttachedFile", info.Sys())
+	}
+	if f.FileMediaType != "image/jpeg" || f.FileDescription == nil || *f.FileDescription != description {
+		t.Errorf("Sys() got %+v", f)
+	}
+	if _, err := fs.Stat(fsys, "../escape"); err == nil {
+		t.Error("Stat() expected error for invalid name")
+	}
+}
+
+func TestFindAttachment(t *testing.T) {
+	a := &Attachments{AttachedFile: []AttachedFile{
+		{FileName: "42", FileUID: 1},
+		{FileName: "font.ttf", FileUID: 42},
+	}}
+	tests := []struct {
+		key     string
+		wantUID uint
+		wantOK  bool
+	}{
+		{key: "42", wantUID: 42, wantOK: true},
+		{key: "font.ttf", wantUID: 42, wantOK: true},
+		{key: "1", wantUID: 1, wantOK: true},
+		{key: "missing", wantOK: false},
+	}
+	for _, tt := range tests {
+		f, ok := FindAttachment(a, tt.key)
+		if ok != tt.wantOK {
+			t.Errorf("FindAttachment(%q) ok = %v, want %v", tt.key, ok, tt.wantOK)
+			continue
+		}
+		if ok && f.FileUID != tt.wantUID {
+			t.Errorf("FindAttachment(%q) FileUID = %d, want %d", tt.key, f.FileUID, tt.wantUID)
+		}
+	}
+}
+
+func TestDetectMediaType(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{name: "font.ttf", data: []byte("\x00\x01\x00\x00\x00\x0f"), want: "font/ttf"},
+		{name: "font", data: []byte("OTTO\x00\x0f"), want: "font/otf"},
+		{name: "cover.png", data: []byte("\x89PNG\r\n\x1a\n"), want: "image/png"},
+		{name: "notes.txt", data: []byte("notes"), want: "text/plain"},
+		{name: "data.bin", data: []byte{0xff, 0x00}, want: "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		if got := DetectMediaType(tt.name, tt.data); got != tt.want {
+			t.Errorf("DetectMediaType(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,765 @@
+package matroska
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChapterOptions controls which chapters are written by the simple
+// chapter formats and which ChapterDisplay is used for their names.
+type ChapterOptions struct {
+	// Language selects the ChapterDisplay by ChapLanguage or ChapLanguageBCP47.
+	// The first ChapterDisplay is used when no display matches.
+	Language string
+	// Hidden includes the chapters which have ChapterFlagHidden set.
+	Hidden bool
+	// Disabled includes the chapters which have ChapterFlagEnabled unset.
+	Disabled bool
+}
+
+// chapterXML types mirror the XML format used by mkvextract and mkvmerge.
+// See: https://mkvtoolnix.download/doc/mkvmerge.html#mkvmerge.chapters
+type chapterXML struct {
+	XMLName      xml.Name          `xml:"Chapters"`
+	EditionEntry []editionEntryXML `xml:"EditionEntry"`
+}
+
+type editionEntryXML struct {
+	EditionUID         *uint               `xml:"EditionUID,omitempty"`
+	EditionFlagHidden  uint                `xml:"EditionFlagHidden"`
+	EditionFlagDefault uint                `xml:"EditionFlagDefault"`
+	EditionFlagOrdered uint                `xml:"EditionFlagOrdered"`
+	EditionDisplay     []editionDisplayXML `xml:"EditionDisplay"`
+	ChapterAtom        []chapterAtomXML    `xml:"ChapterAtom"`
+}
+
+type editionDisplayXML struct {
+	EditionString       string   `xml:"EditionString"`
+	EditionLanguageIETF []string `xml:"EditionLanguageIETF"`
+}
+
+type chapterAtomXML struct {
+	ChapterUID               uint                `xml:"ChapterUID"`
+	ChapterStringUID         *string             `xml:"ChapterStringUID,omitempty"`
+	ChapterTimeStart         string              `xml:"ChapterTimeStart"`
+	ChapterTimeEnd           string              `xml:"ChapterTimeEnd,omitempty"`
+	ChapterFlagHidden        uint                `xml:"ChapterFlagHidden"`
+	ChapterFlagEnabled       *uint               `xml:"ChapterFlagEnabled"`
+	ChapterSegmentUID        *binaryXML          `xml:"ChapterSegmentUID,omitempty"`
+	ChapterSkipType          *uint               `xml:"ChapterSkipType,omitempty"`
+	ChapterSegmentEditionUID *uint               `xml:"ChapterSegmentEditionUID,omitempty"`
+	ChapterPhysicalEquiv     *uint               `xml:"ChapterPhysicalEquiv,omitempty"`
+	ChapterTrack             *chapterTrackXML    `xml:"ChapterTrack,omitempty"`
+	ChapterDisplay           []chapterDisplayXML `xml:"ChapterDisplay"`
+	ChapterProcess           []chapterProcessXML `xml:"ChapterProcess"`
+	ChapterAtom              []chapterAtomXML    `xml:"ChapterAtom"`
+}
+
+type chapterTrackXML struct {
+	ChapterTrackNumber []uint `xml:"ChapterTrackNumber"`
+}
+
+type chapterDisplayXML struct {
+	ChapterString    string   `xml:"ChapterString"`
+	ChapterLanguage  []string `xml:"ChapterLanguage"`
+	ChapLanguageIETF []string `xml:"ChapLanguageIETF"`
+	ChapterCountry   []string `xml:"ChapterCountry"`
+}
+
+type chapterProcessXML struct {
+	ChapterProcessCodecID uint                       `xml:"ChapterProcessCodecID"`
+	ChapterProcessPrivate *binaryXML                 `xml:"ChapterProcessPrivate,omitempty"`
+	ChapterProcessCommand []chapterProcessCommandXML `xml:"ChapterProcessCommand"`
+}
+
+type chapterProcessCommandXML struct {
+	ChapterProcessTime uint      `xml:"ChapterProcessTime"`
+	ChapterProcessData binaryXML `xml:"ChapterProcessData"`
+}
+
+// binaryXML is a binary value stored as hexadecimal characters.
+type binaryXML struct {
+	Format string `xml:"format,attr"`
+	Data   string `xml:",chardata"`
+}
+
+func newBinaryXML(b []byte) *binaryXML {
+	return &binaryXML{Format: "hex", Data: hex.EncodeToString(b)}
+}
+
+// bytes decodes the data of b, which is written in base64 unless the
+// format says otherwise.
+func (b *binaryXML) bytes() ([]byte, error) {
+	data := strings.Join(strings.Fields(b.Data), "")
+	switch b.Format {
+	case "hex":
+		return hex.DecodeString(data)
+	case "", "base64":
+		return base64.StdEncoding.DecodeString(data)
+	}
+	return nil, fmt.Errorf("unknown binary format %q", b.Format)
+}
+
+func newChapterXML(c *Chapters) chapterXML {
+	var cx chapterXML
+	for _, e := range c.EditionEntry {
+		ex := editionEntryXML{
+			EditionUID:         e.EditionUID,
+			EditionFlagHidden:  e.EditionFlagHidden,
+			EditionFlagDefault: e.EditionFlagDefault,
+			EditionFlagOrdered: e.EditionFlagOrdered,
+		}
+		for _, d := range e.EditionDisplay {
+			ex.EditionDisplay = append(ex.EditionDisplay, editionDisplayXML(d))
+		}
+		for _, a := range e.ChapterAtom {
+			ex.ChapterAtom = append(ex.ChapterAtom, newChapterAtomXML(a))
+		}
+		cx.EditionEntry = append(cx.EditionEntry, ex)
+	}
+	return cx
+}
+
+func newChapterAtomXML(a ChapterAtom) chapterAtomXML {
+	ax := chapterAtomXML{
+		ChapterUID:               a.ChapterUID,
+		ChapterStringUID:         a.ChapterStringUID,
+		ChapterTimeStart:         chapterTime(time.Duration(a.ChapterTimeStart)),
+		ChapterFlagHidden:        a.ChapterFlagHidden,
+		ChapterFlagEnabled:       &a.ChapterFlagEnabled,
+		ChapterSkipType:          a.ChapterSkipType,
+		ChapterSegmentEditionUID: a.ChapterSegmentEditionUID,
+		ChapterPhysicalEquiv:     a.ChapterPhysicalEquiv,
+	}
+	if a.ChapterTimeEnd != nil {
+		ax.ChapterTimeEnd = chapterTime(time.Duration(*a.ChapterTimeEnd))
+	}
+	if a.ChapterSegmentUUID != nil {
+		ax.ChapterSegmentUID = newBinaryXML(*a.ChapterSegmentUUID)
+	}
+	if a.ChapterTrack != nil {
+		ax.ChapterTrack = &chapterTrackXML{ChapterTrackNumber: a.ChapterTrack.ChapterTrackUID}
+	}
+	for _, d := range a.ChapterDisplay {
+		ax.ChapterDisplay = append(ax.ChapterDisplay, chapterDisplayXML{
+			ChapterString:    d.ChapString,
+			ChapterLanguage:  d.ChapLanguage,
+			ChapLanguageIETF: d.ChapLanguageBCP47,
+			ChapterCountry:   d.ChapCountry,
+		})
+	}
+	for _, p := range a.ChapProcess {
+		px := chapterProcessXML{ChapterProcessCodecID: p.ChapProcessCodecID}
+		if p.ChapProcessPrivate != nil {
+			px.ChapterProcessPrivate = newBinaryXML(*p.ChapProcessPrivate)
+		}
+		for _, c := range p.ChapProcessCommand {
+			px.ChapterProcessCommand = append(px.ChapterProcessCommand, chapterProcessCommandXML{
+				ChapterProcessTime: c.ChapProcessTime,
+				ChapterProcessData: *newBinaryXML(c.ChapProcessData),
+			})
+		}
+		ax.ChapterProcess = append(ax.ChapterProcess, px)
+	}
+	for _, child := range a.ChapterAtom {
+		ax.ChapterAtom = append(ax.ChapterAtom, newChapterAtomXML(child))
+	}
+	return ax
+}
+
+// WriteChaptersXML writes c in the XML format used by mkvextract.
+func WriteChaptersXML(w io.Writer, c *Chapters) error {
+	if _, err := io.WriteString(w, xml.Header+"<!-- <!DOCTYPE Chapters SYSTEM \"matroskachapters.dtd\"> -->\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(newChapterXML(c)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func (cx chapterXML) chapters() (*Chapters, error) {
+	uids := make(chapterUIDs)
+	c := &Chapters{}
+	for _, ex := range cx.EditionEntry {
+		e := EditionEntry{
+			EditionUID:         ex.EditionUID,
+			EditionFlagHidden:  ex.EditionFlagHidden,
+			EditionFlagDefault: ex.EditionFlagDefault,
+			EditionFlagOrdered: ex.EditionFlagOrdered,
+		}
+		for _, d := range ex.EditionDisplay {
+			e.EditionDisplay = append(e.EditionDisplay, EditionDisplay(d))
+		}
+		for _, ax := range ex.ChapterAtom {
+			a, err := ax.chapterAtom(uids)
+			if err != nil {
+				return nil, err
+			}
+			e.ChapterAtom = append(e.ChapterAtom, a)
+		}
+		c.EditionEntry = append(c.EditionEntry, e)
+	}
+	return c, nil
+}
+
+// chapterAtom converts ax. A missing ChapterUID is generated, and a
+// missing ChapterFlagEnabled defaults to enabled.
+func (ax chapterAtomXML) chapterAtom(uids chapterUIDs) (ChapterAtom, error) {
+	a := ChapterAtom{
+		ChapterUID:               ax.ChapterUID,
+		ChapterStringUID:         ax.ChapterStringUID,
+		ChapterFlagHidden:        ax.ChapterFlagHidden,
+		ChapterFlagEnabled:       1,
+		ChapterSkipType:          ax.ChapterSkipType,
+		ChapterSegmentEditionUID: ax.ChapterSegmentEditionUID,
+		ChapterPhysicalEquiv:     ax.ChapterPhysicalEquiv,
+	}
+	if a.ChapterUID == 0 {
+		a.ChapterUID = uids.next()
+	}
+	uids[a.ChapterUID] = true
+	if ax.ChapterFlagEnabled != nil {
+		a.ChapterFlagEnabled = *ax.ChapterFlagEnabled
+	}
+	start, err := parseChapterTime(ax.ChapterTimeStart)
+	if err != nil {
+		return ChapterAtom{}, err
+	}
+	a.ChapterTimeStart = uint(start)
+	if ax.ChapterTimeEnd != "" {
+		end, err := parseChapterTime(ax.ChapterTimeEnd)
+		if err != nil {
+			return ChapterAtom{}, err
+		}
+		e := uint(end)
+		a.ChapterTimeEnd = &e
+	}
+	if ax.ChapterSegmentUID != nil {
+		b, err := ax.ChapterSegmentUID.bytes()
+		if err != nil {
+			return ChapterAtom{}, fmt.Errorf("matroska: invalid ChapterSegmentUID of chapter %d: %w", a.ChapterUID, err)
+		}
+		a.ChapterSegmentUUID = &b
+	}
+	if ax.ChapterTrack != nil {
+		a.ChapterTrack = &ChapterTrack{ChapterTrackUID: ax.ChapterTrack.ChapterTrackNumber}
+	}
+	for _, d := range ax.ChapterDisplay {
+		cd := ChapterDisplay{
+			ChapString:        d.ChapterString,
+			ChapLanguage:      d.ChapterLanguage,
+			ChapLanguageBCP47: d.ChapLanguageIETF,
+			ChapCountry:       d.ChapterCountry,
+		}
+		if len(cd.ChapLanguage) == 0 {
+			cd.ChapLanguage = []string{"eng"}
+		}
+		a.ChapterDisplay = append(a.ChapterDisplay, cd)
+	}
+	for _, px := range ax.ChapterProcess {
+		p := ChapProcess{ChapProcessCodecID: px.ChapterProcessCodecID}
+		if px.ChapterProcessPrivate != nil {
+			b, err := px.ChapterProcessPrivate.bytes()
+			if err != nil {
+				return ChapterAtom{}, fmt.Errorf("matroska: invalid ChapterProcessPrivate of chapter %d: %w", a.ChapterUID, err)
+			}
+			p.ChapProcessPrivate = &b
+		}
+		for _, cx := range px.ChapterProcessCommand {
+			b, err := cx.ChapterProcessData.bytes()
+			if err != nil {
+				return ChapterAtom{}, fmt.Errorf("matroska: invalid ChapterProcessData of chapter %d: %w", a.ChapterUID, err)
+			}
+			p.ChapProcessCommand = append(p.ChapProcessCommand, ChapProcessCommand{ChapProcessTime: cx.ChapterProcessTime, ChapProcessData: b})
+		}
+		a.ChapProcess = append(a.ChapProcess, p)
+	}
+	for _, child := range ax.ChapterAtom {
+		c, err := child.chapterAtom(uids)
+		if err != nil {
+			return ChapterAtom{}, err
+		}
+		a.ChapterAtom = append(a.ChapterAtom, c)
+	}
+	return a, nil
+}
+
+// ReadChaptersXML reads chapters in the XML format used by mkvmerge.
+// Missing ChapterUIDs are generated.
+func ReadChaptersXML(r io.Reader) (*Chapters, error) {
+	var cx chapterXML
+	if err := xml.NewDecoder(r).Decode(&cx); err != nil {
+		return nil, fmt.Errorf("matroska: could not decode chapters: %w", err)
+	}
+	return cx.chapters()
+}
+
+// WriteChaptersOGM writes the chapters of the default edition in the
+// simple OGM format:
+//
+//	CHAPTER01=00:00:00.000
+//	CHAPTER01NAME=Intro
+//
+// Nested chapters are written in depth-first order.
+func WriteChaptersOGM(w io.Writer, c *Chapters, opts ChapterOptions) error {
+	bw := bufio.NewWriter(w)
+	for i, a := range flattenChapters(c, opts) {
+		fmt.Fprintf(bw, "CHAPTER%02d=%s\n", i+1, ogmTime(time.Duration(a.ChapterTimeStart)))
+		fmt.Fprintf(bw, "CHAPTER%02dNAME=%s\n", i+1, chapterName(a.ChapterAtom, opts.Language))
+	}
+	return bw.Flush()
+}
+
+// WriteChaptersFFMetadata writes the chapters of the default edition in
+// the FFMETADATA1 format used by FFmpeg. A missing ChapterTimeEnd is
+// replaced by the start of the next chapter on the same level, which is
+// limited to the end of the parent chapter. END is left out when neither
+// of them exists.
+//
+// See: https://ffmpeg.org/ffmpeg-formats.html#Metadata-2
+func WriteChaptersFFMetadata(w io.Writer, c *Chapters, opts ChapterOptions) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(";FFMETADATA1\n")
+	for _, a := range flattenChapters(c, opts) {
+		bw.WriteString("[CHAPTER]\nTIMEBASE=1/1000000000\n")
+		fmt.Fprintf(bw, "START=%d\n", a.ChapterTimeStart)
+		if a.end != nil {
+			fmt.Fprintf(bw, "END=%d\n", *a.end)
+		}
+		fmt.Fprintf(bw, "title=%s\n", ffmetadataEscaper.Replace(chapterName(a.ChapterAtom, opts.Language)))
+	}
+	return bw.Flush()
+}
+
+var ffmetadataEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, `;`, `\;`, `#`, `\#`, "\n", "\\\n")
+
+// ReadChaptersOGM reads chapters in the simple OGM format written by
+// WriteChaptersOGM. The names are stored in the given language, or in
+// English when it is empty.
+func ReadChaptersOGM(r io.Reader, language string) (*Chapters, error) {
+	var entries []ChapterEntry
+	index := make(map[string]int)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\ufeff"))
+		if line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || !strings.HasPrefix(strings.ToUpper(key), "CHAPTER") {
+			return nil, fmt.Errorf("matroska: invalid OGM chapter line %d", n)
+		}
+		key = strings.ToUpper(key[len("CHAPTER"):])
+		number, isName := strings.CutSuffix(key, "NAME")
+		i, ok := index[number]
+		if !ok {
+			i = len(entries)
+			index[number] = i
+			entries = append(entries, ChapterEntry{})
+		}
+		if isName {
+			entries[i].Names = []ChapterName{{Name: value, Language: language}}
+			continue
+		}
+		start, err := parseChapterTime(value)
+		if err != nil {
+			return nil, fmt.Errorf("matroska: invalid OGM chapter line %d: %w", n, err)
+		}
+		entries[i].Start = start
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("matroska: could not read chapters: %w", err)
+	}
+	return NewChapters(entries), nil
+}
+
+// ReadChaptersFFMetadata reads the chapters of a file in the FFMETADATA1
+// format used by FFmpeg. The other metadata is ignored. The names are
+// stored in the given language, or in English when it is empty.
+func ReadChaptersFFMetadata(r io.Reader, language string) (*Chapters, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("matroska: could not read chapters: %w", err)
+	}
+	if !strings.HasPrefix(string(b), ";FFMETADATA1") {
+		return nil, errors.New("matroska: missing FFMETADATA1 header")
+	}
+	var (
+		entries    []ChapterEntry
+		num, den   int64
+		start, end int64
+	)
+	inChapter := false
+	scale := func(v int64) time.Duration {
+		return time.Duration(math.Round(float64(v) * float64(num) * float64(time.Second) / float64(den)))
+	}
+	flush := func() {
+		if inChapter {
+			entries[len(entries)-1].Start, entries[len(entries)-1].End = scale(start), scale(end)
+		}
+	}
+	for _, l := range ffmetadataLines(string(b)) {
+		switch {
+		case l.section != "":
+			flush()
+			inChapter = l.section == "CHAPTER"
+			if inChapter {
+				entries = append(entries, ChapterEntry{})
+				num, den, start, end = 1, int64(time.Second), 0, 0
+			}
+			continue
+		case !inChapter:
+			continue
+		}
+		var err error
+		switch l.key {
+		case "TIMEBASE":
+			n, d, ok := strings.Cut(l.value, "/")
+			if num, err = strconv.ParseInt(n, 10, 64); err == nil && ok {
+				den, err = strconv.ParseInt(d, 10, 64)
+			}
+			if err == nil && (!ok || num <= 0 || den <= 0) {
+				err = fmt.Errorf("invalid TIMEBASE %s", l.value)
+			}
+		case "START":
+			start, err = strconv.ParseInt(l.value, 10, 64)
+		case "END":
+			end, err = strconv.ParseInt(l.value, 10, 64)
+		case "title":
+			entries[len(entries)-1].Names = []ChapterName{{Name: l.value, Language: language}}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("matroska: invalid FFMETADATA1 chapter %d: %w", len(entries), err)
+		}
+	}
+	flush()
+	return NewChapters(entries), nil
+}
+
+type ffmetadataLine struct {
+	section    string
+	key, value string
+}
+
+// ffmetadataLines splits s into lines, and the lines into a key and a
+// value at the first unescaped "=". Comments and empty lines are left out,
+// and escaped characters, including escaped newlines, are unescaped.
+func ffmetadataLines(s string) []ffmetadataLine {
+	var (
+		lines      []ffmetadataLine
+		key, value strings.Builder
+	)
+	cur, eq, first := &key, false, true
+	flush := func() {
+		if eq {
+			lines = append(lines, ffmetadataLine{key: key.String(), value: value.String()})
+		}
+		key.Reset()
+		value.Reset()
+		cur, eq, first = &key, false, true
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if first && (c == ';' || c == '#' || c == '[') {
+			end := strings.IndexByte(s[i:], '\n')
+			if end == -1 {
+				end = len(s) - i
+			}
+			if c == '[' {
+				section, _, _ := strings.Cut(strings.TrimSpace(s[i+1:i+end]), "]")
+				lines = append(lines, ffmetadataLine{section: section})
+			}
+			i += end
+			continue
+		}
+		first = false
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			cur.WriteByte(s[i])
+		case c == '\r' && i+1 < len(s) && s[i+1] == '\n':
+		case c == '\n':
+			flush()
+		case c == '=' && !eq:
+			cur, eq = &value, true
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	flush()
+	return lines
+}
+
+// ReadChapters reads chapters in the XML, the OGM or the FFMETADATA1
+// format, which is detected from the beginning of r. The language is
+// used for the names of the simple formats.
+func ReadChapters(r io.Reader, language string) (*Chapters, error) {
+	br := bufio.NewReader(r)
+	b, _ := br.Peek(64)
+	head := strings.TrimSpace(strings.TrimPrefix(string(b), "\ufeff"))
+	switch {
+	case strings.HasPrefix(head, "<"):
+		return ReadChaptersXML(br)
+	case strings.HasPrefix(head, ";FFMETADATA1"):
+		return ReadChaptersFFMetadata(br, language)
+	case strings.HasPrefix(strings.ToUpper(head), "CHAPTER"):
+		return ReadChaptersOGM(br, language)
+	}
+	return nil, errors.New("matroska: unknown chapter format")
+}
+
+// ChapterName is the name of a chapter in a language. The language is an
+// ISO 639-2 or a BCP 47 language code, English when it is empty.
+type ChapterName struct {
+	Name     string
+	Language string
+}
+
+// ChapterEntry describes a chapter created by NewChapters.
+type ChapterEntry struct {
+	Start time.Duration
+	// End is the end of the chapter, or zero when it is not known.
+	End time.Duration
+	// Names has a ChapterDisplay for each language.
+	Names    []ChapterName
+	Children []ChapterEntry
+}
+
+// NewChapters returns a single default edition of enabled chapters. The
+// edition and the chapters get unique UIDs.
+func NewChapters(entries []ChapterEntry) *Chapters {
+	uids := make(chapterUIDs)
+	editionUID := uids.next()
+	uids[editionUID] = true
+	var atoms func(entries []ChapterEntry) []ChapterAtom
+	atoms = func(entries []ChapterEntry) []ChapterAtom {
+		var aa []ChapterAtom
+		for _, e := range entries {
+			a := ChapterAtom{
+				ChapterUID:         uids.next(),
+				ChapterTimeStart:   uint(e.Start),
+				ChapterFlagEnabled: 1,
+				ChapterAtom:        atoms(e.Children),
+			}
+			uids[a.ChapterUID] = true
+			if e.End > e.Start {
+				end := uint(e.End)
+				a.ChapterTimeEnd = &end
+			}
+			for _, n := range e.Names {
+				a.ChapterDisplay = append(a.ChapterDisplay, newChapterDisplay(n))
+			}
+			aa = append(aa, a)
+		}
+		return aa
+	}
+	return &Chapters{EditionEntry: []EditionEntry{{
+		EditionUID:         &editionUID,
+		EditionFlagDefault: 1,
+		ChapterAtom:        atoms(entries),
+	}}}
+}
+
+// IntervalChapters returns chapters starting every interval until the
+// duration. The name of a chapter is format formatted with its number
+// starting from 1 by fmt.Sprintf, for example "Chapter %02d".
+func IntervalChapters(duration, interval time.Duration, format, language string) []ChapterEntry {
+	if interval <= 0 {
+		return nil
+	}
+	var entries []ChapterEntry
+	for start := time.Duration(0); start < duration || start == 0; start += interval {
+		entries = append(entries, ChapterEntry{
+			Start: start,
+			End:   min(start+interval, duration),
+			Names: []ChapterName{{Name: fmt.Sprintf(format, len(entries)+1), Language: language}},
+		})
+	}
+	return entries
+}
+
+// newChapterDisplay stores an ISO 639-2 language code in ChapLanguage, and
+// any other language code in ChapLanguageBCP47.
+func newChapterDisplay(n ChapterName) ChapterDisplay {
+	d := ChapterDisplay{ChapString: n.Name, ChapLanguage: []string{"eng"}}
+	switch l := n.Language; {
+	case l == "":
+	case len(l) == 3 && strings.ToLower(l) == l:
+		d.ChapLanguage = []string{l}
+	default:
+		d.ChapLanguage = []string{"und"}
+		d.ChapLanguageBCP47 = []string{l}
+	}
+	return d
+}
+
+// chapterUIDs is a set of UIDs in use.
+type chapterUIDs map[uint]bool
+
+func (u chapterUIDs) next() uint {
+	for {
+		if uid := newUID(); !u[uid] {
+			return uid
+		}
+	}
+}
+
+// dropDefaultChapLanguage removes the default ChapLanguage "eng" which the
+// decoder prepopulates every ChapterDisplay with. The decoded languages
+// are appended after it, so the default is only kept when ChapLanguage is
+// not stored.
+func dropDefaultChapLanguage(c *Chapters) {
+	var walk func(atoms []ChapterAtom)
+	walk = func(atoms []ChapterAtom) {
+		for i := range atoms {
+			for j := range atoms[i].ChapterDisplay {
+				if d := &atoms[i].ChapterDisplay[j]; len(d.ChapLanguage) > 1 {
+					d.ChapLanguage = d.ChapLanguage[1:]
+				}
+			}
+			walk(atoms[i].ChapterAtom)
+		}
+	}
+	for i := range c.EditionEntry {
+		walk(c.EditionEntry[i].ChapterAtom)
+	}
+}
+
+// DefaultEdition returns the EditionEntry which has EditionFlagDefault
+// set, or the first EditionEntry when none of them has it.
+func DefaultEdition(c *Chapters) (*EditionEntry, bool) {
+	if c == nil || len(c.EditionEntry) == 0 {
+		return nil, false
+	}
+	for i := range c.EditionEntry {
+		if c.EditionEntry[i].EditionFlagDefault == 1 {
+			return &c.EditionEntry[i], true
+		}
+	}
+	return &c.EditionEntry[0], true
+}
+
+// flatChapter is a chapter returned by flattenChapters. end is the
+// ChapterTimeEnd of the chapter or, when it is missing, the start of the
+// next chapter on the same level limited to the end of the parent. It is
+// nil when the end is unknown.
+type flatChapter struct {
+	ChapterAtom
+	end *uint
+}
+
+// flattenChapters returns the chapters of the default edition in
+// depth-first order. Children of an excluded chapter are excluded too.
+func flattenChapters(c *Chapters, opts ChapterOptions) []flatChapter {
+	e, ok := DefaultEdition(c)
+	if !ok {
+		return nil
+	}
+	excluded := func(a ChapterAtom) bool {
+		return (a.ChapterFlagHidden == 1 && !opts.Hidden) || (a.ChapterFlagEnabled == 0 && !opts.Disabled)
+	}
+	var atoms []flatChapter
+	var walk func(aa []ChapterAtom, parentEnd *uint)
+	walk = func(aa []ChapterAtom, parentEnd *uint) {
+		aa = slices.DeleteFunc(slices.Clone(aa), excluded)
+		for i, a := range aa {
+			end := a.ChapterTimeEnd
+			if end == nil {
+				if i+1 < len(aa) {
+					end = &aa[i+1].ChapterTimeStart
+				}
+				if end == nil || (parentEnd != nil && *parentEnd < *end) {
+					end = parentEnd
+				}
+				if end != nil {
+					v := max(a.ChapterTimeStart, *end)
+					end = &v
+				}
+			}
+			atoms = append(atoms, flatChapter{ChapterAtom: a, end: end})
+			walk(a.ChapterAtom, end)
+		}
+	}
+	walk(e.ChapterAtom, nil)
+	return atoms
+}
+
+// chapterName returns the ChapString of the ChapterDisplay matching
+// language, or the first one.
+func chapterName(a ChapterAtom, language string) string {
+	if len(a.ChapterDisplay) == 0 {
+		return ""
+	}
+	if language != "" {
+		for _, d := range a.ChapterDisplay {
+			if slices.Contains(d.ChapLanguage, language) || slices.Contains(d.ChapLanguageBCP47, language) {
+				return d.ChapString
+			}
+		}
+	}
+	return a.ChapterDisplay[0].ChapString
+}
+
+// chapterTime formats d as HH:MM:SS.nnnnnnnnn.
+func chapterTime(d time.Duration) string {
+	h := d / time.Hour
+	m := (d % time.Hour) / time.Minute
+	s := (d % time.Minute) / time.Second
+	ns := d % time.Second
+	return fmt.Sprintf("%02d:%02d:%02d.%09d", h, m, s, ns)
+}
+
+// parseChapterTime parses a time written as [HH:]MM:SS[.fraction].
+func parseChapterTime(s string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid chapter time %q", s)
+	}
+	sec, frac, _ := strings.Cut(parts[len(parts)-1], ".")
+	var d time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second}[3-len(parts):] {
+		v := parts[i]
+		if i == len(parts)-1 {
+			v = sec
+		}
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid chapter time %q", s)
+		}
+		d += time.Duration(n) * unit
+	}
+	if frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		n, err := strconv.ParseUint(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid chapter time %q", s)
+		}
+		d += time.Duration(n)
+	}
+	return d, nil
+}
+
+// ogmTime formats d as HH:MM:SS.mmm.
+func ogmTime(d time.Duration) string {
+	h := d / time.Hour
+	m := (d % time.Hour) / time.Minute
+	s := (d % time.Minute) / time.Second
+	ms := (d % time.Second) / time.Millisecond
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, ms)
+}
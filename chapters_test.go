@@ -0,0 +1,249 @@
+package matroska
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testChapters() *Chapters {
+	ptr := func(v uint) *uint { return &v }
+	return &Chapters{EditionEntry: []EditionEntry{
+		{
+			EditionUID: ptr(1),
+			ChapterAtom: []ChapterAtom{
+				{ChapterUID: 99, ChapterTimeStart: 0, ChapterFlagEnabled: 1, ChapterDisplay: []ChapterDisplay{{ChapString: "Other edition", ChapLanguage: []string{"eng"}}}},
+			},
+		},
+		{
+			EditionUID:         ptr(2),
+			EditionFlagDefault: 1,
+			EditionDisplay:     []EditionDisplay{{EditionString: "Director's cut", EditionLanguageIETF: []string{"en"}}},
+			ChapterAtom: []ChapterAtom{
+				{
+					ChapterUID:         10,
+					ChapterTimeStart:   0,
+					ChapterTimeEnd:     ptr(uint(90 * time.Second)),
+					ChapterFlagEnabled: 1,
+					ChapterDisplay: []ChapterDisplay{
+						{ChapString: "Intro", ChapLanguage: []string{"eng"}, ChapLanguageBCP47: []string{"en"}},
+						{ChapString: "Einleitung", ChapLanguage: []string{"ger"}, ChapLanguageBCP47: []string{"de"}},
+					},
+					ChapterAtom: []ChapterAtom{
+						{ChapterUID: 11, ChapterTimeStart: uint(30 * time.Second), ChapterFlagEnabled: 1, ChapterDisplay: []ChapterDisplay{{ChapString: "Intro; part 2", ChapLanguage: []string{"eng"}}}},
+						{ChapterUID: 12, ChapterTimeStart: uint(60 * time.Second), ChapterFlagEnabled: 1, ChapterFlagHidden: 1, ChapterDisplay: []ChapterDisplay{{ChapString: "Hidden", ChapLanguage: []string{"eng"}}}},
+					},
+				},
+				{
+					ChapterUID:         20,
+					ChapterTimeStart:   uint(90*time.Second + 500*time.Millisecond),
+					ChapterFlagEnabled: 1,
+					ChapterTrack:       &ChapterTrack{ChapterTrackUID: []uint{123456}},
+					ChapterDisplay:     []ChapterDisplay{{ChapString: "Main = story", ChapLanguage: []string{"eng"}}},
+				},
+				{
+					ChapterUID:         30,
+					ChapterTimeStart:   uint(2 * time.Hour),
+					ChapterFlagEnabled: 0,
+					ChapterDisplay:     []ChapterDisplay{{ChapString: "Disabled", ChapLanguage: []string{"eng"}}},
+				},
+			},
+		},
+	}}
+}
+
+func TestWriteChapters(t *testing.T) {
+	tests := []struct {
+		name   string
+		golden string
+		write  func(w io.Writer, c *Chapters) error
+	}{
+		{
+			name:   "XML",
+			golden: "chapters.xml.golden",
+			write:  WriteChaptersXML,
+		},
+		{
+			name:   "OGM",
+			golden: "chapters.ogm.golden",
+			write: func(w io.Writer, c *Chapters) error {
+				return WriteChaptersOGM(w, c, ChapterOptions{})
+			},
+		},
+		{
+			name:   "OGM German with hidden",
+			golden: "chapters.ger.ogm.golden",
+			write: func(w io.Writer, c *Chapters) error {
+				return WriteChaptersOGM(w, c, ChapterOptions{Language: "de", Hidden: true})
+			},
+		},
+		{
+			name:   "FFMETADATA1",
+			golden: "chapters.ffmetadata.golden",
+			write: func(w io.Writer, c *Chapters) error {
+				return WriteChaptersFFMetadata(w, c, ChapterOptions{Disabled: true})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.write(&buf, testChapters()); err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", tt.golden)
+			if *flagUpdate {
+				if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.Bytes(); !bytes.Equal(got, want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestReadChapters(t *testing.T) {
+	open := func(t *testing.T, name string) *os.File {
+		t.Helper()
+		f, err := os.Open(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f
+	}
+	t.Run("XML", func(t *testing.T) {
+		c, err := ReadChapters(open(t, "chapters.xml.golden"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := testChapters(); !reflect.DeepEqual(c, want) {
+			t.Errorf("got %+v, want %+v", c, want)
+		}
+	})
+	simple := []struct {
+		name   string
+		golden string
+		starts []time.Duration
+		ends   []time.Duration
+		names  []string
+	}{
+		{
+			name:   "OGM",
+			golden: "chapters.ogm.golden",
+			starts: []time.Duration{0, 30 * time.Second, 90*time.Second + 500*time.Millisecond},
+			ends:   []time.Duration{0, 0, 0},
+			names:  []string{"Intro", "Intro; part 2", "Main = story"},
+		},
+		{
+			name:   "FFMETADATA1",
+			golden: "chapters.ffmetadata.golden",
+			starts: []time.Duration{0, 30 * time.Second, 90*time.Second + 500*time.Millisecond, 2 * time.Hour},
+			ends:   []time.Duration{90 * time.Second, 90 * time.Second, 2 * time.Hour, 0},
+			names:  []string{"Intro", "Intro; part 2", "Main = story", "Disabled"},
+		},
+	}
+	for _, tt := range simple {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ReadChapters(open(t, tt.golden), "hun")
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := c.EditionEntry[0]
+			if e.EditionFlagDefault != 1 || e.EditionUID == nil {
+				t.Errorf("EditionEntry = %+v", e)
+			}
+			if len(e.ChapterAtom) != len(tt.starts) {
+				t.Fatalf("got %d chapters, want %d", len(e.ChapterAtom), len(tt.starts))
+			}
+			for i, a := range e.ChapterAtom {
+				var end time.Duration
+				if a.ChapterTimeEnd != nil {
+					end = time.Duration(*a.ChapterTimeEnd)
+				}
+				if time.Duration(a.ChapterTimeStart) != tt.starts[i] || end != tt.ends[i] {
+					t.Errorf("chapter %d is %v-%v, want %v-%v", i, time.Duration(a.ChapterTimeStart), end, tt.starts[i], tt.ends[i])
+				}
+				if d := a.ChapterDisplay[0]; d.ChapString != tt.names[i] || d.ChapLanguage[0] != "hun" {
+					t.Errorf("chapter %d display = %+v", i, d)
+				}
+			}
+		})
+	}
+}
+
+func TestScanner_Chapters(t *testing.T) {
+	// A German only file, and a ChapterDisplay without any ChapLanguage.
+	chapters := &Chapters{EditionEntry: []EditionEntry{{ChapterAtom: []ChapterAtom{
+		{ChapterUID: 1, ChapterFlagEnabled: 1, ChapterDisplay: []ChapterDisplay{{ChapString: "Einleitung", ChapLanguage: []string{"ger"}}}},
+		{ChapterUID: 2, ChapterTimeStart: uint(time.Minute), ChapterFlagEnabled: 1, ChapterDisplay: []ChapterDisplay{{ChapString: "Untitled"}}},
+	}}}}
+	b := matroskaFile(t, NewTrackEntry(1, TrackTypeAudio, AudioCodecMP3), chapters, nil)
+	c := NewScanner(bytes.NewReader(b)).Chapters()
+	if c == nil || len(c.EditionEntry) != 1 || len(c.EditionEntry[0].ChapterAtom) != 2 {
+		t.Fatalf("Chapters() = %+v", c)
+	}
+	atoms := c.EditionEntry[0].ChapterAtom
+	if got := atoms[0].ChapterDisplay[0].ChapLanguage; !reflect.DeepEqual(got, []string{"ger"}) {
+		t.Errorf("ChapLanguage = %q, want the stored language", got)
+	}
+	if got := atoms[1].ChapterDisplay[0].ChapLanguage; !reflect.DeepEqual(got, []string{"eng"}) {
+		t.Errorf("ChapLanguage = %q, want the default language", got)
+	}
+	var buf bytes.Buffer
+	if err := WriteChaptersXML(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.Count(buf.Bytes(), []byte("<ChapterLanguage>")); got != 2 {
+		t.Errorf("got %d ChapterLanguage elements, want 2:\n%s", got, buf.Bytes())
+	}
+}
+
+func TestNewChapters(t *testing.T) {
+	c := NewChapters([]ChapterEntry{
+		{Start: 0, Names: []ChapterName{{Name: "One"}, {Name: "Egy", Language: "hu-HU"}}, Children: []ChapterEntry{
+			{Start: time.Minute, Names: []ChapterName{{Name: "One.One"}}},
+		}},
+		{Start: 2 * time.Minute, End: 3 * time.Minute},
+	})
+	e := c.EditionEntry[0]
+	if e.EditionFlagDefault != 1 || e.EditionUID == nil {
+		t.Errorf("EditionEntry = %+v", e)
+	}
+	a := e.ChapterAtom
+	if len(a) != 2 || len(a[0].ChapterAtom) != 1 {
+		t.Fatalf("got %+v", a)
+	}
+	uids := map[uint]bool{*e.EditionUID: true, a[0].ChapterUID: true, a[0].ChapterAtom[0].ChapterUID: true, a[1].ChapterUID: true}
+	if len(uids) != 4 || uids[0] {
+		t.Errorf("UIDs are not unique: %v", uids)
+	}
+	want := []ChapterDisplay{
+		{ChapString: "One", ChapLanguage: []string{"eng"}},
+		{ChapString: "Egy", ChapLanguage: []string{"und"}, ChapLanguageBCP47: []string{"hu-HU"}},
+	}
+	if !reflect.DeepEqual(a[0].ChapterDisplay, want) {
+		t.Errorf("ChapterDisplay = %+v, want %+v", a[0].ChapterDisplay, want)
+	}
+	if a[1].ChapterTimeEnd == nil || *a[1].ChapterTimeEnd != uint(3*time.Minute) {
+		t.Errorf("ChapterTimeEnd = %v", a[1].ChapterTimeEnd)
+	}
+
+	entries := IntervalChapters(25*time.Minute, 10*time.Minute, "Chapter %02d", "")
+	if len(entries) != 3 {
+		t.Fatalf("got %d chapters, want 3", len(entries))
+	}
+	if last := entries[2]; last.Start != 20*time.Minute || last.End != 25*time.Minute || last.Names[0].Name != "Chapter 03" {
+		t.Errorf("last chapter = %+v", last)
+	}
+}
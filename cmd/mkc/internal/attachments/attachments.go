@@ -0,0 +1,244 @@
+package attachments
+
+import (
+	"errors"
+	"fmt"
+	"github.com/charmbracelet/huh"
+	"github.com/coding-socks/matroska"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/cli"
+	flag "github.com/spf13/pflag"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+var Cmd = &cli.Command{
+	Flags: flag.NewFlagSet("attachments", flag.ContinueOnError),
+}
+
+func init() {
+	Cmd.Run = run
+}
+
+var flagOutput = Cmd.Flags.StringP("output", "o", "", "Path to the output folder.")
+var flagDescription = Cmd.Flags.String("description", "", "Description of the added or replacing attachments.")
+var flagMediaType = Cmd.Flags.String("media-type", "", "Media type of the added or replacing attachments. Defaults to a type guessed from the content.")
+
+const (
+	actionList    = "list"
+	actionExtract = "extract"
+	actionAdd     = "add"
+	actionRemove  = "remove"
+	actionReplace = "replace"
+)
+
+type arguments struct {
+	Action string
+	Input  string
+	Output string
+	Keys   []string
+}
+
+func run(flags *flag.FlagSet) {
+	args := arguments{
+		Action: flags.Arg(0),
+		Input:  flags.Arg(1),
+		Output: *flagOutput,
+	}
+	if flags.NArg() > 2 {
+		args.Keys = flags.Args()[2:]
+	}
+	if args.Action == "" {
+		err := huh.NewSelect[string]().
+			Title("Choose an action:").
+			Options(huh.NewOptions(actionList, actionExtract, actionAdd, actionRemove, actionReplace)...).
+			Value(&args.Action).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if args.Input == "" {
+		err := huh.NewInput().
+			Title("Source matroska file:").
+			Prompt("?").
+			Validate(cli.ValidatorFile).
+			Value(&args.Input).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	switch args.Action {
+	case actionAdd, actionRemove, actionReplace:
+		edit(args)
+		return
+	}
+
+	f, err := os.Open(args.Input)
+	if err != nil {
+		log.Fatalf("Could not open input file: %s", args.Input)
+	}
+	defer f.Close()
+
+	s := matroska.NewScanner(f)
+	if err := s.Init(); err != nil {
+		log.Fatal(err)
+	}
+	attachments := s.Attachments()
+	if err := s.Err(); err != nil {
+		log.Fatal(err)
+	}
+	if attachments == nil {
+		attachments = &matroska.Attachments{}
+	}
+
+	switch args.Action {
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown action: %s\n", args.Action)
+		os.Exit(1)
+	case actionList:
+		list(attachments)
+	case actionExtract:
+		extract(args, attachments)
+	}
+}
+
+func list(attachments *matroska.Attachments) {
+	for i, af := range attachments.AttachedFile {
+		fmt.Printf("Attachment ID %d: type '%s', size %d bytes, file name '%s', UID %d",
+			i+1, af.FileMediaType, len(af.FileData), af.FileName, af.FileUID)
+		if af.FileDescription != nil {
+			fmt.Printf(", description '%s'", *af.FileDescription)
+		}
+		fmt.Println()
+	}
+}
+
+func extract(args arguments, attachments *matroska.Attachments) {
+	if len(attachments.AttachedFile) == 0 {
+		fmt.Fprintln(os.Stderr, "The file does not have attachments")
+		return
+	}
+	if args.Output == "" {
+		err := huh.NewInput().
+			Title("Output folder:").
+			Prompt("?").
+			Validate(cli.ValidatorDir).
+			Value(&args.Output).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(args.Keys) == 0 {
+		options := make([]huh.Option[string], len(attachments.AttachedFile))
+		for i, af := range attachments.AttachedFile {
+			key := fmt.Sprint(af.FileUID)
+			options[i] = huh.NewOption(fmt.Sprintf("%s [%s]", af.FileName, af.FileMediaType), key)
+		}
+		err := huh.NewMultiSelect[string]().
+			Title("Attachments:").
+			Options(options...).
+			Value(&args.Keys).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, key := range args.Keys {
+		af, ok := matroska.FindAttachment(attachments, key)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Could not find attachment %s\n", key)
+			continue
+		}
+		name := filepath.Base(af.FileName)
+		if name == "." || name == string(filepath.Separator) {
+			name = fmt.Sprintf("attachment_%d", af.FileUID)
+		}
+		if err := os.WriteFile(filepath.Join(args.Output, name), af.FileData, 0644); err != nil {
+			log.Fatalf("Could not extract attachment: %s", err)
+		}
+		fmt.Printf("Extracted %s\n", name)
+	}
+}
+
+// edit adds, removes or replaces attachments in place. The arguments of
+// add are the files to attach, the arguments of remove are the FileUIDs or
+// the names of the attachments, and the arguments of replace are a
+// FileUID or a name followed by the replacing file.
+func edit(args arguments) {
+	switch {
+	case len(args.Keys) == 0:
+		log.Fatalf("Missing arguments for %s", args.Action)
+	case args.Action == actionReplace && len(args.Keys) != 2:
+		log.Fatal("Replace expects an attachment and a file")
+	}
+	f, err := os.OpenFile(args.Input, os.O_RDWR, 0)
+	if err != nil {
+		log.Fatalf("Could not open input file: %s", args.Input)
+	}
+	defer f.Close()
+	e, err := matroska.NewEditor(f)
+	if err != nil {
+		log.Fatalf("Could not read input file: %s", err)
+	}
+	attachments := e.Attachments()
+	if attachments == nil {
+		attachments = &matroska.Attachments{}
+	}
+	set := func(af *matroska.AttachedFile) {
+		if *flagDescription != "" {
+			af.FileDescription = flagDescription
+		}
+		if *flagMediaType != "" {
+			af.FileMediaType = *flagMediaType
+		}
+	}
+
+	switch args.Action {
+	case actionAdd:
+		for _, name := range args.Keys {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				log.Fatalf("Could not read attachment: %s", err)
+			}
+			af := matroska.AddAttachment(attachments, filepath.Base(name), data)
+			set(af)
+			fmt.Printf("Added %s [%s] with UID %d\n", af.FileName, af.FileMediaType, af.FileUID)
+		}
+	case actionRemove:
+		for _, key := range args.Keys {
+			if !matroska.RemoveAttachment(attachments, key) {
+				log.Fatalf("Could not find attachment %s", key)
+			}
+			fmt.Printf("Removed %s\n", key)
+		}
+	case actionReplace:
+		key, name := args.Keys[0], args.Keys[1]
+		data, err := os.ReadFile(name)
+		if err != nil {
+			log.Fatalf("Could not read attachment: %s", err)
+		}
+		af, ok := matroska.ReplaceAttachment(attachments, key, filepath.Base(name), data)
+		if !ok {
+			log.Fatalf("Could not find attachment %s", key)
+		}
+		set(af)
+		fmt.Printf("Replaced %s with %s [%s]\n", key, af.FileName, af.FileMediaType)
+	}
+	e.SetAttachments(attachments)
+	if err := e.Save(); err != nil {
+		log.Fatalf("Could not save changes: %s", err)
+	}
+}
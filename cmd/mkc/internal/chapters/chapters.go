@@ -0,0 +1,257 @@
+package chapters
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/charmbracelet/huh"
+	"github.com/coding-socks/matroska"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/cli"
+	flag "github.com/spf13/pflag"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+var Cmd = &cli.Command{
+	Flags: flag.NewFlagSet("chapters", flag.ContinueOnError),
+}
+
+func init() {
+	Cmd.Run = run
+}
+
+var flagOutput = Cmd.Flags.StringP("output", "o", "", "Path to the output file. Defaults to the standard output.")
+var flagFormat = Cmd.Flags.StringP("format", "f", "", "Output format: xml, ogm or ffmetadata.")
+var flagLanguage = Cmd.Flags.StringP("language", "l", "", "Preferred language of the exported chapter names, or the language of the imported and generated chapter names.")
+var flagHidden = Cmd.Flags.Bool("hidden", false, "Include hidden chapters in simple formats.")
+var flagDisabled = Cmd.Flags.Bool("disabled", false, "Include disabled chapters in simple formats.")
+var flagInterval = Cmd.Flags.String("interval", "", "Generate a chapter at every interval, for example 5m or 00:05:00.")
+var flagChapter = Cmd.Flags.StringArray("chapter", nil, "Generate a chapter as TIME=NAME, for example 00:01:30=Intro.")
+var flagName = Cmd.Flags.String("name", "Chapter %02d", "Name format of the chapters generated at intervals.")
+
+const (
+	actionExport   = "export"
+	actionImport   = "import"
+	actionGenerate = "generate"
+)
+
+const (
+	formatXML        = "xml"
+	formatOGM        = "ogm"
+	formatFFMetadata = "ffmetadata"
+)
+
+type arguments struct {
+	Action string
+	Input  string
+	Output string
+	Format string
+	// Chapters is the path of the imported chapters.
+	Chapters string
+}
+
+func run(flags *flag.FlagSet) {
+	args := arguments{
+		Action: flags.Arg(0),
+		Input:  flags.Arg(1),
+		Output: *flagOutput,
+		Format: *flagFormat,
+
+		Chapters: flags.Arg(2),
+	}
+	if args.Action == "" {
+		err := huh.NewSelect[string]().
+			Title("Choose an action:").
+			Options(huh.NewOptions(actionExport, actionImport, actionGenerate)...).
+			Value(&args.Action).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if args.Input == "" {
+		err := huh.NewInput().
+			Title("Source matroska file:").
+			Prompt("?").
+			Validate(cli.ValidatorFile).
+			Value(&args.Input).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	switch args.Action {
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown action: %s\n", args.Action)
+		os.Exit(1)
+	case actionExport:
+		export(args)
+	case actionImport:
+		importChapters(args)
+	case actionGenerate:
+		generate(args)
+	}
+}
+
+func export(args arguments) {
+	if args.Format == "" {
+		err := huh.NewSelect[string]().
+			Title("Output format:").
+			Options(huh.NewOptions(formatXML, formatOGM, formatFFMetadata)...).
+			Value(&args.Format).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(args.Input)
+	if err != nil {
+		log.Fatalf("Could not open input file: %s", args.Input)
+	}
+	defer f.Close()
+
+	s := matroska.NewScanner(f)
+	if err := s.Init(); err != nil {
+		log.Fatal(err)
+	}
+	chapters := s.Chapters()
+	if err := s.Err(); err != nil {
+		log.Fatal(err)
+	}
+	if chapters == nil {
+		fmt.Fprintln(os.Stderr, "The file does not have chapters")
+		return
+	}
+
+	var w io.Writer = os.Stdout
+	if args.Output != "" {
+		out, err := os.Create(args.Output)
+		if err != nil {
+			log.Fatalf("Could not create output file: %s", err)
+		}
+		defer out.Close()
+		w = out
+	}
+
+	opts := matroska.ChapterOptions{
+		Language: *flagLanguage,
+		Hidden:   *flagHidden,
+		Disabled: *flagDisabled,
+	}
+	switch args.Format {
+	default:
+		log.Fatalf("Unknown format: %s", args.Format)
+	case formatXML:
+		err = matroska.WriteChaptersXML(w, chapters)
+	case formatOGM:
+		err = matroska.WriteChaptersOGM(w, chapters, opts)
+	case formatFFMetadata:
+		err = matroska.WriteChaptersFFMetadata(w, chapters, opts)
+	}
+	if err != nil {
+		log.Fatalf("Could not export chapters: %s", err)
+	}
+}
+
+func importChapters(args arguments) {
+	if args.Chapters == "" {
+		err := huh.NewInput().
+			Title("Chapters file (XML, OGM or FFMETADATA1):").
+			Prompt("?").
+			Validate(cli.ValidatorFile).
+			Value(&args.Chapters).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+	cf, err := os.Open(args.Chapters)
+	if err != nil {
+		log.Fatalf("Could not open chapters file: %s", args.Chapters)
+	}
+	chapters, err := matroska.ReadChapters(cf, *flagLanguage)
+	cf.Close()
+	if err != nil {
+		log.Fatalf("Could not read chapters file: %s", err)
+	}
+	save(args, func(*matroska.Editor) *matroska.Chapters { return chapters })
+}
+
+func generate(args arguments) {
+	if *flagInterval == "" && len(*flagChapter) == 0 {
+		log.Fatal("Either --interval or --chapter is required")
+	}
+	save(args, func(e *matroska.Editor) *matroska.Chapters {
+		var entries []matroska.ChapterEntry
+		if *flagInterval != "" {
+			interval, err := cli.ParseTimestamp(*flagInterval)
+			if err != nil || interval == 0 {
+				log.Fatalf("Invalid interval: %s", *flagInterval)
+			}
+			info := e.Info()
+			if info.Duration == nil {
+				log.Fatal("The file does not have a duration")
+			}
+			duration := time.Duration(*info.Duration * float64(info.TimestampScale))
+			entries = matroska.IntervalChapters(duration, interval, *flagName, *flagLanguage)
+		}
+		for _, c := range *flagChapter {
+			ts, name, _ := strings.Cut(c, "=")
+			start, err := cli.ParseTimestamp(ts)
+			if err != nil {
+				log.Fatal(err)
+			}
+			entries = append(entries, matroska.ChapterEntry{
+				Start: start,
+				Names: []matroska.ChapterName{{Name: name, Language: *flagLanguage}},
+			})
+		}
+		slices.SortStableFunc(entries, func(a, b matroska.ChapterEntry) int { return cmp.Compare(a.Start, b.Start) })
+		return matroska.NewChapters(entries)
+	})
+}
+
+// save replaces the chapters of the input file in place.
+func save(args arguments, chapters func(e *matroska.Editor) *matroska.Chapters) {
+	f, err := os.OpenFile(args.Input, os.O_RDWR, 0)
+	if err != nil {
+		log.Fatalf("Could not open input file: %s", args.Input)
+	}
+	defer f.Close()
+	e, err := matroska.NewEditor(f)
+	if err != nil {
+		log.Fatalf("Could not read input file: %s", err)
+	}
+	c := chapters(e)
+	e.SetChapters(c)
+	if err := e.Save(); err != nil {
+		log.Fatalf("Could not save chapters: %s", err)
+	}
+	n := 0
+	for _, edition := range c.EditionEntry {
+		n += countChapters(edition.ChapterAtom)
+	}
+	fmt.Printf("Wrote %d chapters\n", n)
+}
+
+func countChapters(atoms []matroska.ChapterAtom) int {
+	n := len(atoms)
+	for _, a := range atoms {
+		n += countChapters(a.ChapterAtom)
+	}
+	return n
+}
@@ -2,8 +2,12 @@ package cli
 
 import (
 	"errors"
+	"fmt"
 	flag "github.com/spf13/pflag"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Command struct {
@@ -34,3 +38,41 @@ func ValidatorDir(val string) error {
 	}
 	return nil
 }
+
+// ParseTimestamp parses a timestamp written as [HH:]MM:SS[.nnn] or as a
+// Go duration such as 1h30m.
+func ParseTimestamp(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, ":") {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("Invalid timestamp: %s", s)
+		}
+		return d, nil
+	}
+	var frac time.Duration
+	if v, digits, ok := strings.Cut(s, "."); ok {
+		n, err := strconv.ParseUint(digits, 10, 32)
+		if err != nil || len(digits) > 9 {
+			return 0, fmt.Errorf("Invalid timestamp: %s", s)
+		}
+		frac = time.Duration(n)
+		for range 9 - len(digits) {
+			frac *= 10
+		}
+		s = v
+	}
+	fields := strings.Split(s, ":")
+	if len(fields) > 3 {
+		return 0, fmt.Errorf("Invalid timestamp: %s", s)
+	}
+	var d time.Duration
+	for _, v := range fields {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid timestamp: %s", s)
+		}
+		d = d*60 + time.Duration(n)
+	}
+	return d*time.Second + frac, nil
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/coding-socks/matroska"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewDemuxer returns a matroska.Demuxer for the format of the file.
+// frameDuration is only used by raw video streams.
+func NewDemuxer(r io.ReadSeeker, name string, frameDuration time.Duration) (matroska.Demuxer, error) {
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".mkv", ".mka", ".mks", ".mk3d":
+		return matroska.NewMatroskaDemuxer(r)
+	case ".avi":
+		return matroska.NewAVIDemuxer(r)
+	case ".ogg", ".oga", ".ogv", ".opus":
+		return matroska.NewOggDemuxer(r)
+	case ".h264", ".264", ".avc":
+		return matroska.NewH264Demuxer(r, frameDuration)
+	case ".h265", ".265", ".hevc":
+		return matroska.NewHEVCDemuxer(r, frameDuration)
+	case ".ivf":
+		return matroska.NewIVFDemuxer(r)
+	case ".aac", ".adts":
+		return matroska.NewADTSDemuxer(r)
+	case ".mp3", ".mp2", ".mp1", ".mpa":
+		return matroska.NewMPEGAudioDemuxer(r)
+	case ".ac3":
+		return matroska.NewAC3Demuxer(r)
+	case ".flac":
+		return matroska.NewFLACDemuxer(r)
+	case ".wav", ".rf64", ".bw64":
+		return matroska.NewWAVDemuxer(r)
+	case ".srt":
+		return matroska.NewSRTDemuxer(r)
+	case ".ass", ".ssa":
+		return matroska.NewSSADemuxer(r)
+	case ".vtt":
+		return matroska.NewWebVTTDemuxer(r)
+	default:
+		return nil, fmt.Errorf("Unsupported input format: %s", ext)
+	}
+}
+
+// ParseFrameRate returns the frame duration of a frame rate written as
+// a number or a fraction.
+func ParseFrameRate(s string) (time.Duration, error) {
+	num, den, ok := strings.Cut(s, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	d := 1.0
+	if err == nil && ok {
+		d, err = strconv.ParseFloat(den, 64)
+	}
+	if err != nil || n <= 0 || d <= 0 {
+		return 0, fmt.Errorf("Invalid frame rate: %s", s)
+	}
+	return time.Duration(math.Round(float64(time.Second) * d / n)), nil
+}
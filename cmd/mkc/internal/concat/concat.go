@@ -0,0 +1,109 @@
+package concat
+
+import (
+	"errors"
+	"fmt"
+	"github.com/charmbracelet/huh"
+	"github.com/coding-socks/matroska"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/cli"
+	flag "github.com/spf13/pflag"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var Cmd = &cli.Command{
+	Flags: flag.NewFlagSet("append", flag.ContinueOnError),
+}
+
+func init() {
+	Cmd.Run = run
+}
+
+var flagOutput = Cmd.Flags.StringP("output", "o", "", "Path to the output file. A .webm extension selects the webm DocType.")
+
+type arguments struct {
+	Inputs []string
+	Output string
+}
+
+func run(flags *flag.FlagSet) {
+	args := arguments{
+		Inputs: flags.Args(),
+		Output: *flagOutput,
+	}
+	for len(args.Inputs) < 2 {
+		var input string
+		err := huh.NewInput().
+			Title(fmt.Sprintf("Source matroska file %d (leave empty to finish):", len(args.Inputs))).
+			Prompt("?").
+			Validate(func(s string) error {
+				if s == "" && len(args.Inputs) > 1 {
+					return nil
+				}
+				return cli.ValidatorFile(s)
+			}).
+			Value(&input).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		if input == "" {
+			break
+		}
+		args.Inputs = append(args.Inputs, input)
+	}
+	if args.Output == "" {
+		err := huh.NewInput().
+			Title("Output file:").
+			Prompt("?").
+			Value(&args.Output).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	rs := make([]io.ReadSeeker, len(args.Inputs))
+	for i, input := range args.Inputs {
+		f, err := os.Open(input)
+		if err != nil {
+			log.Fatalf("Could not open input file: %s", input)
+		}
+		defer f.Close()
+		rs[i] = f
+	}
+	var opts matroska.WriterOptions
+	if strings.ToLower(filepath.Ext(args.Output)) == ".webm" {
+		opts.DocType = "webm"
+	}
+	out, err := os.Create(args.Output)
+	if err != nil {
+		log.Fatalf("Could not create output file: %s", err)
+	}
+	defer out.Close()
+
+	err = matroska.Append(out, rs, opts)
+	var ie *matroska.IncompatibleError
+	if errors.As(err, &ie) {
+		out.Close()
+		os.Remove(args.Output)
+		fmt.Fprintf(os.Stderr, "Could not append files to %s:\n", args.Inputs[0])
+		for _, in := range ie.Incompatibilities {
+			if in.TrackNumber == 0 {
+				fmt.Fprintf(os.Stderr, "  %s: %s\n", args.Inputs[in.Input], in.Reason)
+			} else {
+				fmt.Fprintf(os.Stderr, "  %s track %d: %s\n", args.Inputs[in.Input], in.TrackNumber, in.Reason)
+			}
+		}
+		os.Exit(1)
+	} else if err != nil {
+		log.Fatalf("Could not append files: %s", err)
+	}
+}
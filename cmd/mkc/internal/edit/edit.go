@@ -0,0 +1,184 @@
+package edit
+
+import (
+	"errors"
+	"fmt"
+	"github.com/charmbracelet/huh"
+	"github.com/coding-socks/matroska"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/cli"
+	flag "github.com/spf13/pflag"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var Cmd = &cli.Command{
+	Flags: flag.NewFlagSet("edit", flag.ContinueOnError),
+}
+
+func init() {
+	Cmd.Run = run
+}
+
+var flagTitle = Cmd.Flags.String("title", "", "Title of the segment.")
+var flagDate = Cmd.Flags.String("date", "", "Date of the segment in RFC 3339 format, for example 2006-01-02T15:04:05Z.")
+var flagLanguage = Cmd.Flags.StringArray("language", nil, "Language of a track as TRACK=LANGUAGE using an ISO 639-2 or a BCP 47 language code.")
+var flagName = Cmd.Flags.StringArray("name", nil, "Name of a track as TRACK=NAME. An empty name removes it.")
+var flagDefault = Cmd.Flags.StringArray("default", nil, "Default flag of a track as TRACK=0 or TRACK=1.")
+var flagForced = Cmd.Flags.StringArray("forced", nil, "Forced flag of a track as TRACK=0 or TRACK=1.")
+var flagTags = Cmd.Flags.String("tags", "", "Path to a Matroska XML tags file replacing the tags.")
+var flagRemoveTags = Cmd.Flags.Bool("remove-tags", false, "Remove the tags.")
+var flagRemoveChapters = Cmd.Flags.Bool("remove-chapters", false, "Remove the chapters.")
+
+type arguments struct {
+	Input string
+}
+
+func run(flags *flag.FlagSet) {
+	args := arguments{
+		Input: flags.Arg(0),
+	}
+	if args.Input == "" {
+		err := huh.NewInput().
+			Title("Matroska file to edit:").
+			Prompt("?").
+			Validate(cli.ValidatorFile).
+			Value(&args.Input).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	f, err := os.OpenFile(args.Input, os.O_RDWR, 0)
+	if err != nil {
+		log.Fatalf("Could not open input file: %s", args.Input)
+	}
+	defer f.Close()
+	e, err := matroska.NewEditor(f)
+	if err != nil {
+		log.Fatalf("Could not read input file: %s", err)
+	}
+
+	if flags.Changed("title") {
+		if *flagTitle == "" {
+			e.Info().Title = nil
+		} else {
+			e.Info().Title = flagTitle
+		}
+	}
+	if flags.Changed("date") {
+		if *flagDate == "" {
+			e.Info().DateUTC = nil
+		} else {
+			date, err := time.Parse(time.RFC3339, *flagDate)
+			if err != nil {
+				log.Fatalf("Invalid date: %s", *flagDate)
+			}
+			date = date.UTC()
+			e.Info().DateUTC = &date
+		}
+	}
+	if err := applyTrackFlags(e); err != nil {
+		log.Fatal(err)
+	}
+	if *flagRemoveTags {
+		e.SetTags(nil)
+	}
+	if *flagTags != "" {
+		tf, err := os.Open(*flagTags)
+		if err != nil {
+			log.Fatalf("Could not open tags file: %s", *flagTags)
+		}
+		tags, err := matroska.ReadTagsXML(tf)
+		tf.Close()
+		if err != nil {
+			log.Fatalf("Could not read tags file: %s", err)
+		}
+		e.SetTags(tags)
+	}
+	if *flagRemoveChapters {
+		e.SetChapters(nil)
+	}
+
+	if err := e.Save(); err != nil {
+		log.Fatalf("Could not save changes: %s", err)
+	}
+}
+
+// applyTrackFlags applies the --language, --name, --default and --forced
+// flags to the tracks of e.
+func applyTrackFlags(e *matroska.Editor) error {
+	apply := func(values []string, set func(t *matroska.TrackEntry, v string) error) error {
+		for _, s := range values {
+			track, v, ok := strings.Cut(s, "=")
+			if !ok {
+				return fmt.Errorf("Invalid value: %s", s)
+			}
+			n, err := strconv.ParseUint(track, 10, 64)
+			if err != nil {
+				return fmt.Errorf("Invalid track: %s", track)
+			}
+			t, ok := e.Track(uint(n))
+			if !ok {
+				return fmt.Errorf("Unknown track: %d", n)
+			}
+			if err := set(t, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	parseFlag := func(v string) (uint, error) {
+		switch v {
+		case "0", "1":
+			return uint(v[0] - '0'), nil
+		}
+		return 0, fmt.Errorf("Invalid flag value: %s", v)
+	}
+	if err := apply(*flagLanguage, func(t *matroska.TrackEntry, v string) error {
+		setLanguage(t, v)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := apply(*flagName, func(t *matroska.TrackEntry, v string) error {
+		if v == "" {
+			t.Name = nil
+		} else {
+			t.Name = &v
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := apply(*flagDefault, func(t *matroska.TrackEntry, v string) (err error) {
+		t.FlagDefault, err = parseFlag(v)
+		return err
+	}); err != nil {
+		return err
+	}
+	return apply(*flagForced, func(t *matroska.TrackEntry, v string) (err error) {
+		t.FlagForced, err = parseFlag(v)
+		return err
+	})
+}
+
+// setLanguage stores an ISO 639-2 language code in Language, and any
+// other language code in LanguageBCP47.
+func setLanguage(t *matroska.TrackEntry, language string) {
+	t.LanguageBCP47 = nil
+	if language == "" {
+		t.Language = "und"
+		return
+	}
+	if len(language) == 3 && strings.ToLower(language) == language && !strings.ContainsAny(language, "-_") {
+		t.Language = language
+		return
+	}
+	t.LanguageBCP47 = &language
+}
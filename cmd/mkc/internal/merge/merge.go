@@ -0,0 +1,399 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+	"github.com/charmbracelet/huh"
+	"github.com/coding-socks/matroska"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/cli"
+	flag "github.com/spf13/pflag"
+	"io"
+	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var Cmd = &cli.Command{
+	Flags: flag.NewFlagSet("merge", flag.ContinueOnError),
+}
+
+func init() {
+	Cmd.Run = run
+}
+
+var flagOutput = Cmd.Flags.StringP("output", "o", "", "Path to the output file. A .webm extension selects the webm DocType.")
+var flagTitle = Cmd.Flags.String("title", "", "Title of the segment.")
+var flagTracks = Cmd.Flags.StringSliceP("tracks", "t", nil, "Tracks to keep as INPUT:TRACK where INPUT is the index of the input file starting from 0. Defaults to every track.")
+var flagLanguage = Cmd.Flags.StringArray("language", nil, "Language of a track as INPUT:TRACK=LANGUAGE using an ISO 639-2 or a BCP 47 language code.")
+var flagName = Cmd.Flags.StringArray("name", nil, "Name of a track as INPUT:TRACK=NAME.")
+var flagDefault = Cmd.Flags.StringArray("default", nil, "Default flag of a track as INPUT:TRACK=0 or INPUT:TRACK=1.")
+var flagForced = Cmd.Flags.StringArray("forced", nil, "Forced flag of a track as INPUT:TRACK=0 or INPUT:TRACK=1.")
+var flagFPS = Cmd.Flags.String("fps", "", "Frame rate of raw H.264 and HEVC streams, for example 25 or 24000/1001. Defaults to the timing information of the stream.")
+
+// trackID identifies a track of an input file.
+type trackID struct {
+	Input int
+	Track uint
+}
+
+func (id trackID) String() string {
+	return fmt.Sprintf("%d:%d", id.Input, id.Track)
+}
+
+// trackOptions are the properties of a track set by the user.
+type trackOptions struct {
+	Language string
+	Name     string
+	Default  bool
+	Forced   bool
+}
+
+type arguments struct {
+	Inputs []string
+	Output string
+	Title  string
+	FPS    string
+	// Tracks are the kept tracks, or nil when every track is kept.
+	Tracks  []trackID
+	Options map[trackID]*trackOptions
+}
+
+func run(flags *flag.FlagSet) {
+	args := arguments{
+		Inputs:  flags.Args(),
+		Output:  *flagOutput,
+		Title:   *flagTitle,
+		FPS:     *flagFPS,
+		Options: make(map[trackID]*trackOptions),
+	}
+	interactive := len(args.Inputs) == 0
+	for interactive {
+		var input string
+		err := huh.NewInput().
+			Title(fmt.Sprintf("Source file %d (leave empty to finish):", len(args.Inputs))).
+			Prompt("?").
+			Validate(func(s string) error {
+				if s == "" && len(args.Inputs) > 0 {
+					return nil
+				}
+				return cli.ValidatorFile(s)
+			}).
+			Value(&input).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		if input == "" {
+			break
+		}
+		args.Inputs = append(args.Inputs, input)
+	}
+	if args.Output == "" {
+		err := huh.NewInput().
+			Title("Output file:").
+			Prompt("?").
+			Value(&args.Output).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, s := range *flagTracks {
+		id, err := parseTrackID(s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		args.Tracks = append(args.Tracks, id)
+	}
+
+	var frameDuration time.Duration
+	if args.FPS != "" {
+		var err error
+		if frameDuration, err = cli.ParseFrameRate(args.FPS); err != nil {
+			log.Fatal(err)
+		}
+	}
+	ds := make([]matroska.Demuxer, len(args.Inputs))
+	for i, input := range args.Inputs {
+		f, err := os.Open(input)
+		if err != nil {
+			log.Fatalf("Could not open input file: %s", input)
+		}
+		defer f.Close()
+		if ds[i], err = cli.NewDemuxer(f, input, frameDuration); err != nil {
+			log.Fatalf("Could not read input file %s: %s", input, err)
+		}
+	}
+
+	for i, d := range ds {
+		for _, t := range d.Tracks() {
+			o := &trackOptions{
+				Language: t.Language,
+				Default:  t.FlagDefault == 1,
+				Forced:   t.FlagForced == 1,
+			}
+			if t.LanguageBCP47 != nil {
+				o.Language = *t.LanguageBCP47
+			}
+			if t.Name != nil {
+				o.Name = *t.Name
+			}
+			args.Options[trackID{i, t.TrackNumber}] = o
+		}
+	}
+	if err := applyOptions(args.Options); err != nil {
+		log.Fatal(err)
+	}
+	if interactive && args.Tracks == nil {
+		var options []huh.Option[trackID]
+		for i, d := range ds {
+			for _, t := range d.Tracks() {
+				id := trackID{i, t.TrackNumber}
+				label := fmt.Sprintf("%s Track %02d [%s] %s", filepath.Base(args.Inputs[i]), t.TrackNumber, t.CodecID, args.Options[id].Name)
+				options = append(options, huh.NewOption(label, id).Selected(true))
+			}
+		}
+		err := huh.NewMultiSelect[trackID]().
+			Title("Tracks:").
+			Options(options...).
+			Value(&args.Tracks).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+		for _, id := range args.Tracks {
+			o := args.Options[id]
+			err := huh.NewForm(huh.NewGroup(
+				huh.NewInput().Title(fmt.Sprintf("Language of track %s:", id)).Value(&o.Language),
+				huh.NewInput().Title(fmt.Sprintf("Name of track %s:", id)).Value(&o.Name),
+				huh.NewConfirm().Title(fmt.Sprintf("Is track %s a default track?", id)).Value(&o.Default),
+				huh.NewConfirm().Title(fmt.Sprintf("Is track %s a forced track?", id)).Value(&o.Forced),
+			)).Run()
+			if errors.Is(err, huh.ErrUserAborted) {
+				return
+			} else if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	for _, id := range args.Tracks {
+		if _, ok := args.Options[id]; !ok {
+			log.Fatalf("Could not find track %s", id)
+		}
+	}
+
+	d, trackUIDs := matroska.Interleave(ds, func(input int, t *matroska.TrackEntry) bool {
+		id := trackID{input, t.TrackNumber}
+		if args.Tracks != nil && !slices.Contains(args.Tracks, id) {
+			return false
+		}
+		o := args.Options[id]
+		setLanguage(t, o.Language)
+		t.Name = nil
+		if o.Name != "" {
+			t.Name = &o.Name
+		}
+		t.FlagDefault, t.FlagForced = 0, 0
+		if o.Default {
+			t.FlagDefault = 1
+		}
+		if o.Forced {
+			t.FlagForced = 1
+		}
+		return true
+	})
+	if len(d.Tracks()) == 0 {
+		log.Fatal("No track to merge")
+	}
+
+	var opts matroska.WriterOptions
+	if strings.ToLower(filepath.Ext(args.Output)) == ".webm" {
+		opts.DocType = "webm"
+	}
+	out, err := os.Create(args.Output)
+	if err != nil {
+		log.Fatalf("Could not create output file: %s", err)
+	}
+	defer out.Close()
+
+	var info matroska.Info
+	if args.Title != "" {
+		info.Title = &args.Title
+	}
+	w, err := matroska.NewWriter(out, info, matroska.Tracks{TrackEntry: d.Tracks()}, opts)
+	if err != nil {
+		log.Fatalf("Could not write output file: %s", err)
+	}
+	copyElements(w, ds, trackUIDs)
+	for {
+		f, err := d.ReadFrame()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			log.Fatalf("Could not read frame: %s", err)
+		}
+		if err := w.WriteFrame(f); err != nil {
+			log.Fatalf("Could not write frame: %s", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		log.Fatalf("Could not write output file: %s", err)
+	}
+}
+
+// parseTrackID parses a track written as INPUT:TRACK.
+func parseTrackID(s string) (trackID, error) {
+	input, track, ok := strings.Cut(s, ":")
+	i, err := strconv.Atoi(input)
+	if err != nil || !ok || i < 0 {
+		return trackID{}, fmt.Errorf("Invalid track: %s", s)
+	}
+	n, err := strconv.ParseUint(track, 10, 64)
+	if err != nil {
+		return trackID{}, fmt.Errorf("Invalid track: %s", s)
+	}
+	return trackID{i, uint(n)}, nil
+}
+
+// applyOptions applies the --language, --name, --default and --forced
+// flags to the options of the tracks.
+func applyOptions(options map[trackID]*trackOptions) error {
+	apply := func(values []string, set func(o *trackOptions, v string) error) error {
+		for _, s := range values {
+			track, v, ok := strings.Cut(s, "=")
+			if !ok {
+				return fmt.Errorf("Invalid value: %s", s)
+			}
+			id, err := parseTrackID(track)
+			if err != nil {
+				return err
+			}
+			o, ok := options[id]
+			if !ok {
+				return fmt.Errorf("Could not find track %s", id)
+			}
+			if err := set(o, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	parseFlag := func(v string) (bool, error) {
+		switch v {
+		case "0":
+			return false, nil
+		case "1":
+			return true, nil
+		}
+		return false, fmt.Errorf("Invalid flag value: %s", v)
+	}
+	if err := apply(*flagLanguage, func(o *trackOptions, v string) error {
+		o.Language = v
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := apply(*flagName, func(o *trackOptions, v string) error {
+		o.Name = v
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := apply(*flagDefault, func(o *trackOptions, v string) (err error) {
+		o.Default, err = parseFlag(v)
+		return err
+	}); err != nil {
+		return err
+	}
+	return apply(*flagForced, func(o *trackOptions, v string) (err error) {
+		o.Forced, err = parseFlag(v)
+		return err
+	})
+}
+
+// setLanguage stores an ISO 639-2 language code in Language, and any
+// other language code in LanguageBCP47.
+func setLanguage(t *matroska.TrackEntry, language string) {
+	t.LanguageBCP47 = nil
+	if language == "" {
+		t.Language = "und"
+		return
+	}
+	if len(language) == 3 && strings.ToLower(language) == language && !strings.ContainsAny(language, "-_") {
+		t.Language = language
+		return
+	}
+	t.LanguageBCP47 = &language
+}
+
+// copyElements copies the chapters of the first Matroska input having
+// them, and the attachments and the tags of every Matroska input. The
+// tracks which are not merged are removed from the targets of the tags,
+// and tags left without a track are dropped. trackUIDs maps the TrackUIDs
+// of the merged tracks of each input to their new TrackUID.
+func copyElements(w *matroska.Writer, ds []matroska.Demuxer, trackUIDs []map[uint]uint) {
+	var (
+		chapters    *matroska.Chapters
+		attachments matroska.Attachments
+		tags        matroska.Tags
+	)
+	usedFileUIDs := make(map[uint]bool)
+	for i, d := range ds {
+		md, ok := d.(*matroska.MatroskaDemuxer)
+		if !ok {
+			continue
+		}
+		s := md.Scanner()
+		if c := s.Chapters(); c != nil && chapters == nil {
+			chapters = c
+		}
+		fileUIDs := make(map[uint]uint)
+		if a := s.Attachments(); a != nil {
+			for _, f := range a.AttachedFile {
+				uid := f.FileUID
+				for usedFileUIDs[f.FileUID] {
+					f.FileUID = uint(rand.Uint64())
+				}
+				usedFileUIDs[f.FileUID] = true
+				fileUIDs[uid] = f.FileUID
+				attachments.AttachedFile = append(attachments.AttachedFile, f)
+			}
+		}
+		if t := s.Tags(); t != nil {
+			dropped := func(uid uint) bool {
+				_, ok := trackUIDs[i][uid]
+				return uid != 0 && !ok
+			}
+			for _, tag := range t.Tag {
+				if uids := tag.Targets.TagTrackUID; len(uids) > 0 {
+					tag.Targets.TagTrackUID = slices.DeleteFunc(slices.Clone(uids), dropped)
+					if len(tag.Targets.TagTrackUID) == 0 {
+						continue
+					}
+				}
+				tag.Targets = matroska.MapTargets(tag.Targets, trackUIDs[i], nil, nil, fileUIDs)
+				tags.Tag = append(tags.Tag, tag)
+			}
+		}
+	}
+	if chapters != nil {
+		w.SetChapters(chapters)
+	}
+	if len(attachments.AttachedFile) > 0 {
+		w.SetAttachments(&attachments)
+	}
+	if len(tags.Tag) > 0 {
+		w.SetTags(&tags)
+	}
+}
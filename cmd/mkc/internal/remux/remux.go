@@ -0,0 +1,101 @@
+package remux
+
+import (
+	"errors"
+	"github.com/charmbracelet/huh"
+	"github.com/coding-socks/matroska"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/cli"
+	flag "github.com/spf13/pflag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var Cmd = &cli.Command{
+	Flags: flag.NewFlagSet("remux", flag.ContinueOnError),
+}
+
+func init() {
+	Cmd.Run = run
+}
+
+var flagOutput = Cmd.Flags.StringP("output", "o", "", "Path to the output file. Defaults to the input file with .mkv, .mka or .mks extension. A .webm extension selects the webm DocType.")
+var flagTitle = Cmd.Flags.String("title", "", "Title of the segment.")
+var flagFPS = Cmd.Flags.String("fps", "", "Frame rate of raw H.264 and HEVC streams, for example 25 or 24000/1001. Defaults to the timing information of the stream.")
+
+type arguments struct {
+	Input  string
+	Output string
+	Title  string
+	FPS    string
+}
+
+func run(flags *flag.FlagSet) {
+	args := arguments{
+		Input:  flags.Arg(0),
+		Output: *flagOutput,
+		Title:  *flagTitle,
+		FPS:    *flagFPS,
+	}
+	if args.Input == "" {
+		err := huh.NewInput().
+			Title("Source file:").
+			Prompt("?").
+			Validate(cli.ValidatorFile).
+			Value(&args.Input).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+	f, err := os.Open(args.Input)
+	if err != nil {
+		log.Fatalf("Could not open input file: %s", args.Input)
+	}
+	defer f.Close()
+
+	var frameDuration time.Duration
+	if args.FPS != "" {
+		if frameDuration, err = cli.ParseFrameRate(args.FPS); err != nil {
+			log.Fatal(err)
+		}
+	}
+	d, err := cli.NewDemuxer(f, args.Input, frameDuration)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if args.Output == "" {
+		ext := ".mks"
+		for _, t := range d.Tracks() {
+			switch {
+			case t.TrackType == matroska.TrackTypeVideo:
+				ext = ".mkv"
+			case t.TrackType == matroska.TrackTypeAudio && ext == ".mks":
+				ext = ".mka"
+			}
+		}
+		args.Output = strings.TrimSuffix(args.Input, filepath.Ext(args.Input)) + ext
+	}
+	var opts matroska.WriterOptions
+	if strings.ToLower(filepath.Ext(args.Output)) == ".webm" {
+		opts.DocType = "webm"
+	}
+
+	out, err := os.Create(args.Output)
+	if err != nil {
+		log.Fatalf("Could not create output file: %s", err)
+	}
+	defer out.Close()
+
+	var info matroska.Info
+	if args.Title != "" {
+		info.Title = &args.Title
+	}
+	if err := matroska.Remux(out, d, info, opts); err != nil {
+		log.Fatalf("Could not remux file: %s", err)
+	}
+}
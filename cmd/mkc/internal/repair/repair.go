@@ -0,0 +1,85 @@
+package repair
+
+import (
+	"errors"
+	"fmt"
+	"github.com/charmbracelet/huh"
+	"github.com/coding-socks/ebml"
+	"github.com/coding-socks/matroska"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/cli"
+	flag "github.com/spf13/pflag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var Cmd = &cli.Command{
+	Flags: flag.NewFlagSet("repair", flag.ContinueOnError),
+}
+
+func init() {
+	Cmd.Run = run
+}
+
+var flagOutput = Cmd.Flags.StringP("output", "o", "", "Path to the output file. Defaults to the input file with a .repaired suffix.")
+
+type arguments struct {
+	Input  string
+	Output string
+}
+
+func run(flags *flag.FlagSet) {
+	args := arguments{
+		Input:  flags.Arg(0),
+		Output: *flagOutput,
+	}
+	if args.Input == "" {
+		err := huh.NewInput().
+			Title("Damaged matroska file:").
+			Prompt("?").
+			Validate(cli.ValidatorFile).
+			Value(&args.Input).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if args.Output == "" {
+		ext := filepath.Ext(args.Input)
+		args.Output = strings.TrimSuffix(args.Input, ext) + ".repaired" + ext
+	}
+
+	f, err := os.Open(args.Input)
+	if err != nil {
+		log.Fatalf("Could not open input file: %s", args.Input)
+	}
+	defer f.Close()
+	out, err := os.Create(args.Output)
+	if err != nil {
+		log.Fatalf("Could not create output file: %s", err)
+	}
+	defer out.Close()
+
+	report, err := matroska.Repair(out, f, matroska.WriterOptions{})
+	if err != nil {
+		log.Fatalf("Could not repair file: %s", err)
+	}
+	def, err := ebml.Definition(matroska.DocType)
+	if err != nil {
+		log.Fatal(err)
+	}
+	names := make([]string, len(report.Elements))
+	for i, id := range report.Elements {
+		el, _ := def.Get(id)
+		names[i] = el.Name
+	}
+	fmt.Printf("Recovered elements: %s\n", strings.Join(names, ", "))
+	fmt.Printf("Recovered clusters: %d with %d blocks\n", report.Clusters, report.Blocks)
+	fmt.Printf("Dropped clusters: %d\n", report.DroppedClusters)
+	fmt.Printf("Skipped: %d octets\n", report.Skipped)
+	fmt.Printf("Cue points: %d\n", report.CuePoints)
+	fmt.Printf("Duration: %s\n", report.Duration)
+}
@@ -0,0 +1,261 @@
+package split
+
+import (
+	"errors"
+	"fmt"
+	"github.com/charmbracelet/huh"
+	"github.com/coding-socks/matroska"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/cli"
+	flag "github.com/spf13/pflag"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var Cmd = &cli.Command{
+	Flags: flag.NewFlagSet("split", flag.ContinueOnError),
+}
+
+func init() {
+	Cmd.Run = run
+}
+
+var flagOutput = Cmd.Flags.StringP("output", "o", "", "Pattern of the output files containing a verb for the number of the part, for example out-%03d.mkv. Defaults to the input file with a -%03d suffix.")
+var flagDuration = Cmd.Flags.String("duration", "", "Start a new part after a duration, for example 10m or 00:10:00.")
+var flagSize = Cmd.Flags.String("size", "", "Start a new part after a size, for example 700M or 1G.")
+var flagTimestamps = Cmd.Flags.StringSlice("timestamps", nil, "Start a new part at each timestamp, for example 00:10:00,00:20:00.")
+var flagParts = Cmd.Flags.StringSlice("parts", nil, "Write each range into its own part and drop the rest, for example 00:01:00-00:02:00,00:05:00-. A range without an end lasts until the end of the file.")
+var flagChapters = Cmd.Flags.Bool("chapters", false, "Start a new part at each chapter.")
+var flagLink = Cmd.Flags.Bool("link", false, "Link the parts to each other with PrevUUID and NextUUID.")
+
+const (
+	modeDuration   = "duration"
+	modeSize       = "size"
+	modeTimestamps = "timestamps"
+	modeParts      = "parts"
+	modeChapters   = "chapters"
+)
+
+type arguments struct {
+	Input      string
+	Output     string
+	Duration   string
+	Size       string
+	Timestamps []string
+	Parts      []string
+	Chapters   bool
+	Link       bool
+}
+
+func run(flags *flag.FlagSet) {
+	args := arguments{
+		Input:      flags.Arg(0),
+		Output:     *flagOutput,
+		Duration:   *flagDuration,
+		Size:       *flagSize,
+		Timestamps: *flagTimestamps,
+		Parts:      *flagParts,
+		Chapters:   *flagChapters,
+		Link:       *flagLink,
+	}
+	if args.Input == "" {
+		err := huh.NewInput().
+			Title("Source matroska file:").
+			Prompt("?").
+			Validate(cli.ValidatorFile).
+			Value(&args.Input).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if args.Duration == "" && args.Size == "" && args.Timestamps == nil && args.Parts == nil && !args.Chapters {
+		if err := prompt(&args); errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+	opts, err := splitOptions(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if args.Output == "" {
+		ext := filepath.Ext(args.Input)
+		args.Output = strings.ReplaceAll(strings.TrimSuffix(args.Input, ext), "%", "%%") + "-%03d" + ext
+	}
+	if strings.ToLower(filepath.Ext(args.Output)) == ".webm" {
+		opts.WriterOptions.DocType = "webm"
+	}
+
+	f, err := os.Open(args.Input)
+	if err != nil {
+		log.Fatalf("Could not open input file: %s", args.Input)
+	}
+	defer f.Close()
+
+	n, err := matroska.Split(f, func(part int) (io.WriteSeeker, error) {
+		name := fmt.Sprintf(args.Output, part+1)
+		fmt.Println(name)
+		return os.Create(name)
+	}, opts)
+	if err != nil {
+		log.Fatalf("Could not split file: %s", err)
+	}
+	fmt.Printf("%d parts\n", n)
+}
+
+// prompt asks for the split mode and its value.
+func prompt(args *arguments) error {
+	var mode string
+	err := huh.NewSelect[string]().
+		Title("Split by:").
+		Options(
+			huh.NewOption("Duration", modeDuration),
+			huh.NewOption("Size", modeSize),
+			huh.NewOption("Timestamps", modeTimestamps),
+			huh.NewOption("Parts", modeParts),
+			huh.NewOption("Chapters", modeChapters),
+		).
+		Value(&mode).
+		Run()
+	if err != nil {
+		return err
+	}
+	var title, value string
+	var validate func(string) error
+	switch mode {
+	case modeChapters:
+		args.Chapters = true
+		return nil
+	case modeDuration:
+		title, validate = "Duration of a part:", func(s string) error {
+			_, err := cli.ParseTimestamp(s)
+			return err
+		}
+	case modeSize:
+		title, validate = "Size of a part:", func(s string) error {
+			_, err := parseSize(s)
+			return err
+		}
+	case modeTimestamps:
+		title, validate = "Timestamps separated by commas:", func(s string) error {
+			for _, v := range strings.Split(s, ",") {
+				if _, err := cli.ParseTimestamp(v); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case modeParts:
+		title, validate = "Ranges separated by commas:", func(s string) error {
+			for _, v := range strings.Split(s, ",") {
+				if _, err := parsePart(v); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	err = huh.NewInput().
+		Title(title).
+		Prompt("?").
+		Validate(validate).
+		Value(&value).
+		Run()
+	if err != nil {
+		return err
+	}
+	switch mode {
+	case modeDuration:
+		args.Duration = value
+	case modeSize:
+		args.Size = value
+	case modeTimestamps:
+		args.Timestamps = strings.Split(value, ",")
+	case modeParts:
+		args.Parts = strings.Split(value, ",")
+	}
+	return nil
+}
+
+func splitOptions(args arguments) (matroska.SplitOptions, error) {
+	opts := matroska.SplitOptions{Chapters: args.Chapters, Link: args.Link}
+	var err error
+	if args.Duration != "" {
+		if opts.Duration, err = cli.ParseTimestamp(args.Duration); err != nil {
+			return opts, err
+		}
+	}
+	if args.Size != "" {
+		if opts.Size, err = parseSize(args.Size); err != nil {
+			return opts, err
+		}
+	}
+	for _, s := range args.Timestamps {
+		ts, err := cli.ParseTimestamp(s)
+		if err != nil {
+			return opts, err
+		}
+		opts.Timestamps = append(opts.Timestamps, ts)
+	}
+	for _, s := range args.Parts {
+		p, err := parsePart(s)
+		if err != nil {
+			return opts, err
+		}
+		opts.Parts = append(opts.Parts, p)
+	}
+	return opts, nil
+}
+
+// parsePart parses a range written as START-END, where END may be
+// omitted.
+func parsePart(s string) (matroska.SplitPart, error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return matroska.SplitPart{}, fmt.Errorf("Invalid range: %s", s)
+	}
+	var p matroska.SplitPart
+	var err error
+	if p.Start, err = cli.ParseTimestamp(start); err != nil {
+		return p, err
+	}
+	if strings.TrimSpace(end) != "" {
+		if p.End, err = cli.ParseTimestamp(end); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// parseSize parses a number of octets with an optional k, M or G suffix
+// for powers of 1024.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	v, shift := s, 0
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k', 'K':
+			shift = 10
+		case 'm', 'M':
+			shift = 20
+		case 'g', 'G':
+			shift = 30
+		}
+		if shift != 0 {
+			v = s[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("Invalid size: %s", s)
+	}
+	return n << shift, nil
+}
@@ -0,0 +1,108 @@
+package tags
+
+import (
+	"errors"
+	"fmt"
+	"github.com/charmbracelet/huh"
+	"github.com/coding-socks/matroska"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/cli"
+	flag "github.com/spf13/pflag"
+	"io"
+	"log"
+	"os"
+)
+
+var Cmd = &cli.Command{
+	Flags: flag.NewFlagSet("tags", flag.ContinueOnError),
+}
+
+func init() {
+	Cmd.Run = run
+}
+
+var flagOutput = Cmd.Flags.StringP("output", "o", "", "Path to the output file. Defaults to the standard output.")
+
+const (
+	actionExport = "export"
+)
+
+type arguments struct {
+	Action string
+	Input  string
+	Output string
+}
+
+func run(flags *flag.FlagSet) {
+	args := arguments{
+		Action: flags.Arg(0),
+		Input:  flags.Arg(1),
+		Output: *flagOutput,
+	}
+	if args.Action == "" {
+		err := huh.NewSelect[string]().
+			Title("Choose an action:").
+			Options(huh.NewOptions(actionExport)...).
+			Value(&args.Action).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if args.Input == "" {
+		err := huh.NewInput().
+			Title("Source matroska file:").
+			Prompt("?").
+			Validate(cli.ValidatorFile).
+			Value(&args.Input).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	switch args.Action {
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown action: %s\n", args.Action)
+		os.Exit(1)
+	case actionExport:
+		export(args)
+	}
+}
+
+func export(args arguments) {
+	f, err := os.Open(args.Input)
+	if err != nil {
+		log.Fatalf("Could not open input file: %s", args.Input)
+	}
+	defer f.Close()
+
+	s := matroska.NewScanner(f)
+	if err := s.Init(); err != nil {
+		log.Fatal(err)
+	}
+	tags := s.Tags()
+	if err := s.Err(); err != nil {
+		log.Fatal(err)
+	}
+	if tags == nil {
+		fmt.Fprintln(os.Stderr, "The file does not have tags")
+		return
+	}
+
+	var w io.Writer = os.Stdout
+	if args.Output != "" {
+		out, err := os.Create(args.Output)
+		if err != nil {
+			log.Fatalf("Could not create output file: %s", err)
+		}
+		defer out.Close()
+		w = out
+	}
+	if err := matroska.WriteTagsXML(w, tags); err != nil {
+		log.Fatalf("Could not export tags: %s", err)
+	}
+}
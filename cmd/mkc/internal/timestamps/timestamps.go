@@ -0,0 +1,125 @@
+package timestamps
+
+import (
+	"errors"
+	"fmt"
+	"github.com/charmbracelet/huh"
+	"github.com/coding-socks/matroska"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/cli"
+	flag "github.com/spf13/pflag"
+	"io"
+	"log"
+	"os"
+)
+
+var Cmd = &cli.Command{
+	Flags: flag.NewFlagSet("timestamps", flag.ContinueOnError),
+}
+
+func init() {
+	Cmd.Run = run
+}
+
+var flagOutput = Cmd.Flags.StringP("output", "o", "", "Path to the output file. Defaults to the standard output.")
+var flagTrack = Cmd.Flags.UintP("track", "t", 0, "Number of the track.")
+var flagFormat = Cmd.Flags.StringP("format", "f", formatV2, "Output format: v2 or v4.")
+
+const (
+	formatV2 = "v2"
+	formatV4 = "v4"
+)
+
+type arguments struct {
+	Input  string
+	Output string
+	Track  uint
+	Format string
+}
+
+func run(flags *flag.FlagSet) {
+	args := arguments{
+		Input:  flags.Arg(0),
+		Output: *flagOutput,
+		Track:  *flagTrack,
+		Format: *flagFormat,
+	}
+	var format matroska.TimestampFormat
+	switch args.Format {
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format: %s\n", args.Format)
+		os.Exit(1)
+	case formatV2:
+		format = matroska.TimestampFormatV2
+	case formatV4:
+		format = matroska.TimestampFormatV4
+	}
+	if args.Input == "" {
+		err := huh.NewInput().
+			Title("Source matroska file:").
+			Prompt("?").
+			Validate(cli.ValidatorFile).
+			Value(&args.Input).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(args.Input)
+	if err != nil {
+		log.Fatalf("Could not open input file: %s", args.Input)
+	}
+	defer f.Close()
+
+	s := matroska.NewScanner(f)
+	if err := s.Init(); err != nil {
+		log.Fatal(err)
+	}
+	tracks := s.Tracks()
+	if args.Track == 0 {
+		options := make([]huh.Option[uint], len(tracks.TrackEntry))
+		for i, e := range tracks.TrackEntry {
+			options[i] = huh.NewOption(fmt.Sprintf("Track %02d [%s]", e.TrackNumber, e.CodecID), e.TrackNumber)
+		}
+		err = huh.NewSelect[uint]().
+			Title("Track:").
+			Options(options...).
+			Value(&args.Track).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+	var te *matroska.TrackEntry
+	for i := range tracks.TrackEntry {
+		if tracks.TrackEntry[i].TrackNumber == args.Track {
+			te = &tracks.TrackEntry[i]
+			break
+		}
+	}
+	if te == nil {
+		log.Fatalf("Could not find track %d", args.Track)
+	}
+
+	ts, err := matroska.TrackTimestamps(s, *te)
+	if err != nil {
+		log.Fatalf("Could not read timestamps: %s", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if args.Output != "" {
+		out, err := os.Create(args.Output)
+		if err != nil {
+			log.Fatalf("Could not create output file: %s", err)
+		}
+		defer out.Close()
+		w = out
+	}
+	if err := matroska.WriteTimestamps(w, ts, format); err != nil {
+		log.Fatalf("Could not write timestamps: %s", err)
+	}
+}
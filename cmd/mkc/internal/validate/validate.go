@@ -0,0 +1,81 @@
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/charmbracelet/huh"
+	"github.com/coding-socks/matroska"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/cli"
+	flag "github.com/spf13/pflag"
+	"log"
+	"os"
+)
+
+var Cmd = &cli.Command{
+	Flags: flag.NewFlagSet("validate", flag.ContinueOnError),
+}
+
+func init() {
+	Cmd.Run = run
+}
+
+var flagJSON = Cmd.Flags.Bool("json", false, "Print the report in JSON format.")
+var flagWarnings = Cmd.Flags.BoolP("warnings", "w", true, "Print warnings as well as errors.")
+
+type arguments struct {
+	Input string
+}
+
+func run(flags *flag.FlagSet) {
+	args := arguments{
+		Input: flags.Arg(0),
+	}
+	if args.Input == "" {
+		err := huh.NewInput().
+			Title("Matroska file to validate:").
+			Prompt("?").
+			Validate(cli.ValidatorFile).
+			Value(&args.Input).
+			Run()
+		if errors.Is(err, huh.ErrUserAborted) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(args.Input)
+	if err != nil {
+		log.Fatalf("Could not open input file: %s", args.Input)
+	}
+	defer f.Close()
+	report, err := matroska.Validate(f)
+	if err != nil {
+		log.Fatalf("Could not read input file: %s", err)
+	}
+
+	if !*flagWarnings {
+		findings := report.Findings[:0]
+		for _, finding := range report.Findings {
+			if finding.Severity == matroska.SeverityError {
+				findings = append(findings, finding)
+			}
+		}
+		report.Findings = findings
+	}
+	if *flagJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		for _, finding := range report.Findings {
+			fmt.Println(finding)
+		}
+	}
+	if !report.Valid() {
+		os.Exit(1)
+	}
+}
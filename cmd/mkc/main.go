@@ -5,9 +5,20 @@ import (
 	"flag"
 	"fmt"
 	"github.com/charmbracelet/huh"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/attachments"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/chapters"
 	"github.com/coding-socks/matroska/cmd/mkc/internal/cli"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/concat"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/edit"
 	"github.com/coding-socks/matroska/cmd/mkc/internal/extract"
 	"github.com/coding-socks/matroska/cmd/mkc/internal/list"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/merge"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/remux"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/repair"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/split"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/tags"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/timestamps"
+	"github.com/coding-socks/matroska/cmd/mkc/internal/validate"
 	"log"
 	"os"
 	"os/signal"
@@ -15,8 +26,19 @@ import (
 )
 
 var commands = []*cli.Command{
+	concat.Cmd,
+	attachments.Cmd,
+	chapters.Cmd,
+	edit.Cmd,
 	extract.Cmd,
 	list.Cmd,
+	merge.Cmd,
+	remux.Cmd,
+	repair.Cmd,
+	split.Cmd,
+	tags.Cmd,
+	timestamps.Cmd,
+	validate.Cmd,
 }
 
 func main() {
@@ -0,0 +1,135 @@
+package matroska
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/coding-socks/ebml"
+	"github.com/coding-socks/ebml/schema"
+	"hash/crc32"
+	"io"
+)
+
+// CRC32Policy tells the Scanner what to do with the CRC-32 elements of the
+// master elements it decodes.
+type CRC32Policy int
+
+const (
+	// CRC32Ignore skips CRC-32 elements without verifying them.
+	CRC32Ignore CRC32Policy = iota
+	// CRC32Report verifies CRC-32 elements and reports mismatches to a
+	// callback while the element is still decoded.
+	CRC32Report
+	// CRC32Fail verifies CRC-32 elements and stops the Scanner at the
+	// first mismatch with a *CRC32Error.
+	CRC32Fail
+)
+
+// CRC32Error is a CRC-32 element which does not match the data of its
+// parent.
+type CRC32Error struct {
+	// ID is the ID of the parent of the CRC-32 element.
+	ID schema.ElementID
+	// Offset is the position of the CRC-32 element, or -1 when it is
+	// unknown because the io.Reader is not an io.Seeker.
+	Offset int64
+	// Want is the value stored in the CRC-32 element, and Got is the
+	// value calculated from the data.
+	Want, Got uint32
+}
+
+func (e *CRC32Error) Error() string {
+	return fmt.Sprintf("matroska: CRC-32 of %s at %d is %08x, the data has %08x", elementName(e.ID), e.Offset, e.Want, e.Got)
+}
+
+// crc32ElementSize is the size of a CRC-32 element.
+const crc32ElementSize = 6
+
+// verifyCRC32 verifies the CRC-32 element of the master element id, and
+// the CRC-32 elements of the master elements inside it. data is the data
+// of the element found at offset, which is -1 when it is unknown. report
+// is called with each mismatch, and verifyCRC32 stops when it returns an
+// error.
+func verifyCRC32(id schema.ElementID, offset int64, data []byte, report func(*CRC32Error) error) error {
+	def := validation().def
+	for pos, first := 0, true; pos < len(data); first = false {
+		childID, hl, ds, ok := parseElementHeader(data[pos:])
+		if !ok || ds == -1 || int64(len(data)-pos-hl) < ds {
+			// Unknown and invalid sizes are left to the decoder.
+			return nil
+		}
+		end := pos + hl + int(ds)
+		childOffset := int64(-1)
+		if offset != -1 {
+			childOffset = offset + int64(pos)
+		}
+		if el, known := def.Get(childID); !known {
+			// Nothing to verify.
+		} else if childID == ebml.IDCRC32 && first && ds == 4 {
+			want := binary.LittleEndian.Uint32(data[pos+hl : end])
+			if got := crc32.ChecksumIEEE(data[end:]); want != got {
+				if err := report(&CRC32Error{ID: id, Offset: childOffset, Want: want, Got: got}); err != nil {
+					return err
+				}
+			}
+		} else if el.Type == ebml.TypeMaster {
+			dataOffset := int64(-1)
+			if offset != -1 {
+				dataOffset = childOffset + int64(hl)
+			}
+			if err := verifyCRC32(childID, dataOffset, data[pos+hl:end], report); err != nil {
+				return err
+			}
+		}
+		pos = end
+	}
+	return nil
+}
+
+// appendCRC32 inserts a CRC-32 element at the beginning of the data of
+// the master element el, and appends the result to b.
+func appendCRC32(b []byte, el []byte) []byte {
+	id, hl, ds, ok := parseElementHeader(el)
+	if !ok || ds == -1 {
+		panic("matroska: cannot add CRC-32 to an element of unknown size")
+	}
+	data := el[hl : hl+int(ds)]
+	b = appendElementHeader(b, id, ds+crc32ElementSize)
+	b = appendElementHeader(b, ebml.IDCRC32, 4)
+	b = binary.LittleEndian.AppendUint32(b, crc32.ChecksumIEEE(data))
+	return append(b, data...)
+}
+
+// dataDecoder decodes master elements which are already read into memory.
+type dataDecoder struct {
+	r bytes.Reader
+	d *ebml.Decoder
+}
+
+func newDataDecoder(docType string) (*dataDecoder, error) {
+	dd := &dataDecoder{}
+	dd.r.Reset(appendHeader(nil, docType))
+	dd.d = ebml.NewDecoder(&dd.r)
+	if _, err := dd.d.DecodeHeader(); err != nil {
+		return nil, err
+	}
+	return dd, nil
+}
+
+// decode decodes data as the data of el into v.
+func (dd *dataDecoder) decode(el ebml.Element, data []byte, v any) error {
+	dd.r.Reset(data)
+	// The decoder remembers the EOF of the previous data until a relative
+	// seek resets it.
+	ss, _ := dd.d.AsSeeker()
+	for _, s := range []struct {
+		offset int64
+		whence int
+	}{{0, io.SeekStart}, {1, io.SeekCurrent}, {0, io.SeekStart}} {
+		if _, err := ss.Seek(s.offset, s.whence); err != nil {
+			return err
+		}
+	}
+	el.DataSize = int64(len(data))
+	return dd.d.Decode(el, v)
+}
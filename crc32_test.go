@@ -0,0 +1,108 @@
+package matroska
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestCRC32(t *testing.T) {
+	b, positions := testFile(t, WriterOptions{ClusterDuration: time.Second, CRC32: true})
+	s := NewScanner(bytes.NewReader(b))
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	var cues Cues
+	if ok, err := s.decodeTopLevel(IDCues, 0, &cues); !ok || err != nil {
+		t.Fatalf("could not decode Cues: %v", err)
+	}
+	for _, cp := range cues.CuePoint {
+		for _, tp := range cp.CueTrackPositions {
+			cluster := b[positions[0]+int(tp.CueClusterPosition):]
+			_, hl, _, _ := parseElementHeader(cluster)
+			if id, _, _, _ := parseElementHeader(cluster[hl+int(*tp.CueRelativePosition):]); id != IDSimpleBlock {
+				t.Errorf("CueRelativePosition %d points to %v", *tp.CueRelativePosition, id)
+			}
+		}
+	}
+	report, err := Validate(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Findings) != 0 {
+		t.Errorf("Validate() = %v", report.Findings)
+	}
+
+	// scan reads every Cluster of r and returns the number of blocks.
+	scan := func(t *testing.T, r io.Reader, policy CRC32Policy, report func(*CRC32Error)) (int, error) {
+		t.Helper()
+		s := NewScanner(r)
+		s.SetCRC32Policy(policy, report)
+		if err := s.Init(); err != nil {
+			return 0, err
+		}
+		if s.Info() == nil || s.Tracks() == nil {
+			t.Fatal("missing Info or Tracks")
+		}
+		n := 0
+		for s.Next() {
+			n += len(s.Cluster().SimpleBlock)
+		}
+		return n, s.Err()
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		n, err := scan(t, bytes.NewReader(b), CRC32Fail, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 100 {
+			t.Errorf("got %d blocks, want 100", n)
+		}
+	})
+	t.Run("NotSeekable", func(t *testing.T) {
+		n, err := scan(t, struct{ io.Reader }{bytes.NewReader(b)}, CRC32Fail, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 100 {
+			t.Errorf("got %d blocks, want 100", n)
+		}
+	})
+
+	damaged := bytes.Clone(b)
+	pos := bytes.Index(damaged, []byte("frame23"))
+	damaged[pos] = 'F'
+	t.Run("Ignore", func(t *testing.T) {
+		n, err := scan(t, bytes.NewReader(damaged), CRC32Ignore, func(*CRC32Error) {
+			t.Error("unexpected report")
+		})
+		if err != nil || n != 100 {
+			t.Errorf("got %d blocks, %v", n, err)
+		}
+	})
+	t.Run("Report", func(t *testing.T) {
+		var errs []*CRC32Error
+		n, err := scan(t, bytes.NewReader(damaged), CRC32Report, func(err *CRC32Error) {
+			errs = append(errs, err)
+		})
+		if err != nil || n != 100 {
+			t.Errorf("got %d blocks, %v", n, err)
+		}
+		if len(errs) != 1 {
+			t.Fatalf("got %d reports, want 1", len(errs))
+		}
+		if errs[0].ID != IDCluster || errs[0].Offset <= 0 || int(errs[0].Offset) > pos {
+			t.Errorf("report = %v", errs[0])
+		}
+	})
+	t.Run("Fail", func(t *testing.T) {
+		_, err := scan(t, bytes.NewReader(damaged), CRC32Fail, nil)
+		var crcErr *CRC32Error
+		if !errors.As(err, &crcErr) {
+			t.Fatalf("got %v, want a CRC32Error", err)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package matroska
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A Demuxer reads the tracks and the frames of a file which is not a
+// Matroska file.
+type Demuxer interface {
+	// Tracks returns the tracks of the file. The TrackNumber of each
+	// TrackEntry matches the TrackNumber of its frames.
+	Tracks() []TrackEntry
+	// ReadFrame returns the next frame of the file. It returns io.EOF
+	// after the last frame.
+	ReadFrame() (Frame, error)
+}
+
+// Remux writes the tracks and the frames of d into w as a Matroska file.
+func Remux(w io.WriteSeeker, d Demuxer, info Info, opts WriterOptions) error {
+	mw, err := NewWriter(w, info, Tracks{TrackEntry: d.Tracks()}, opts)
+	if err != nil {
+		return err
+	}
+	for {
+		f, err := d.ReadFrame()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("matroska: could not read frame: %w", err)
+		}
+		if err := mw.WriteFrame(f); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// Interleave returns a Demuxer reading the frames of ds interleaved by
+// timestamp. The frames of each Demuxer keep their order, so the frames
+// of a track stay in decoding order.
+//
+// The tracks are numbered in the order of ds. keep is called with the
+// index of the Demuxer and each of its tracks, and the track is dropped
+// when it returns false. keep may modify the track. All tracks are kept
+// when keep is nil. A TrackUID used by an earlier track is replaced by a
+// random one.
+//
+// trackUIDs maps the TrackUIDs of the kept tracks of each Demuxer to their
+// TrackUID in the returned Demuxer, which is useful to update the targets
+// of tags.
+func Interleave(ds []Demuxer, keep func(input int, t *TrackEntry) bool) (d Demuxer, trackUIDs []map[uint]uint) {
+	m := &interleaver{
+		ds:      ds,
+		numbers: make([]map[uint]uint, len(ds)),
+		heads:   make([]*Frame, len(ds)),
+		done:    make([]bool, len(ds)),
+	}
+	trackUIDs = make([]map[uint]uint, len(ds))
+	uids := make(map[uint]bool)
+	for i, d := range ds {
+		m.numbers[i] = make(map[uint]uint)
+		trackUIDs[i] = make(map[uint]uint)
+		for _, t := range d.Tracks() {
+			if keep != nil && !keep(i, &t) {
+				continue
+			}
+			uid := t.TrackUID
+			for uids[t.TrackUID] {
+				t.TrackUID = newUID()
+			}
+			uids[t.TrackUID] = true
+			trackUIDs[i][uid] = t.TrackUID
+			m.numbers[i][t.TrackNumber] = uint(len(m.tracks) + 1)
+			t.TrackNumber = uint(len(m.tracks) + 1)
+			m.tracks = append(m.tracks, t)
+		}
+		m.done[i] = len(m.numbers[i]) == 0
+	}
+	return m, trackUIDs
+}
+
+type interleaver struct {
+	ds     []Demuxer
+	tracks []TrackEntry
+	// numbers maps the TrackNumbers of the kept tracks of each Demuxer
+	// to their new TrackNumber.
+	numbers []map[uint]uint
+	// heads are the next frames of each Demuxer, and done reports
+	// whether a Demuxer does not have more frames.
+	heads []*Frame
+	done  []bool
+}
+
+func (m *interleaver) Tracks() []TrackEntry {
+	return m.tracks
+}
+
+func (m *interleaver) ReadFrame() (Frame, error) {
+	next := -1
+	for i, d := range m.ds {
+		for m.heads[i] == nil && !m.done[i] {
+			f, err := d.ReadFrame()
+			if errors.Is(err, io.EOF) {
+				m.done[i] = true
+				break
+			} else if err != nil {
+				return Frame{}, err
+			}
+			if n, ok := m.numbers[i][f.TrackNumber]; ok {
+				f.TrackNumber = n
+				m.heads[i] = &f
+			}
+		}
+		if m.heads[i] != nil && (next < 0 || m.heads[i].Timestamp < m.heads[next].Timestamp) {
+			next = i
+		}
+	}
+	if next < 0 {
+		return Frame{}, io.EOF
+	}
+	f := *m.heads[next]
+	m.heads[next] = nil
+	return f, nil
+}
@@ -0,0 +1,488 @@
+package matroska
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/coding-socks/matroska/internal/annexb"
+	"github.com/coding-socks/matroska/internal/h264"
+	"github.com/coding-socks/matroska/internal/hevc"
+	"io"
+	"math"
+	"slices"
+	"time"
+)
+
+// annexBDefaultDuration is the frame duration used when neither the
+// stream nor the caller specifies the frame rate. It is 25 frames per
+// second like in most muxers.
+const annexBDefaultDuration = 40 * time.Millisecond
+
+// annexBReorderWindow is the number of pictures looked at in both
+// directions to find the presentation order of a picture. It is larger
+// than the largest decoded picture buffer of H.264 and HEVC.
+const annexBReorderWindow = 32
+
+// AnnexBDemuxer reads an H.264 or HEVC elementary stream in the Annex B
+// byte stream format as a single video track.
+//
+// The parameter sets preceding the first picture are stored in the
+// CodecPrivate as a decoder configuration record, and the access units
+// are converted to NAL units prefixed with a 4-octet length. Parameter
+// sets repeated in the stream are only kept when they differ from the
+// ones in CodecPrivate. Access unit delimiters and filler data are
+// dropped.
+//
+// The stream has no timestamps, so they are computed from the frame
+// duration and the presentation order derived from the picture order
+// count of the pictures.
+type AnnexBDemuxer struct {
+	r        *annexb.Reader
+	codec    annexBCodec
+	tracks   []TrackEntry
+	duration time.Duration
+
+	// configured are the parameter sets stored in CodecPrivate.
+	configured [][]byte
+	au         annexBPicture
+	eof        bool
+
+	// pending are the pictures in decoding order waiting for the
+	// pictures following them to be read, and history are the picture
+	// order counts of the pictures which precede them in their group.
+	pending []annexBPicture
+	history []int
+	// A group of pictures starts at a picture which resets the picture
+	// order count. groupBase is the number of pictures before the group.
+	groupBase int
+	groupLen  int
+	frames    []Frame
+}
+
+type annexBPicture struct {
+	data     []byte
+	vcl      bool
+	keyframe bool
+	reset    bool
+	poc      int
+	index    int
+}
+
+// annexBNAL is the role of a NAL unit in an access unit.
+type annexBNAL struct {
+	parameterSet bool
+	// drop is set for NAL units which are not stored.
+	drop bool
+	// start is set for the NAL units which begin a new access unit when
+	// they follow a picture.
+	start bool
+	vcl   bool
+	// The following fields are set for the first slice of a picture.
+	firstSlice bool
+	keyframe   bool
+	reset      bool
+	poc        int
+}
+
+// annexBCodec parses the NAL units of a codec.
+type annexBCodec interface {
+	// parse returns the role of a NAL unit. Parameter sets are kept to
+	// parse the slices following them.
+	parse(nal []byte) (annexBNAL, error)
+	// configure sets the CodecPrivate and the Video element of the track
+	// from the parameter sets, and its DefaultDuration when the stream
+	// has timing information.
+	configure(t *TrackEntry, sets [][]byte) error
+}
+
+// NewH264Demuxer reads the parameter sets of an H.264 byte stream. The
+// frame duration is taken from defaultDuration when it is not zero,
+// otherwise from the timing information of the SPS. Without both, 25
+// frames per second is assumed.
+func NewH264Demuxer(r io.Reader, defaultDuration time.Duration) (*AnnexBDemuxer, error) {
+	c := &h264Codec{sps: make(map[uint]h264.SPS), pps: make(map[uint]h264.PPS)}
+	return newAnnexBDemuxer(r, c, VideoCodecMPEG4_ISO_AVC, defaultDuration)
+}
+
+// NewHEVCDemuxer reads the parameter sets of an HEVC byte stream. The
+// frame duration is taken from defaultDuration when it is not zero,
+// otherwise from the timing information of the SPS. Without both, 25
+// frames per second is assumed.
+func NewHEVCDemuxer(r io.Reader, defaultDuration time.Duration) (*AnnexBDemuxer, error) {
+	c := &hevcCodec{sps: make(map[uint]hevc.SPS), pps: make(map[uint]hevc.PPS), first: true}
+	return newAnnexBDemuxer(r, c, VideoCodecMPEGH_ISO_HEVC, defaultDuration)
+}
+
+func newAnnexBDemuxer(r io.Reader, c annexBCodec, codecID string, defaultDuration time.Duration) (*AnnexBDemuxer, error) {
+	d := &AnnexBDemuxer{r: annexb.NewReader(r), codec: c}
+	for !d.au.vcl {
+		nal, err := d.r.ReadNAL()
+		if err == io.EOF {
+			return nil, fmt.Errorf("matroska: %s stream has no picture", codecID)
+		} else if err != nil {
+			return nil, fmt.Errorf("matroska: could not read NAL unit: %w", err)
+		}
+		info, err := c.parse(nal)
+		if err != nil {
+			return nil, fmt.Errorf("matroska: could not parse NAL unit: %w", err)
+		}
+		if info.parameterSet && !slices.ContainsFunc(d.configured, func(b []byte) bool { return bytes.Equal(b, nal) }) {
+			d.configured = append(d.configured, nal)
+		}
+		d.add(nal, info)
+	}
+	t := NewTrackEntry(1, TrackTypeVideo, codecID)
+	if err := c.configure(&t, d.configured); err != nil {
+		return nil, fmt.Errorf("matroska: could not create decoder configuration: %w", err)
+	}
+	if defaultDuration > 0 {
+		duration := uint(defaultDuration)
+		t.DefaultDuration = &duration
+	} else if t.DefaultDuration == nil {
+		duration := uint(annexBDefaultDuration)
+		t.DefaultDuration = &duration
+	}
+	d.duration = time.Duration(*t.DefaultDuration)
+	d.tracks = []TrackEntry{t}
+	return d, nil
+}
+
+// Tracks returns the video track.
+func (d *AnnexBDemuxer) Tracks() []TrackEntry {
+	return d.tracks
+}
+
+// ReadFrame returns the next access unit in decoding order.
+func (d *AnnexBDemuxer) ReadFrame() (Frame, error) {
+	for len(d.frames) == 0 {
+		if d.eof {
+			return Frame{}, io.EOF
+		}
+		nal, err := d.r.ReadNAL()
+		if err == io.EOF {
+			d.eof = true
+			if d.au.vcl {
+				d.finish()
+			}
+			d.flush()
+			continue
+		} else if err != nil {
+			return Frame{}, fmt.Errorf("matroska: could not read NAL unit: %w", err)
+		}
+		info, err := d.codec.parse(nal)
+		if err != nil {
+			return Frame{}, fmt.Errorf("matroska: could not parse NAL unit: %w", err)
+		}
+		d.add(nal, info)
+	}
+	f := d.frames[0]
+	d.frames = d.frames[1:]
+	return f, nil
+}
+
+// add adds a NAL unit to the current access unit, or finishes it when
+// the NAL unit begins the next one.
+func (d *AnnexBDemuxer) add(nal []byte, info annexBNAL) {
+	if (info.start || info.firstSlice) && d.au.vcl {
+		d.finish()
+	}
+	if info.drop {
+		return
+	}
+	if info.parameterSet && slices.ContainsFunc(d.configured, func(b []byte) bool { return bytes.Equal(b, nal) }) {
+		return
+	}
+	if info.firstSlice {
+		d.au.keyframe, d.au.reset, d.au.poc = info.keyframe, info.reset, info.poc
+	}
+	d.au.vcl = d.au.vcl || info.vcl
+	d.au.data = binary.BigEndian.AppendUint32(d.au.data, uint32(len(nal)))
+	d.au.data = append(d.au.data, nal...)
+}
+
+// finish moves the current access unit to the pictures waiting for
+// their presentation order.
+func (d *AnnexBDemuxer) finish() {
+	p := d.au
+	d.au = annexBPicture{}
+	if p.reset {
+		d.flush()
+		d.groupBase += d.groupLen
+		d.groupLen = 0
+		d.history = d.history[:0]
+	}
+	p.index = d.groupLen
+	d.groupLen++
+	d.pending = append(d.pending, p)
+	if len(d.pending) > annexBReorderWindow {
+		d.emit()
+	}
+}
+
+func (d *AnnexBDemuxer) flush() {
+	for len(d.pending) > 0 {
+		d.emit()
+	}
+}
+
+// emit computes the presentation timestamp of the oldest pending picture
+// from the number of pictures of its group presented before it.
+func (d *AnnexBDemuxer) emit() {
+	p := d.pending[0]
+	d.pending = d.pending[1:]
+	rank := p.index
+	for _, poc := range d.history {
+		if poc > p.poc {
+			rank--
+		}
+	}
+	for _, q := range d.pending {
+		if q.poc < p.poc {
+			rank++
+		}
+	}
+	d.history = append(d.history, p.poc)
+	if len(d.history) > annexBReorderWindow {
+		d.history = d.history[1:]
+	}
+	d.frames = append(d.frames, Frame{
+		TrackNumber: 1,
+		Timestamp:   time.Duration(d.groupBase+max(rank, 0)) * d.duration,
+		Keyframe:    p.keyframe,
+		Data:        p.data,
+	})
+}
+
+// newAnnexBVideo returns the Video element of a picture with a sample
+// aspect ratio.
+func newAnnexBVideo(width, height, sarWidth, sarHeight uint) *Video {
+	v := &Video{}
+	setDefaults(v)
+	v.PixelWidth, v.PixelHeight = width, height
+	if sarWidth > 0 && sarHeight > 0 && sarWidth != sarHeight {
+		dw := uint(math.Round(float64(width) * float64(sarWidth) / float64(sarHeight)))
+		dh := height
+		v.DisplayWidth, v.DisplayHeight = &dw, &dh
+	}
+	return v
+}
+
+type h264Codec struct {
+	sps map[uint]h264.SPS
+	pps map[uint]h264.PPS
+
+	// prev is the first slice of the previous picture. paired is set when
+	// it is the second field of a frame.
+	prev     h264.SliceHeader
+	prevType int
+	picture  bool
+	paired   bool
+	// prevMsb and prevLsb are the picture order count of the previous
+	// reference picture, and count is the number of pictures since the
+	// last IDR picture.
+	prevMsb, prevLsb int
+	count            int
+}
+
+func (c *h264Codec) parse(nal []byte) (annexBNAL, error) {
+	switch t := h264.NALType(nal); t {
+	case h264.NALSPS:
+		s, err := h264.ParseSPS(nal)
+		if err != nil {
+			return annexBNAL{}, err
+		}
+		c.sps[s.ID] = s
+		return annexBNAL{parameterSet: true, start: true}, nil
+	case h264.NALPPS:
+		p, err := h264.ParsePPS(nal)
+		if err != nil {
+			return annexBNAL{}, err
+		}
+		c.pps[p.ID] = p
+		return annexBNAL{parameterSet: true, start: true}, nil
+	case h264.NALAUD:
+		return annexBNAL{drop: true, start: true}, nil
+	case h264.NALFiller:
+		return annexBNAL{drop: true}, nil
+	case h264.NALSEI, h264.NALPrefix, h264.NALSubsetSPS, 16, 17, 18:
+		return annexBNAL{start: true}, nil
+	case h264.NALSlice, h264.NALIDR:
+		h, err := h264.ParseSliceHeader(nal, c.sps, c.pps)
+		if err != nil {
+			return annexBNAL{}, err
+		}
+		info := annexBNAL{vcl: true}
+		if h.FirstMbInSlice != 0 {
+			return info, nil
+		}
+		// The second field of a frame is stored with the first one.
+		if c.picture && !c.paired && h.FieldPic && c.prev.FieldPic && h.FrameNum == c.prev.FrameNum &&
+			h.BottomField != c.prev.BottomField && (t != h264.NALIDR || c.prevType == h264.NALIDR) {
+			c.prev, c.paired = h, true
+			return info, nil
+		}
+		c.prev, c.prevType, c.picture, c.paired = h, t, true, false
+		info.firstSlice = true
+		info.keyframe = t == h264.NALIDR
+		info.reset = t == h264.NALIDR
+		if info.reset {
+			c.prevMsb, c.prevLsb, c.count = 0, 0, 0
+		}
+		s := c.sps[c.pps[h.PPSID].SPSID]
+		if s.PicOrderCntType != 0 {
+			// The other types do not reorder pictures in practice, the
+			// decoding order is used.
+			info.poc = c.count
+			c.count++
+			return info, nil
+		}
+		maxLsb := 1 << s.Log2MaxPicOrderCntLsb
+		lsb := int(h.PicOrderCntLsb)
+		msb := c.prevMsb
+		switch {
+		case lsb < c.prevLsb && c.prevLsb-lsb >= maxLsb/2:
+			msb += maxLsb
+		case lsb > c.prevLsb && lsb-c.prevLsb > maxLsb/2:
+			msb -= maxLsb
+		}
+		info.poc = msb + lsb
+		if nal[0]&0x60 != 0 { // nal_ref_idc
+			c.prevMsb, c.prevLsb = msb, lsb
+		}
+		return info, nil
+	default:
+		return annexBNAL{}, nil
+	}
+}
+
+func (c *h264Codec) configure(t *TrackEntry, sets [][]byte) error {
+	var sps, pps [][]byte
+	for _, nal := range sets {
+		switch h264.NALType(nal) {
+		case h264.NALSPS:
+			sps = append(sps, nal)
+		case h264.NALPPS:
+			pps = append(pps, nal)
+		}
+	}
+	b, err := h264.DecoderConfigurationRecord(sps, pps)
+	if err != nil {
+		return err
+	}
+	t.CodecPrivate = &b
+	s, err := h264.ParseSPS(sps[0])
+	if err != nil {
+		return err
+	}
+	t.Video = newAnnexBVideo(s.Width, s.Height, s.SARWidth, s.SARHeight)
+	if s.NumUnitsInTick > 0 && s.TimeScale > 0 {
+		duration := uint(2 * uint64(s.NumUnitsInTick) * uint64(time.Second) / uint64(s.TimeScale))
+		t.DefaultDuration = &duration
+	}
+	return nil
+}
+
+type hevcCodec struct {
+	sps map[uint]hevc.SPS
+	pps map[uint]hevc.PPS
+
+	// first is set until the first picture, and eos after an end of
+	// sequence. A CRA picture starts a new coded video sequence then.
+	first, eos bool
+	// prevTid0 is the picture order count of the previous picture with
+	// TemporalId 0 which is not a RASL, RADL or SLNR picture.
+	prevTid0 int
+}
+
+func (c *hevcCodec) parse(nal []byte) (annexBNAL, error) {
+	t := hevc.NALType(nal)
+	switch {
+	case t == hevc.NALVPS:
+		return annexBNAL{parameterSet: true, start: true}, nil
+	case t == hevc.NALSPS:
+		s, err := hevc.ParseSPS(nal)
+		if err != nil {
+			return annexBNAL{}, err
+		}
+		c.sps[s.ID] = s
+		return annexBNAL{parameterSet: true, start: true}, nil
+	case t == hevc.NALPPS:
+		p, err := hevc.ParsePPS(nal)
+		if err != nil {
+			return annexBNAL{}, err
+		}
+		c.pps[p.ID] = p
+		return annexBNAL{parameterSet: true, start: true}, nil
+	case t == hevc.NALAUD:
+		return annexBNAL{drop: true, start: true}, nil
+	case t == hevc.NALFiller:
+		return annexBNAL{drop: true}, nil
+	case t == hevc.NALEndOfSeq:
+		c.eos = true
+		return annexBNAL{}, nil
+	case t == hevc.NALPrefixSEI, t >= 41 && t <= 44, t >= 48 && t <= 55:
+		return annexBNAL{start: true}, nil
+	case hevc.IsVCL(t):
+		h, err := hevc.ParseSliceHeader(nal, c.sps, c.pps)
+		if err != nil {
+			return annexBNAL{}, err
+		}
+		info := annexBNAL{vcl: true}
+		if !h.FirstSliceSegmentInPic {
+			return info, nil
+		}
+		info.firstSlice = true
+		info.keyframe = hevc.IsIRAP(t)
+		info.reset = hevc.IsIDR(t) || (t >= hevc.NALBLA_W_LP && t <= hevc.NALBLA_N_LP) || (t == hevc.NALCRA && (c.first || c.eos))
+		c.first, c.eos = false, false
+		maxLsb := 1 << c.sps[c.pps[h.PPSID].SPSID].Log2MaxPicOrderCntLsb
+		lsb := int(h.PicOrderCntLsb)
+		msb := 0
+		if !info.reset {
+			prevLsb := c.prevTid0 & (maxLsb - 1)
+			msb = c.prevTid0 - prevLsb
+			switch {
+			case lsb < prevLsb && prevLsb-lsb >= maxLsb/2:
+				msb += maxLsb
+			case lsb > prevLsb && lsb-prevLsb > maxLsb/2:
+				msb -= maxLsb
+			}
+		}
+		info.poc = msb + lsb
+		if hevc.TemporalID(nal) == 0 && (t < hevc.NALRADL_N || t > hevc.NALRASL_R) && !hevc.IsSubLayerNonReference(t) {
+			c.prevTid0 = info.poc
+		}
+		return info, nil
+	default:
+		return annexBNAL{}, nil
+	}
+}
+
+func (c *hevcCodec) configure(t *TrackEntry, sets [][]byte) error {
+	var vps, sps, pps [][]byte
+	for _, nal := range sets {
+		switch hevc.NALType(nal) {
+		case hevc.NALVPS:
+			vps = append(vps, nal)
+		case hevc.NALSPS:
+			sps = append(sps, nal)
+		case hevc.NALPPS:
+			pps = append(pps, nal)
+		}
+	}
+	b, err := hevc.DecoderConfigurationRecord(vps, sps, pps)
+	if err != nil {
+		return err
+	}
+	t.CodecPrivate = &b
+	s, err := hevc.ParseSPS(sps[0])
+	if err != nil {
+		return err
+	}
+	t.Video = newAnnexBVideo(s.Width, s.Height, s.SARWidth, s.SARHeight)
+	if s.NumUnitsInTick > 0 && s.TimeScale > 0 {
+		duration := uint(uint64(s.NumUnitsInTick) * uint64(time.Second) / uint64(s.TimeScale))
+		t.DefaultDuration = &duration
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package matroska
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// bitWriter writes the syntax elements of the H.264, HEVC and AV1
+// bitstreams.
+type bitWriter struct {
+	b []byte
+	n int
+}
+
+func (w *bitWriter) u(v uint64, n int) *bitWriter {
+	for i := n - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.b = append(w.b, 0)
+		}
+		w.b[len(w.b)-1] |= byte(v>>i&1) << (7 - w.n%8)
+		w.n++
+	}
+	return w
+}
+
+func (w *bitWriter) ue(v uint64) *bitWriter {
+	v++
+	n := 0
+	for v>>n > 1 {
+		n++
+	}
+	return w.u(0, n).u(v, n+1)
+}
+
+// rbsp returns the data with the rbsp_trailing_bits.
+func (w *bitWriter) rbsp() []byte {
+	w.u(1, 1)
+	return w.b
+}
+
+// nal returns a NAL unit with a header and emulation prevention.
+func nal(header []byte, rbsp []byte) []byte {
+	b := append([]byte(nil), header...)
+	zeros := 0
+	for _, c := range rbsp {
+		if zeros >= 2 && c <= 3 {
+			b = append(b, 3)
+			zeros = 0
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+func annexBStream(nals ...[]byte) []byte {
+	var b []byte
+	for i, n := range nals {
+		if i%2 == 0 {
+			b = append(b, 0) // 4-octet start code
+		}
+		b = append(b, 0, 0, 1)
+		b = append(b, n...)
+	}
+	return b
+}
+
+func TestH264Demuxer(t *testing.T) {
+	sps := nal([]byte{0x67}, new(bitWriter).
+		u(100, 8).u(0, 8).u(40, 8).ue(0).         // profile_idc, constraint flags, level_idc, seq_parameter_set_id
+		ue(1).ue(0).ue(0).u(0, 1).u(0, 1).        // chroma_format_idc, bit depths, qpprime_y_zero_transform_bypass_flag, seq_scaling_matrix_present_flag
+		ue(0).ue(0).ue(0).                        // log2_max_frame_num_minus4, pic_order_cnt_type, log2_max_pic_order_cnt_lsb_minus4
+		ue(1).u(0, 1).ue(119).ue(67).             // max_num_ref_frames, gaps, 1920x1088
+		u(1, 1).u(1, 1).                          // frame_mbs_only_flag, direct_8x8_inference_flag
+		u(1, 1).ue(0).ue(0).ue(0).ue(4).          // frame_cropping_flag and offsets
+		u(1, 1).u(1, 1).u(14, 8).                 // vui_parameters_present_flag, aspect_ratio_idc 4:3
+		u(0, 1).u(0, 1).u(0, 1).                  // overscan, video signal type, chroma location
+		u(1, 1).u(1001, 32).u(48000, 32).u(1, 1). // timing information
+		u(0, 5).rbsp())
+	pps := nal([]byte{0x68}, new(bitWriter).ue(0).ue(0).u(0, 4).rbsp())
+	sei := []byte{0x06, 0x05, 0x01, 0xAA, 0x80}
+	aud := []byte{0x09, 0xF0}
+	slice := func(header byte, firstMb uint64, frameNum, lsb uint64) []byte {
+		w := new(bitWriter).ue(firstMb).ue(0).ue(0).u(frameNum, 4)
+		if header&0x1F == 5 {
+			w.ue(0) // idr_pic_id
+		}
+		return nal([]byte{header}, w.u(lsb, 4).u(0xAB, 8).rbsp())
+	}
+	const (
+		idr    = 0x65
+		ref    = 0x41
+		nonRef = 0x01
+	)
+	stream := annexBStream(
+		aud, sps, pps, sei, slice(idr, 0, 0, 0), slice(idr, 60, 0, 0),
+		slice(ref, 0, 1, 6), slice(nonRef, 0, 2, 2), slice(nonRef, 0, 2, 4),
+		slice(ref, 0, 2, 12), slice(nonRef, 0, 3, 8), slice(nonRef, 0, 3, 10),
+		// The picture order count wraps around.
+		slice(ref, 0, 3, 2), slice(nonRef, 0, 4, 14), slice(nonRef, 0, 4, 0),
+		aud, sps, pps, slice(idr, 0, 0, 0),
+	)
+
+	d, err := NewH264Demuxer(bytes.NewReader(stream), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := d.Tracks()[0]
+	if track.CodecID != VideoCodecMPEG4_ISO_AVC {
+		t.Errorf("CodecID = %s, want %s", track.CodecID, VideoCodecMPEG4_ISO_AVC)
+	}
+	var want []byte
+	want = append(want, 1, 100, 0, 40, 0xFF, 0xE1)
+	want = binary.BigEndian.AppendUint16(want, uint16(len(sps)))
+	want = append(want, sps...)
+	want = append(want, 1)
+	want = binary.BigEndian.AppendUint16(want, uint16(len(pps)))
+	want = append(want, pps...)
+	want = append(want, 0xFD, 0xF8, 0xF8, 0)
+	if got := *track.CodecPrivate; !bytes.Equal(got, want) {
+		t.Errorf("CodecPrivate = % x, want % x", got, want)
+	}
+	if v := track.Video; v.PixelWidth != 1920 || v.PixelHeight != 1080 || v.DisplayWidth == nil || *v.DisplayWidth != 2560 {
+		t.Errorf("Video = %+v, want 1920x1080 displayed as 2560x1080", v)
+	}
+	duration := time.Duration(41708333)
+	if got := time.Duration(*track.DefaultDuration); got != duration {
+		t.Errorf("DefaultDuration = %v, want %v", got, duration)
+	}
+
+	frames := readFrames(t, d)
+	order := []int{0, 3, 1, 2, 6, 4, 5, 9, 7, 8, 10}
+	if len(frames) != len(order) {
+		t.Fatalf("got %d frames, want %d", len(frames), len(order))
+	}
+	for i, n := range order {
+		if got, want := frames[i].Timestamp, time.Duration(n)*duration; got != want {
+			t.Errorf("frame %d: Timestamp = %v, want %v", i, got, want)
+		}
+		if got, want := frames[i].Keyframe, i == 0 || i == len(order)-1; got != want {
+			t.Errorf("frame %d: Keyframe = %v, want %v", i, got, want)
+		}
+	}
+	var first []byte
+	for _, n := range [][]byte{sei, slice(idr, 0, 0, 0), slice(idr, 60, 0, 0)} {
+		first = binary.BigEndian.AppendUint32(first, uint32(len(n)))
+		first = append(first, n...)
+	}
+	if !bytes.Equal(frames[0].Data, first) {
+		t.Errorf("first frame = % x, want % x", frames[0].Data, first)
+	}
+	// The repeated parameter sets and the delimiter are dropped.
+	if got, want := len(frames[len(frames)-1].Data), 4+len(slice(idr, 0, 0, 0)); got != want {
+		t.Errorf("last frame has %d octets, want %d", got, want)
+	}
+
+	d, err = NewH264Demuxer(bytes.NewReader(stream), 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := time.Duration(*d.Tracks()[0].DefaultDuration); got != 20*time.Millisecond {
+		t.Errorf("DefaultDuration = %v, want 20ms", got)
+	}
+}
+
+func TestHEVCDemuxer(t *testing.T) {
+	vps := []byte{0x40, 0x01, 0x0C, 0x01, 0xFF, 0xFF}
+	w := new(bitWriter).
+		u(0, 4).u(0, 3).u(1, 1).                         // sps_video_parameter_set_id, sps_max_sub_layers_minus1, sps_temporal_id_nesting_flag
+		u(0, 2).u(0, 1).u(1, 5).u(0x60000000, 32).       // general profile
+		u(0x9, 4).u(0, 44).u(120, 8).                    // general constraint flags and level
+		ue(0).ue(1).ue(1920).ue(1088).                   // sps_seq_parameter_set_id, chroma_format_idc, size
+		u(1, 1).ue(0).ue(0).ue(0).ue(4).                 // conformance_window_flag and offsets
+		ue(0).ue(0).ue(4).                               // bit depths, log2_max_pic_order_cnt_lsb_minus4
+		u(1, 1).ue(4).ue(2).ue(0).                       // sub layer ordering info
+		ue(0).ue(1).ue(0).ue(3).ue(0).ue(0).             // block sizes and transform hierarchy
+		u(0, 1).u(1, 1).u(1, 1).u(0, 1).                 // scaling_list_enabled_flag, amp, sao, pcm
+		ue(2).                                           // num_short_term_ref_pic_sets
+		ue(1).ue(0).ue(0).u(1, 1).                       // st_ref_pic_set(0)
+		u(1, 1).u(0, 1).ue(0).u(1, 1).u(0, 1).u(1, 1).   // st_ref_pic_set(1) predicted from st_ref_pic_set(0)
+		u(0, 1).u(1, 1).u(1, 1).                         // long term, temporal mvp, strong intra smoothing
+		u(1, 1).u(1, 1).u(1, 8).                         // vui_parameters_present_flag, aspect_ratio_idc 1:1
+		u(0, 1).u(0, 1).u(0, 1).                         // overscan, video signal type, chroma location
+		u(0, 3).u(0, 1).                                 // neutral chroma, field seq, frame field info, default display window
+		u(1, 1).u(1001, 32).u(24000, 32).u(0, 1).u(0, 1) // timing information
+	sps := nal([]byte{0x42, 0x01}, w.u(0, 1).rbsp())
+	pps := nal([]byte{0x44, 0x01}, new(bitWriter).ue(0).ue(0).u(0, 1).u(0, 1).u(0, 3).u(0, 5).rbsp())
+	slice := func(typ int, first bool, sliceType, lsb uint64) []byte {
+		w := new(bitWriter)
+		if first {
+			w.u(1, 1)
+		} else {
+			w.u(0, 1)
+		}
+		if typ >= 16 && typ <= 23 {
+			w.u(0, 1) // no_output_of_prior_pics_flag
+		}
+		w.ue(0)
+		if first {
+			w.ue(sliceType)
+			if typ != 19 && typ != 20 {
+				w.u(lsb, 8)
+			}
+		}
+		return nal([]byte{byte(typ << 1), 0x01}, w.u(0x5A, 8).rbsp())
+	}
+	stream := annexBStream(
+		vps, sps, pps, slice(19, true, 2, 0), slice(19, false, 0, 0),
+		slice(1, true, 1, 3), slice(0, true, 0, 1), slice(0, true, 0, 2),
+		// The RASL pictures of a CRA picture which does not start the
+		// stream are presented before it.
+		slice(21, true, 2, 6), slice(8, true, 0, 4), slice(8, true, 0, 5),
+	)
+	d, err := NewHEVCDemuxer(bytes.NewReader(stream), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := d.Tracks()[0]
+	if track.CodecID != VideoCodecMPEGH_ISO_HEVC {
+		t.Errorf("CodecID = %s, want %s", track.CodecID, VideoCodecMPEGH_ISO_HEVC)
+	}
+	want := []byte{1, 0x01, 0x60, 0, 0, 0, 0x90, 0, 0, 0, 0, 0, 120, 0xF0, 0, 0xFC, 0xFD, 0xF8, 0xF8, 0, 0, 0x0F, 3, 0xA0, 0, 1}
+	want = binary.BigEndian.AppendUint16(want, uint16(len(vps)))
+	want = append(want, vps...)
+	if got := *track.CodecPrivate; !bytes.HasPrefix(got, want) {
+		t.Errorf("CodecPrivate = % x, want prefix % x", got, want)
+	}
+	if v := track.Video; v.PixelWidth != 1920 || v.PixelHeight != 1080 || v.DisplayWidth != nil {
+		t.Errorf("Video = %+v, want 1920x1080", v)
+	}
+	duration := time.Duration(41708333)
+	if got := time.Duration(*track.DefaultDuration); got != duration {
+		t.Errorf("DefaultDuration = %v, want %v", got, duration)
+	}
+	frames := readFrames(t, d)
+	order := []int{0, 3, 1, 2, 6, 4, 5}
+	keyframes := []bool{true, false, false, false, true, false, false}
+	if len(frames) != len(order) {
+		t.Fatalf("got %d frames, want %d", len(frames), len(order))
+	}
+	for i, n := range order {
+		if got, want := frames[i].Timestamp, time.Duration(n)*duration; got != want {
+			t.Errorf("frame %d: Timestamp = %v, want %v", i, got, want)
+		}
+		if frames[i].Keyframe != keyframes[i] {
+			t.Errorf("frame %d: Keyframe = %v, want %v", i, frames[i].Keyframe, keyframes[i])
+		}
+	}
+	if got, want := len(frames[0].Data), 8+len(slice(19, true, 2, 0))+len(slice(19, false, 0, 0)); got != want {
+		t.Errorf("first frame has %d octets, want %d", got, want)
+	}
+}
@@ -0,0 +1,607 @@
+package matroska
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/coding-socks/matroska/internal/bitstream"
+	"io"
+	"math/bits"
+	"slices"
+)
+
+// AudioDemuxer reads the frames of an ADTS AAC, MPEG audio, AC-3 or FLAC
+// elementary stream as a single audio track.
+//
+// Block timestamps are computed from the number of samples preceding a
+// frame. Octets which do not belong to a frame, such as an ID3 tag, are
+// skipped.
+type AudioDemuxer struct {
+	r      io.Reader
+	buf    []byte
+	eof    bool
+	tracks []TrackEntry
+
+	// read returns the next frame of the stream.
+	read func() (audioFrame, error)
+	// sync is the first octet of a frame, and parse parses the
+	// headerSize octets of a frame header of a stream using sync words.
+	sync       byte
+	headerSize int
+	parse      func(b []byte) (audioFrame, bool)
+	// first is the first frame which was found, and next is the frame
+	// which is read by the constructor to configure the track.
+	first *audioFrame
+	next  *audioFrame
+
+	// rate is the sampling frequency, frameSamples is the number of
+	// samples of the DefaultDuration, and samples is the number of
+	// samples which were read.
+	rate         uint64
+	frameSamples uint64
+	samples      uint64
+}
+
+// An audioFrame is a frame of an elementary stream.
+type audioFrame struct {
+	codecID  string
+	rate     uint64
+	channels uint
+	samples  uint64
+	// header is the number of octets which are not stored in the Block,
+	// and size is the length of data.
+	header, size int
+	data         []byte
+}
+
+// NewADTSDemuxer reads the first frame of an AAC stream using the Audio
+// Data Transport Stream format. The AudioSpecificConfig of the track is
+// built from the first ADTS header, and the headers are not stored in
+// the Blocks.
+func NewADTSDemuxer(r io.Reader) (*AudioDemuxer, error) {
+	d, err := newSyncAudioDemuxer(r, 0xFF, 7, parseADTSHeader)
+	if err != nil {
+		return nil, err
+	}
+	h := d.next.data
+	switch {
+	case d.next.channels == 0:
+		return nil, errors.New("matroska: ADTS program config elements are not supported")
+	case d.next.samples != 1024:
+		return nil, errors.New("matroska: ADTS frames with several raw data blocks are not supported")
+	}
+	// audioObjectType, samplingFrequencyIndex and channelConfiguration
+	// followed by a GASpecificConfig without flags.
+	profile, sfi, cc := uint16(h[2]>>6), uint16(h[2]>>2&0x0F), uint16(h[2]&0x01)<<2|uint16(h[3]>>6)
+	cp := binary.BigEndian.AppendUint16(nil, (profile+1)<<11|sfi<<7|cc<<3)
+	d.tracks[0].CodecPrivate = &cp
+	return d, nil
+}
+
+// NewMPEGAudioDemuxer reads the first frame of an MPEG-1, MPEG-2 or
+// MPEG-2.5 Layer I, II or III stream. A leading Xing, Info or VBRI frame
+// is not stored.
+func NewMPEGAudioDemuxer(r io.Reader) (*AudioDemuxer, error) {
+	d, err := newSyncAudioDemuxer(r, 0xFF, 4, parseMPEGAudioHeader)
+	if err != nil {
+		return nil, err
+	}
+	if mpegAudioInfoFrame(d.next.data) {
+		f, err := d.read()
+		if err == io.EOF {
+			d.next = nil
+		} else if err != nil {
+			return nil, err
+		} else {
+			d.next = &f
+		}
+	}
+	return d, nil
+}
+
+// NewAC3Demuxer reads the first syncframe of an AC-3 stream.
+func NewAC3Demuxer(r io.Reader) (*AudioDemuxer, error) {
+	return newSyncAudioDemuxer(r, 0x0B, 8, parseAC3Header)
+}
+
+// NewFLACDemuxer reads the metadata blocks of a FLAC stream. They are
+// stored in the CodecPrivate, except for the PADDING blocks.
+func NewFLACDemuxer(r io.Reader) (*AudioDemuxer, error) {
+	d := &AudioDemuxer{r: r}
+	d.read = d.flacFrame
+	if err := d.skipID3v2(); err != nil {
+		return nil, err
+	}
+	if err := d.fill(4); err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(d.buf, []byte("fLaC")) {
+		return nil, errors.New("matroska: missing fLaC marker")
+	}
+	d.buf = d.buf[4:]
+	cp := []byte("fLaC")
+	var info []byte
+	last := -1
+	for done := false; !done; {
+		if err := d.fill(4); err != nil {
+			return nil, err
+		}
+		if len(d.buf) < 4 {
+			return nil, fmt.Errorf("matroska: could not read FLAC metadata block: %w", io.ErrUnexpectedEOF)
+		}
+		done = d.buf[0]&0x80 != 0
+		n := 4 + (int(d.buf[1])<<16 | int(d.buf[2])<<8 | int(d.buf[3]))
+		if err := d.fill(n); err != nil {
+			return nil, err
+		}
+		if len(d.buf) < n {
+			return nil, fmt.Errorf("matroska: could not read FLAC metadata block: %w", io.ErrUnexpectedEOF)
+		}
+		b := d.buf[:n]
+		d.buf = d.buf[n:]
+		switch b[0] & 0x7F {
+		case 0: // STREAMINFO
+			info = b[4:]
+		case 1: // PADDING
+			continue
+		}
+		last = len(cp)
+		cp = append(cp, b[0]&0x7F)
+		cp = append(cp, b[1:]...)
+	}
+	if len(info) < 34 {
+		return nil, errors.New("matroska: missing FLAC STREAMINFO")
+	}
+	cp[last] |= 0x80
+	rate, channels, depth := flacStreamInfo(info)
+	if rate == 0 {
+		return nil, errors.New("matroska: invalid FLAC sample rate")
+	}
+	d.rate = rate
+	t := newAudioTrackEntry(1, AudioCodecFLAC, float64(rate), channels)
+	t.Audio.BitDepth = &depth
+	t.CodecPrivate = &cp
+	// Only the last block of a stream using a fixed block size may be
+	// shorter.
+	if minBlock, maxBlock := binary.BigEndian.Uint16(info[0:2]), binary.BigEndian.Uint16(info[2:4]); minBlock == maxBlock {
+		d.frameSamples = uint64(minBlock)
+		duration := uint(granuleDuration(int64(minBlock), 1, rate))
+		t.DefaultDuration = &duration
+	}
+	d.tracks = []TrackEntry{t}
+	return d, nil
+}
+
+func newSyncAudioDemuxer(r io.Reader, sync byte, headerSize int, parse func(b []byte) (audioFrame, bool)) (*AudioDemuxer, error) {
+	d := &AudioDemuxer{r: r, sync: sync, headerSize: headerSize, parse: parse}
+	d.read = d.syncFrame
+	if err := d.skipID3v2(); err != nil {
+		return nil, err
+	}
+	f, err := d.read()
+	if err == io.EOF {
+		return nil, errors.New("matroska: no audio frame found")
+	} else if err != nil {
+		return nil, err
+	}
+	d.next = &f
+	d.rate, d.frameSamples = f.rate, f.samples
+	t := newAudioTrackEntry(1, f.codecID, float64(f.rate), f.channels)
+	duration := uint(granuleDuration(int64(f.samples), 1, f.rate))
+	t.DefaultDuration = &duration
+	d.tracks = []TrackEntry{t}
+	return d, nil
+}
+
+// Tracks returns the audio track.
+func (d *AudioDemuxer) Tracks() []TrackEntry {
+	return d.tracks
+}
+
+// ReadFrame returns the next frame. Frames which do not have the number
+// of samples of the DefaultDuration have a Duration.
+func (d *AudioDemuxer) ReadFrame() (Frame, error) {
+	var f audioFrame
+	if d.next != nil {
+		f, d.next = *d.next, nil
+	} else {
+		var err error
+		if f, err = d.read(); err != nil {
+			return Frame{}, err
+		}
+	}
+	ts := granuleDuration(int64(d.samples), 1, d.rate)
+	frame := Frame{TrackNumber: 1, Timestamp: ts, Keyframe: true, Data: f.data[f.header:]}
+	d.samples += f.samples
+	if f.samples != d.frameSamples {
+		frame.Duration = granuleDuration(int64(d.samples), 1, d.rate) - ts
+	}
+	return frame, nil
+}
+
+// fill reads until the buffer holds n octets or the end of the stream is
+// reached.
+func (d *AudioDemuxer) fill(n int) error {
+	for len(d.buf) < n && !d.eof {
+		d.buf = slices.Grow(d.buf, max(n-len(d.buf), 64<<10))
+		k, err := d.r.Read(d.buf[len(d.buf):cap(d.buf)])
+		d.buf = d.buf[:len(d.buf)+k]
+		if err == io.EOF {
+			d.eof = true
+		} else if err != nil {
+			return fmt.Errorf("matroska: could not read audio stream: %w", err)
+		}
+	}
+	return nil
+}
+
+// skipID3v2 skips the ID3v2 tag at the beginning of the stream.
+func (d *AudioDemuxer) skipID3v2() error {
+	if err := d.fill(10); err != nil {
+		return err
+	}
+	b := d.buf
+	if len(b) < 10 || string(b[:3]) != "ID3" || b[6]|b[7]|b[8]|b[9] >= 0x80 {
+		return nil
+	}
+	// The size is a synchsafe integer which does not include the header
+	// and the footer.
+	n := 10 + (int(b[6])<<21 | int(b[7])<<14 | int(b[8])<<7 | int(b[9]))
+	if b[5]&0x10 != 0 {
+		n += 10
+	}
+	for n > 0 {
+		if err := d.fill(min(n, 64<<10)); err != nil {
+			return err
+		}
+		if len(d.buf) == 0 {
+			return nil
+		}
+		k := min(n, len(d.buf))
+		d.buf = d.buf[k:]
+		n -= k
+	}
+	return nil
+}
+
+// syncFrame returns the next frame of a stream using sync words. When
+// octets were skipped to find a frame, the frame must be followed by
+// another one to avoid false sync words.
+func (d *AudioDemuxer) syncFrame() (audioFrame, error) {
+	skipped := d.first == nil
+	for {
+		if err := d.fill(d.headerSize); err != nil {
+			return audioFrame{}, err
+		}
+		if len(d.buf) < d.headerSize {
+			return audioFrame{}, io.EOF
+		}
+		f, ok := d.parse(d.buf)
+		ok = ok && d.compatible(f)
+		if ok {
+			n := f.size
+			if skipped {
+				n += d.headerSize
+			}
+			if err := d.fill(n); err != nil {
+				return audioFrame{}, err
+			}
+			ok = len(d.buf) >= f.size
+			if ok && skipped && len(d.buf) >= n {
+				g, next := d.parse(d.buf[f.size:])
+				ok = next && g.codecID == f.codecID && g.rate == f.rate
+			}
+		}
+		if ok {
+			f.data = d.buf[:f.size:f.size]
+			d.buf = d.buf[f.size:]
+			if d.first == nil {
+				d.first = &f
+			}
+			return f, nil
+		}
+		// Skip to the next octet which could start a frame. A truncated
+		// last frame is dropped.
+		if i := bytes.IndexByte(d.buf[1:], d.sync); i >= 0 {
+			d.buf = d.buf[i+1:]
+		} else {
+			d.buf = d.buf[:0]
+		}
+		skipped = true
+	}
+}
+
+// compatible reports whether f belongs to the same stream as the first
+// frame.
+func (d *AudioDemuxer) compatible(f audioFrame) bool {
+	return d.first == nil || f.codecID == d.first.codecID && f.rate == d.first.rate && f.channels == d.first.channels
+}
+
+// flacFrame returns the next FLAC frame. A frame ends where a valid
+// frame header follows a valid CRC-16 footer.
+func (d *AudioDemuxer) flacFrame() (audioFrame, error) {
+	const maxHeaderSize = 16
+	var n int
+	for {
+		if err := d.fill(maxHeaderSize); err != nil {
+			return audioFrame{}, err
+		}
+		if len(d.buf) == 0 {
+			return audioFrame{}, io.EOF
+		}
+		var ok bool
+		if n, ok = flacFrameHeader(d.buf); ok {
+			break
+		}
+		if i := bytes.IndexByte(d.buf[1:], 0xFF); i >= 0 {
+			d.buf = d.buf[i+1:]
+		} else {
+			d.buf = d.buf[:0]
+		}
+	}
+	size := -1
+	for i := n; size < 0; {
+		j := bytes.IndexByte(d.buf[i:], 0xFF)
+		switch {
+		case j < 0 && d.eof:
+			size = len(d.buf)
+		case j < 0:
+			i = len(d.buf)
+			if err := d.fill(len(d.buf) + 1); err != nil {
+				return audioFrame{}, err
+			}
+		case i+j+maxHeaderSize > len(d.buf) && !d.eof:
+			if err := d.fill(i + j + maxHeaderSize); err != nil {
+				return audioFrame{}, err
+			}
+		default:
+			p := i + j
+			// The blocking strategy of a stream does not change.
+			if _, ok := flacFrameHeader(d.buf[p:]); ok && d.buf[p+1] == d.buf[1] && flacCRC16(d.buf[:p]) == 0 {
+				size = p
+			}
+			i = p + 1
+		}
+	}
+	f := audioFrame{size: size, data: d.buf[:size:size]}
+	f.samples = uint64(flacFrameSamples(f.data))
+	d.buf = d.buf[size:]
+	return f, nil
+}
+
+var adtsSampleRates = [...]uint64{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// parseADTSHeader parses the fixed and the variable header of an ADTS
+// frame.
+func parseADTSHeader(b []byte) (audioFrame, bool) {
+	// syncword and a layer of zero
+	if b[0] != 0xFF || b[1]&0xF6 != 0xF0 {
+		return audioFrame{}, false
+	}
+	sfi := b[2] >> 2 & 0x0F
+	if int(sfi) >= len(adtsSampleRates) {
+		return audioFrame{}, false
+	}
+	f := audioFrame{
+		codecID: AudioCodecAAC,
+		rate:    adtsSampleRates[sfi],
+		samples: 1024 * (uint64(b[6]&0x03) + 1),
+		header:  7,
+		size:    int(b[3]&0x03)<<11 | int(b[4])<<3 | int(b[5])>>5,
+	}
+	if b[1]&0x01 == 0 { // protection_absent
+		f.header += 2
+	}
+	if f.channels = uint(b[2]&0x01)<<2 | uint(b[3]>>6); f.channels == 7 {
+		f.channels = 8
+	}
+	return f, f.size > f.header
+}
+
+var (
+	mpegAudioSampleRates = [...]uint64{44100, 48000, 32000}
+	// mpegAudioBitrates are the bitrates in kbit/s of MPEG-1 Layer I, II
+	// and III, and of MPEG-2 Layer I, and II and III.
+	mpegAudioBitrates = [...][15]uint64{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	}
+)
+
+// parseMPEGAudioHeader parses the header of an MPEG audio frame. Free
+// format frames are not supported.
+func parseMPEGAudioHeader(b []byte) (audioFrame, bool) {
+	version, layer := b[1]>>3&0x03, 4-b[1]>>1&0x03
+	bri, sri := b[2]>>4, b[2]>>2&0x03
+	if b[0] != 0xFF || b[1]&0xE0 != 0xE0 || version == 1 || layer == 4 || bri == 0 || bri == 15 || sri == 3 || b[3]&0x03 == 2 {
+		return audioFrame{}, false
+	}
+	f := audioFrame{rate: mpegAudioSampleRates[sri], channels: 2}
+	if b[3]>>6 == 3 {
+		f.channels = 1
+	}
+	var br uint64
+	if version == 3 {
+		br = mpegAudioBitrates[layer-1][bri]
+	} else {
+		// MPEG-2 and MPEG-2.5 use half and a quarter of the sample
+		// rates.
+		f.rate >>= 1
+		if version == 0 {
+			f.rate >>= 1
+		}
+		br = mpegAudioBitrates[3+min(layer-1, 1)][bri]
+	}
+	br *= 1000
+	padding := uint64(b[2] >> 1 & 0x01)
+	switch layer {
+	case 1:
+		f.codecID, f.samples = AudioCodecMP1, 384
+		f.size = int((12*br/f.rate + padding) * 4)
+	case 2:
+		f.codecID, f.samples = AudioCodecMP2, 1152
+		f.size = int(144*br/f.rate + padding)
+	case 3:
+		f.codecID, f.samples = AudioCodecMP3, 1152
+		f.size = int(144*br/f.rate + padding)
+		if version != 3 {
+			f.samples = 576
+			f.size = int(72*br/f.rate + padding)
+		}
+	}
+	return f, true
+}
+
+// mpegAudioInfoFrame reports whether b is a Layer III frame holding a
+// Xing, Info or VBRI header instead of audio data.
+func mpegAudioInfoFrame(b []byte) bool {
+	if len(b) < 4 || b[1]>>1&0x03 != 1 {
+		return false
+	}
+	// The Xing header follows the side information.
+	i := 4 + 32
+	switch mpeg1, mono := b[1]>>3&0x03 == 3, b[3]>>6 == 3; {
+	case mpeg1 && mono, !mpeg1 && !mono:
+		i = 4 + 17
+	case !mpeg1 && mono:
+		i = 4 + 9
+	}
+	if b[1]&0x01 == 0 {
+		i += 2
+	}
+	has := func(i int, tag string) bool {
+		return len(b) >= i+len(tag) && string(b[i:i+len(tag)]) == tag
+	}
+	return has(i, "Xing") || has(i, "Info") || has(4+32, "VBRI")
+}
+
+var (
+	ac3SampleRates = [...]uint64{48000, 44100, 32000}
+	// ac3Bitrates are the bitrates in kbit/s of frmsizecod / 2.
+	ac3Bitrates = [...]uint64{32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 448, 512, 576, 640}
+	// ac3Channels are the number of full bandwidth channels of acmod.
+	ac3Channels = [...]uint{2, 1, 2, 3, 3, 4, 4, 5}
+)
+
+// parseAC3Header parses the syncinfo and the beginning of the bit stream
+// information of an AC-3 syncframe.
+func parseAC3Header(b []byte) (audioFrame, bool) {
+	fscod, frmsizecod, bsid := b[4]>>6, b[4]&0x3F, b[5]>>3
+	if b[0] != 0x0B || b[1] != 0x77 || fscod == 3 || int(frmsizecod/2) >= len(ac3Bitrates) || bsid > 8 {
+		return audioFrame{}, false
+	}
+	f := audioFrame{codecID: AudioCodecAC3, rate: ac3SampleRates[fscod], samples: 1536}
+	// Each syncframe holds 1536 samples in 16-bit words, and a frame
+	// at 44.1 kHz is padded with a word when frmsizecod is odd.
+	words := ac3Bitrates[frmsizecod/2] * 1000 * 96 / f.rate
+	if fscod == 1 {
+		words += uint64(frmsizecod & 0x01)
+	}
+	f.size = int(words * 2)
+	r := bitstream.NewReader(b[6:8])
+	acmod := r.ReadBits(3)
+	if acmod&0x01 != 0 && acmod != 1 {
+		r.Skip(2) // cmixlev
+	}
+	if acmod&0x04 != 0 {
+		r.Skip(2) // surmixlev
+	}
+	if acmod == 2 {
+		r.Skip(2) // dsurmod
+	}
+	f.channels = ac3Channels[acmod]
+	if r.ReadFlag() { // lfeon
+		f.channels++
+	}
+	return f, true
+}
+
+// flacStreamInfo returns the sample rate, the number of channels and the
+// bits per sample of a STREAMINFO metadata block.
+func flacStreamInfo(info []byte) (rate uint64, channels, depth uint) {
+	rate = uint64(info[10])<<12 | uint64(info[11])<<4 | uint64(info[12])>>4
+	channels = uint(info[12]>>1&0x07) + 1
+	depth = uint(info[12]&0x01)<<4 | uint(info[13]>>4) + 1
+	return rate, channels, depth
+}
+
+// flacFrameHeader returns the length of the FLAC frame header at the
+// beginning of b, and reports whether it is valid.
+func flacFrameHeader(b []byte) (int, bool) {
+	if len(b) < 5 || b[0] != 0xFF || b[1]&0xFE != 0xF8 {
+		return 0, false
+	}
+	// block size, sample rate, channel assignment and sample size
+	if b[2]>>4 == 0 || b[2]&0x0F == 0x0F || b[3]>>4 > 10 || b[3]>>1&0x07 == 3 || b[3]&0x01 != 0 {
+		return 0, false
+	}
+	// The frame or sample number is coded like UTF-8.
+	n := 5
+	switch l := bits.LeadingZeros8(^b[4]); {
+	case l == 1 || l == 8:
+		return 0, false
+	case l > 1:
+		n = 4 + l
+	}
+	if len(b) < n {
+		return 0, false
+	}
+	for _, c := range b[5:n] {
+		if c&0xC0 != 0x80 {
+			return 0, false
+		}
+	}
+	switch b[2] >> 4 {
+	case 6:
+		n++
+	case 7:
+		n += 2
+	}
+	switch b[2] & 0x0F {
+	case 12:
+		n++
+	case 13, 14:
+		n += 2
+	}
+	if len(b) < n+1 || flacCRC8(b[:n+1]) != 0 {
+		return 0, false
+	}
+	return n + 1, true
+}
+
+// flacCRC8 returns the CRC-8 of b with the polynomial x^8 + x^2 + x + 1.
+func flacCRC8(b []byte) byte {
+	var crc byte
+	for _, c := range b {
+		crc ^= c
+		for range 8 {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// flacCRC16 returns the CRC-16 of b with the polynomial x^16 + x^15 +
+// x^2 + 1.
+func flacCRC16(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,151 @@
+package matroska
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestADTSDemuxer(t *testing.T) {
+	// AAC LC, 44.1 kHz, stereo, without CRC
+	frame := func(payload ...byte) []byte {
+		n := 7 + len(payload)
+		h := []byte{0xFF, 0xF1, 0x50, 0x80 | byte(n>>11), byte(n >> 3), byte(n<<5) | 0x1F, 0xFC}
+		return append(h, payload...)
+	}
+	id3 := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 2, 0, 0}
+	var stream []byte
+	stream = append(stream, id3...)
+	stream = append(stream, 0x00, 0xFF) // garbage
+	stream = append(stream, frame(0x21, 0x10)...)
+	stream = append(stream, frame(0x21, 0x11, 0x12)...)
+	d, err := NewADTSDemuxer(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := d.Tracks()[0]
+	if got, want := *track.CodecPrivate, []byte{0x12, 0x10}; !bytes.Equal(got, want) {
+		t.Errorf("CodecPrivate = % x, want % x", got, want)
+	}
+	if track.Audio.SamplingFrequency != 44100 || track.Audio.Channels != 2 {
+		t.Errorf("Audio = %v Hz %d channels, want 44100 Hz 2 channels", track.Audio.SamplingFrequency, track.Audio.Channels)
+	}
+	frames := readFrames(t, d)
+	if len(frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(frames))
+	}
+	if got, want := frames[1].Data, []byte{0x21, 0x11, 0x12}; !bytes.Equal(got, want) {
+		t.Errorf("Data = % x, want % x", got, want)
+	}
+	if got, want := frames[1].Timestamp, 1024*time.Second/44100; got != want {
+		t.Errorf("Timestamp = %v, want %v", got, want)
+	}
+}
+
+func TestMPEGAudioDemuxer(t *testing.T) {
+	// MPEG-1 Layer III, 128 kbit/s, 44.1 kHz, joint stereo
+	frame := func(fill byte) []byte {
+		b := bytes.Repeat([]byte{fill}, 417)
+		copy(b, []byte{0xFF, 0xFB, 0x90, 0x40})
+		return b
+	}
+	xing := frame(0)
+	copy(xing[4+32:], "Info")
+	var stream []byte
+	stream = append(stream, xing...)
+	stream = append(stream, frame(1)...)
+	stream = append(stream, "junk"...)
+	stream = append(stream, frame(2)...)
+	stream = append(stream, frame(3)[:100]...) // truncated frame
+	d, err := NewMPEGAudioDemuxer(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := d.Tracks()[0]
+	if track.CodecID != AudioCodecMP3 || track.Audio.SamplingFrequency != 44100 || track.Audio.Channels != 2 {
+		t.Errorf("track = %s %v Hz %d channels, want %s 44100 Hz 2 channels", track.CodecID, track.Audio.SamplingFrequency, track.Audio.Channels, AudioCodecMP3)
+	}
+	if got, want := *track.DefaultDuration, uint(1152*time.Second/44100); got != want {
+		t.Errorf("DefaultDuration = %d, want %d", got, want)
+	}
+	frames := readFrames(t, d)
+	if len(frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(frames))
+	}
+	for i, f := range frames {
+		if len(f.Data) != 417 || f.Data[4] != byte(i+1) {
+			t.Errorf("frame %d has %d octets of % x", i, len(f.Data), f.Data[4])
+		}
+	}
+}
+
+func TestAC3Demuxer(t *testing.T) {
+	// 48 kHz, 64 kbit/s, bsid 8, 2/0 with LFE
+	frame := make([]byte, 256)
+	copy(frame, []byte{0x0B, 0x77, 0x00, 0x00, 0x08, 0x40, 0x44})
+	d, err := NewAC3Demuxer(bytes.NewReader(bytes.Repeat(frame, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := d.Tracks()[0]
+	if track.Audio.SamplingFrequency != 48000 || track.Audio.Channels != 3 {
+		t.Errorf("Audio = %v Hz %d channels, want 48000 Hz 3 channels", track.Audio.SamplingFrequency, track.Audio.Channels)
+	}
+	frames := readFrames(t, d)
+	if len(frames) != 3 {
+		t.Fatalf("got %d frames, want 3", len(frames))
+	}
+	if got, want := frames[2].Timestamp, 64*time.Millisecond; got != want {
+		t.Errorf("Timestamp = %v, want %v", got, want)
+	}
+}
+
+func TestFLACDemuxer(t *testing.T) {
+	frame := func(header []byte, payload ...byte) []byte {
+		b := append(header, flacCRC8(header))
+		b = append(b, payload...)
+		return binary.BigEndian.AppendUint16(b, flacCRC16(b))
+	}
+	info := make([]byte, 34)
+	binary.BigEndian.PutUint16(info[0:], 4096)
+	binary.BigEndian.PutUint16(info[2:], 4096)
+	// 44.1 kHz, 2 channels, 16 bits per sample
+	copy(info[10:], []byte{0x0A, 0xC4, 0x42, 0xF0})
+	var stream []byte
+	stream = append(stream, "fLaC"...)
+	stream = append(stream, 0x00, 0, 0, 34)
+	stream = append(stream, info...)
+	stream = append(stream, 0x81, 0, 0, 2, 0, 0) // PADDING
+	// The payload of the first frame contains a sync code.
+	stream = append(stream, frame([]byte{0xFF, 0xF8, 0xC9, 0x18, 0x00}, 0xFF, 0xF8, 0xC9)...)
+	stream = append(stream, frame([]byte{0xFF, 0xF8, 0x69, 0x18, 0x01, 99}, 0x01)...)
+	d, err := NewFLACDemuxer(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := d.Tracks()[0]
+	want := append([]byte("fLaC\x80\x00\x00\x22"), info...)
+	if got := *track.CodecPrivate; !bytes.Equal(got, want) {
+		t.Errorf("CodecPrivate = % x, want % x", got, want)
+	}
+	if track.Audio.SamplingFrequency != 44100 || track.Audio.Channels != 2 || track.Audio.BitDepth == nil || *track.Audio.BitDepth != 16 {
+		t.Errorf("Audio = %v Hz %d channels, want 44100 Hz 2 channels 16 bits", track.Audio.SamplingFrequency, track.Audio.Channels)
+	}
+	frames := readFrames(t, d)
+	if len(frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(frames))
+	}
+	if got, want := len(frames[0].Data), 11; got != want {
+		t.Errorf("got %d octets, want %d", got, want)
+	}
+	if frames[0].Duration != 0 {
+		t.Errorf("Duration = %v, want 0", frames[0].Duration)
+	}
+	if got, want := frames[1].Timestamp, 4096*time.Second/44100; got != want {
+		t.Errorf("Timestamp = %v, want %v", got, want)
+	}
+	if got, want := frames[1].Duration, 4196*time.Second/44100-4096*time.Second/44100; got != want {
+		t.Errorf("Duration = %v, want %v", got, want)
+	}
+}
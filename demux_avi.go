@@ -0,0 +1,150 @@
+package matroska
+
+import (
+	"fmt"
+	"github.com/coding-socks/matroska/internal/avi"
+	"io"
+	"time"
+)
+
+// AVIDemuxer reads the video and audio streams of an AVI file.
+//
+// Video streams are mapped to V_MS/VFW/FOURCC tracks with the
+// BITMAPINFOHEADER as CodecPrivate. MPEG Layer III and AC-3 audio
+// streams are mapped to A_MPEG/L3 and A_AC3, other audio streams to
+// A_MS/ACM tracks with the WAVEFORMATEX as CodecPrivate. Other streams
+// are skipped.
+type AVIDemuxer struct {
+	r       *avi.Reader
+	tracks  []TrackEntry
+	streams []aviDemuxStream
+}
+
+// aviDemuxStream tracks the position of a stream to compute the
+// timestamps of its chunks.
+type aviDemuxStream struct {
+	track  uint // 0 when the stream is skipped
+	video  bool
+	chunks uint64
+	bytes  uint64
+}
+
+// NewAVIDemuxer reads the headers and the indexes of an AVI file.
+func NewAVIDemuxer(r io.ReadSeeker) (*AVIDemuxer, error) {
+	ar, err := avi.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("matroska: could not read avi file: %w", err)
+	}
+	d := &AVIDemuxer{r: ar, streams: make([]aviDemuxStream, len(ar.Streams))}
+	for i, st := range ar.Streams {
+		number := uint(len(d.tracks) + 1)
+		var t TrackEntry
+		switch st.Header.Type() {
+		case avi.StreamTypeVIDS:
+			if len(st.Format) < len(avi.StreamFormat{}) {
+				return nil, fmt.Errorf("matroska: stream %d: missing BITMAPINFOHEADER", i)
+			}
+			sf := avi.StreamFormat(st.Format)
+			t = NewTrackEntry(number, TrackTypeVideo, VideoCodecMSCOMP)
+			t.CodecPrivate = &st.Format
+			t.Video = &Video{}
+			setDefaults(t.Video)
+			t.Video.PixelWidth = uint(sf.Width())
+			// A negative height means a top-down bitmap.
+			t.Video.PixelHeight = uint(abs(int32(sf.Height())))
+			if st.Header.Rate() > 0 {
+				duration := uint(aviDuration(1, st.Header))
+				t.DefaultDuration = &duration
+			}
+			d.streams[i].video = true
+		case avi.StreamTypeAUDS:
+			if len(st.Format) < 16 { // WAVEFORMAT without cbSize
+				return nil, fmt.Errorf("matroska: stream %d: missing WAVEFORMATEX", i)
+			}
+			var wf avi.WaveFormatEx
+			copy(wf[:], st.Format)
+			switch wf.FormatTag() {
+			case avi.WAVE_FORMAT_MPEGLAYER3:
+				t = NewTrackEntry(number, TrackTypeAudio, AudioCodecMP3)
+			case avi.WAVE_FORMAT_DOLBY_AC3:
+				t = NewTrackEntry(number, TrackTypeAudio, AudioCodecAC3)
+			default:
+				t = NewTrackEntry(number, TrackTypeAudio, AudioCodecMS_ACM)
+				t.CodecPrivate = &st.Format
+			}
+			t.Audio = &Audio{}
+			setDefaults(t.Audio)
+			t.Audio.SamplingFrequency = float64(wf.SamplesPerSec())
+			t.Audio.Channels = uint(wf.Channels())
+			if bits := uint(wf.BitsPerSample()); bits > 0 {
+				t.Audio.BitDepth = &bits
+			}
+		default:
+			continue
+		}
+		if st.Name != "" {
+			name := st.Name
+			t.Name = &name
+		}
+		if st.Header.Flags()&avi.AVISF_DISABLED != 0 {
+			t.FlagDefault = 0
+		}
+		d.streams[i].track = number
+		d.tracks = append(d.tracks, t)
+	}
+	return d, nil
+}
+
+func abs(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// aviDuration converts n units of the time base of a stream header
+// into a duration.
+func aviDuration(n uint64, sh avi.StreamHeader) time.Duration {
+	if sh.Rate() == 0 {
+		return 0
+	}
+	return time.Duration(float64(n) * float64(sh.Scale()) / float64(sh.Rate()) * float64(time.Second))
+}
+
+// Tracks returns the tracks of the file.
+func (d *AVIDemuxer) Tracks() []TrackEntry {
+	return d.tracks
+}
+
+// ReadFrame returns the next chunk of a mapped stream. Empty video
+// chunks are dropped frames, they are skipped but their time is kept.
+func (d *AVIDemuxer) ReadFrame() (Frame, error) {
+	for {
+		c, err := d.r.Next()
+		if err != nil {
+			return Frame{}, err
+		}
+		s := &d.streams[c.Stream]
+		if s.track == 0 {
+			continue
+		}
+		sh := d.r.Streams[c.Stream].Header
+		// Constant bitrate audio streams count samples of SampleSize
+		// octets, every other stream counts chunks.
+		pos := s.chunks
+		if n := sh.SampleSize(); n > 0 && !s.video {
+			pos = s.bytes / uint64(n)
+		}
+		s.chunks++
+		s.bytes += uint64(len(c.Data))
+		if len(c.Data) == 0 {
+			continue
+		}
+		return Frame{
+			TrackNumber: s.track,
+			Timestamp:   aviDuration(pos+uint64(sh.Start()), sh),
+			Keyframe:    c.Keyframe || !s.video,
+			Data:        c.Data,
+		}, nil
+	}
+}
@@ -0,0 +1,167 @@
+package matroska
+
+import (
+	"bytes"
+	"github.com/coding-socks/matroska/internal/avi"
+	"testing"
+	"time"
+)
+
+// aviWriterAt is an in-memory io.WriterAt.
+type aviWriterAt struct {
+	buf []byte
+}
+
+func (w *aviWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if n := off + int64(len(p)); n > int64(len(w.buf)) {
+		w.buf = append(w.buf, make([]byte, n-int64(len(w.buf)))...)
+	}
+	copy(w.buf[off:], p)
+	return len(p), nil
+}
+
+func TestAVIDemuxer(t *testing.T) {
+	var wa aviWriterAt
+	aw, err := avi.NewWriter(&wa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	video := avi.NewStreamID(0, avi.StreamTypeDC)
+	audio := avi.NewStreamID(1, avi.StreamTypeWB)
+	for i := range 4 {
+		var flags uint32
+		if i%2 == 0 {
+			flags = avi.AVIIF_KEYFRAME
+		}
+		frame := []byte{'v', byte(i)}
+		if i == 2 {
+			frame = nil // dropped frame
+		}
+		if err := aw.WriteData(video, frame, flags); err != nil {
+			t.Fatal(err)
+		}
+		if err := aw.WriteData(audio, make([]byte, 8000), avi.AVIIF_KEYFRAME); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var vh, ah avi.StreamHeader
+	vh.SetType(avi.StreamTypeVIDS)
+	vh.SetScale(1)
+	vh.SetRate(25)
+	var vf avi.StreamFormat
+	vf.SetSize(40)
+	vf.SetWidth(320)
+	vf.SetHeight(uint32(0xFFFFFF10)) // -240, top-down
+	vf.SetCompression(0x34363258)    // X264
+	ah.SetType(avi.StreamTypeAUDS)
+	ah.SetScale(4)
+	ah.SetRate(8000 * 4)
+	ah.SetSampleSize(4)
+	var af avi.WaveFormatEx
+	af.SetFormatTag(avi.WAVE_FORMAT_PCM)
+	af.SetChannels(2)
+	af.SetSamplesPerSec(8000)
+	af.SetAvgBytesPerSec(32000)
+	af.SetBlockAlign(4)
+	af.SetBitsPerSample(16)
+	if err := aw.WriteHeader(avi.Stream{Header: vh, Format: vf[:]}, avi.Stream{Header: ah, Format: af[:], Name: "Stereo"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewAVIDemuxer(bytes.NewReader(wa.buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracks := d.Tracks()
+	if len(tracks) != 2 {
+		t.Fatalf("got %d tracks, want 2", len(tracks))
+	}
+	if v := tracks[0]; v.CodecID != VideoCodecMSCOMP || v.Video.PixelHeight != 240 || *v.DefaultDuration != uint(40*time.Millisecond) {
+		t.Errorf("video track = %+v", v)
+	}
+	if a := tracks[1]; a.CodecID != AudioCodecMS_ACM || a.Audio.Channels != 2 || a.Name == nil || *a.Name != "Stereo" || !bytes.Equal(*a.CodecPrivate, af[:]) {
+		t.Errorf("audio track = %+v", a)
+	}
+
+	type frame struct {
+		track uint
+		ts    time.Duration
+		key   bool
+	}
+	want := []frame{
+		{1, 0, true}, {2, 0, true},
+		{1, 40 * time.Millisecond, false}, {2, 250 * time.Millisecond, true},
+		{2, 500 * time.Millisecond, true},
+		{1, 120 * time.Millisecond, false}, {2, 750 * time.Millisecond, true},
+	}
+	var got []frame
+	for {
+		f, err := d.ReadFrame()
+		if err != nil {
+			break
+		}
+		got = append(got, frame{f.TrackNumber, f.Timestamp, f.Keyframe})
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ReadFrame() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("frame %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRemux(t *testing.T) {
+	var wa aviWriterAt
+	aw, err := avi.NewWriter(&wa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	video := avi.NewStreamID(0, avi.StreamTypeDC)
+	for i := range 10 {
+		if err := aw.WriteData(video, []byte{'v', byte(i)}, avi.AVIIF_KEYFRAME); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var vh avi.StreamHeader
+	vh.SetType(avi.StreamTypeVIDS)
+	vh.SetScale(1)
+	vh.SetRate(25)
+	var vf avi.StreamFormat
+	if err := aw.WriteHeader(avi.Stream{Header: vh, Format: vf[:]}); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewAVIDemuxer(bytes.NewReader(wa.buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ws writeSeeker
+	if err := Remux(&ws, d, Info{}, WriterOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewScanner(bytes.NewReader(ws.buf))
+	if got := s.Tracks().TrackEntry[0].CodecID; got != VideoCodecMSCOMP {
+		t.Errorf("CodecID = %s, want %s", got, VideoCodecMSCOMP)
+	}
+	if got := *s.Info().Duration; got != 400 {
+		t.Errorf("Duration = %v, want 400", got)
+	}
+	blocks := 0
+	for s.Next() {
+		blocks += len(s.Cluster().SimpleBlock)
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if blocks != 10 {
+		t.Errorf("got %d blocks, want 10", blocks)
+	}
+}
@@ -0,0 +1,167 @@
+package matroska
+
+import (
+	"fmt"
+	"github.com/coding-socks/matroska/internal/av1"
+	"github.com/coding-socks/matroska/internal/bitstream"
+	"github.com/coding-socks/matroska/internal/ivf"
+	"io"
+	"time"
+)
+
+// IVFDemuxer reads the VP8, VP9 or AV1 frames of an IVF file as a single
+// video track.
+//
+// AV1 temporal units are stored without temporal delimiter, padding and
+// redundant frame header OBUs, and the first sequence header OBU is
+// stored in the CodecPrivate with an AV1CodecConfigurationRecord.
+type IVFDemuxer struct {
+	r      *ivf.Reader
+	tracks []TrackEntry
+
+	// av1 is the last AV1 sequence header, and next is the first frame
+	// which is read by NewIVFDemuxer to find the first one.
+	av1  *av1.SequenceHeader
+	next *ivf.Frame
+}
+
+// NewIVFDemuxer reads the file header of an IVF file.
+func NewIVFDemuxer(r io.Reader) (*IVFDemuxer, error) {
+	ir, err := ivf.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("matroska: could not read ivf file: %w", err)
+	}
+	d := &IVFDemuxer{r: ir}
+	var codecID string
+	switch string(ir.Header.FourCC[:]) {
+	case "VP80":
+		codecID = VideoCodecVP8
+	case "VP90":
+		codecID = VideoCodecVP9
+	case "AV01":
+		codecID = VideoCodecAV1
+	default:
+		return nil, fmt.Errorf("matroska: unsupported ivf codec: %q", ir.Header.FourCC[:])
+	}
+	t := NewTrackEntry(1, TrackTypeVideo, codecID)
+	t.Video = &Video{}
+	setDefaults(t.Video)
+	t.Video.PixelWidth = uint(ir.Header.Width)
+	t.Video.PixelHeight = uint(ir.Header.Height)
+	if codecID == VideoCodecAV1 {
+		f, err := ir.ReadFrame()
+		if err != nil {
+			return nil, fmt.Errorf("matroska: could not read first AV1 temporal unit: %w", err)
+		}
+		obus, err := av1.ParseOBUs(f.Data)
+		if err != nil {
+			return nil, fmt.Errorf("matroska: could not read first AV1 temporal unit: %w", err)
+		}
+		for _, o := range obus {
+			if o.Type != av1.OBUSequenceHeader {
+				continue
+			}
+			h, err := av1.ParseSequenceHeader(o.Payload)
+			if err != nil {
+				return nil, err
+			}
+			b := h.CodecConfigurationRecord(o)
+			t.CodecPrivate = &b
+			break
+		}
+		if t.CodecPrivate == nil {
+			return nil, fmt.Errorf("matroska: first AV1 temporal unit has no sequence header")
+		}
+		d.next = &f
+	}
+	d.tracks = []TrackEntry{t}
+	return d, nil
+}
+
+// Tracks returns the video track.
+func (d *IVFDemuxer) Tracks() []TrackEntry {
+	return d.tracks
+}
+
+// ReadFrame returns the next frame.
+func (d *IVFDemuxer) ReadFrame() (Frame, error) {
+	var f ivf.Frame
+	if d.next != nil {
+		f, d.next = *d.next, nil
+	} else {
+		var err error
+		if f, err = d.r.ReadFrame(); err != nil {
+			return Frame{}, err
+		}
+	}
+	h := d.r.Header
+	if h.TimebaseDen == 0 {
+		return Frame{}, fmt.Errorf("matroska: invalid ivf time base: %d/%d", h.TimebaseNum, h.TimebaseDen)
+	}
+	// The remainder is converted separately to avoid an overflow.
+	n := f.Timestamp * uint64(h.TimebaseNum)
+	ts := time.Duration(n/uint64(h.TimebaseDen))*time.Second +
+		time.Duration(n%uint64(h.TimebaseDen)*uint64(time.Second)/uint64(h.TimebaseDen))
+	frame := Frame{TrackNumber: 1, Timestamp: ts, Data: f.Data}
+	switch d.tracks[0].CodecID {
+	case VideoCodecVP8:
+		// The frame tag starts with the inverse of the key frame flag.
+		frame.Keyframe = len(f.Data) > 0 && f.Data[0]&0x01 == 0
+	case VideoCodecVP9:
+		frame.Keyframe = vp9KeyFrame(f.Data)
+	case VideoCodecAV1:
+		var err error
+		if frame.Data, frame.Keyframe, err = d.av1TemporalUnit(f.Data); err != nil {
+			return Frame{}, err
+		}
+	}
+	return frame, nil
+}
+
+// vp9KeyFrame parses the beginning of the uncompressed header of a VP9
+// frame.
+func vp9KeyFrame(b []byte) bool {
+	r := bitstream.NewReader(b)
+	if r.ReadBits(2) != 2 { // frame_marker
+		return false
+	}
+	profile := r.ReadBits(1)
+	profile |= r.ReadBits(1) << 1
+	if profile == 3 {
+		r.Skip(1) // reserved_zero
+	}
+	if r.ReadFlag() { // show_existing_frame
+		return false
+	}
+	return !r.ReadFlag() && r.Err() == nil // frame_type KEY_FRAME
+}
+
+// av1TemporalUnit removes the OBUs which should not be stored in a
+// Block and reports whether the temporal unit starts with a key frame.
+func (d *IVFDemuxer) av1TemporalUnit(b []byte) ([]byte, bool, error) {
+	obus, err := av1.ParseOBUs(b)
+	if err != nil {
+		return nil, false, fmt.Errorf("matroska: could not read AV1 temporal unit: %w", err)
+	}
+	out := make([]byte, 0, len(b))
+	keyframe, frame := false, false
+	for _, o := range obus {
+		switch o.Type {
+		case av1.OBUTemporalDelimiter, av1.OBUPadding, av1.OBURedundantFrameHeader:
+			continue
+		case av1.OBUSequenceHeader:
+			h, err := av1.ParseSequenceHeader(o.Payload)
+			if err != nil {
+				return nil, false, err
+			}
+			d.av1 = &h
+		case av1.OBUFrameHeader, av1.OBUFrame:
+			if !frame && d.av1 != nil {
+				keyframe = d.av1.IsKeyFrame(o.Payload)
+			}
+			frame = true
+		}
+		out = o.Append(out)
+	}
+	return out, keyframe, nil
+}
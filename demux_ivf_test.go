@@ -0,0 +1,106 @@
+package matroska
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func ivfFile(fourCC string, den, num uint32, frames ...[]byte) []byte {
+	b := []byte("DKIF")
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = binary.LittleEndian.AppendUint16(b, 32)
+	b = append(b, fourCC...)
+	b = binary.LittleEndian.AppendUint16(b, 640)
+	b = binary.LittleEndian.AppendUint16(b, 360)
+	b = binary.LittleEndian.AppendUint32(b, den)
+	b = binary.LittleEndian.AppendUint32(b, num)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(frames)))
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	for i, f := range frames {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(f)))
+		b = binary.LittleEndian.AppendUint64(b, uint64(i))
+		b = append(b, f...)
+	}
+	return b
+}
+
+func TestIVFDemuxer(t *testing.T) {
+	t.Run("VP8", func(t *testing.T) {
+		file := ivfFile("VP80", 30, 1, []byte{0x10, 0x02, 0x00, 0x9D, 0x01, 0x2A}, []byte{0x11, 0x02, 0x00})
+		d, err := NewIVFDemuxer(bytes.NewReader(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		track := d.Tracks()[0]
+		if track.CodecID != VideoCodecVP8 || track.Video.PixelWidth != 640 || track.Video.PixelHeight != 360 {
+			t.Errorf("track = %s %dx%d, want %s 640x360", track.CodecID, track.Video.PixelWidth, track.Video.PixelHeight, VideoCodecVP8)
+		}
+		frames := readFrames(t, d)
+		if len(frames) != 2 {
+			t.Fatalf("got %d frames, want 2", len(frames))
+		}
+		if !frames[0].Keyframe || frames[1].Keyframe {
+			t.Errorf("Keyframe = %v, %v, want true, false", frames[0].Keyframe, frames[1].Keyframe)
+		}
+		if got, want := frames[1].Timestamp, time.Second/30; got != want {
+			t.Errorf("Timestamp = %v, want %v", got, want)
+		}
+	})
+	t.Run("VP9", func(t *testing.T) {
+		// frame_marker, profile 0, show_existing_frame and frame_type
+		file := ivfFile("VP90", 1000, 1, []byte{0x82, 0x49, 0x83}, []byte{0x86, 0x00})
+		d, err := NewIVFDemuxer(bytes.NewReader(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames := readFrames(t, d)
+		if len(frames) != 2 || !frames[0].Keyframe || frames[1].Keyframe {
+			t.Errorf("got %d frames with keyframes %v, want a keyframe and an interframe", len(frames), frames)
+		}
+	})
+	t.Run("AV1", func(t *testing.T) {
+		seq := new(bitWriter).
+			u(0, 3).u(0, 1).u(0, 1).                 // seq_profile, still_picture, reduced_still_picture_header
+			u(0, 1).u(0, 1).u(0, 5).                 // timing_info_present_flag, initial_display_delay_present_flag, operating_points_cnt_minus_1
+			u(0, 12).u(8, 5).u(0, 1).                // operating_point_idc, seq_level_idx, seq_tier
+			u(10, 4).u(10, 4).u(639, 11).u(359, 11). // frame size
+			u(0, 1).u(0, 3).                         // frame_id_numbers_present_flag, superblock size and intra tools
+			u(0, 4).u(1, 1).u(0, 2).                 // inter tools, enable_order_hint, enable_jnt_comp, enable_ref_frame_mvs
+			u(1, 1).u(1, 1).u(6, 3).                 // seq_choose_screen_content_tools, seq_choose_integer_mv, order_hint_bits_minus_1
+			u(0, 1).u(1, 1).u(1, 1).                 // enable_superres, enable_cdef, enable_restoration
+			u(0, 1).u(0, 1).u(0, 1).u(0, 1).u(1, 2). // color_config
+			u(0, 1).u(0, 1).rbsp()                   // separate_uv_delta_q, film_grain_params_present
+		seqOBU := append([]byte{0x0A, byte(len(seq))}, seq...)
+		temporalDelimiter := []byte{0x12, 0x00}
+		keyFrame := []byte{0x32, 0x02, 0x10, 0xFF}
+		interFrame := []byte{0x32, 0x02, 0x30, 0xFF}
+		var tu []byte
+		tu = append(tu, temporalDelimiter...)
+		tu = append(tu, seqOBU...)
+		tu = append(tu, keyFrame...)
+		file := ivfFile("AV01", 25, 1, tu, append(temporalDelimiter, interFrame...))
+		d, err := NewIVFDemuxer(bytes.NewReader(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := append([]byte{0x81, 0x08, 0x0D, 0x00}, seqOBU...)
+		if got := *d.Tracks()[0].CodecPrivate; !bytes.Equal(got, want) {
+			t.Errorf("CodecPrivate = % x, want % x", got, want)
+		}
+		frames := readFrames(t, d)
+		if len(frames) != 2 {
+			t.Fatalf("got %d frames, want 2", len(frames))
+		}
+		if got, want := frames[0].Data, append(append([]byte(nil), seqOBU...), keyFrame...); !bytes.Equal(got, want) {
+			t.Errorf("Data = % x, want % x", got, want)
+		}
+		if !frames[0].Keyframe || frames[1].Keyframe {
+			t.Errorf("Keyframe = %v, %v, want true, false", frames[0].Keyframe, frames[1].Keyframe)
+		}
+		if got, want := frames[1].Timestamp, 40*time.Millisecond; got != want {
+			t.Errorf("Timestamp = %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package matroska
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MatroskaDemuxer reads the frames of a Matroska file, which allows its
+// tracks to be copied into another file.
+//
+// Laced blocks are split into their frames. The Cluster struct does not
+// keep the order of SimpleBlocks and BlockGroups, so they are merged by
+// timestamp.
+type MatroskaDemuxer struct {
+	s      *Scanner
+	scale  time.Duration
+	tracks map[uint]TrackEntry
+	frames []Frame
+}
+
+// NewMatroskaDemuxer reads the beginning of a Matroska file until its
+// first Cluster.
+func NewMatroskaDemuxer(r io.ReadSeeker) (*MatroskaDemuxer, error) {
+	s := NewScanner(r)
+	if err := s.Init(); err != nil {
+		return nil, err
+	}
+	if s.Info() == nil || s.Tracks() == nil {
+		return nil, errors.New("matroska: missing Info or Tracks element")
+	}
+	d := &MatroskaDemuxer{s: s, scale: s.Info().TimestampScale, tracks: make(map[uint]TrackEntry)}
+	for _, t := range s.Tracks().TrackEntry {
+		d.tracks[t.TrackNumber] = t
+	}
+	return d, nil
+}
+
+// Scanner returns the Scanner reading the file, which gives access to its
+// other top level elements.
+func (d *MatroskaDemuxer) Scanner() *Scanner {
+	return d.s
+}
+
+// Tracks returns the tracks of the file.
+func (d *MatroskaDemuxer) Tracks() []TrackEntry {
+	return d.s.Tracks().TrackEntry
+}
+
+// ReadFrame returns the next frame.
+func (d *MatroskaDemuxer) ReadFrame() (Frame, error) {
+	for len(d.frames) == 0 {
+		if !d.s.Next() {
+			if err := d.s.Err(); err != nil {
+				return Frame{}, err
+			}
+			return Frame{}, io.EOF
+		}
+		if err := d.readCluster(d.s.Cluster()); err != nil {
+			return Frame{}, err
+		}
+	}
+	f := d.frames[0]
+	d.frames = d.frames[1:]
+	return f, nil
+}
+
+func (d *MatroskaDemuxer) readCluster(c Cluster) error {
+	var simple, groups []Frame
+	for _, b := range c.SimpleBlock {
+		block, err := ReadSimpleBlock(b, c.Timestamp)
+		if err != nil {
+			return fmt.Errorf("matroska: could not create block struct: %w", err)
+		}
+		f := Frame{
+			TrackNumber: block.TrackNumber(),
+			Timestamp:   block.Timestamp(d.scale),
+			Keyframe:    block.Flags()&SimpleBlockFlagKeyframe != 0,
+			Discardable: block.Flags()&SimpleBlockFlagDiscardable != 0,
+		}
+		simple = d.appendFrames(simple, f, block.Frames(), 0)
+	}
+	for _, g := range c.BlockGroup {
+		block, err := ReadBlock(g.Block, c.Timestamp)
+		if err != nil {
+			return fmt.Errorf("matroska: could not create block struct: %w", err)
+		}
+		f := Frame{
+			TrackNumber: block.TrackNumber(),
+			Timestamp:   block.Timestamp(d.scale),
+			Keyframe:    len(g.ReferenceBlock) == 0,
+		}
+		if g.DiscardPadding != nil {
+			f.DiscardPadding = time.Duration(*g.DiscardPadding)
+		}
+		if g.BlockAdditions != nil {
+			for _, m := range g.BlockAdditions.BlockMore {
+				if m.BlockAddID <= 1 {
+					f.BlockAdditional = m.BlockAdditional
+				}
+			}
+		}
+		var duration time.Duration
+		if g.BlockDuration != nil {
+			duration = time.Duration(*g.BlockDuration) * d.scale
+		}
+		groups = d.appendFrames(groups, f, block.Frames(), duration)
+	}
+	// Merging keeps the decoding order of the blocks of each list.
+	d.frames = d.frames[:0]
+	for len(simple) > 0 || len(groups) > 0 {
+		if len(groups) == 0 || len(simple) > 0 && simple[0].Timestamp <= groups[0].Timestamp {
+			d.frames = append(d.frames, simple[0])
+			simple = simple[1:]
+		} else {
+			d.frames = append(d.frames, groups[0])
+			groups = groups[1:]
+		}
+	}
+	return nil
+}
+
+// appendFrames appends the frames of a block based on f. The frames of a
+// laced block follow each other by DefaultDuration, or by the duration of
+// the block divided between them.
+func (d *MatroskaDemuxer) appendFrames(frames []Frame, f Frame, data [][]byte, duration time.Duration) []Frame {
+	step := duration / time.Duration(len(data))
+	if t, ok := d.tracks[f.TrackNumber]; ok && t.DefaultDuration != nil && len(data) > 1 {
+		step = time.Duration(*t.DefaultDuration)
+	}
+	for i, b := range data {
+		g := f
+		g.Timestamp += time.Duration(i) * step
+		g.Data = b
+		if duration != 0 {
+			g.Duration = duration / time.Duration(len(data))
+		}
+		if i > 0 {
+			g.BlockAdditional = nil
+		}
+		frames = append(frames, g)
+	}
+	return frames
+}
@@ -0,0 +1,131 @@
+package matroska
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// matroskaFile writes the frames of a single track, and the chapters and
+// the tags when they are not nil, into a Matroska file.
+func matroskaFile(t *testing.T, track TrackEntry, chapters *Chapters, tags *Tags, frames ...Frame) []byte {
+	t.Helper()
+	var ws writeSeeker
+	w, err := NewWriter(&ws, Info{}, Tracks{TrackEntry: []TrackEntry{track}}, WriterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chapters != nil {
+		w.SetChapters(chapters)
+	}
+	if tags != nil {
+		w.SetTags(tags)
+	}
+	for _, f := range frames {
+		if err := w.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return ws.buf
+}
+
+func TestMatroskaDemuxer(t *testing.T) {
+	track := NewTrackEntry(1, TrackTypeAudio, AudioCodecMP3)
+	want := []Frame{
+		{TrackNumber: 1, Timestamp: 0, Keyframe: true, Data: []byte{0}},
+		{TrackNumber: 1, Timestamp: 20 * time.Millisecond, Duration: 10 * time.Millisecond, Keyframe: true, Data: []byte{1}},
+		{TrackNumber: 1, Timestamp: 30 * time.Millisecond, Keyframe: true, Discardable: true, Data: []byte{2}},
+	}
+	d, err := NewMatroskaDemuxer(bytes.NewReader(matroskaFile(t, track, nil, nil, want...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Tracks()[0].TrackUID; got != track.TrackUID {
+		t.Errorf("TrackUID = %d, want %d", got, track.TrackUID)
+	}
+	got := readFrames(t, d)
+	if len(got) != len(want) {
+		t.Fatalf("got %d frames, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Timestamp != w.Timestamp || g.Duration != w.Duration || g.Keyframe != w.Keyframe || g.Discardable != w.Discardable || !bytes.Equal(g.Data, w.Data) {
+			t.Errorf("frame %d = %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+func TestInterleave(t *testing.T) {
+	video := NewTrackEntry(1, TrackTypeVideo, VideoCodecMSCOMP)
+	video.Video = &Video{PixelWidth: 320, PixelHeight: 240}
+	var videoFrames []Frame
+	for i := range 10 {
+		videoFrames = append(videoFrames, Frame{TrackNumber: 1, Timestamp: time.Duration(i) * 40 * time.Millisecond, Keyframe: i%5 == 0, Data: []byte{byte(i)}})
+	}
+	audio := NewTrackEntry(1, TrackTypeAudio, AudioCodecMP3)
+	var audioFrames []Frame
+	for i := range 16 {
+		audioFrames = append(audioFrames, Frame{TrackNumber: 1, Timestamp: time.Duration(i) * 24 * time.Millisecond, Keyframe: true, Data: []byte{byte(i)}})
+	}
+	subtitles, err := NewSRTDemuxer(bytes.NewReader([]byte("1\n00:00:00,100 --> 00:00:00,200\nHello\n\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ds []Demuxer
+	for _, b := range [][]byte{matroskaFile(t, video, nil, nil, videoFrames...), matroskaFile(t, audio, nil, nil, audioFrames...)} {
+		d, err := NewMatroskaDemuxer(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds = append(ds, d)
+	}
+	// The same file twice has the same TrackUIDs.
+	d, err := NewMatroskaDemuxer(bytes.NewReader(matroskaFile(t, audio, nil, nil, audioFrames...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds = append(ds, d, subtitles)
+	m, trackUIDs := Interleave(ds, func(input int, t *TrackEntry) bool {
+		if input == 2 {
+			t.Language = "hun"
+		}
+		return input != 3
+	})
+	tracks := m.Tracks()
+	if len(tracks) != 3 {
+		t.Fatalf("got %d tracks, want 3", len(tracks))
+	}
+	for i, track := range tracks {
+		if track.TrackNumber != uint(i+1) {
+			t.Errorf("TrackNumber = %d, want %d", track.TrackNumber, i+1)
+		}
+	}
+	if tracks[1].TrackUID == tracks[2].TrackUID {
+		t.Error("TrackUIDs are not unique")
+	}
+	for i := range 3 {
+		uid := ds[i].Tracks()[0].TrackUID
+		if got := trackUIDs[i][uid]; got != tracks[i].TrackUID {
+			t.Errorf("trackUIDs[%d][%d] = %d, want %d", i, uid, got, tracks[i].TrackUID)
+		}
+	}
+	if len(trackUIDs[3]) != 0 {
+		t.Errorf("trackUIDs[3] = %v, want empty", trackUIDs[3])
+	}
+	if tracks[2].Language != "hun" {
+		t.Errorf("Language = %s, want hun", tracks[2].Language)
+	}
+	frames := readFrames(t, m)
+	if len(frames) != 10+16+16 {
+		t.Fatalf("got %d frames, want %d", len(frames), 10+16+16)
+	}
+	for i := 1; i < len(frames); i++ {
+		if frames[i].Timestamp < frames[i-1].Timestamp {
+			t.Fatalf("frame %d at %v follows a frame at %v", i, frames[i].Timestamp, frames[i-1].Timestamp)
+		}
+	}
+}
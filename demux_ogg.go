@@ -0,0 +1,486 @@
+package matroska
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/coding-socks/matroska/internal/ogg"
+	"github.com/coding-socks/matroska/internal/vorbis"
+	"io"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// OggDemuxer reads the Vorbis, Opus, FLAC and Theora streams of an Ogg
+// file. Streams of other codecs are skipped.
+//
+// The CodecPrivate of the tracks is built from the header packets of
+// the streams, and the timestamps of the frames are computed from the
+// durations of the packets aligned to the granule positions.
+type OggDemuxer struct {
+	r       *ogg.PacketReader
+	streams map[uint32]*oggStream
+	tracks  []TrackEntry
+	frames  []Frame
+	eof     bool
+}
+
+// oggPacket is a packet waiting for the granule position of its page.
+type oggPacket struct {
+	data     []byte
+	duration int64
+}
+
+type oggStream struct {
+	track   int // index in OggDemuxer.tracks, -1 when the stream is skipped
+	codecID string
+	headers [][]byte
+	// headerCount is the number of header packets. It is 0 when the
+	// number of FLAC metadata packets is unknown.
+	headerCount int
+	ready       bool
+
+	// A granule is num/den seconds.
+	num, den uint64
+
+	pending []oggPacket
+	pos     int64
+	started bool
+
+	// Vorbis
+	blocksizes    [2]int64
+	modes         []bool
+	prevBlocksize int64
+	// Theora
+	granuleShift uint
+	newGranule   bool
+}
+
+// NewOggDemuxer reads the header packets of every logical bitstream of
+// an Ogg file.
+func NewOggDemuxer(r io.Reader) (*OggDemuxer, error) {
+	d := &OggDemuxer{r: ogg.NewPacketReader(r), streams: make(map[uint32]*oggStream)}
+	for {
+		p, err := d.r.ReadPacket()
+		if err == io.EOF {
+			d.eof = true
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("matroska: could not read ogg packet: %w", err)
+		}
+		if err := d.handlePacket(p); err != nil {
+			return nil, err
+		}
+		if p.BOS {
+			continue
+		}
+		ready := true
+		for _, s := range d.streams {
+			ready = ready && (s.track == -1 || s.ready)
+		}
+		if ready {
+			break
+		}
+	}
+	for serial, s := range d.streams {
+		if s.track != -1 && !s.ready {
+			return nil, fmt.Errorf("matroska: missing headers of ogg stream %d", serial)
+		}
+	}
+	if len(d.tracks) == 0 {
+		return nil, errors.New("matroska: no supported ogg stream")
+	}
+	return d, nil
+}
+
+// Tracks returns the tracks of the file.
+func (d *OggDemuxer) Tracks() []TrackEntry {
+	return d.tracks
+}
+
+// ReadFrame returns the next frame of the file.
+func (d *OggDemuxer) ReadFrame() (Frame, error) {
+	for len(d.frames) == 0 {
+		if d.eof {
+			return Frame{}, io.EOF
+		}
+		p, err := d.r.ReadPacket()
+		if err == io.EOF {
+			d.eof = true
+			// Packets without a granule position are only left on
+			// broken files.
+			for _, s := range d.streams {
+				if s.ready {
+					d.flush(s, -1, false)
+				}
+			}
+			continue
+		} else if err != nil {
+			return Frame{}, fmt.Errorf("matroska: could not read ogg packet: %w", err)
+		}
+		if err := d.handlePacket(p); err != nil {
+			return Frame{}, err
+		}
+	}
+	f := d.frames[0]
+	d.frames = d.frames[1:]
+	return f, nil
+}
+
+func (d *OggDemuxer) handlePacket(p ogg.Packet) error {
+	s, ok := d.streams[p.SerialNum]
+	if !ok {
+		if !p.BOS {
+			return nil // the stream started before the file
+		}
+		s = newOggStream(p.Data)
+		if s.codecID != "" {
+			s.track = len(d.tracks)
+			d.tracks = append(d.tracks, TrackEntry{}) // built when the headers are read
+		}
+		d.streams[p.SerialNum] = s
+	}
+	if s.track == -1 {
+		return nil
+	}
+	if !s.ready {
+		if s.isHeader(p.Data) {
+			s.headers = append(s.headers, p.Data)
+			if len(s.headers) < s.headerCount || s.headerCount == 0 {
+				return nil
+			}
+		}
+		t, err := s.trackEntry(uint(s.track + 1))
+		if err != nil {
+			return fmt.Errorf("matroska: ogg stream %d: %w", p.SerialNum, err)
+		}
+		d.tracks[s.track] = t
+		s.ready = true
+		if s.isHeader(p.Data) {
+			return nil
+		}
+	}
+	s.pending = append(s.pending, oggPacket{data: p.Data, duration: s.duration(p.Data)})
+	if p.GranulePosition != -1 {
+		d.flush(s, s.end(p.GranulePosition), p.EOS)
+	}
+	return nil
+}
+
+// flush turns the pending packets of s into frames. The end is the
+// position after the last packet or -1 when it is unknown.
+func (d *OggDemuxer) flush(s *oggStream, end int64, eos bool) {
+	if len(s.pending) == 0 {
+		return
+	}
+	if !s.started && end != -1 {
+		s.pos = end
+		for _, p := range s.pending {
+			s.pos -= p.duration
+		}
+	}
+	s.started = true
+	t := d.tracks[s.track]
+	for i, p := range s.pending {
+		ts := granuleDuration(s.pos, s.num, s.den)
+		s.pos += p.duration
+		if len(p.data) == 0 {
+			continue // a Theora frame repeating the previous frame
+		}
+		f := Frame{
+			TrackNumber: t.TrackNumber,
+			Timestamp:   ts,
+			Keyframe:    s.codecID != VideoCodecTHEORA || p.data[0]&0x40 == 0,
+			Data:        p.data,
+		}
+		if eos && i == len(s.pending)-1 && s.codecID == AudioCodecOPUS && end != -1 && s.pos > end {
+			f.DiscardPadding = granuleDuration(s.pos-end, s.num, s.den)
+		}
+		d.frames = append(d.frames, f)
+	}
+	s.pending = s.pending[:0]
+}
+
+// granuleDuration returns pos*num/den seconds.
+func granuleDuration(pos int64, num, den uint64) time.Duration {
+	if den == 0 {
+		return 0
+	}
+	neg := pos < 0
+	if neg {
+		pos = -pos
+	}
+	hi, lo := bits.Mul64(uint64(pos), num)
+	if hi >= den {
+		return math.MaxInt64
+	}
+	q, r := bits.Div64(hi, lo, den)
+	hi, lo = bits.Mul64(r, uint64(time.Second))
+	f, _ := bits.Div64(hi, lo, den)
+	d := time.Duration(q)*time.Second + time.Duration(f)
+	if neg {
+		return -d
+	}
+	return d
+}
+
+// newOggStream detects the codec of a logical bitstream from its first
+// packet.
+func newOggStream(bos []byte) *oggStream {
+	s := &oggStream{track: -1, num: 1}
+	switch {
+	case bytes.HasPrefix(bos, []byte("\x01vorbis")):
+		s.codecID, s.headerCount = AudioCodecVORBIS, 3
+	case bytes.HasPrefix(bos, []byte("OpusHead")):
+		s.codecID, s.headerCount = AudioCodecOPUS, 2
+	case bytes.HasPrefix(bos, []byte("\x7fFLAC")) && len(bos) >= 9:
+		s.codecID = AudioCodecFLAC
+		// The number of header packets includes the first packet.
+		if n := int(binary.BigEndian.Uint16(bos[7:9])); n > 0 {
+			s.headerCount = n + 1
+		}
+	case bytes.HasPrefix(bos, []byte("\x80theora")):
+		s.codecID, s.headerCount = VideoCodecTHEORA, 3
+	}
+	return s
+}
+
+func (s *oggStream) isHeader(p []byte) bool {
+	if len(s.headers) == 0 {
+		return true
+	}
+	switch s.codecID {
+	case AudioCodecVORBIS:
+		return len(p) > 0 && p[0]&1 == 1
+	case AudioCodecOPUS:
+		return bytes.HasPrefix(p, []byte("OpusTags"))
+	case AudioCodecFLAC:
+		// Frames start with a sync code.
+		return len(p) > 0 && p[0] != 0xFF
+	case VideoCodecTHEORA:
+		return len(p) > 0 && p[0]&0x80 != 0
+	}
+	return false
+}
+
+// trackEntry builds the TrackEntry from the header packets.
+func (s *oggStream) trackEntry(number uint) (TrackEntry, error) {
+	h := s.headers
+	switch s.codecID {
+	case AudioCodecVORBIS:
+		if len(h) != 3 || len(h[0]) < 30 {
+			return TrackEntry{}, errors.New("invalid Vorbis headers")
+		}
+		ih, err := vorbis.ParseIdentificationHeader([30]byte(h[0]))
+		if err != nil {
+			return TrackEntry{}, err
+		}
+		if s.modes, err = vorbis.ParseSetupModes(h[2]); err != nil {
+			return TrackEntry{}, err
+		}
+		s.blocksizes = [2]int64{int64(ih.Blocksize0), int64(ih.Blocksize1)}
+		s.den = uint64(ih.AudioSampleRate)
+		t := newAudioTrackEntry(number, s.codecID, float64(ih.AudioSampleRate), uint(ih.AudioChannels))
+		cp := xiphLacing(h)
+		t.CodecPrivate = &cp
+		return t, nil
+	case AudioCodecOPUS:
+		if len(h[0]) < 19 {
+			return TrackEntry{}, errors.New("invalid OpusHead")
+		}
+		s.den = 48000
+		t := newAudioTrackEntry(number, s.codecID, 48000, uint(h[0][9]))
+		preSkip := int64(binary.LittleEndian.Uint16(h[0][10:12]))
+		t.CodecDelay = uint(granuleDuration(preSkip, s.num, s.den))
+		t.SeekPreRoll = uint(80 * time.Millisecond)
+		cp := h[0]
+		t.CodecPrivate = &cp
+		return t, nil
+	case AudioCodecFLAC:
+		// 0x7F "FLAC", version, number of headers, "fLaC" and STREAMINFO
+		if len(h[0]) < 9+4+4+34 || string(h[0][9:13]) != "fLaC" {
+			return TrackEntry{}, errors.New("invalid FLAC header")
+		}
+		rate, channels, depth := flacStreamInfo(h[0][17:])
+		s.den = rate
+		t := newAudioTrackEntry(number, s.codecID, float64(rate), channels)
+		t.Audio.BitDepth = &depth
+		// The metadata blocks follow the fLaC marker, and only the last
+		// one has its last-metadata-block flag set.
+		cp := []byte("fLaC")
+		for i, b := range append([][]byte{h[0][13:]}, h[1:]...) {
+			if len(b) < 4 {
+				return TrackEntry{}, errors.New("invalid FLAC metadata block")
+			}
+			flag := byte(0)
+			if i == len(h)-1 {
+				flag = 0x80
+			}
+			cp = append(cp, b[0]&0x7F|flag)
+			cp = append(cp, b[1:]...)
+		}
+		t.CodecPrivate = &cp
+		return t, nil
+	case VideoCodecTHEORA:
+		if len(h) != 3 || len(h[0]) < 42 {
+			return TrackEntry{}, errors.New("invalid Theora headers")
+		}
+		id := h[0]
+		be24 := func(b []byte) uint { return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2]) }
+		frn := uint64(binary.BigEndian.Uint32(id[22:26]))
+		frd := uint64(binary.BigEndian.Uint32(id[26:30]))
+		if frn == 0 || frd == 0 {
+			return TrackEntry{}, errors.New("invalid Theora frame rate")
+		}
+		s.num, s.den = frd, frn
+		s.granuleShift = uint(id[40]&0x03)<<3 | uint(id[41]>>5)
+		// Since version 3.2.1 the granule position counts the frames
+		// including the current one.
+		s.newGranule = id[7] > 3 || (id[7] == 3 && (id[8] > 2 || (id[8] == 2 && id[9] >= 1)))
+
+		t := NewTrackEntry(number, TrackTypeVideo, s.codecID)
+		t.Video = &Video{}
+		setDefaults(t.Video)
+		t.Video.PixelWidth = be24(id[14:17])
+		t.Video.PixelHeight = be24(id[17:20])
+		if parn, pard := be24(id[30:33]), be24(id[33:36]); parn != 0 && pard != 0 && parn != pard {
+			dw, dh := t.Video.PixelWidth*parn/pard, t.Video.PixelHeight
+			t.Video.DisplayWidth, t.Video.DisplayHeight = &dw, &dh
+		}
+		duration := uint(granuleDuration(1, s.num, s.den))
+		t.DefaultDuration = &duration
+		cp := xiphLacing(h)
+		t.CodecPrivate = &cp
+		return t, nil
+	}
+	return TrackEntry{}, fmt.Errorf("unsupported codec %s", s.codecID)
+}
+
+func newAudioTrackEntry(number uint, codecID string, rate float64, channels uint) TrackEntry {
+	t := NewTrackEntry(number, TrackTypeAudio, codecID)
+	t.Audio = &Audio{}
+	setDefaults(t.Audio)
+	t.Audio.SamplingFrequency = rate
+	t.Audio.Channels = channels
+	return t
+}
+
+// xiphLacing returns the packets in the Xiph lacing format used by the
+// CodecPrivate of Vorbis and Theora tracks.
+func xiphLacing(packets [][]byte) []byte {
+	b := []byte{byte(len(packets) - 1)}
+	for _, p := range packets[:len(packets)-1] {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			b = append(b, 255)
+		}
+		b = append(b, byte(n))
+	}
+	for _, p := range packets {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// duration returns the duration of a data packet in granules.
+func (s *oggStream) duration(p []byte) int64 {
+	switch s.codecID {
+	case AudioCodecVORBIS:
+		if len(p) == 0 || p[0]&1 == 1 {
+			return 0
+		}
+		mode := 0
+		if len(s.modes) > 1 {
+			mask := byte(1<<bits.Len(uint(len(s.modes)-1))-1) << 1
+			mode = int(p[0]&mask) >> 1
+		}
+		if mode >= len(s.modes) {
+			return 0
+		}
+		cur := s.blocksizes[0]
+		if s.modes[mode] {
+			cur = s.blocksizes[1]
+		}
+		prev := s.prevBlocksize
+		s.prevBlocksize = cur
+		if prev == 0 {
+			return 0 // the first packet only primes the decoder
+		}
+		return (prev + cur) / 4
+	case AudioCodecOPUS:
+		return opusPacketSamples(p)
+	case AudioCodecFLAC:
+		return flacFrameSamples(p)
+	case VideoCodecTHEORA:
+		return 1
+	}
+	return 0
+}
+
+// end returns the position after the last packet finished on a page
+// with the given granule position.
+func (s *oggStream) end(granule int64) int64 {
+	if s.codecID != VideoCodecTHEORA {
+		return granule
+	}
+	frames := granule>>s.granuleShift + granule&(1<<s.granuleShift-1)
+	if !s.newGranule {
+		frames++
+	}
+	return frames
+}
+
+// opusPacketSamples returns the number of 48 kHz samples of an Opus
+// packet based on Section 3.1 of RFC 6716.
+func opusPacketSamples(p []byte) int64 {
+	if len(p) == 0 {
+		return 0
+	}
+	config := p[0] >> 3
+	var frame int64
+	switch {
+	case config < 12: // SILK
+		frame = []int64{480, 960, 1920, 2880}[config%4]
+	case config < 16: // Hybrid
+		frame = []int64{480, 960}[config%2]
+	default: // CELT
+		frame = []int64{120, 240, 480, 960}[config%4]
+	}
+	switch p[0] & 0x03 {
+	case 0:
+		return frame
+	case 1, 2:
+		return 2 * frame
+	}
+	if len(p) < 2 {
+		return 0
+	}
+	return int64(p[1]&0x3F) * frame
+}
+
+// flacFrameSamples returns the block size of a FLAC frame.
+func flacFrameSamples(p []byte) int64 {
+	if len(p) < 5 || p[0] != 0xFF || p[1]&0xFE != 0xF8 {
+		return 0
+	}
+	switch code := p[2] >> 4; {
+	case code == 1:
+		return 192
+	case code >= 2 && code <= 5:
+		return 576 << (code - 2)
+	case code >= 8:
+		return 256 << (code - 8)
+	case code == 6 || code == 7:
+		// The block size follows the UTF-8 coded frame or sample number.
+		i := 4 + max(bits.LeadingZeros8(^p[4]), 1)
+		if code == 6 && i < len(p) {
+			return int64(p[i]) + 1
+		}
+		if code == 7 && i+1 < len(p) {
+			return int64(binary.BigEndian.Uint16(p[i:])) + 1
+		}
+	}
+	return 0
+}
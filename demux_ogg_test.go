@@ -0,0 +1,118 @@
+package matroska
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/coding-socks/matroska/internal/ogg"
+	"testing"
+	"time"
+)
+
+// vorbisSetupHeader returns a setup header with a short and a long mode.
+// The codebook, floor, residue and mapping configurations are replaced
+// by filler.
+func vorbisSetupHeader() []byte {
+	var bits []byte
+	put := func(v uint32, n int) {
+		for i := range n {
+			bits = append(bits, byte(v>>i)&1)
+		}
+	}
+	put(1, 6) // vorbis_mode_count - 1
+	for _, blockflag := range []uint32{0, 1} {
+		put(blockflag, 1)
+		put(0, 16) // vorbis_mode_windowtype
+		put(0, 16) // vorbis_mode_transformtype
+		put(0, 8)  // vorbis_mode_mapping
+	}
+	put(1, 1) // framing bit
+	b := append([]byte("\x05vorbis"), bytes.Repeat([]byte{0xFF}, 16)...)
+	for i := 0; i < len(bits); i += 8 {
+		var v byte
+		for j := 0; j < 8 && i+j < len(bits); j++ {
+			v |= bits[i+j] << j
+		}
+		b = append(b, v)
+	}
+	return b
+}
+
+func TestOggDemuxer(t *testing.T) {
+	ident := make([]byte, 30)
+	copy(ident, "\x01vorbis")
+	ident[11] = 2
+	binary.LittleEndian.PutUint32(ident[12:16], 44100)
+	ident[28] = 0xB8 // 256 and 2048
+	ident[29] = 1
+	comment := []byte("\x03vorbis\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+	setup := vorbisSetupHeader()
+
+	opusHead := []byte("OpusHead\x01\x02\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+	opusTags := []byte("OpusTags\x00\x00\x00\x00\x00\x00\x00\x00")
+	opus := []byte{31 << 3, 0} // CELT 20 ms
+
+	var buf bytes.Buffer
+	b := ogg.NewBuilder()
+	pages := []struct {
+		headerType byte
+		granule    uint64
+		serial     int32
+		packets    [][]byte
+	}{
+		{ogg.HeaderTypeFirstPage, 0, 1, [][]byte{ident}},
+		{ogg.HeaderTypeFirstPage, 0, 2, [][]byte{opusHead}},
+		{0, 0, 1, [][]byte{comment, setup}},
+		{0, 0, 2, [][]byte{opusTags}},
+		{0, 1600, 1, [][]byte{{0x02}, {0x02}, {0x00}}},
+		{0, 1920, 2, [][]byte{opus, opus}},
+		{ogg.HeaderTypeLastPage, 2500, 2, [][]byte{opus}},
+	}
+	for i, p := range pages {
+		b.B().HeaderType(p.headerType).GranulePosition(p.granule).SerialNum(p.serial).PageSequence(uint32(i)).Segments(p.packets).WriteTo(&buf)
+	}
+
+	d, err := NewOggDemuxer(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracks := d.Tracks()
+	if len(tracks) != 2 {
+		t.Fatalf("got %d tracks, want 2", len(tracks))
+	}
+	v, o := tracks[0], tracks[1]
+	if v.CodecID != AudioCodecVORBIS || v.Audio.SamplingFrequency != 44100 || v.Audio.Channels != 2 {
+		t.Errorf("Vorbis track = %+v", v)
+	}
+	if cp := *v.CodecPrivate; !bytes.Equal(cp[:3], []byte{2, 30, byte(len(comment))}) || len(cp) != 3+30+len(comment)+len(setup) {
+		t.Errorf("Vorbis CodecPrivate = %x", cp)
+	}
+	if o.CodecID != AudioCodecOPUS || o.CodecDelay != uint(6500*time.Microsecond) || !bytes.Equal(*o.CodecPrivate, opusHead) {
+		t.Errorf("Opus track = %+v", o)
+	}
+
+	type frame struct {
+		track          uint
+		ts             time.Duration
+		discardPadding time.Duration
+	}
+	want := []frame{
+		{1, 0, 0},
+		{1, 0, 0},
+		{1, granuleDuration(1024, 1, 44100), 0},
+		{2, 0, 0},
+		{2, 20 * time.Millisecond, 0},
+		{2, 40 * time.Millisecond, granuleDuration(380, 1, 48000)},
+	}
+	for i, w := range want {
+		f, err := d.ReadFrame()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if got := (frame{f.TrackNumber, f.Timestamp, f.DiscardPadding}); got != w {
+			t.Errorf("frame %d = %v, want %v", i, got, w)
+		}
+	}
+	if _, err := d.ReadFrame(); err == nil {
+		t.Error("ReadFrame() after the last frame should fail")
+	}
+}
@@ -0,0 +1,362 @@
+package matroska
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SubtitleDemuxer reads the events of a SubRip, SubStation Alpha or
+// WebVTT file as the frames of a single subtitle track.
+//
+// Every event becomes a frame with its start time as Timestamp and its
+// display time as Duration, so the Writer stores it in a BlockGroup
+// with a BlockDuration. Events which are never displayed, because they
+// do not end after they start, are skipped.
+type SubtitleDemuxer struct {
+	tracks []TrackEntry
+	frames []Frame
+}
+
+type subtitleEvent struct {
+	start, end time.Duration
+	data       []byte
+	additional []byte
+}
+
+func newSubtitleDemuxer(codecID string, codecPrivate []byte, events []subtitleEvent) *SubtitleDemuxer {
+	t := NewTrackEntry(1, TrackTypeSubtitle, codecID)
+	if len(codecPrivate) > 0 {
+		t.CodecPrivate = &codecPrivate
+	}
+	// Events are stored in presentation order. Formats which need the
+	// original order keep it in the block.
+	slices.SortStableFunc(events, func(a, b subtitleEvent) int {
+		return cmp.Compare(a.start, b.start)
+	})
+	d := &SubtitleDemuxer{tracks: []TrackEntry{t}}
+	for _, e := range events {
+		if e.end <= e.start {
+			continue
+		}
+		d.frames = append(d.frames, Frame{
+			TrackNumber:     1,
+			Timestamp:       e.start,
+			Duration:        e.end - e.start,
+			Keyframe:        true,
+			BlockAdditional: e.additional,
+			Data:            e.data,
+		})
+	}
+	return d
+}
+
+// Tracks returns the subtitle track.
+func (d *SubtitleDemuxer) Tracks() []TrackEntry {
+	return d.tracks
+}
+
+// ReadFrame returns the next event in presentation order.
+func (d *SubtitleDemuxer) ReadFrame() (Frame, error) {
+	if len(d.frames) == 0 {
+		return Frame{}, io.EOF
+	}
+	f := d.frames[0]
+	d.frames = d.frames[1:]
+	return f, nil
+}
+
+// readSubtitleLines reads a text subtitle file without its byte order
+// mark and splits it into lines.
+func readSubtitleLines(r io.Reader) ([]string, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("matroska: could not read subtitle file: %w", err)
+	}
+	b = bytes.TrimPrefix(b, []byte("\ufeff"))
+	s := strings.ReplaceAll(string(b), "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n"), nil
+}
+
+// NewSRTDemuxer reads a SubRip file into an S_TEXT/UTF8 track.
+//
+// Cue numbers are dropped, and the text of the cues is stored as is.
+// Coordinates following the end time are ignored.
+func NewSRTDemuxer(r io.Reader) (*SubtitleDemuxer, error) {
+	lines, err := readSubtitleLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var events []subtitleEvent
+	var text []string
+	var cue *subtitleEvent
+	flush := func() {
+		if cue != nil {
+			cue.data = []byte(strings.Join(text, "\n"))
+			events = append(events, *cue)
+		}
+		cue, text = nil, nil
+	}
+	for i, l := range lines {
+		if cue == nil && strings.Contains(l, "-->") {
+			start, end, _, err := parseSubtitleTiming(l)
+			if err != nil {
+				return nil, fmt.Errorf("matroska: line %d: %w", i+1, err)
+			}
+			cue = &subtitleEvent{start: start, end: end}
+			continue
+		}
+		if strings.TrimSpace(l) == "" {
+			flush()
+			continue
+		}
+		if cue != nil {
+			text = append(text, l)
+		}
+	}
+	flush()
+	return newSubtitleDemuxer(SubtitleCodecTEXTUTF8, nil, events), nil
+}
+
+// NewSSADemuxer reads a SubStation Alpha (v4) or Advanced SubStation
+// Alpha (v4+) script into an S_TEXT/SSA or S_TEXT/ASS track.
+//
+// The script without its Dialogue lines becomes the CodecPrivate, with
+// the [Events] section moved to the end. The Dialogue lines are stored
+// without the Start and End fields in the Matroska field layout, where
+// ReadOrder is the position of the line in the script.
+func NewSSADemuxer(r io.Reader) (*SubtitleDemuxer, error) {
+	lines, err := readSubtitleLines(r)
+	if err != nil {
+		return nil, err
+	}
+	const eventsSection = "[Events]"
+	codecID := SubtitleCodecTEXTSSA
+	var header, eventsHeader []string
+	var format []string
+	var events []subtitleEvent
+	var section string
+	for i, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		if len(trimmed) > 1 && trimmed[0] == '[' && trimmed[len(trimmed)-1] == ']' {
+			section = trimmed
+			if strings.EqualFold(section, "[V4+ Styles]") {
+				codecID = SubtitleCodecTEXTASS
+			}
+		}
+		if key, value, ok := strings.Cut(trimmed, ":"); ok && strings.EqualFold(key, "ScriptType") &&
+			strings.EqualFold(strings.TrimSpace(value), "v4.00+") {
+			codecID = SubtitleCodecTEXTASS
+		}
+		if !strings.EqualFold(section, eventsSection) {
+			header = append(header, l)
+			continue
+		}
+		value, ok := strings.CutPrefix(trimmed, "Dialogue:")
+		if !ok {
+			if v, ok := strings.CutPrefix(trimmed, "Format:"); ok {
+				format = strings.Split(v, ",")
+				for j := range format {
+					format[j] = strings.ToLower(strings.TrimSpace(format[j]))
+				}
+			}
+			eventsHeader = append(eventsHeader, l)
+			continue
+		}
+		if format == nil {
+			return nil, fmt.Errorf("matroska: line %d: Dialogue before Format line", i+1)
+		}
+		e, err := subStationAlphaBlock(format, strings.TrimLeft(value, " "), len(events))
+		if err != nil {
+			return nil, fmt.Errorf("matroska: line %d: %w", i+1, err)
+		}
+		events = append(events, e)
+	}
+	if format == nil {
+		return nil, fmt.Errorf("matroska: SubStation Alpha script requires an %s section with a Format line", eventsSection)
+	}
+	// Drop the blank lines separating the sections which were moved.
+	header = trimBlankLines(header)
+	if len(header) > 0 {
+		header = append(header, "")
+	}
+	eventsHeader = trimBlankLines(eventsHeader)
+	codecPrivate := strings.Join(append(header, eventsHeader...), "\n") + "\n"
+	return newSubtitleDemuxer(codecID, []byte(codecPrivate), events), nil
+}
+
+func trimBlankLines(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// subStationAlphaBlock converts the fields of a Dialogue line into an
+// event with the content of a block in the subStationAlphaFields order.
+func subStationAlphaBlock(format []string, value string, readOrder int) (subtitleEvent, error) {
+	fields := strings.SplitN(value, ",", len(format))
+	if len(fields) != len(format) {
+		return subtitleEvent{}, fmt.Errorf("Dialogue requires %d fields, got %d", len(format), len(fields))
+	}
+	column := func(names ...string) (string, bool) {
+		for _, name := range names {
+			if i := slices.Index(format, name); i >= 0 {
+				return fields[i], true
+			}
+		}
+		return "", false
+	}
+	start, ok := column("start")
+	end, ok2 := column("end")
+	if !ok || !ok2 {
+		return subtitleEvent{}, fmt.Errorf("Format requires Start and End fields")
+	}
+	var e subtitleEvent
+	var err error
+	if e.start, err = parseSubtitleTime(start); err != nil {
+		return subtitleEvent{}, err
+	}
+	if e.end, err = parseSubtitleTime(end); err != nil {
+		return subtitleEvent{}, err
+	}
+	block := make([]string, len(subStationAlphaFields))
+	for i, name := range subStationAlphaFields {
+		switch name {
+		case "readorder":
+			block[i] = strconv.Itoa(readOrder)
+		case "layer":
+			// SSA stores the Marked field in place of the ASS Layer field.
+			block[i], _ = column("layer", "marked")
+		case "name":
+			block[i], _ = column("name", "actor")
+		default:
+			block[i], _ = column(name)
+		}
+	}
+	e.data = []byte(strings.Join(block, ","))
+	return e, nil
+}
+
+// NewWebVTTDemuxer reads a WebVTT file into an S_TEXT/WEBVTT track.
+//
+// The blocks preceding the first cue, including STYLE and REGION
+// blocks, become the CodecPrivate. The payload of a cue is stored in
+// the block, while its settings, its identifier and the comments
+// preceding it are stored in the BlockAdditional as described by the
+// Matroska codec specification.
+func NewWebVTTDemuxer(r io.Reader) (*SubtitleDemuxer, error) {
+	lines, err := readSubtitleLines(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "WEBVTT") {
+		return nil, fmt.Errorf("matroska: WebVTT file requires a WEBVTT signature")
+	}
+	var blocks [][]string
+	var block []string
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			if len(block) > 0 {
+				blocks = append(blocks, block)
+			}
+			block = nil
+			continue
+		}
+		block = append(block, l)
+	}
+	if len(block) > 0 {
+		blocks = append(blocks, block)
+	}
+
+	var header, comments []string
+	var events []subtitleEvent
+	cues := false
+	for _, b := range blocks {
+		timing := slices.IndexFunc(b, func(l string) bool {
+			return strings.Contains(l, "-->")
+		})
+		if timing < 0 || timing > 1 {
+			if !cues {
+				header = append(header, strings.Join(b, "\n"))
+			} else if strings.HasPrefix(b[0], "NOTE") {
+				comments = append(comments, strings.Join(b, "\n"))
+			}
+			continue
+		}
+		start, end, settings, err := parseSubtitleTiming(b[timing])
+		if err != nil {
+			return nil, fmt.Errorf("matroska: cue %q: %w", b[timing], err)
+		}
+		var id string
+		if timing == 1 {
+			id = b[0]
+		}
+		e := subtitleEvent{start: start, end: end, data: []byte(strings.Join(b[timing+1:], "\n"))}
+		if settings != "" || id != "" || len(comments) > 0 {
+			additional := settings + "\n" + id
+			if len(comments) > 0 {
+				additional += "\n" + strings.Join(comments, "\n\n")
+			}
+			e.additional = []byte(additional)
+		}
+		comments, cues = nil, true
+		events = append(events, e)
+	}
+	return newSubtitleDemuxer(SubtitleCodecTEXTWEBVTT, []byte(strings.Join(header, "\n\n")), events), nil
+}
+
+// parseSubtitleTiming parses a cue timing line of SubRip and WebVTT
+// files and returns the start time, the end time and the rest of the
+// line.
+func parseSubtitleTiming(l string) (start, end time.Duration, settings string, err error) {
+	a, b, _ := strings.Cut(l, "-->")
+	if start, err = parseSubtitleTime(a); err != nil {
+		return 0, 0, "", err
+	}
+	b = strings.TrimSpace(b)
+	v, settings, _ := strings.Cut(b, " ")
+	if end, err = parseSubtitleTime(v); err != nil {
+		return 0, 0, "", err
+	}
+	return start, end, strings.TrimSpace(settings), nil
+}
+
+// parseSubtitleTime parses the [hours:]minutes:seconds[.fraction]
+// timestamps of SubRip, SubStation Alpha and WebVTT files. The fraction
+// may be separated by a comma, and it may have any number of digits.
+func parseSubtitleTime(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	var frac time.Duration
+	if i := strings.LastIndexAny(s, ",."); i >= 0 {
+		digits := s[i+1:]
+		n, err := strconv.ParseUint(digits, 10, 32)
+		if err != nil || len(digits) > 9 {
+			return 0, fmt.Errorf("invalid timestamp: %q", s)
+		}
+		frac = time.Duration(n)
+		for range 9 - len(digits) {
+			frac *= 10
+		}
+		s = s[:i]
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp: %q", s)
+	}
+	var d time.Duration
+	for _, p := range parts {
+		n, err := strconv.ParseUint(strings.TrimSpace(p), 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp: %q", s)
+		}
+		d = d*60 + time.Duration(n)
+	}
+	return d*time.Second + frac, nil
+}
@@ -0,0 +1,175 @@
+package matroska
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readFrames(t *testing.T, d Demuxer) []Frame {
+	t.Helper()
+	var frames []Frame
+	for {
+		f, err := d.ReadFrame()
+		if errors.Is(err, io.EOF) {
+			return frames
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, f)
+	}
+}
+
+func TestSRTDemuxer(t *testing.T) {
+	const srt = "\ufeff1\r\n00:00:01,000 --> 00:00:02,500\r\nFirst\r\nline two\r\n\r\n" +
+		"2\r\n00:00:00,500 --> 00:00:00.750 X1:10 X2:20 Y1:10 Y2:20\r\n<i>Earlier</i>\r\n\r\n" +
+		"3\r\n00:00:03,000 --> 00:00:03,000\r\nNever displayed\r\n"
+	d, err := NewSRTDemuxer(strings.NewReader(srt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Tracks()[0].CodecID; got != SubtitleCodecTEXTUTF8 {
+		t.Errorf("CodecID = %s, want %s", got, SubtitleCodecTEXTUTF8)
+	}
+	frames := readFrames(t, d)
+	want := []Frame{
+		{TrackNumber: 1, Timestamp: 500 * time.Millisecond, Duration: 250 * time.Millisecond, Keyframe: true, Data: []byte("<i>Earlier</i>")},
+		{TrackNumber: 1, Timestamp: time.Second, Duration: 1500 * time.Millisecond, Keyframe: true, Data: []byte("First\nline two")},
+	}
+	if len(frames) != len(want) {
+		t.Fatalf("got %d frames, want %d", len(frames), len(want))
+	}
+	for i := range want {
+		if f := frames[i]; f.Timestamp != want[i].Timestamp || f.Duration != want[i].Duration || !bytes.Equal(f.Data, want[i].Data) {
+			t.Errorf("frame %d = %+v, want %+v", i, f, want[i])
+		}
+	}
+}
+
+func TestSSADemuxer(t *testing.T) {
+	// The reconstructor orders the Dialogue lines by ReadOrder, so
+	// importing its output and reconstructing it again must not change
+	// the script.
+	for _, golden := range []string{"ass.golden", "ssa.golden"} {
+		t.Run(golden, func(t *testing.T) {
+			want, err := os.ReadFile(filepath.Join("testdata", golden))
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := NewSSADemuxer(bytes.NewReader(want))
+			if err != nil {
+				t.Fatal(err)
+			}
+			track := d.Tracks()[0]
+			if wantID := map[string]string{"ass.golden": SubtitleCodecTEXTASS, "ssa.golden": SubtitleCodecTEXTSSA}[golden]; track.CodecID != wantID {
+				t.Errorf("CodecID = %s, want %s", track.CodecID, wantID)
+			}
+			r := newSubStationAlphaReconstructor(*track.CodecPrivate)
+			for _, f := range readFrames(t, d) {
+				if err := r.Add(f.Data, f.Timestamp, f.Timestamp+f.Duration); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var buf bytes.Buffer
+			if _, err := r.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.Bytes(); !bytes.Equal(got, want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestSSADemuxerEventsSection(t *testing.T) {
+	const ass = "[Script Info]\nScriptType: v4.00+\n\n" +
+		"[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n" +
+		"Comment: 0,0:00:00.00,0:00:01.00,Default,,0,0,0,,Note\n" +
+		"Dialogue: 1,0:00:01.50,0:00:02.00,Default,Alice,0,0,0,,Hello, world\n\n" +
+		"[Fonts]\nfontname: a.ttf\n"
+	d, err := NewSSADemuxer(strings.NewReader(ass))
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := d.Tracks()[0]
+	wantHeader := "[Script Info]\nScriptType: v4.00+\n\n[Fonts]\nfontname: a.ttf\n\n" +
+		"[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n" +
+		"Comment: 0,0:00:00.00,0:00:01.00,Default,,0,0,0,,Note\n"
+	if got := string(*track.CodecPrivate); got != wantHeader {
+		t.Errorf("CodecPrivate = %q, want %q", got, wantHeader)
+	}
+	frames := readFrames(t, d)
+	if len(frames) != 1 {
+		t.Fatalf("got %d frames, want 1", len(frames))
+	}
+	if got, want := string(frames[0].Data), "0,1,Default,Alice,0,0,0,,Hello, world"; got != want {
+		t.Errorf("Data = %q, want %q", got, want)
+	}
+	if f := frames[0]; f.Timestamp != 1500*time.Millisecond || f.Duration != 500*time.Millisecond {
+		t.Errorf("Timestamp, Duration = %v, %v, want 1.5s, 500ms", f.Timestamp, f.Duration)
+	}
+}
+
+func TestWebVTTDemuxer(t *testing.T) {
+	const vtt = "WEBVTT - Test\n\nSTYLE\n::cue { color: lime }\n\nNOTE header comment\n\n" +
+		"intro\n00:01.000 --> 00:02.000 align:start line:0\nHello\n<b>world</b>\n\n" +
+		"NOTE about the next cue\n\n" +
+		"00:00:02.000 --> 00:00:03.000\nNo identifier\n\n" +
+		"00:00:03.000 --> 00:00:04.000\nPlain\n"
+	d, err := NewWebVTTDemuxer(strings.NewReader(vtt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := d.Tracks()[0]
+	if track.CodecID != SubtitleCodecTEXTWEBVTT {
+		t.Errorf("CodecID = %s, want %s", track.CodecID, SubtitleCodecTEXTWEBVTT)
+	}
+	wantHeader := "WEBVTT - Test\n\nSTYLE\n::cue { color: lime }\n\nNOTE header comment"
+	if got := string(*track.CodecPrivate); got != wantHeader {
+		t.Errorf("CodecPrivate = %q, want %q", got, wantHeader)
+	}
+	want := []struct {
+		timestamp  time.Duration
+		data       string
+		additional string
+	}{
+		{time.Second, "Hello\n<b>world</b>", "align:start line:0\nintro"},
+		{2 * time.Second, "No identifier", "\n\nNOTE about the next cue"},
+		{3 * time.Second, "Plain", ""},
+	}
+	frames := readFrames(t, d)
+	if len(frames) != len(want) {
+		t.Fatalf("got %d frames, want %d", len(frames), len(want))
+	}
+	for i, w := range want {
+		f := frames[i]
+		if f.Timestamp != w.timestamp || f.Duration != time.Second || string(f.Data) != w.data || string(f.BlockAdditional) != w.additional {
+			t.Errorf("frame %d = %v %v %q %q, want %v 1s %q %q", i, f.Timestamp, f.Duration, f.Data, f.BlockAdditional, w.timestamp, w.data, w.additional)
+		}
+	}
+}
+
+func TestRemuxSubtitle(t *testing.T) {
+	const srt = "1\n00:00:01,000 --> 00:00:02,000\nFirst\n\n2\n00:00:03,000 --> 00:00:04,250\nSecond\n\n"
+	d, err := NewSRTDemuxer(strings.NewReader(srt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ws writeSeeker
+	if err := Remux(&ws, d, Info{}, WriterOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	s := NewScanner(bytes.NewReader(ws.buf))
+	var buf bytes.Buffer
+	if err := extractTrackSRT(&buf, s, s.Tracks().TrackEntry[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != srt {
+		t.Errorf("got:\n%s\nwant:\n%s", got, srt)
+	}
+}
@@ -0,0 +1,136 @@
+package matroska
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/coding-socks/matroska/internal/wav"
+	"io"
+	"math/bits"
+)
+
+// WAVDemuxer reads the samples of a WAVE or an RF64 file as a single
+// A_PCM/INT/LIT or A_PCM/FLOAT/IEEE audio track.
+//
+// Each Block holds 40 milliseconds of samples, and the speakers of a
+// channel mask are stored in the ChannelPositions when each of them has
+// a horizontal angle.
+type WAVDemuxer struct {
+	r      *wav.Reader
+	tracks []TrackEntry
+
+	rate       uint64
+	blockAlign int
+	// frameSamples is the number of samples of a Block, and samples is
+	// the number of samples which were read.
+	frameSamples uint64
+	samples      uint64
+}
+
+// NewWAVDemuxer reads the chunks of a WAVE or an RF64 file until the
+// data chunk.
+func NewWAVDemuxer(r io.Reader) (*WAVDemuxer, error) {
+	wr, err := wav.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("matroska: could not read wav file: %w", err)
+	}
+	f := wr.Format
+	if f.Channels == 0 || f.SamplesPerSec == 0 || f.BlockAlign == 0 || f.BlockAlign%f.Channels != 0 {
+		return nil, fmt.Errorf("matroska: invalid wav format: %d channels, %d Hz, block align %d", f.Channels, f.SamplesPerSec, f.BlockAlign)
+	}
+	// The samples of formats using less bits per sample are stored in
+	// the most significant bits of their container.
+	depth := uint(f.BlockAlign/f.Channels) * 8
+	var codecID string
+	switch {
+	case f.FormatTag == wav.FormatPCM && depth <= 32:
+		codecID = AudioCodecPCM
+	case f.FormatTag == wav.FormatIEEEFloat && (depth == 32 || depth == 64):
+		codecID = AudioCodecPCM_FLOAT
+	default:
+		return nil, fmt.Errorf("matroska: unsupported wav format: tag 0x%04X, %d bits", f.FormatTag, depth)
+	}
+	d := &WAVDemuxer{r: wr, rate: uint64(f.SamplesPerSec), blockAlign: int(f.BlockAlign)}
+	d.frameSamples = max(d.rate/25, 1)
+	t := newAudioTrackEntry(1, codecID, float64(f.SamplesPerSec), uint(f.Channels))
+	t.Audio.BitDepth = &depth
+	if p := channelPositions(f.ChannelMask, int(f.Channels)); p != nil {
+		t.Audio.ChannelPositions = &p
+	}
+	duration := uint(granuleDuration(int64(d.frameSamples), 1, d.rate))
+	t.DefaultDuration = &duration
+	d.tracks = []TrackEntry{t}
+	return d, nil
+}
+
+// Tracks returns the audio track.
+func (d *WAVDemuxer) Tracks() []TrackEntry {
+	return d.tracks
+}
+
+// ReadFrame returns the next frame. The last frame has a Duration when
+// it is shorter than the others, and an incomplete last sample is
+// dropped.
+func (d *WAVDemuxer) ReadFrame() (Frame, error) {
+	b := make([]byte, int(d.frameSamples)*d.blockAlign)
+	n, err := io.ReadFull(d.r, b)
+	if err != nil && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return Frame{}, fmt.Errorf("matroska: could not read wav data: %w", err)
+	}
+	n -= n % d.blockAlign
+	if n == 0 {
+		return Frame{}, io.EOF
+	}
+	samples := uint64(n / d.blockAlign)
+	ts := granuleDuration(int64(d.samples), 1, d.rate)
+	frame := Frame{TrackNumber: 1, Timestamp: ts, Keyframe: true, Data: b[:n]}
+	d.samples += samples
+	if samples != d.frameSamples {
+		frame.Duration = granuleDuration(int64(d.samples), 1, d.rate) - ts
+	}
+	return frame, nil
+}
+
+// speakerAngles are the horizontal angles in degrees of the speakers of
+// a channel mask, clockwise from the front center. The low frequency
+// speaker has none.
+var speakerAngles = map[uint32]int16{
+	wav.SpeakerFrontLeft:          -30,
+	wav.SpeakerFrontRight:         30,
+	wav.SpeakerFrontCenter:        0,
+	wav.SpeakerBackLeft:           -135,
+	wav.SpeakerBackRight:          135,
+	wav.SpeakerFrontLeftOfCenter:  -15,
+	wav.SpeakerFrontRightOfCenter: 15,
+	wav.SpeakerBackCenter:         180,
+	wav.SpeakerSideLeft:           -90,
+	wav.SpeakerSideRight:          90,
+	wav.SpeakerTopCenter:          0,
+	wav.SpeakerTopFrontLeft:       -30,
+	wav.SpeakerTopFrontCenter:     0,
+	wav.SpeakerTopFrontRight:      30,
+	wav.SpeakerTopBackLeft:        -135,
+	wav.SpeakerTopBackCenter:      180,
+	wav.SpeakerTopBackRight:       135,
+}
+
+// channelPositions returns the table of horizontal angles of the
+// channels as big-endian 16-bit signed integers. It returns nil when
+// the mask does not describe each channel with a speaker which has an
+// angle.
+func channelPositions(mask uint32, channels int) []byte {
+	if bits.OnesCount32(mask) != channels {
+		return nil
+	}
+	var b []byte
+	// The channels are interleaved in the order of the bits of the
+	// mask.
+	for ; mask != 0; mask &= mask - 1 {
+		angle, ok := speakerAngles[mask&-mask]
+		if !ok {
+			return nil
+		}
+		b = binary.BigEndian.AppendUint16(b, uint16(angle))
+	}
+	return b
+}
@@ -0,0 +1,115 @@
+package matroska
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestWAVDemuxer(t *testing.T) {
+	t.Run("WAVE_FORMAT_EXTENSIBLE", func(t *testing.T) {
+		// 8 kHz, stereo, 24 bits of which 20 are valid
+		fmtChunk := binary.LittleEndian.AppendUint16(nil, 0xFFFE)
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 2)
+		fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, 8000)
+		fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, 8000*6)
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 6)
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 24)
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 22)
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 20)
+		fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, 0x3)
+		fmtChunk = append(fmtChunk, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71)
+		// Two and a half Blocks of 320 samples and an incomplete sample
+		data := make([]byte, 800*6+1)
+		var file []byte
+		file = append(file, "RIFF"...)
+		file = binary.LittleEndian.AppendUint32(file, uint32(4+8+len(fmtChunk)+8+len(data)+1))
+		file = append(file, "WAVE"...)
+		file = append(file, "fmt "...)
+		file = binary.LittleEndian.AppendUint32(file, uint32(len(fmtChunk)))
+		file = append(file, fmtChunk...)
+		file = append(file, "data"...)
+		file = binary.LittleEndian.AppendUint32(file, uint32(len(data)))
+		file = append(file, data...)
+		file = append(file, 0)
+		d, err := NewWAVDemuxer(bytes.NewReader(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		track := d.Tracks()[0]
+		if track.CodecID != AudioCodecPCM || track.Audio.BitDepth == nil || *track.Audio.BitDepth != 24 {
+			t.Errorf("track = %s %v, want %s 24 bits", track.CodecID, track.Audio.BitDepth, AudioCodecPCM)
+		}
+		if got, want := *track.DefaultDuration, uint(40*time.Millisecond); got != want {
+			t.Errorf("DefaultDuration = %d, want %d", got, want)
+		}
+		if track.Audio.ChannelPositions == nil {
+			t.Fatal("ChannelPositions = nil")
+		}
+		if got, want := *track.Audio.ChannelPositions, []byte{0xFF, 0xE2, 0x00, 0x1E}; !bytes.Equal(got, want) {
+			t.Errorf("ChannelPositions = % x, want % x", got, want)
+		}
+		frames := readFrames(t, d)
+		if len(frames) != 3 {
+			t.Fatalf("got %d frames, want 3", len(frames))
+		}
+		if got, want := len(frames[2].Data), 160*6; got != want {
+			t.Errorf("got %d octets, want %d", got, want)
+		}
+		if got, want := frames[2].Timestamp, 80*time.Millisecond; got != want {
+			t.Errorf("Timestamp = %v, want %v", got, want)
+		}
+		if got, want := frames[2].Duration, 20*time.Millisecond; got != want {
+			t.Errorf("Duration = %v, want %v", got, want)
+		}
+	})
+	t.Run("RF64", func(t *testing.T) {
+		// 1 kHz, mono, 32-bit IEEE float
+		fmtChunk := binary.LittleEndian.AppendUint16(nil, 0x0003)
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 1)
+		fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, 1000)
+		fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, 4000)
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 4)
+		fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 32)
+		data := make([]byte, 40*4)
+		var file []byte
+		file = append(file, "RF64\xFF\xFF\xFF\xFFWAVE"...)
+		file = append(file, "ds64\x1C\x00\x00\x00"...)
+		file = binary.LittleEndian.AppendUint64(file, uint64(4+8+28+8+len(fmtChunk)+8+len(data)))
+		file = binary.LittleEndian.AppendUint64(file, uint64(len(data)))
+		file = binary.LittleEndian.AppendUint64(file, 40)
+		file = binary.LittleEndian.AppendUint32(file, 0)
+		file = append(file, "fmt "...)
+		file = binary.LittleEndian.AppendUint32(file, uint32(len(fmtChunk)))
+		file = append(file, fmtChunk...)
+		file = append(file, "data\xFF\xFF\xFF\xFF"...)
+		file = append(file, data...)
+		file = append(file, "LIST\x04\x00\x00\x00INFO"...)
+		d, err := NewWAVDemuxer(bytes.NewReader(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ws writeSeeker
+		if err := Remux(&ws, d, Info{}, WriterOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		s := NewScanner(bytes.NewReader(ws.buf))
+		if got := s.Tracks().TrackEntry[0].CodecID; got != AudioCodecPCM_FLOAT {
+			t.Errorf("CodecID = %s, want %s", got, AudioCodecPCM_FLOAT)
+		}
+		if got := *s.Info().Duration; got != 40 {
+			t.Errorf("Duration = %v, want 40", got)
+		}
+		blocks := 0
+		for s.Next() {
+			blocks += len(s.Cluster().SimpleBlock) + len(s.Cluster().BlockGroup)
+		}
+		if err := s.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if blocks != 1 {
+			t.Errorf("got %d blocks, want 1", blocks)
+		}
+	})
+}
@@ -844,7 +844,7 @@ const (
 )
 
 type ChapterAtom struct {
-	ChapterAtom              *ChapterAtom
+	ChapterAtom              []ChapterAtom
 	ChapterUID               uint
 	ChapterStringUID         *string
 	ChapterTimeStart         uint
@@ -912,7 +912,7 @@ type Targets struct {
 }
 
 type SimpleTag struct {
-	SimpleTag        *SimpleTag
+	SimpleTag        []SimpleTag
 	TagName          string
 	TagLanguage      string
 	TagLanguageBCP47 *string
@@ -0,0 +1,533 @@
+package matroska
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/coding-socks/ebml"
+	"github.com/coding-socks/ebml/schema"
+	"io"
+	"maps"
+	"math/bits"
+	"slices"
+)
+
+// editorElement is a top level element of the Segment. The content of
+// changed elements is written by Save, the content of a changed Void
+// element is not written.
+type editorElement struct {
+	id      schema.ElementID
+	pos     int64
+	size    int64
+	content []byte
+	changed bool
+}
+
+func (el editorElement) end() int64 {
+	return el.pos + el.size
+}
+
+// Editor modifies the Info, the Tracks, the Tags, the Chapters and the
+// Attachments of a Matroska file without rewriting the whole file.
+//
+// A changed element is written over the original one, and the Void
+// elements following it absorb the difference in size. An element which
+// does not fit anymore is replaced by a Void element and written at the
+// end of the Segment, and the SeekHead is updated to point to its new
+// position. Elements which are not changed are left untouched.
+type Editor struct {
+	f io.ReadWriteSeeker
+
+	info        *Info
+	tracks      *Tracks
+	tags        *Tags
+	chapters    *Chapters
+	attachments *Attachments
+	// original holds the encoding of the elements as they were read.
+	original map[schema.ElementID][]byte
+
+	segmentStart   int64
+	segmentSizePos int64
+	segmentSizeLen int
+	knownSize      bool
+	// atEnd signals that the Segment ends at the end of the file, so
+	// elements can be appended to it.
+	atEnd      bool
+	segmentEnd int64
+	// firstCluster is the position of the first Cluster, or zero when it
+	// is not known.
+	firstCluster int64
+	elements     []editorElement
+	seeks        []Seek
+}
+
+// NewEditor reads the top level elements of the Matroska file f. The
+// SeekHead locates the elements following the Clusters.
+func NewEditor(f io.ReadWriteSeeker) (*Editor, error) {
+	s := NewScanner(f)
+	if err := s.Init(); err != nil {
+		return nil, err
+	}
+	if s.Info() == nil || s.Tracks() == nil {
+		return nil, errors.New("matroska: missing Info or Tracks element")
+	}
+	e := &Editor{
+		f:            f,
+		info:         s.Info(),
+		tracks:       s.Tracks(),
+		chapters:     s.Chapters(),
+		tags:         s.Tags(),
+		attachments:  s.Attachments(),
+		original:     make(map[schema.ElementID][]byte),
+		segmentStart: s.segmentStart,
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	for id, v := range e.values() {
+		b, err := e.marshal(id, v)
+		if err != nil {
+			return nil, err
+		}
+		e.original[id] = b
+	}
+	// A constructed SeekHead still has the positions of the elements
+	// read by the Scanner.
+	sh, ok := s.SeekHead()
+	if ok {
+		for _, seek := range sh.Seek {
+			if seek.SeekID != IDSeekHead {
+				e.seeks = append(e.seeks, seek)
+			}
+		}
+	}
+	if err := e.readLayout(sh); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// readLayout reads the headers of the top level elements of the Segment.
+// The reading stops at the first element with an unknown size, and the
+// elements after it are located by the SeekHead.
+func (e *Editor) readLayout(sh *SeekHead) error {
+	size, err := e.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("matroska: could not seek: %w", err)
+	}
+	// The Segment header precedes segmentStart. Its Element ID is 4
+	// octets long, and its Data Size is at most 8 octets long.
+	var segment []byte
+	for n := 1; n <= 8; n++ {
+		b, err := e.readAt(e.segmentStart-int64(4+n), 4+n)
+		if err != nil {
+			return err
+		}
+		if id, hl, ds, ok := parseElementHeader(b); ok && id == IDSegment && hl == len(b) {
+			segment = b
+			e.knownSize = ds != -1
+			if e.knownSize {
+				size = min(size, e.segmentStart+ds)
+			}
+			break
+		}
+	}
+	if segment == nil {
+		return errors.New("matroska: could not locate the Segment header")
+	}
+	e.segmentSizePos, e.segmentSizeLen = e.segmentStart-int64(len(segment)-4), len(segment)-4
+	end, err := e.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("matroska: could not seek: %w", err)
+	}
+	e.atEnd, e.segmentEnd = end == size, size
+
+	known := make(map[int64]bool)
+	for pos := e.segmentStart; pos < size; {
+		id, hl, ds, err := e.readHeader(pos)
+		if err == nil && id == IDCluster && e.firstCluster == 0 {
+			e.firstCluster = pos
+		}
+		if err != nil || ds == -1 {
+			break
+		}
+		e.elements = append(e.elements, editorElement{id: id, pos: pos, size: int64(hl) + ds})
+		known[pos] = true
+		pos += int64(hl) + ds
+	}
+	if sh != nil {
+		for _, seek := range sh.Seek {
+			pos := e.segmentStart + int64(seek.SeekPosition)
+			if known[pos] {
+				continue
+			}
+			id, hl, ds, err := e.readHeader(pos)
+			if err != nil || id != seek.SeekID || ds == -1 {
+				continue
+			}
+			e.elements = append(e.elements, editorElement{id: id, pos: pos, size: int64(hl) + ds})
+			known[pos] = true
+		}
+	}
+	slices.SortFunc(e.elements, func(a, b editorElement) int { return cmp.Compare(a.pos, b.pos) })
+	return nil
+}
+
+// Info returns the Info element. Changes to it are written by Save.
+func (e *Editor) Info() *Info {
+	return e.info
+}
+
+// Tracks returns the Tracks element. Changes to it are written by Save.
+func (e *Editor) Tracks() *Tracks {
+	return e.tracks
+}
+
+// Track returns the TrackEntry with the given TrackNumber.
+func (e *Editor) Track(number uint) (*TrackEntry, bool) {
+	for i := range e.tracks.TrackEntry {
+		if e.tracks.TrackEntry[i].TrackNumber == number {
+			return &e.tracks.TrackEntry[i], true
+		}
+	}
+	return nil, false
+}
+
+// Tags returns the tags of every Tags element merged into a single Tags,
+// or nil when the file does not have tags. Save writes them into a single
+// Tags element.
+func (e *Editor) Tags() *Tags {
+	return e.tags
+}
+
+// SetTags replaces the tags. A nil t removes the Tags elements.
+func (e *Editor) SetTags(t *Tags) {
+	e.tags = t
+}
+
+// Chapters returns the Chapters element, or nil when the file does not
+// have chapters.
+func (e *Editor) Chapters() *Chapters {
+	return e.chapters
+}
+
+// SetChapters replaces the chapters. A nil c removes the Chapters element.
+func (e *Editor) SetChapters(c *Chapters) {
+	e.chapters = c
+}
+
+// Attachments returns the Attachments element, or nil when the file does
+// not have attachments. AddAttachment, ReplaceAttachment and
+// RemoveAttachment modify it.
+func (e *Editor) Attachments() *Attachments {
+	return e.attachments
+}
+
+// SetAttachments replaces the attachments. A nil a, or an a without
+// attached files, removes the Attachments element.
+func (e *Editor) SetAttachments(a *Attachments) {
+	e.attachments = a
+}
+
+func (e *Editor) values() map[schema.ElementID]any {
+	return map[schema.ElementID]any{IDInfo: e.info, IDTracks: e.tracks, IDChapters: e.chapters, IDTags: e.tags, IDAttachments: e.attachments}
+}
+
+// Save writes the changed elements into the file. Nothing is written when
+// the changes cannot be saved.
+func (e *Editor) Save() error {
+	values := e.values()
+	changes := make(map[schema.ElementID][]byte)
+	for id, v := range values {
+		b, err := e.marshal(id, v)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(b, e.original[id]) {
+			changes[id] = b
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	elements, seeks, end := slices.Clone(e.elements), slices.Clone(e.seeks), e.segmentEnd
+	if err := e.plan(changes); err != nil {
+		e.elements, e.seeks, e.segmentEnd = elements, seeks, end
+		return err
+	}
+	if e.knownSize && e.segmentEnd != end {
+		size := appendDataSize(nil, e.segmentEnd-e.segmentStart, e.segmentSizeLen)
+		if len(size) != e.segmentSizeLen {
+			e.elements, e.seeks, e.segmentEnd = elements, seeks, end
+			return errors.New("matroska: Segment size does not fit into its Data Size")
+		}
+		if err := e.writeAt(size, e.segmentSizePos); err != nil {
+			return err
+		}
+	}
+	for i, el := range e.elements {
+		if !el.changed {
+			continue
+		}
+		b := el.content
+		if el.id == ebml.IDVoid {
+			b = appendVoidHeader(nil, int(el.size))
+		}
+		if err := e.writeAt(b, el.pos); err != nil {
+			return err
+		}
+		e.elements[i].content, e.elements[i].changed = nil, false
+	}
+	for id, b := range changes {
+		e.original[id] = b
+	}
+	return nil
+}
+
+// plan places the changed elements into the layout. The elements are
+// placed from the end of the Segment, so an element moved to the end
+// leaves room for the elements before it.
+func (e *Editor) plan(changes map[schema.ElementID][]byte) error {
+	first := func(id schema.ElementID) int64 {
+		if i := slices.IndexFunc(e.elements, func(el editorElement) bool { return el.id == id }); i >= 0 {
+			return e.elements[i].pos
+		}
+		return -1
+	}
+	ids := slices.Collect(maps.Keys(changes))
+	slices.SortFunc(ids, func(a, b schema.ElementID) int { return cmp.Compare(first(b), first(a)) })
+	seeks := slices.Clone(e.seeks)
+	for _, id := range ids {
+		if err := e.replace(id, changes[id]); err != nil {
+			return err
+		}
+	}
+	if slices.Equal(seeks, e.seeks) {
+		return nil
+	}
+	// The Scanner looks for the Clusters after the Tracks unless the
+	// SeekHead points to them.
+	if e.firstCluster != 0 && !slices.ContainsFunc(e.seeks, func(s Seek) bool { return s.SeekID == IDCluster }) {
+		e.seeks = append(e.seeks, Seek{SeekID: IDCluster, SeekPosition: uint(e.firstCluster - e.segmentStart)})
+	}
+	return e.replaceSeekHead()
+}
+
+// marshal returns the encoding of v, or nil when v is nil.
+func (e *Editor) marshal(id schema.ElementID, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case *Chapters:
+		if v == nil {
+			return nil, nil
+		}
+	case *Tags:
+		if v == nil {
+			return nil, nil
+		}
+	case *Attachments:
+		if v == nil || len(v.AttachedFile) == 0 {
+			return nil, nil
+		}
+	}
+	return MarshalElement(id, v)
+}
+
+// replace places the encoding b of the element id into the layout. The
+// first element with the id is replaced, the others are removed. A nil b
+// removes every element with the id.
+func (e *Editor) replace(id schema.ElementID, b []byte) error {
+	var positions []int64
+	for _, el := range e.elements {
+		if el.id == id {
+			positions = append(positions, el.pos)
+		}
+	}
+	placed := len(positions) > 0 && b != nil && e.place(positions[0], b)
+	if placed {
+		positions = positions[1:]
+	}
+	for _, pos := range positions {
+		e.free(pos)
+		e.seeks = slices.DeleteFunc(e.seeks, func(s Seek) bool {
+			return s.SeekID == id && e.segmentStart+int64(s.SeekPosition) == pos
+		})
+	}
+	if b == nil || placed {
+		return nil
+	}
+	pos, err := e.append(b)
+	if err != nil {
+		return err
+	}
+	e.seeks = append(e.seeks, Seek{SeekID: id, SeekPosition: uint(pos - e.segmentStart)})
+	return nil
+}
+
+// replaceSeekHead writes the seeks into the first SeekHead. When they do
+// not fit, they are written into a SeekHead at the end of the Segment,
+// and the first SeekHead only points to it.
+func (e *Editor) replaceSeekHead() error {
+	var positions []int64
+	for _, el := range e.elements {
+		if el.id == IDSeekHead {
+			positions = append(positions, el.pos)
+		}
+	}
+	if len(positions) == 0 {
+		return errors.New("matroska: missing SeekHead element")
+	}
+	for _, pos := range positions[1:] {
+		e.free(pos)
+	}
+	b, err := MarshalElement(IDSeekHead, SeekHead{Seek: e.seeks})
+	if err != nil {
+		return err
+	}
+	if e.place(positions[0], b) {
+		return nil
+	}
+	pos, err := e.append(b)
+	if err != nil {
+		return err
+	}
+	b, err = MarshalElement(IDSeekHead, SeekHead{Seek: []Seek{{SeekID: IDSeekHead, SeekPosition: uint(pos - e.segmentStart)}}})
+	if err != nil {
+		return err
+	}
+	if !e.place(positions[0], b) {
+		return errors.New("matroska: SeekHead does not fit")
+	}
+	return nil
+}
+
+// place writes b over the element at pos and the Void elements following
+// it. It reports whether b fits.
+func (e *Editor) place(pos int64, b []byte) bool {
+	i := slices.IndexFunc(e.elements, func(el editorElement) bool { return el.pos == pos })
+	j := i + 1
+	for j < len(e.elements) && e.elements[j].id == ebml.IDVoid && e.elements[j].pos == e.elements[j-1].end() {
+		j++
+	}
+	avail := e.elements[j-1].end() - pos
+	fitted, ok := fitElement(b, avail)
+	if !ok && j == len(e.elements) && e.elements[j-1].end() == e.segmentEnd && e.atEnd {
+		// The last element of the Segment can grow.
+		fitted, avail, ok = b, int64(len(b)), true
+		e.segmentEnd = pos + avail
+	}
+	if !ok {
+		return false
+	}
+	b = fitted
+	el := editorElement{id: e.elements[i].id, pos: pos, size: int64(len(b)), content: b, changed: true}
+	replacement := []editorElement{el}
+	if n := avail - int64(len(b)); n > 0 {
+		replacement = append(replacement, editorElement{id: ebml.IDVoid, pos: el.end(), size: n, changed: true})
+	}
+	e.elements = slices.Replace(e.elements, i, j, replacement...)
+	return true
+}
+
+// free replaces the element at pos and the Void elements following it
+// with a single Void element.
+func (e *Editor) free(pos int64) {
+	i := slices.IndexFunc(e.elements, func(el editorElement) bool { return el.pos == pos })
+	j := i + 1
+	for j < len(e.elements) && e.elements[j].id == ebml.IDVoid && e.elements[j].pos == e.elements[j-1].end() {
+		j++
+	}
+	void := editorElement{id: ebml.IDVoid, pos: pos, size: e.elements[j-1].end() - pos, changed: true}
+	e.elements = slices.Replace(e.elements, i, j, void)
+}
+
+// append adds b to the end of the Segment and returns its position.
+func (e *Editor) append(b []byte) (int64, error) {
+	if !e.atEnd {
+		return 0, errors.New("matroska: cannot append to a Segment which does not end the file")
+	}
+	id, _, _, _ := parseElementHeader(b)
+	pos := e.segmentEnd
+	e.elements = append(e.elements, editorElement{id: id, pos: pos, size: int64(len(b)), content: b, changed: true})
+	e.segmentEnd += int64(len(b))
+	return pos, nil
+}
+
+// fitElement returns the element b resized to n octets by a following
+// Void element or by a longer Data Size.
+func fitElement(b []byte, n int64) ([]byte, bool) {
+	switch d := n - int64(len(b)); {
+	case d == 0 || d >= 2:
+		return b, true
+	case d == 1:
+		// A Void element is at least 2 octets long, so the Data Size
+		// grows instead.
+		id, hl, ds, ok := parseElementHeader(b)
+		if !ok || hl-len(appendElementID(nil, id)) >= 8 {
+			return nil, false
+		}
+		resized := appendDataSize(appendElementID(nil, id), ds, hl-len(appendElementID(nil, id))+1)
+		return append(resized, b[hl:]...), true
+	}
+	return nil, false
+}
+
+// parseElementHeader parses the Element ID and the Element Data Size at
+// the beginning of b, and returns the length of the header. A Data Size
+// of -1 means unknown size.
+func parseElementHeader(b []byte) (schema.ElementID, int, int64, bool) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0, 0, false
+	}
+	idLen := bits.LeadingZeros8(b[0]) + 1
+	if idLen > 4 || len(b) < idLen+1 || b[idLen] == 0 {
+		return 0, 0, 0, false
+	}
+	var id uint64
+	for _, c := range b[:idLen] {
+		id = id<<8 | uint64(c)
+	}
+	sizeLen := bits.LeadingZeros8(b[idLen]) + 1
+	if len(b) < idLen+sizeLen {
+		return 0, 0, 0, false
+	}
+	size := uint64(b[idLen] & (0xFF >> sizeLen))
+	for _, c := range b[idLen+1 : idLen+sizeLen] {
+		size = size<<8 | uint64(c)
+	}
+	if size == 1<<(7*sizeLen)-1 {
+		return schema.ElementID(id), idLen + sizeLen, -1, true
+	}
+	return schema.ElementID(id), idLen + sizeLen, int64(size), true
+}
+
+func (e *Editor) readHeader(pos int64) (schema.ElementID, int, int64, error) {
+	b, err := e.readAt(pos, 12)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, 0, 0, err
+	}
+	id, hl, ds, ok := parseElementHeader(b)
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("matroska: invalid element header at %d", pos)
+	}
+	return id, hl, ds, nil
+}
+
+func (e *Editor) readAt(pos int64, n int) ([]byte, error) {
+	if _, err := e.f.Seek(pos, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("matroska: could not seek: %w", err)
+	}
+	b := make([]byte, n)
+	n, err := io.ReadFull(e.f, b)
+	return b[:n], err
+}
+
+func (e *Editor) writeAt(b []byte, pos int64) error {
+	if _, err := e.f.Seek(pos, io.SeekStart); err != nil {
+		return fmt.Errorf("matroska: could not seek: %w", err)
+	}
+	if _, err := e.f.Write(b); err != nil {
+		return fmt.Errorf("matroska: could not write: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package matroska
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readWriteSeeker is a writeSeeker which can be read.
+type readWriteSeeker struct {
+	writeSeeker
+}
+
+func (rw *readWriteSeeker) Read(p []byte) (int, error) {
+	r := bytes.NewReader(rw.buf)
+	if _, err := r.Seek(rw.pos, 0); err != nil {
+		return 0, err
+	}
+	n, err := r.Read(p)
+	rw.pos += int64(n)
+	return n, err
+}
+
+func TestEditor(t *testing.T) {
+	file := func(t *testing.T) *readWriteSeeker {
+		t.Helper()
+		video := NewTrackEntry(1, TrackTypeVideo, VideoCodecMSCOMP)
+		video.Video = &Video{PixelWidth: 320, PixelHeight: 240}
+		name := "Video"
+		video.Name = &name
+		audio := NewTrackEntry(2, TrackTypeAudio, AudioCodecMP3)
+		var rw readWriteSeeker
+		w, err := NewWriter(&rw, Info{}, Tracks{TrackEntry: []TrackEntry{video, audio}}, WriterOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.SetChapters(&Chapters{EditionEntry: []EditionEntry{{
+			ChapterAtom: []ChapterAtom{{ChapterUID: 1, ChapterFlagEnabled: 1}},
+		}}})
+		title := "Tag"
+		w.SetTags(&Tags{Tag: []Tag{{
+			Targets:   Targets{TargetTypeValue: TargetTypeValueAlbum},
+			SimpleTag: []SimpleTag{{TagName: "TITLE", TagLanguage: "und", TagDefault: 1, TagString: &title}},
+		}}})
+		for i := range 10 {
+			ts := time.Duration(i) * 40 * time.Millisecond
+			if err := w.WriteFrame(Frame{TrackNumber: 1, Timestamp: ts, Keyframe: i == 0, Data: []byte{byte(i)}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.WriteFrame(Frame{TrackNumber: 2, Timestamp: ts, Keyframe: true, Data: []byte{byte(i)}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		rw.pos = 0
+		return &rw
+	}
+	// reopen checks that the file is still readable.
+	reopen := func(t *testing.T, rw *readWriteSeeker) *MatroskaDemuxer {
+		t.Helper()
+		d, err := NewMatroskaDemuxer(bytes.NewReader(rw.buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(readFrames(t, d)); got != 20 {
+			t.Errorf("got %d frames, want 20", got)
+		}
+		report, err := Validate(bytes.NewReader(rw.buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Valid() {
+			t.Errorf("Validate() = %v", report.Findings)
+		}
+		return d
+	}
+	edit := func(t *testing.T, rw *readWriteSeeker, f func(e *Editor)) {
+		t.Helper()
+		rw.pos = 0
+		e, err := NewEditor(rw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f(e)
+		if err := e.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("InPlace", func(t *testing.T) {
+		rw := file(t)
+		size := len(rw.buf)
+		edit(t, rw, func(e *Editor) {
+			track, _ := e.Track(1)
+			track.Name = nil
+			track.FlagDefault = 0
+			track.Language = "hun"
+		})
+		if len(rw.buf) != size {
+			t.Errorf("file size = %d, want %d", len(rw.buf), size)
+		}
+		d := reopen(t, rw)
+		track := d.Tracks()[0]
+		if track.Name != nil || track.FlagDefault != 0 || track.Language != "hun" {
+			t.Errorf("track = %+v", track)
+		}
+	})
+	t.Run("Move", func(t *testing.T) {
+		rw := file(t)
+		title := strings.Repeat("Title", 100)
+		date := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+		edit(t, rw, func(e *Editor) {
+			e.Info().Title = &title
+			e.Info().DateUTC = &date
+			track, _ := e.Track(2)
+			name := strings.Repeat("Audio", 100)
+			track.Name = &name
+		})
+		d := reopen(t, rw)
+		info := d.Scanner().Info()
+		if info.Title == nil || *info.Title != title || info.DateUTC == nil || !info.DateUTC.Equal(date) {
+			t.Errorf("Title = %v, DateUTC = %v", info.Title, info.DateUTC)
+		}
+		if name := d.Tracks()[1].Name; name == nil || !strings.HasPrefix(*name, "Audio") {
+			t.Errorf("Name = %v", name)
+		}
+		if d.Scanner().Chapters() == nil || d.Scanner().Tags() == nil {
+			t.Error("lost Chapters or Tags")
+		}
+	})
+	t.Run("TagsAndChapters", func(t *testing.T) {
+		rw := file(t)
+		comment := strings.Repeat("Comment", 100)
+		edit(t, rw, func(e *Editor) {
+			e.SetChapters(nil)
+			tags := e.Tags()
+			tags.Tag[0].SimpleTag = append(tags.Tag[0].SimpleTag, SimpleTag{TagName: "COMMENT", TagLanguage: "und", TagDefault: 1, TagString: &comment})
+		})
+		d := reopen(t, rw)
+		if c := d.Scanner().Chapters(); c != nil {
+			t.Errorf("Chapters = %+v, want nil", c)
+		}
+		tags := d.Scanner().Tags()
+		if tags == nil || len(tags.Tag[0].SimpleTag) != 2 {
+			t.Fatalf("Tags = %+v", tags)
+		}
+		// A second edit after a move.
+		edit(t, rw, func(e *Editor) {
+			e.SetTags(nil)
+			e.SetChapters(&Chapters{EditionEntry: []EditionEntry{{
+				ChapterAtom: []ChapterAtom{{ChapterUID: 2, ChapterFlagEnabled: 1}},
+			}}})
+		})
+		d = reopen(t, rw)
+		if tags := d.Scanner().Tags(); tags != nil {
+			t.Errorf("Tags = %+v, want nil", tags)
+		}
+		if c := d.Scanner().Chapters(); c == nil || c.EditionEntry[0].ChapterAtom[0].ChapterUID != 2 {
+			t.Errorf("Chapters = %+v", c)
+		}
+	})
+	t.Run("Attachments", func(t *testing.T) {
+		rw := file(t)
+		font := append([]byte("\x00\x01\x00\x00"), bytes.Repeat([]byte{1}, 1000)...)
+		edit(t, rw, func(e *Editor) {
+			a := &Attachments{}
+			AddAttachment(a, "font.ttf", font)
+			AddAttachment(a, "notes.txt", []byte("notes"))
+			e.SetAttachments(a)
+		})
+		d := reopen(t, rw)
+		a := d.Scanner().Attachments()
+		if a == nil || len(a.AttachedFile) != 2 {
+			t.Fatalf("Attachments = %+v", a)
+		}
+		if f := a.AttachedFile[0]; f.FileMediaType != "font/ttf" || !bytes.Equal(f.FileData, font) {
+			t.Errorf("AttachedFile = %s %s", f.FileName, f.FileMediaType)
+		}
+		if a.AttachedFile[0].FileUID == a.AttachedFile[1].FileUID {
+			t.Error("FileUIDs are not unique")
+		}
+		uid := a.AttachedFile[0].FileUID
+		edit(t, rw, func(e *Editor) {
+			a := e.Attachments()
+			if _, ok := ReplaceAttachment(a, "font.ttf", "font.otf", []byte("OTTO")); !ok {
+				t.Error("font.ttf not found")
+			}
+			RemoveAttachment(a, "notes.txt")
+		})
+		d = reopen(t, rw)
+		a = d.Scanner().Attachments()
+		if a == nil || len(a.AttachedFile) != 1 {
+			t.Fatalf("Attachments = %+v", a)
+		}
+		if f := a.AttachedFile[0]; f.FileUID != uid || f.FileName != "font.otf" || f.FileMediaType != "font/otf" {
+			t.Errorf("AttachedFile = %d %s %s", f.FileUID, f.FileName, f.FileMediaType)
+		}
+		edit(t, rw, func(e *Editor) {
+			RemoveAttachment(e.Attachments(), "font.otf")
+		})
+		if a := reopen(t, rw).Scanner().Attachments(); a != nil {
+			t.Errorf("Attachments = %+v, want nil", a)
+		}
+	})
+}
+
+func TestFitElement(t *testing.T) {
+	b, err := MarshalElement(IDInfo, Info{MuxingApp: "a", WritingApp: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{len(b), len(b) + 1, len(b) + 2, len(b) + 100} {
+		fitted, ok := fitElement(b, int64(n))
+		if !ok {
+			t.Errorf("fitElement(%d) did not fit", n)
+			continue
+		}
+		if n == len(b)+1 && len(fitted) != n {
+			t.Errorf("got %d octets, want %d", len(fitted), n)
+		}
+		if _, hl, ds, ok := parseElementHeader(fitted); !ok || int(ds) != len(fitted)-hl {
+			t.Errorf("invalid header % x", fitted[:hl])
+		}
+	}
+	if _, ok := fitElement(b, int64(len(b)-1)); ok {
+		t.Error("fitElement fit into a smaller space")
+	}
+}
@@ -0,0 +1,300 @@
+package matroska
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/coding-socks/ebml"
+	"github.com/coding-socks/ebml/schema"
+	"math"
+	"math/bits"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	typeDuration  = reflect.TypeOf(time.Duration(0))
+	typeTime      = reflect.TypeOf(time.Time{})
+	typeElementID = reflect.TypeOf(schema.ElementID(0))
+)
+
+// dateEpoch is the origin of EBML date elements.
+var dateEpoch = time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var schemaByName = sync.OnceValue(func() map[string]schema.Element {
+	def, err := ebml.Definition(DocType)
+	if err != nil {
+		panic("matroska: " + err.Error())
+	}
+	m := make(map[string]schema.Element)
+	for el := range def.All() {
+		m[el.Name] = el
+	}
+	return m
+})
+
+// MarshalElement returns the EBML encoding of v as the element id,
+// including the Element ID and the Element Data Size.
+//
+// The value v is one of the structs generated from the Matroska schema or
+// a value of a matching Go type. Fields are matched to child elements by
+// name, nil pointers are omitted, and optional fields holding their
+// default value are omitted too.
+func MarshalElement(id schema.ElementID, v any) ([]byte, error) {
+	def, err := ebml.Definition(DocType)
+	if err != nil {
+		return nil, err
+	}
+	el, ok := def.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("matroska: unknown element %v", id)
+	}
+	return appendElement(nil, el, reflect.ValueOf(v))
+}
+
+func appendElement(b []byte, el schema.Element, v reflect.Value) ([]byte, error) {
+	if el.Type == ebml.TypeMaster {
+		data, err := appendMaster(nil, el, v)
+		if err != nil {
+			return nil, err
+		}
+		b = appendElementHeader(b, el.ID, int64(len(data)))
+		return append(b, data...), nil
+	}
+	data, err := appendValue(nil, el, v)
+	if err != nil {
+		return nil, err
+	}
+	b = appendElementHeader(b, el.ID, int64(len(data)))
+	return append(b, data...), nil
+}
+
+func appendMaster(b []byte, el schema.Element, v reflect.Value) ([]byte, error) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("matroska: cannot encode %s as master element %s", v.Type(), el.Name)
+	}
+	names := schemaByName()
+	t := v.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		child, ok := names[f.Name]
+		if !ok {
+			continue
+		}
+		fv := v.Field(i)
+		switch {
+		case fv.Kind() == reflect.Pointer:
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		case fv.Kind() == reflect.Slice && !(child.Type == ebml.TypeBinary && fv.Type().Elem().Kind() == reflect.Uint8):
+			for j := range fv.Len() {
+				var err error
+				if b, err = appendElement(b, child, fv.Index(j)); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		case child.Type != ebml.TypeMaster && isDefault(child, fv):
+			continue
+		}
+		var err error
+		if b, err = appendElement(b, child, fv); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// isDefault reports whether v holds the default value of el. Elements
+// without a default value are never omitted.
+func isDefault(el schema.Element, v reflect.Value) bool {
+	if el.Default == nil {
+		return false
+	}
+	d := *el.Default
+	switch el.Type {
+	case ebml.TypeUinteger:
+		if v.CanInt() {
+			x, err := strconv.ParseInt(d, 0, 64)
+			return err == nil && v.Int() == x
+		}
+		x, err := strconv.ParseUint(d, 0, 64)
+		return err == nil && v.CanUint() && v.Uint() == x
+	case ebml.TypeInteger:
+		x, err := strconv.ParseInt(d, 0, 64)
+		return err == nil && v.CanInt() && v.Int() == x
+	case ebml.TypeFloat:
+		x, err := strconv.ParseFloat(d, 64)
+		return err == nil && v.CanFloat() && v.Float() == x
+	case ebml.TypeString, ebml.TypeUTF8:
+		return v.Kind() == reflect.String && v.String() == d
+	}
+	return false
+}
+
+func appendValue(b []byte, el schema.Element, v reflect.Value) ([]byte, error) {
+	switch el.Type {
+	case ebml.TypeUinteger:
+		switch {
+		case v.Type() == typeDuration:
+			return appendUint(b, uint64(v.Int())), nil
+		case v.CanUint():
+			return appendUint(b, v.Uint()), nil
+		}
+	case ebml.TypeInteger:
+		if v.CanInt() {
+			return appendInt(b, v.Int()), nil
+		}
+	case ebml.TypeFloat:
+		if v.CanFloat() {
+			return binary.BigEndian.AppendUint64(b, math.Float64bits(v.Float())), nil
+		}
+	case ebml.TypeString, ebml.TypeUTF8:
+		if v.Kind() == reflect.String {
+			return append(b, v.String()...), nil
+		}
+	case ebml.TypeDate:
+		if v.Type() == typeTime {
+			d := v.Interface().(time.Time).Sub(dateEpoch)
+			return binary.BigEndian.AppendUint64(b, uint64(d)), nil
+		}
+	case ebml.TypeBinary:
+		switch {
+		case v.Type() == typeElementID:
+			return appendUint(b, v.Uint()), nil
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			return append(b, v.Bytes()...), nil
+		}
+	}
+	return nil, fmt.Errorf("matroska: cannot encode %s as %s element %s", v.Type(), el.Type, el.Name)
+}
+
+// appendUint appends the shortest big-endian representation of v. Zero is
+// written as a single byte for compatibility with older readers.
+func appendUint(b []byte, v uint64) []byte {
+	n := max((bits.Len64(v)+7)/8, 1)
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(8*i)))
+	}
+	return b
+}
+
+// appendInt appends the shortest two's complement representation of v.
+func appendInt(b []byte, v int64) []byte {
+	n := 1
+	for n < 8 && (v < -(1<<(8*n-1)) || v >= 1<<(8*n-1)) {
+		n++
+	}
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(8*i)))
+	}
+	return b
+}
+
+// appendElementID appends an Element ID. IDs already contain their VINT
+// marker, so they are written as their shortest big-endian representation.
+func appendElementID(b []byte, id schema.ElementID) []byte {
+	return appendUint(b, uint64(id))
+}
+
+// appendDataSize appends size as a VINT of at least width octets. A size
+// of -1 is written as unknown.
+func appendDataSize(b []byte, size int64, width int) []byte {
+	if size == -1 {
+		w := max(width, 1)
+		v := uint64(1)<<(7*w) - 1 // all data bits set
+		v |= 1 << (7 * w)         // marker
+		for i := w - 1; i >= 0; i-- {
+			b = append(b, byte(v>>(8*i)))
+		}
+		return b
+	}
+	w := max((bits.Len64(uint64(size+1))+6)/7, width, 1) // all ones is reserved
+	v := uint64(size) | 1<<(7*w)
+	for i := w - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(8*i)))
+	}
+	return b
+}
+
+func appendElementHeader(b []byte, id schema.ElementID, size int64) []byte {
+	return appendDataSize(appendElementID(b, id), size, 0)
+}
+
+// appendVoid appends a Void element which occupies exactly n octets.
+// The smallest Void element is 2 octets long.
+func appendVoid(b []byte, n int) []byte {
+	l := len(b)
+	b = appendVoidHeader(b, n)
+	return append(b, make([]byte, n-(len(b)-l))...)
+}
+
+// appendVoidHeader appends the header of a Void element which occupies
+// exactly n octets.
+func appendVoidHeader(b []byte, n int) []byte {
+	if n < 2 {
+		panic("matroska: Void element must be at least 2 octets long")
+	}
+	// The Data Size width grows until the header and the data fit into n.
+	for w := 1; w <= 8; w++ {
+		size := n - 1 - w
+		if size < 0 {
+			break
+		}
+		if uint64(size) < 1<<(7*w)-1 {
+			return appendDataSize(append(b, byte(ebml.IDVoid)), int64(size), w)
+		}
+	}
+	panic("matroska: Void element too long")
+}
+
+// setDefaults sets the fields of the struct pointed to by v which are
+// mandatory and have a default value in the schema. Optional fields are
+// left unset.
+func setDefaults(v any) {
+	val := reflect.ValueOf(v).Elem()
+	el, ok := schemaByName()[val.Type().Name()]
+	if !ok {
+		return
+	}
+	def, err := ebml.Definition(DocType)
+	if err != nil {
+		return
+	}
+	for sel := range def.Fields(el.Path) {
+		if sel.Default == nil {
+			continue
+		}
+		f := val.FieldByName(sel.Name)
+		if !f.IsValid() || f.Kind() == reflect.Pointer || f.Kind() == reflect.Slice {
+			continue
+		}
+		switch sel.Type {
+		case ebml.TypeInteger:
+			x, _ := strconv.ParseInt(*sel.Default, 0, 64)
+			f.SetInt(x)
+		case ebml.TypeUinteger:
+			if f.CanInt() {
+				x, _ := strconv.ParseInt(*sel.Default, 0, 64)
+				f.SetInt(x)
+			} else {
+				x, _ := strconv.ParseUint(*sel.Default, 0, 64)
+				f.SetUint(x)
+			}
+		case ebml.TypeFloat:
+			x, _ := strconv.ParseFloat(*sel.Default, 64)
+			f.SetFloat(x)
+		case ebml.TypeString, ebml.TypeUTF8:
+			f.SetString(*sel.Default)
+		}
+	}
+}
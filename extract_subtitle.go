@@ -3,8 +3,10 @@ package matroska
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"fmt"
 	"io"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -84,13 +86,11 @@ func subRipTime(d time.Duration) string {
 }
 
 func extractTrackSSA(w io.Writer, s *Scanner, t TrackEntry) error {
-	if _, err := w.Write(*t.CodecPrivate); err != nil {
-		return err
+	if t.CodecPrivate == nil {
+		return fmt.Errorf("matroska: SubStation Alpha track requires a CodecPrivate header")
 	}
-
 	scale := s.Info().TimestampScale
-	f := subStationAlphaFormat(*t.CodecPrivate)
-	events := make([]string, 0, (1<<9)-1)
+	r := newSubStationAlphaReconstructor(*t.CodecPrivate)
 	for s.Next() {
 		c := s.Cluster()
 		// Due to timing and duration, SSA only uses Block Groups
@@ -119,66 +119,120 @@ func extractTrackSSA(w io.Writer, s *Scanner, t TrackEntry) error {
 			}
 			end := block.Timestamp(scale) + duration*scale
 
-			var sb strings.Builder
+			if err := r.Add(block.data, start, end); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	_, err := r.WriteTo(w)
+	return err
+}
 
-			sb.WriteString("Dialogue: ")
+// subStationAlphaFields is the order of the fields stored in a SubStation
+// Alpha block according to the Matroska codec specification. The Start and
+// End fields are removed as they are represented by the Block timestamp and
+// BlockDuration.
+//
+// See: https://datatracker.ietf.org/doc/html/draft-ietf-cellar-codec-14#name-s_text-ssa-s_text-ass
+var subStationAlphaFields = [...]string{
+	"readorder", "layer", "style", "name", "marginl", "marginr", "marginv", "effect", "text",
+}
 
-			b, _ := io.ReadAll(block.Data())
-			n := len(f) + 1 // data starts with line number
-			for i := range f {
-				switch f[i] {
-				case "marked", "start", "end":
-					n--
-				}
-			}
-			fields := strings.SplitN(string(b), ",", n)
-			fieldIndex := 1 // data starts with line number
-			for i := range f {
-				if i > 0 {
-					sb.WriteRune(',')
-				}
-				switch f[i] {
-				case "marked":
-					sb.WriteString(fmt.Sprintf("Marked=%d", 0))
-				case "start":
-					sb.WriteString(subStationAlphaTime(start))
-				case "end":
-					sb.WriteString(subStationAlphaTime(end))
-				default:
-					sb.WriteString(fields[fieldIndex])
-					fieldIndex++
+type subStationAlphaEvent struct {
+	readOrder int
+	line      string
+}
+
+// subStationAlphaReconstructor rebuilds the Dialogue lines of a SubStation
+// Alpha (v4) or Advanced SubStation Alpha (v4+) script from Matroska blocks.
+//
+// The events are kept in a slice and sorted by ReadOrder when written, so
+// gaps and duplicates in ReadOrder do not cause any extra allocation.
+// Events sharing the same ReadOrder keep the order they were added in.
+type subStationAlphaReconstructor struct {
+	header []byte
+	format []string
+	events []subStationAlphaEvent
+}
+
+func newSubStationAlphaReconstructor(codecPrivate []byte) *subStationAlphaReconstructor {
+	return &subStationAlphaReconstructor{
+		header: codecPrivate,
+		format: subStationAlphaFormat(codecPrivate),
+	}
+}
+
+// Add reconstructs a Dialogue line from the content of a block and
+// the start and end time of the event.
+func (r *subStationAlphaReconstructor) Add(data []byte, start, end time.Duration) error {
+	fields := strings.SplitN(string(data), ",", len(subStationAlphaFields))
+	if len(fields) != len(subStationAlphaFields) {
+		return fmt.Errorf("matroska: SubStation Alpha block requires %d fields, got %d", len(subStationAlphaFields), len(fields))
+	}
+	readOrder, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+	if err != nil {
+		return fmt.Errorf("matroska: could read SubStation Alpha line number: %w", err)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Dialogue: ")
+	for i, column := range r.format {
+		if i > 0 {
+			sb.WriteRune(',')
+		}
+		switch column {
+		case "start":
+			sb.WriteString(subStationAlphaTime(start))
+		case "end":
+			sb.WriteString(subStationAlphaTime(end))
+		case "marked":
+			// SSA stores the Marked field in place of the ASS Layer field.
+			sb.WriteString("Marked=")
+			sb.WriteString(strings.TrimPrefix(fields[1], "Marked="))
+		case "actor":
+			sb.WriteString(fields[3])
+		default:
+			for j, name := range subStationAlphaFields {
+				if j > 0 && name == column {
+					sb.WriteString(fields[j])
+					break
 				}
 			}
-			i, err := strconv.Atoi(fields[0])
-			if err != nil {
-				return fmt.Errorf("matroska: could read SubStation Alpha line number: %w", err)
-			}
-			events = grow(i, events)
-			events[i] = sb.String()
-		}
-	}
-	for i := range events {
-		if _, err := w.Write(append([]byte(events[i]), '\n')); err != nil {
-			return err
 		}
 	}
+	r.events = append(r.events, subStationAlphaEvent{readOrder: readOrder, line: sb.String()})
 	return nil
 }
 
-func grow(i int, events []string) []string {
-	if l := i + 1; l > len(events) {
-		if l > cap(events) {
-			n := cap(events)
-			for l > cap(events) {
-				n = (n << 1) + 1
-			}
-			tmp := make([]string, n)
-			copy(tmp, events)
-			events = tmp
+// WriteTo writes the script header followed by the events in ReadOrder.
+func (r *subStationAlphaReconstructor) WriteTo(w io.Writer) (int64, error) {
+	slices.SortStableFunc(r.events, func(a, b subStationAlphaEvent) int {
+		return cmp.Compare(a.readOrder, b.readOrder)
+	})
+	var n int64
+	m, err := w.Write(r.header)
+	n += int64(m)
+	if err != nil {
+		return n, err
+	}
+	if len(r.header) > 0 && r.header[len(r.header)-1] != '\n' {
+		m, err := w.Write([]byte{'\n'})
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	for _, e := range r.events {
+		m, err := io.WriteString(w, e.line+"\n")
+		n += int64(m)
+		if err != nil {
+			return n, err
 		}
-		events = events[:l]
 	}
-	return events
+	return n, nil
 }
 
 func subStationAlphaTime(d time.Duration) string {
@@ -0,0 +1,91 @@
+package matroska
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var flagUpdate = flag.Bool("update", false, "update golden files")
+
+func TestSubStationAlphaReconstructor(t *testing.T) {
+	type block struct {
+		data       string
+		start, end time.Duration
+	}
+	tests := []struct {
+		name   string
+		golden string
+		header string
+		blocks []block
+	}{
+		{
+			name:   "ASS v4+",
+			golden: "ass.golden",
+			header: "[Script Info]\nScriptType: v4.00+\n\n[V4+ Styles]\n" +
+				"Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding\n" +
+				"Style: Default,Arial,20,&H00FFFFFF,&H000000FF,&H00000000,&H00000000,0,0,0,0,100,100,0,0,1,2,2,2,10,10,10,1\n\n" +
+				"[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text",
+			blocks: []block{
+				{data: "2,0,Default,,0,0,0,,Third, with a comma", start: 3 * time.Second, end: 4 * time.Second},
+				{data: "0,1,Default,Alice,0,0,0,,First", start: 1 * time.Second, end: 2*time.Second + 500*time.Millisecond},
+				{data: "1,0,Default,Bob,10,20,30,Banner;5,{\\i1}Second{\\i0}", start: 2 * time.Second, end: 3 * time.Second},
+				{data: "1,2,Default,Bob,0,0,0,,Duplicate of second", start: 2 * time.Second, end: 3 * time.Second},
+				{data: "1073741824,0,Default,,0,0,0,,Far away", start: time.Hour + 2*time.Minute, end: time.Hour + 3*time.Minute},
+			},
+		},
+		{
+			name:   "SSA v4",
+			golden: "ssa.golden",
+			header: "[Script Info]\nScriptType: v4.00\n\n[V4 Styles]\n" +
+				"Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, TertiaryColour, BackColour, Bold, Italic, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, AlphaLevel, Encoding\n" +
+				"Style: Default,Arial,20,16777215,65535,65535,0,0,0,1,2,2,2,10,10,10,0,1\n\n" +
+				"[Events]\nFormat: Marked, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n",
+			blocks: []block{
+				{data: "5,0,Default,,0000,0000,0000,,Gap before me", start: 5 * time.Second, end: 6 * time.Second},
+				{data: "3,1,Default,Alice,0000,0000,0000,,Marked line", start: 3 * time.Second, end: 4 * time.Second},
+				{data: "4,Marked=0,Default,,0000,0000,0000,,Already prefixed", start: 4 * time.Second, end: 5 * time.Second},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newSubStationAlphaReconstructor([]byte(tt.header))
+			for _, b := range tt.blocks {
+				if err := r.Add([]byte(b.data), b.start, b.end); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var buf bytes.Buffer
+			if _, err := r.WriteTo(&buf); err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", tt.golden)
+			if *flagUpdate {
+				if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.Bytes(); !bytes.Equal(got, want) {
+				t.Errorf("WriteTo() got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestSubStationAlphaReconstructorInvalidBlock(t *testing.T) {
+	r := newSubStationAlphaReconstructor([]byte("[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n"))
+	if err := r.Add([]byte("0,0,Default"), 0, time.Second); err == nil {
+		t.Error("Add() expected error for missing fields")
+	}
+	if err := r.Add([]byte("x,0,Default,,0,0,0,,Text"), 0, time.Second); err == nil {
+		t.Error("Add() expected error for invalid ReadOrder")
+	}
+}
@@ -6,8 +6,8 @@ import (
 	"github.com/coding-socks/matroska/internal/avi"
 	"github.com/coding-socks/matroska/internal/riff"
 	"io"
-	"math"
 	"os"
+	"time"
 )
 
 func extractTrackVideo(w *os.File, s *Scanner, t TrackEntry) error {
@@ -18,11 +18,57 @@ func extractTrackVideo(w *os.File, s *Scanner, t TrackEntry) error {
 	return fmt.Errorf("matroska: unknown audio codec %s", t.CodecID)
 }
 
+// aviStream collects the statistics of a track written into an AVI file.
+type aviStream struct {
+	track  TrackEntry
+	id     riff.FourCC
+	frames uint32
+	bytes  uint64
+	maxLen uint32
+}
+
+// extractTrackMSCOMP writes the V_MS/VFW/FOURCC track t into an AVI file.
+// Every A_MS/ACM and A_MPEG/L3 track of the file is added as an audio
+// stream, and the chunks of the streams are interleaved by timestamp.
 func extractTrackMSCOMP(w io.WriterAt, s *Scanner, t TrackEntry) error {
 	scale := s.Info().TimestampScale
 	if t.Video == nil {
 		return fmt.Errorf("matroska: missing video stream")
 	}
+	if t.CodecPrivate == nil || len(*t.CodecPrivate) < len(avi.StreamFormat{}) {
+		return fmt.Errorf("matroska: missing BITMAPINFOHEADER")
+	}
+	if t.DefaultDuration == nil || *t.DefaultDuration == 0 {
+		return fmt.Errorf("matroska: missing default duration")
+	}
+
+	streams := []*aviStream{{track: t, id: avi.NewStreamID(0, avi.StreamTypeDC)}}
+	for _, a := range s.Tracks().TrackEntry {
+		if len(streams) == 99 {
+			break
+		}
+		switch a.CodecID {
+		case AudioCodecMS_ACM:
+			if a.CodecPrivate == nil || len(*a.CodecPrivate) < len(avi.WaveFormatEx{}) {
+				continue
+			}
+		case AudioCodecMP3:
+			if a.Audio == nil {
+				continue
+			}
+		default:
+			continue
+		}
+		streams = append(streams, &aviStream{track: a, id: avi.NewStreamID(uint8(len(streams)), avi.StreamTypeWB)})
+	}
+	findStream := func(n uint) *aviStream {
+		for _, st := range streams {
+			if st.track.TrackNumber == n {
+				return st
+			}
+		}
+		return nil
+	}
 
 	ww, err := avi.NewWriter(w)
 	if err != nil {
@@ -30,79 +76,151 @@ func extractTrackMSCOMP(w io.WriterAt, s *Scanner, t TrackEntry) error {
 	}
 	defer ww.Close()
 
-	videoStreamID := avi.NewStreamID(0, avi.StreamTypeDC)
-	var totalFrames uint32 = 0
+	queue := func(st *aviStream, ts time.Duration, frames [][]byte, keyframe bool) {
+		for i := range frames {
+			var flags uint32 = 0
+			if st.id == streams[0].id {
+				if i == 0 && keyframe {
+					flags |= avi.AVIIF_KEYFRAME
+				}
+			} else {
+				// Every audio chunk can be decoded independently.
+				flags |= avi.AVIIF_KEYFRAME
+			}
+			fts := ts
+			if st.track.DefaultDuration != nil {
+				fts += time.Duration(i) * time.Duration(*st.track.DefaultDuration)
+			}
+			ww.Queue(st.id, fts, frames[i], flags)
+			st.frames++
+			st.bytes += uint64(len(frames[i]))
+			st.maxLen = max(st.maxLen, uint32(len(frames[i])))
+		}
+	}
 	for s.Next() {
 		c := s.Cluster()
-		m := len(c.SimpleBlock) + len(c.BlockGroup)
-		if m == 0 {
-			continue
-		}
 		for i := range c.SimpleBlock {
 			block, err := ReadSimpleBlock(c.SimpleBlock[i], c.Timestamp)
 			if err != nil {
 				return fmt.Errorf("matroska: could not create block struct: %w", err)
 			}
-			if block.TrackNumber() != t.TrackNumber {
+			st := findStream(block.TrackNumber())
+			if st == nil {
 				continue
 			}
-			frames := block.Frames()
-			for i := range frames {
-				var flags uint32 = 0
-				if i == 0 && ((block.Flags() & SimpleBlockFlagKeyframe) > 0) {
-					flags |= avi.AVIIF_KEYFRAME
-				}
-				if err := ww.WriteData(videoStreamID, frames[i], flags); err != nil {
-					return err
-				}
-				totalFrames++
-			}
+			queue(st, block.Timestamp(scale), block.Frames(), block.Flags()&SimpleBlockFlagKeyframe > 0)
 		}
 		for _, group := range c.BlockGroup {
 			block, err := ReadBlock(group.Block, c.Timestamp)
 			if err != nil {
 				return fmt.Errorf("matroska: could not create block struct: %w", err)
 			}
-			if block.TrackNumber() != t.TrackNumber {
+			st := findStream(block.TrackNumber())
+			if st == nil {
 				continue
 			}
-			frames := block.Frames()
-			for i := range frames {
-				var flags uint32 = 0
-				// TODO: I'm not sure if this is correct. Maybe this is only relevant for
-				//  RAPs (i.e., frames that don't depend on other frames).
-				if i == 0 && len(group.ReferenceBlock) == 0 {
-					flags |= avi.AVIIF_KEYFRAME
-				}
-				if err := ww.WriteData(videoStreamID, frames[i], flags); err != nil {
-					return err
-				}
-				totalFrames++
-			}
+			// TODO: I'm not sure if this is correct. Maybe this is only relevant for
+			//  RAPs (i.e., frames that don't depend on other frames).
+			queue(st, block.Timestamp(scale), block.Frames(), len(group.ReferenceBlock) == 0)
+		}
+		// Blocks of a cluster reference its data, so the chunks are written
+		// before the next cluster is read.
+		if err := ww.Flush(); err != nil {
+			return err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	aviStreams := make([]avi.Stream, len(streams))
+	for i, st := range streams {
+		if i == 0 {
+			aviStreams[i] = videoStreamMSCOMP(st, scale)
+			continue
+		}
+		switch st.track.CodecID {
+		case AudioCodecMS_ACM:
+			aviStreams[i] = audioStreamMSACM(st)
+		case AudioCodecMP3:
+			aviStreams[i] = audioStreamMP3(st)
 		}
 	}
+	if err := ww.WriteHeader(aviStreams...); err != nil {
+		return fmt.Errorf("matroska: could not write header: %w", err)
+	}
+	return nil
+}
+
+func videoStreamMSCOMP(st *aviStream, scale time.Duration) avi.Stream {
+	t := st.track
 	var sf = avi.StreamFormat(*t.CodecPrivate)
 	var handler riff.FourCC
 	binary.LittleEndian.PutUint32(handler[:], sf.Compression())
 
-	var mh avi.MainHeader
-	mh.SetMicroSecPerFrame(uint32(math.Ceil(float64(*t.DefaultDuration) / 1000.0)))
-	mh.SetFlags(avi.AVIF_HASINDEX | avi.AVIF_ISINTERLEAVED)
-	mh.SetTotalFrames(totalFrames)
-	mh.SetStreams(1) // number of audio streams plus one
-	mh.SetWidth(uint32(t.Video.PixelWidth))
-	mh.SetHeight(uint32(t.Video.PixelHeight))
-
 	var sh avi.StreamHeader
 	sh.SetType(avi.StreamTypeVIDS)
 	sh.SetHandler(handler)
 	sh.SetScale(uint32(scale))
 	sh.SetRate(uint32(float64(scale) / float64(*t.DefaultDuration) * 1000000000.0))
-	sh.SetLength(totalFrames)
-	sh.SetSuggestedBufferSize(ww.MaxLen())
+	sh.SetLength(st.frames)
+	sh.SetSuggestedBufferSize(st.maxLen)
+	return avi.Stream{Header: sh, Format: *t.CodecPrivate}
+}
 
-	if err := ww.WriteHeader(sh, sf); err != nil {
-		return fmt.Errorf("matroska: could not write header: %w", err)
+// audioStreamMSACM uses the WAVEFORMATEX stored in the CodecPrivate of
+// an A_MS/ACM track as is.
+func audioStreamMSACM(st *aviStream) avi.Stream {
+	wf := avi.WaveFormatEx(*st.track.CodecPrivate)
+	blockAlign := max(uint32(wf.BlockAlign()), 1)
+
+	var sh avi.StreamHeader
+	sh.SetType(avi.StreamTypeAUDS)
+	sh.SetScale(blockAlign)
+	sh.SetRate(wf.AvgBytesPerSec())
+	sh.SetSampleSize(blockAlign)
+	sh.SetLength(uint32(st.bytes / uint64(blockAlign)))
+	sh.SetSuggestedBufferSize(st.maxLen)
+	return avi.Stream{Header: sh, Format: *st.track.CodecPrivate}
+}
+
+// audioStreamMP3 describes an A_MPEG/L3 track as a variable bitrate
+// stream where each chunk is one MPEG audio frame.
+func audioStreamMP3(st *aviStream) avi.Stream {
+	a := st.track.Audio
+	rate := uint32(a.SamplingFrequency)
+	samplesPerFrame := uint32(1152)
+	if rate < 32000 {
+		samplesPerFrame = 576 // MPEG-2 and MPEG-2.5
 	}
-	return nil
+
+	var wf avi.WaveFormatEx
+	wf.SetFormatTag(avi.WAVE_FORMAT_MPEGLAYER3)
+	wf.SetChannels(uint16(a.Channels))
+	wf.SetSamplesPerSec(rate)
+	if st.frames > 0 && rate > 0 {
+		d := float64(st.frames) * float64(samplesPerFrame) / float64(rate)
+		wf.SetAvgBytesPerSec(uint32(float64(st.bytes) / d))
+	}
+	wf.SetBlockAlign(uint16(samplesPerFrame))
+	wf.SetSize(12)
+	// MPEGLAYER3WAVEFORMAT
+	// See: https://learn.microsoft.com/en-us/windows/win32/api/mmreg/ns-mmreg-mpeglayer3waveformat
+	var ext [12]byte
+	binary.LittleEndian.PutUint16(ext[0:2], 1)      // wID: MPEGLAYER3_ID_MPEG
+	binary.LittleEndian.PutUint32(ext[2:6], 2)      // fdwFlags: MPEGLAYER3_FLAG_PADDING_OFF
+	binary.LittleEndian.PutUint16(ext[6:8], 0)      // nBlockSize
+	binary.LittleEndian.PutUint16(ext[8:10], 1)     // nFramesPerBlock
+	binary.LittleEndian.PutUint16(ext[10:12], 1393) // nCodecDelay
+	if st.frames > 0 {
+		binary.LittleEndian.PutUint16(ext[6:8], uint16(st.bytes/uint64(st.frames)))
+	}
+
+	var sh avi.StreamHeader
+	sh.SetType(avi.StreamTypeAUDS)
+	sh.SetScale(samplesPerFrame)
+	sh.SetRate(rate)
+	sh.SetLength(st.frames)
+	sh.SetSuggestedBufferSize(st.maxLen)
+	return avi.Stream{Header: sh, Format: append(wf[:], ext[:]...)}
 }
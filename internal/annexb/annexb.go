@@ -0,0 +1,102 @@
+// Package annexb implements the byte stream format of H.264 and HEVC
+// described in Annex B of ITU-T H.264 and ITU-T H.265.
+package annexb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+)
+
+var startCode = []byte{0, 0, 1}
+
+// Reader reads the NAL units of a byte stream.
+type Reader struct {
+	r   io.Reader
+	buf []byte
+	// scanned is the number of octets of buf which do not contain a
+	// start code.
+	scanned int
+	started bool
+	eof     bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// ReadNAL returns the next NAL unit without its start code. Data
+// preceding the first start code is skipped. It returns io.EOF when
+// there are no more NAL units.
+//
+// The returned slice is not modified by later calls.
+func (r *Reader) ReadNAL() ([]byte, error) {
+	for {
+		if i := bytes.Index(r.buf[r.scanned:], startCode); i >= 0 {
+			end := r.scanned + i
+			nal := trimZeros(r.buf[:end])
+			r.buf = r.buf[end+len(startCode):]
+			r.scanned = 0
+			if !r.started {
+				r.started = true
+				continue
+			}
+			if len(nal) == 0 {
+				continue
+			}
+			return nal, nil
+		}
+		if r.eof {
+			nal := trimZeros(r.buf)
+			r.buf, r.scanned = nil, 0
+			if !r.started || len(nal) == 0 {
+				return nil, io.EOF
+			}
+			return nal, nil
+		}
+		// The last two octets may be the beginning of a start code.
+		r.scanned = max(len(r.buf)-len(startCode)+1, 0)
+		r.buf = slices.Grow(r.buf, 64<<10)
+		n, err := r.r.Read(r.buf[len(r.buf):cap(r.buf)])
+		r.buf = r.buf[:len(r.buf)+n]
+		if err == io.EOF {
+			r.eof = true
+		} else if err != nil {
+			return nil, fmt.Errorf("annexb: could not read byte stream: %w", err)
+		}
+	}
+}
+
+// trimZeros removes the zero octets preceding a start code. A NAL unit
+// never ends with a zero octet.
+func trimZeros(b []byte) []byte {
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	return b
+}
+
+// Unescape returns the raw byte sequence payload of a NAL unit by
+// removing the emulation prevention octets following two zero octets.
+func Unescape(b []byte) []byte {
+	i := bytes.Index(b, []byte{0, 0, 3})
+	if i < 0 {
+		return b
+	}
+	out := make([]byte, 0, len(b))
+	zeros := 0
+	for _, c := range b {
+		if zeros >= 2 && c == 3 {
+			zeros = 0
+			continue
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,41 @@
+package annexb
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReader(t *testing.T) {
+	stream := []byte{
+		0xFF, // data preceding the first start code
+		0, 0, 0, 1, 0x09, 0xF0,
+		0, 0, 1, 0x67, 0x42, 0, 0, 3, 0, 0x80,
+		0, 0, 0, 0, 1, 0x68, 0xCE, // trailing_zero_8bits
+		0, 0, 1, 0x65, 0x88, 0x80,
+	}
+	want := [][]byte{{0x09, 0xF0}, {0x67, 0x42, 0, 0, 3, 0, 0x80}, {0x68, 0xCE}, {0x65, 0x88, 0x80}}
+	// Reading one octet at a time splits the start codes.
+	r := NewReader(iotest.OneByteReader(bytes.NewReader(stream)))
+	for i, w := range want {
+		nal, err := r.ReadNAL()
+		if err != nil {
+			t.Fatalf("NAL %d: %v", i, err)
+		}
+		if !bytes.Equal(nal, w) {
+			t.Errorf("NAL %d = % x, want % x", i, nal, w)
+		}
+	}
+	if _, err := r.ReadNAL(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	got := Unescape([]byte{0x67, 0, 0, 3, 0, 0, 0, 3, 1, 0, 3})
+	want := []byte{0x67, 0, 0, 0, 0, 0, 1, 0, 3}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Unescape() = % x, want % x", got, want)
+	}
+}
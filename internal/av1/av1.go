@@ -0,0 +1,264 @@
+// Package av1 implements the parsing of the AV1 syntax structures
+// needed to store AV1 temporal units in a container.
+// See: https://aomediacodec.github.io/av1-spec/
+package av1
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/coding-socks/matroska/internal/bitstream"
+)
+
+// OBU types of Section 6.2.2.
+const (
+	OBUSequenceHeader       = 1
+	OBUTemporalDelimiter    = 2
+	OBUFrameHeader          = 3
+	OBUTileGroup            = 4
+	OBUMetadata             = 5
+	OBUFrame                = 6
+	OBURedundantFrameHeader = 7
+	OBUTileList             = 8
+	OBUPadding              = 15
+)
+
+// OBU is an open bitstream unit.
+type OBU struct {
+	Type int
+	// Header is the obu_header including the extension.
+	Header []byte
+	// HasSize reports whether the OBU has an obu_size field.
+	HasSize bool
+	Payload []byte
+}
+
+// Append appends the OBU to b with an obu_size field.
+func (o OBU) Append(b []byte) []byte {
+	b = append(b, o.Header[0]|0x02)
+	b = append(b, o.Header[1:]...)
+	b = binary.AppendUvarint(b, uint64(len(o.Payload)))
+	return append(b, o.Payload...)
+}
+
+// ParseOBUs splits a temporal unit in the low overhead bitstream format
+// of Section 5.2 into OBUs. An OBU without obu_size field extends to the
+// end of the data.
+func ParseOBUs(b []byte) ([]OBU, error) {
+	var obus []OBU
+	for len(b) > 0 {
+		o := OBU{Type: int(b[0]>>3) & 0x0F, HasSize: b[0]&0x02 != 0}
+		n := 1
+		if b[0]&0x04 != 0 { // obu_extension_flag
+			n = 2
+		}
+		if len(b) < n {
+			return nil, errors.New("av1: short OBU header")
+		}
+		o.Header, b = b[:n], b[n:]
+		size := uint64(len(b))
+		if o.HasSize {
+			// leb128 is the same encoding as an unsigned varint.
+			v, m := binary.Uvarint(b)
+			if m <= 0 {
+				return nil, errors.New("av1: invalid obu_size")
+			}
+			size, b = v, b[m:]
+		}
+		if size > uint64(len(b)) {
+			return nil, fmt.Errorf("av1: obu_size %d exceeds data", size)
+		}
+		o.Payload, b = b[:size], b[size:]
+		obus = append(obus, o)
+	}
+	return obus, nil
+}
+
+// SequenceHeader is the part of a sequence_header_obu needed to write an
+// AV1CodecConfigurationRecord and to parse frame headers.
+type SequenceHeader struct {
+	Profile                   uint8
+	ReducedStillPictureHeader bool
+	Level                     uint8
+	Tier                      uint8
+	MaxFrameWidth             uint
+	MaxFrameHeight            uint
+	HighBitDepth              bool
+	TwelveBit                 bool
+	MonoChrome                bool
+	ChromaSubsamplingX        bool
+	ChromaSubsamplingY        bool
+	ChromaSamplePosition      uint8
+	// NumUnitsInDisplayTick and TimeScale are the timing information.
+	// They are 0 when it is not present.
+	NumUnitsInDisplayTick, TimeScale uint32
+}
+
+// ParseSequenceHeader parses a sequence_header_obu of Section 5.5 up to
+// the color_config.
+func ParseSequenceHeader(payload []byte) (SequenceHeader, error) {
+	r := bitstream.NewReader(payload)
+	var h SequenceHeader
+	h.Profile = uint8(r.ReadBits(3))
+	r.Skip(1) // still_picture
+	h.ReducedStillPictureHeader = r.ReadFlag()
+	if h.ReducedStillPictureHeader {
+		h.Level = uint8(r.ReadBits(5))
+	} else {
+		decoderModelInfo := false
+		bufferDelayLength := 0
+		if r.ReadFlag() { // timing_info_present_flag
+			h.NumUnitsInDisplayTick = uint32(r.ReadBits(32))
+			h.TimeScale = uint32(r.ReadBits(32))
+			if r.ReadFlag() { // equal_picture_interval
+				readUVLC(r) // num_ticks_per_picture_minus_1
+			}
+			decoderModelInfo = r.ReadFlag()
+			if decoderModelInfo {
+				bufferDelayLength = int(r.ReadBits(5)) + 1
+				r.Skip(32 + 5 + 5)
+			}
+		}
+		initialDisplayDelay := r.ReadFlag()
+		points := int(r.ReadBits(5)) + 1
+		for i := range points {
+			r.Skip(12) // operating_point_idc
+			level := uint8(r.ReadBits(5))
+			var tier uint8
+			if level > 7 {
+				tier = uint8(r.ReadBits(1))
+			}
+			if i == 0 {
+				h.Level, h.Tier = level, tier
+			}
+			if decoderModelInfo && r.ReadFlag() { // decoder_model_present_for_this_op
+				r.Skip(2*bufferDelayLength + 1)
+			}
+			if initialDisplayDelay && r.ReadFlag() { // initial_display_delay_present_for_this_op
+				r.Skip(4)
+			}
+		}
+	}
+	widthBits := int(r.ReadBits(4)) + 1
+	heightBits := int(r.ReadBits(4)) + 1
+	h.MaxFrameWidth = uint(r.ReadBits(widthBits)) + 1
+	h.MaxFrameHeight = uint(r.ReadBits(heightBits)) + 1
+	if !h.ReducedStillPictureHeader && r.ReadFlag() { // frame_id_numbers_present_flag
+		r.Skip(4 + 3)
+	}
+	r.Skip(3) // use_128x128_superblock, enable_filter_intra, enable_intra_edge_filter
+	if !h.ReducedStillPictureHeader {
+		// enable_interintra_compound, enable_masked_compound,
+		// enable_warped_motion, enable_dual_filter
+		r.Skip(4)
+		orderHint := r.ReadFlag()
+		if orderHint {
+			r.Skip(2) // enable_jnt_comp, enable_ref_frame_mvs
+		}
+		forceScreenContentTools := uint64(2)
+		if !r.ReadFlag() { // seq_choose_screen_content_tools
+			forceScreenContentTools = r.ReadBits(1)
+		}
+		if forceScreenContentTools > 0 && !r.ReadFlag() { // seq_choose_integer_mv
+			r.Skip(1) // seq_force_integer_mv
+		}
+		if orderHint {
+			r.Skip(3) // order_hint_bits_minus_1
+		}
+	}
+	r.Skip(3) // enable_superres, enable_cdef, enable_restoration
+	parseColorConfig(r, &h)
+	if err := r.Err(); err != nil {
+		return SequenceHeader{}, fmt.Errorf("av1: could not parse sequence header: %w", err)
+	}
+	return h, nil
+}
+
+// parseColorConfig parses the color_config of Section 5.5.2 up to
+// chroma_sample_position.
+func parseColorConfig(r *bitstream.Reader, h *SequenceHeader) {
+	h.HighBitDepth = r.ReadFlag()
+	if h.Profile == 2 && h.HighBitDepth {
+		h.TwelveBit = r.ReadFlag()
+	}
+	if h.Profile != 1 {
+		h.MonoChrome = r.ReadFlag()
+	}
+	// CP_UNSPECIFIED, TC_UNSPECIFIED and MC_UNSPECIFIED
+	cp, tc, mc := uint64(2), uint64(2), uint64(2)
+	if r.ReadFlag() { // color_description_present_flag
+		cp, tc, mc = r.ReadBits(8), r.ReadBits(8), r.ReadBits(8)
+	}
+	if h.MonoChrome {
+		h.ChromaSubsamplingX, h.ChromaSubsamplingY = true, true
+		return
+	}
+	if cp == 1 && tc == 13 && mc == 0 { // CP_BT_709, TC_SRGB, MC_IDENTITY
+		return
+	}
+	r.Skip(1) // color_range
+	switch h.Profile {
+	case 0:
+		h.ChromaSubsamplingX, h.ChromaSubsamplingY = true, true
+	case 1:
+	default:
+		if h.TwelveBit {
+			h.ChromaSubsamplingX = r.ReadFlag()
+			if h.ChromaSubsamplingX {
+				h.ChromaSubsamplingY = r.ReadFlag()
+			}
+		} else {
+			h.ChromaSubsamplingX = true
+		}
+	}
+	if h.ChromaSubsamplingX && h.ChromaSubsamplingY {
+		h.ChromaSamplePosition = uint8(r.ReadBits(2))
+	}
+}
+
+func readUVLC(r *bitstream.Reader) uint64 {
+	zeros := 0
+	for !r.ReadFlag() && r.Err() == nil {
+		zeros++
+		if zeros >= 32 {
+			return 1<<32 - 1
+		}
+	}
+	return r.ReadBits(zeros) + 1<<zeros - 1
+}
+
+// CodecConfigurationRecord returns the AV1CodecConfigurationRecord of
+// the AV1 Codec ISO Media File Format Binding followed by the sequence
+// header OBU.
+func (h SequenceHeader) CodecConfigurationRecord(sequenceHeader OBU) []byte {
+	flag := func(v bool, shift int) byte {
+		if v {
+			return 1 << shift
+		}
+		return 0
+	}
+	b := []byte{
+		0x81, // marker, version
+		h.Profile<<5 | h.Level&0x1F,
+		h.Tier<<7 | flag(h.HighBitDepth, 6) | flag(h.TwelveBit, 5) | flag(h.MonoChrome, 4) |
+			flag(h.ChromaSubsamplingX, 3) | flag(h.ChromaSubsamplingY, 2) | h.ChromaSamplePosition&0x03,
+		0, // initial_presentation_delay_present
+	}
+	return sequenceHeader.Append(b)
+}
+
+// IsKeyFrame reports whether the payload of a frame_header_obu or a
+// frame_obu is a shown key frame. Only the beginning of the
+// uncompressed_header of Section 5.9.2 is parsed.
+func (h SequenceHeader) IsKeyFrame(payload []byte) bool {
+	if h.ReducedStillPictureHeader {
+		return true
+	}
+	r := bitstream.NewReader(payload)
+	if r.ReadFlag() { // show_existing_frame
+		return false
+	}
+	frameType := r.ReadBits(2)
+	showFrame := r.ReadFlag()
+	return r.Err() == nil && frameType == 0 && showFrame // KEY_FRAME
+}
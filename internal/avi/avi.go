@@ -4,6 +4,7 @@
 package avi
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -13,6 +14,8 @@ import (
 	"os"
 	"path/filepath"
 	"runtime/debug"
+	"slices"
+	"time"
 )
 
 var (
@@ -66,15 +69,74 @@ const smallsString = "00010203040506070809" +
 	"80818283848586878889" +
 	"90919293949596979899"
 
+// Writer writes an AVI file.
+//
+// The file starts as an AVI 1.0 file with a single RIFF 'AVI ' chunk and
+// an idx1 index. When the RIFF chunk would grow over 1 GiB, the Writer
+// switches to OpenDML (AVI 2.0): the data continues in RIFF 'AVIX' chunks,
+// every movi list gets an ix## standard index per stream, and the header
+// gets an indx super index per stream and an odml list.
+//
+// See: https://www.alexander-noe.com/video/documentation/avi.pdf
 type Writer struct {
+	w      io.WriterAt
 	avi    *riff.Writer
 	movi   *riff.Writer
 	header *riff.Writer // placeholder
 	idx    []byte
 	err    error
 
-	offset uint32
-	maxLen uint32
+	queues [][]queuedChunk
+
+	offset    int64 // absolute offset of the next chunk
+	riffStart int64 // absolute offset of the current RIFF chunk
+	limit     int64 // size of a RIFF chunk which starts a new one
+	maxLen    uint32
+
+	odml        bool
+	indexed     bool                         // the last standard indexes have been written
+	index       map[riff.FourCC][]indexEntry // entries of the current movi list
+	super       map[riff.FourCC][]superEntry // standard indexes written so far
+	firstFrames map[riff.FourCC]uint32       // chunks in the first RIFF chunk
+	chunkIDs    map[int]riff.FourCC          // first chunk ID written per stream
+}
+
+// headerSize is the space reserved for the header at the beginning of
+// the file.
+const headerSize = 8192
+
+// riffLimit is the maximum size of a RIFF chunk before the Writer starts
+// a new one. Some readers treat sizes as signed, so this stays far below
+// 4 GiB.
+const riffLimit = 1 << 30
+
+var (
+	RIFFAVIX = riff.FourCC{'A', 'V', 'I', 'X'}
+
+	ListODML  = riff.FourCC{'o', 'd', 'm', 'l'}
+	ChunkDMLH = riff.FourCC{'d', 'm', 'l', 'h'}
+)
+
+const (
+	// AVI_INDEX_OF_INDEXES is the bIndexType of a super index.
+	AVI_INDEX_OF_INDEXES uint8 = 0x00
+	// AVI_INDEX_OF_CHUNKS is the bIndexType of a standard index.
+	AVI_INDEX_OF_CHUNKS uint8 = 0x01
+)
+
+// indexEntry is an entry of an ix## standard index.
+type indexEntry struct {
+	offset int64 // absolute offset of the chunk data
+	size   uint32
+	key    bool
+}
+
+// superEntry is an entry of an indx super index.
+type superEntry struct {
+	offset int64 // absolute offset of the ix## chunk
+	size   uint32
+	chunks uint32
+	bytes  uint64
 }
 
 func NewWriter(w io.WriterAt) (*Writer, error) {
@@ -83,31 +145,94 @@ func NewWriter(w io.WriterAt) (*Writer, error) {
 		return nil, err
 	}
 
-	return &Writer{avi: lw}, nil
+	return &Writer{
+		w:           w,
+		avi:         lw,
+		limit:       riffLimit,
+		index:       make(map[riff.FourCC][]indexEntry),
+		super:       make(map[riff.FourCC][]superEntry),
+		firstFrames: make(map[riff.FourCC]uint32),
+		chunkIDs:    make(map[int]riff.FourCC),
+	}, nil
 }
 
 func (w *Writer) MaxLen() uint32 {
 	return w.maxLen
 }
 
+// OpenDML reports whether the file has been switched to OpenDML.
+func (w *Writer) OpenDML() bool {
+	return w.odml
+}
+
+// Stream describes a stream of the AVI file.
+type Stream struct {
+	Header StreamHeader
+	// Format is the content of the strf chunk. It is a BITMAPINFOHEADER
+	// for video streams and a WAVEFORMATEX for audio streams, optionally
+	// followed by codec specific data.
+	Format []byte
+	// Name is the content of the optional strn chunk.
+	Name string
+}
+
 // WriteHeader writes the header and metadata information into the
-// first 2048 bytes of the given io.WriterAt.
+// first 8192 bytes of the given io.WriterAt. One strl list is written
+// for each stream in the order of streams, so the stream with index i
+// has to use the chunk IDs created by NewStreamID(i, ...).
 //
-// WriteHeader should be called last as it requires information like total frames.
-func (w *Writer) WriteHeader(sh StreamHeader, sf StreamFormat) error {
+// The main header is derived from the first video stream.
+//
+// WriteHeader should be called last as it requires information like total
+// frames. In OpenDML mode it also writes the indexes of the last movi
+// list, so no data can be written afterward.
+func (w *Writer) WriteHeader(streams ...Stream) error {
 	if w.header == nil {
 		return errors.New("avi: header writer has not been initialised (forgot to call WriteData?)")
 	}
+	if len(streams) == 0 || len(streams) > 99 {
+		return fmt.Errorf("avi: invalid number of streams: %d", len(streams))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if w.odml && !w.indexed {
+		if w.err = w.writeStandardIndexes(); w.err != nil {
+			return w.err
+		}
+		w.indexed = true
+	}
 	lw := w.header
 
 	var mh MainHeader
-	microSecPerFrame := float64(sh.Scale()) / float64(sh.Rate()) * float64(sh.Scale())
-	mh.SetMicroSecPerFrame(uint32(math.Ceil(microSecPerFrame)))
 	mh.SetFlags(AVIF_HASINDEX | AVIF_ISINTERLEAVED)
-	mh.SetTotalFrames(sh.Length())
-	mh.SetStreams(1) // number of audio streams plus one
-	mh.SetWidth(sf.Width())
-	mh.SetHeight(sf.Height())
+	mh.SetStreams(uint32(len(streams)))
+	mh.SetSuggestedBufferSize(w.maxLen)
+	var totalFrames uint32
+	for i, st := range streams {
+		sh := st.Header
+		if sh.Type() != StreamTypeVIDS {
+			continue
+		}
+		if sh.Rate() > 0 {
+			microSecPerFrame := float64(sh.Scale()) / float64(sh.Rate()) * 1000000.0
+			mh.SetMicroSecPerFrame(uint32(math.Ceil(microSecPerFrame)))
+		}
+		totalFrames = sh.Length()
+		mh.SetTotalFrames(totalFrames)
+		if w.odml {
+			// The main header only counts the frames of the first RIFF chunk.
+			if id, ok := w.streamChunkID(i); ok {
+				mh.SetTotalFrames(w.firstFrames[id])
+			}
+		}
+		if len(st.Format) >= len(StreamFormat{}) {
+			sf := StreamFormat(st.Format)
+			mh.SetWidth(sf.Width())
+			mh.SetHeight(sf.Height())
+		}
+		break
+	}
 
 	hdrlChunk, err := lw.Next(riff.LIST)
 	if err != nil {
@@ -126,7 +251,7 @@ func (w *Writer) WriteHeader(sh StreamHeader, sf StreamFormat) error {
 			return err
 		}
 	}
-	{ // LIST strl
+	for i, st := range streams { // LIST strl
 		strlChunk, err := hdrl.Next(riff.LIST)
 		if err != nil {
 			return err
@@ -140,7 +265,7 @@ func (w *Writer) WriteHeader(sh StreamHeader, sf StreamFormat) error {
 			if err != nil {
 				return err
 			}
-			if _, err := strhChunk.Write(sh[:]); err != nil {
+			if _, err := strhChunk.Write(st.Header[:]); err != nil {
 				return err
 			}
 		}
@@ -149,7 +274,25 @@ func (w *Writer) WriteHeader(sh StreamHeader, sf StreamFormat) error {
 			if err != nil {
 				return err
 			}
-			if _, err := strfChunk.Write(sf[:]); err != nil {
+			if _, err := strfChunk.Write(st.Format); err != nil {
+				return err
+			}
+		}
+		if st.Name != "" { // strn
+			strnChunk, err := strl.Next(ChunkSTRN)
+			if err != nil {
+				return err
+			}
+			if _, err := strnChunk.Write(append([]byte(st.Name), 0)); err != nil {
+				return err
+			}
+		}
+		if w.odml { // indx
+			indxChunk, err := strl.Next(ChunkINDX)
+			if err != nil {
+				return err
+			}
+			if _, err := indxChunk.Write(w.superIndex(i, st.Header)); err != nil {
 				return err
 			}
 		}
@@ -157,6 +300,28 @@ func (w *Writer) WriteHeader(sh StreamHeader, sf StreamFormat) error {
 			return err
 		}
 	}
+	if w.odml { // LIST odml
+		odmlChunk, err := hdrl.Next(riff.LIST)
+		if err != nil {
+			return err
+		}
+		odml, err := riff.NewListWriter(odmlChunk, ListODML)
+		if err != nil {
+			return err
+		}
+		dmlh, err := odml.Next(ChunkDMLH)
+		if err != nil {
+			return err
+		}
+		var buf [248]byte // dwTotalFrames followed by reserved space
+		binary.LittleEndian.PutUint32(buf[0:4], totalFrames)
+		if _, err := dmlh.Write(buf[:]); err != nil {
+			return err
+		}
+		if err := odml.Close(); err != nil {
+			return err
+		}
+	}
 	if err := hdrl.Close(); err != nil {
 		return err
 	}
@@ -191,6 +356,36 @@ func (w *Writer) WriteHeader(sh StreamHeader, sf StreamFormat) error {
 	return nil
 }
 
+// streamChunkID returns the ID of the first data chunk written for stream
+// i.
+func (w *Writer) streamChunkID(i int) (riff.FourCC, bool) {
+	id, ok := w.chunkIDs[i]
+	return id, ok
+}
+
+// superIndex returns the content of the indx chunk of stream i.
+func (w *Writer) superIndex(i int, sh StreamHeader) []byte {
+	id, _ := w.streamChunkID(i)
+	entries := w.super[id]
+	b := make([]byte, 24+16*len(entries))
+	binary.LittleEndian.PutUint16(b[0:2], 4) // wLongsPerEntry
+	b[2] = 0                                 // bIndexSubType
+	b[3] = AVI_INDEX_OF_INDEXES
+	binary.LittleEndian.PutUint32(b[4:8], uint32(len(entries)))
+	copy(b[8:12], id[:])
+	for j, e := range entries {
+		eb := b[24+16*j:]
+		binary.LittleEndian.PutUint64(eb[0:8], uint64(e.offset))
+		binary.LittleEndian.PutUint32(eb[8:12], e.size)
+		duration := e.chunks
+		if n := sh.SampleSize(); n > 0 {
+			duration = uint32(e.bytes / uint64(n))
+		}
+		binary.LittleEndian.PutUint32(eb[12:16], duration)
+	}
+	return b
+}
+
 func (w *Writer) init() error {
 	if w.movi != nil {
 		return errors.New("avi: movi already initialized (called WriteHeader twice?)")
@@ -198,8 +393,8 @@ func (w *Writer) init() error {
 	lw := w.avi
 
 	w.offset = 32 // According to my math, this is supposed to be 12
-	var target uint32 = 2048
-	if w.header, w.err = lw.Placeholder(target - w.offset); w.err != nil {
+	var target int64 = headerSize
+	if w.header, w.err = lw.Placeholder(uint32(target - w.offset)); w.err != nil {
 		return w.err
 	}
 	w.offset = target
@@ -217,11 +412,19 @@ func (w *Writer) init() error {
 }
 
 func (w *Writer) WriteData(id riff.FourCC, b []byte, flags uint32) error {
+	if w.indexed {
+		return errors.New("avi: data written after the header")
+	}
 	if w.movi == nil {
 		if err := w.init(); err != nil {
 			return err
 		}
 	}
+	if w.offset+8+int64(len(b))-w.riffStart > w.limit {
+		if err := w.nextRIFF(); err != nil {
+			return err
+		}
+	}
 	ww, err := w.movi.Next(id)
 	if err != nil {
 		return err
@@ -231,16 +434,28 @@ func (w *Writer) WriteData(id riff.FourCC, b []byte, flags uint32) error {
 		return err
 	}
 
-	w.idx = append(w.idx, id[0], id[1], id[2], id[3])
-	var buf [4]byte
-	binary.LittleEndian.PutUint32(buf[:], flags)
-	w.idx = append(w.idx, buf[0], buf[1], buf[2], buf[3]) // flags
-	binary.LittleEndian.PutUint32(buf[:], w.offset)
-	w.idx = append(w.idx, buf[0], buf[1], buf[2], buf[3]) // offset
-	binary.LittleEndian.PutUint32(buf[:], uint32(len(b)))
-	w.idx = append(w.idx, buf[0], buf[1], buf[2], buf[3]) // size
+	if !w.odml {
+		w.idx = append(w.idx, id[0], id[1], id[2], id[3])
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], flags)
+		w.idx = append(w.idx, buf[0], buf[1], buf[2], buf[3]) // flags
+		binary.LittleEndian.PutUint32(buf[:], uint32(w.offset))
+		w.idx = append(w.idx, buf[0], buf[1], buf[2], buf[3]) // offset
+		binary.LittleEndian.PutUint32(buf[:], uint32(len(b)))
+		w.idx = append(w.idx, buf[0], buf[1], buf[2], buf[3]) // size
+	}
+	if i, ok := streamIndex(id); ok {
+		if _, ok := w.chunkIDs[i]; !ok {
+			w.chunkIDs[i] = id
+		}
+	}
+	w.index[id] = append(w.index[id], indexEntry{
+		offset: w.offset + 8,
+		size:   uint32(len(b)),
+		key:    flags&AVIIF_KEYFRAME != 0,
+	})
 
-	w.offset += uint32(len(b)) + 8 /* id and size */
+	w.offset += int64(len(b)) + 8 /* id and size */
 	if w.offset&1 == 1 {
 		w.offset++ // padding
 	}
@@ -251,21 +466,165 @@ func (w *Writer) WriteData(id riff.FourCC, b []byte, flags uint32) error {
 	return nil
 }
 
+// nextRIFF closes the current RIFF chunk and starts a RIFF 'AVIX' chunk
+// with a new movi list.
+func (w *Writer) nextRIFF() error {
+	if err := w.writeStandardIndexes(); err != nil {
+		return err
+	}
+	if err := w.movi.Close(); err != nil {
+		return err
+	}
+	if !w.odml {
+		for id, entries := range w.super {
+			w.firstFrames[id] = entries[0].chunks
+		}
+		if err := w.writeIDX1(); err != nil {
+			return err
+		}
+		w.odml = true
+	}
+	if err := w.avi.Close(); err != nil {
+		return err
+	}
+
+	w.riffStart = w.offset
+	var err error
+	if w.avi, err = riff.NewWriter(io.NewOffsetWriter(w.w, w.riffStart), RIFFAVIX); err != nil {
+		return err
+	}
+	moviChunk, err := w.avi.Next(riff.LIST)
+	if err != nil {
+		return err
+	}
+	if w.movi, err = riff.NewListWriter(moviChunk, ListMOVI); err != nil {
+		return err
+	}
+	w.offset = w.riffStart + 24 // RIFF header and LIST header
+	return nil
+}
+
+// writeStandardIndexes writes an ix## chunk for each stream of the
+// current movi list and records them for the super indexes.
+func (w *Writer) writeStandardIndexes() error {
+	ids := make([]riff.FourCC, 0, len(w.index))
+	for id := range w.index {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, func(a, b riff.FourCC) int { return bytes.Compare(a[:], b[:]) })
+	for _, id := range ids {
+		entries := w.index[id]
+		b := make([]byte, 24+8*len(entries))
+		binary.LittleEndian.PutUint16(b[0:2], 2) // wLongsPerEntry
+		b[2] = 0                                 // bIndexSubType
+		b[3] = AVI_INDEX_OF_CHUNKS
+		binary.LittleEndian.PutUint32(b[4:8], uint32(len(entries)))
+		copy(b[8:12], id[:])
+		binary.LittleEndian.PutUint64(b[12:20], uint64(w.riffStart)) // qwBaseOffset
+		var total uint64
+		for j, e := range entries {
+			eb := b[24+8*j:]
+			binary.LittleEndian.PutUint32(eb[0:4], uint32(e.offset-w.riffStart))
+			size := e.size
+			if !e.key {
+				size |= 1 << 31
+			}
+			binary.LittleEndian.PutUint32(eb[4:8], size)
+			total += uint64(e.size)
+		}
+		ix, err := w.movi.Next(riff.FourCC{'i', 'x', id[0], id[1]})
+		if err != nil {
+			return err
+		}
+		if _, err := ix.Write(b); err != nil {
+			return err
+		}
+		w.super[id] = append(w.super[id], superEntry{
+			offset: w.offset,
+			size:   uint32(8 + len(b)),
+			chunks: uint32(len(entries)),
+			bytes:  total,
+		})
+		w.offset += int64(8 + len(b))
+	}
+	clear(w.index)
+	return nil
+}
+
+func (w *Writer) writeIDX1() error {
+	ww, err := w.avi.Next(ChunkIDX1)
+	if err != nil {
+		return err
+	}
+	if _, err := ww.Write(w.idx); err != nil {
+		return err
+	}
+	w.offset += int64(8 + len(w.idx))
+	w.idx = nil
+	return nil
+}
+
+type queuedChunk struct {
+	id    riff.FourCC
+	ts    time.Duration
+	b     []byte
+	flags uint32
+}
+
+// Queue buffers a chunk until Flush is called. Chunks of the same stream
+// keep their order, so video frames can be queued in decoding order.
+func (w *Writer) Queue(id riff.FourCC, ts time.Duration, b []byte, flags uint32) {
+	for i, q := range w.queues {
+		if q[0].id == id {
+			w.queues[i] = append(q, queuedChunk{id: id, ts: ts, b: b, flags: flags})
+			return
+		}
+	}
+	w.queues = append(w.queues, []queuedChunk{{id: id, ts: ts, b: b, flags: flags}})
+}
+
+// Flush writes the queued chunks interleaved by their timestamp. The next
+// chunk is always the head of the stream with the lowest timestamp.
+func (w *Writer) Flush() error {
+	for len(w.queues) > 0 {
+		next := 0
+		for i := 1; i < len(w.queues); i++ {
+			if w.queues[i][0].ts < w.queues[next][0].ts {
+				next = i
+			}
+		}
+		c := w.queues[next][0]
+		if err := w.WriteData(c.id, c.b, c.flags); err != nil {
+			return err
+		}
+		if w.queues[next] = w.queues[next][1:]; len(w.queues[next]) == 0 {
+			w.queues = slices.Delete(w.queues, next, next+1)
+		}
+	}
+	return nil
+}
+
 func (w *Writer) Close() error {
 	if w.err != nil {
 		return w.err
 	}
+	if w.err = w.Flush(); w.err != nil {
+		return w.err
+	}
 	if w.movi != nil {
+		if w.odml && !w.indexed {
+			if w.err = w.writeStandardIndexes(); w.err != nil {
+				return w.err
+			}
+			w.indexed = true
+		}
 		if w.err = w.movi.Close(); w.err != nil {
 			return w.err
 		}
-		ww, err := w.avi.Next(ChunkIDX1)
-		if err != nil {
-			w.err = err
-			return err
-		}
-		if _, w.err = ww.Write(w.idx); w.err != nil {
-			return w.err
+		if !w.odml {
+			if w.err = w.writeIDX1(); w.err != nil {
+				return w.err
+			}
 		}
 	}
 	if w.err = w.avi.Close(); w.err != nil {
@@ -614,3 +973,79 @@ const (
 	// AVIIF_NO_TIME indicates that the data chunk does not affect the timing of the stream.
 	AVIIF_NO_TIME uint32 = 1 << 8
 )
+
+// WaveFormatEx defines the format of waveform-audio data. It is used as
+// the strf chunk of audio streams. Codec specific data of Size bytes may
+// follow it.
+//
+// See: https://learn.microsoft.com/en-us/windows/win32/api/mmeapi/ns-mmeapi-waveformatex
+type WaveFormatEx [18]byte
+
+const (
+	WAVE_FORMAT_PCM        uint16 = 0x0001
+	WAVE_FORMAT_IEEE_FLOAT uint16 = 0x0003
+	WAVE_FORMAT_MPEG       uint16 = 0x0050
+	WAVE_FORMAT_MPEGLAYER3 uint16 = 0x0055
+	WAVE_FORMAT_DOLBY_AC3  uint16 = 0x2000
+	WAVE_FORMAT_EXTENSIBLE uint16 = 0xFFFE
+)
+
+// FormatTag specifies the waveform-audio format type.
+func (f *WaveFormatEx) FormatTag() uint16 {
+	return binary.LittleEndian.Uint16(f[0:2])
+}
+
+func (f *WaveFormatEx) SetFormatTag(v uint16) {
+	binary.LittleEndian.PutUint16(f[0:2], v)
+}
+
+func (f *WaveFormatEx) Channels() uint16 {
+	return binary.LittleEndian.Uint16(f[2:4])
+}
+
+func (f *WaveFormatEx) SetChannels(v uint16) {
+	binary.LittleEndian.PutUint16(f[2:4], v)
+}
+
+func (f *WaveFormatEx) SamplesPerSec() uint32 {
+	return binary.LittleEndian.Uint32(f[4:8])
+}
+
+func (f *WaveFormatEx) SetSamplesPerSec(v uint32) {
+	binary.LittleEndian.PutUint32(f[4:8], v)
+}
+
+func (f *WaveFormatEx) AvgBytesPerSec() uint32 {
+	return binary.LittleEndian.Uint32(f[8:12])
+}
+
+func (f *WaveFormatEx) SetAvgBytesPerSec(v uint32) {
+	binary.LittleEndian.PutUint32(f[8:12], v)
+}
+
+// BlockAlign specifies the minimum atomic unit of data in bytes.
+func (f *WaveFormatEx) BlockAlign() uint16 {
+	return binary.LittleEndian.Uint16(f[12:14])
+}
+
+func (f *WaveFormatEx) SetBlockAlign(v uint16) {
+	binary.LittleEndian.PutUint16(f[12:14], v)
+}
+
+func (f *WaveFormatEx) BitsPerSample() uint16 {
+	return binary.LittleEndian.Uint16(f[14:16])
+}
+
+func (f *WaveFormatEx) SetBitsPerSample(v uint16) {
+	binary.LittleEndian.PutUint16(f[14:16], v)
+}
+
+// Size specifies the size of the extra format information following
+// the structure.
+func (f *WaveFormatEx) Size() uint16 {
+	return binary.LittleEndian.Uint16(f[16:18])
+}
+
+func (f *WaveFormatEx) SetSize(v uint16) {
+	binary.LittleEndian.PutUint16(f[16:18], v)
+}
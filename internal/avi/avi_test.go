@@ -0,0 +1,393 @@
+package avi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/coding-socks/matroska/internal/riff"
+	"io"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	var writeAt bytesWriterAt
+	w, err := NewWriter(&writeAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	video := NewStreamID(0, StreamTypeDC)
+	audio := NewStreamID(1, StreamTypeWB)
+	// Video frames are queued in decoding order: I P B.
+	w.Queue(video, 0, []byte("I"), AVIIF_KEYFRAME)
+	w.Queue(video, 80*time.Millisecond, []byte("P"), 0)
+	w.Queue(video, 40*time.Millisecond, []byte("B"), 0)
+	w.Queue(audio, 0, []byte("a0"), AVIIF_KEYFRAME)
+	w.Queue(audio, 50*time.Millisecond, []byte("a1"), AVIIF_KEYFRAME)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var vh, ah StreamHeader
+	vh.SetType(StreamTypeVIDS)
+	vh.SetScale(1)
+	vh.SetRate(25)
+	vh.SetLength(3)
+	var vf StreamFormat
+	vf.SetSize(40)
+	vf.SetWidth(320)
+	vf.SetHeight(240)
+	ah.SetType(StreamTypeAUDS)
+	var af WaveFormatEx
+	af.SetFormatTag(WAVE_FORMAT_PCM)
+	if err := w.WriteHeader(Stream{Header: vh, Format: vf[:]}, Stream{Header: ah, Format: af[:]}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, r, err := riff.NewReader(bytes.NewReader(writeAt.buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mh      MainHeader
+		formats [][]byte
+		chunks  []string
+	)
+	for {
+		id, l, cr, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if id != riff.LIST {
+			io.Copy(io.Discard, cr)
+			continue
+		}
+		listType, lr, err := riff.NewListReader(l, cr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch listType {
+		case ListHRDL:
+			for {
+				id, l, cr, err := lr.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				if id == ChunkAVIH {
+					io.ReadFull(cr, mh[:])
+					continue
+				}
+				_, sr, err := riff.NewListReader(l, cr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for {
+					id, _, cr, err := sr.Next()
+					if err == io.EOF {
+						break
+					} else if err != nil {
+						t.Fatal(err)
+					}
+					b, _ := io.ReadAll(cr)
+					if id == ChunkSTRF {
+						formats = append(formats, b)
+					}
+				}
+			}
+		case ListMOVI:
+			for {
+				id, _, cr, err := lr.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				b, _ := io.ReadAll(cr)
+				chunks = append(chunks, string(id[:])+":"+string(b))
+			}
+		default:
+			io.Copy(io.Discard, cr)
+		}
+	}
+
+	if got, want := mh.Streams(), uint32(2); got != want {
+		t.Errorf("Streams() = %d, want %d", got, want)
+	}
+	if got, want := mh.MicroSecPerFrame(), uint32(40000); got != want {
+		t.Errorf("MicroSecPerFrame() = %d, want %d", got, want)
+	}
+	if got, want := mh.Width(), uint32(320); got != want {
+		t.Errorf("Width() = %d, want %d", got, want)
+	}
+	if len(formats) != 2 || !bytes.Equal(formats[0], vf[:]) || !bytes.Equal(formats[1], af[:]) {
+		t.Errorf("strf chunks = %x, want %x and %x", formats, vf, af)
+	}
+	want := []string{"00dc:I", "01wb:a0", "01wb:a1", "00dc:P", "00dc:B"}
+	if !slices.Equal(chunks, want) {
+		t.Errorf("movi chunks = %q, want %q", chunks, want)
+	}
+}
+
+type bytesWriterAt struct {
+	buf []byte
+}
+
+func (b *bytesWriterAt) WriteAt(p []byte, off int64) (n int, err error) {
+	if n := off + int64(len(p)); n > int64(len(b.buf)) {
+		b.buf = append(b.buf, make([]byte, n-int64(len(b.buf)))...)
+	}
+	copy(b.buf[off:], p)
+	return len(p), nil
+}
+
+func TestWriterOpenDML(t *testing.T) {
+	var writeAt bytesWriterAt
+	w, err := NewWriter(&writeAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.limit = headerSize + 64
+	video := NewStreamID(0, StreamTypeDC)
+	frames := []string{"frame-00", "frame-01", "frame-02", "frame-03", "frame-04", "frame-05", "frame-06", "frame-07"}
+	for i, f := range frames {
+		var flags uint32
+		if i%4 == 0 {
+			flags = AVIIF_KEYFRAME
+		}
+		if err := w.WriteData(video, []byte(f), flags); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !w.OpenDML() {
+		t.Fatal("OpenDML() = false, want true")
+	}
+	var sh StreamHeader
+	sh.SetType(StreamTypeVIDS)
+	sh.SetScale(1)
+	sh.SetRate(25)
+	sh.SetLength(uint32(len(frames)))
+	var sf StreamFormat
+	if err := w.WriteHeader(Stream{Header: sh, Format: sf[:]}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteData(video, []byte("late"), 0); err == nil {
+		t.Error("WriteData() after WriteHeader() in OpenDML mode should fail")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	buf := writeAt.buf
+
+	// RIFF chunks
+	var riffs []string
+	for off := 0; off < len(buf); {
+		if string(buf[off:off+4]) != "RIFF" {
+			t.Fatalf("missing RIFF at offset %d", off)
+		}
+		riffs = append(riffs, string(buf[off+8:off+12]))
+		off += 8 + int(binary.LittleEndian.Uint32(buf[off+4:]))
+	}
+	if len(riffs) < 2 || riffs[0] != "AVI " || slices.ContainsFunc(riffs[1:], func(s string) bool { return s != "AVIX" }) {
+		t.Fatalf("RIFF chunks = %q, want AVI followed by AVIX", riffs)
+	}
+
+	// odml/dmlh
+	dmlh := bytes.Index(buf[:headerSize], []byte("dmlh"))
+	if dmlh == -1 {
+		t.Fatal("missing dmlh chunk")
+	}
+	if got := binary.LittleEndian.Uint32(buf[dmlh+8:]); got != uint32(len(frames)) {
+		t.Errorf("dwTotalFrames = %d, want %d", got, len(frames))
+	}
+
+	// indx, ix00 and data chunks
+	indx := bytes.Index(buf[:headerSize], []byte("indx"))
+	if indx == -1 {
+		t.Fatal("missing indx chunk")
+	}
+	indx += 8
+	if got := buf[indx+3]; got != AVI_INDEX_OF_INDEXES {
+		t.Errorf("bIndexType = %d, want %d", got, AVI_INDEX_OF_INDEXES)
+	}
+	var got []string
+	var keys []bool
+	n := int(binary.LittleEndian.Uint32(buf[indx+4:]))
+	for i := range n {
+		e := buf[indx+24+16*i:]
+		ix := int(binary.LittleEndian.Uint64(e[0:8]))
+		if string(buf[ix:ix+4]) != "ix00" {
+			t.Fatalf("super index entry %d points to %q", i, buf[ix:ix+4])
+		}
+		ix += 8
+		base := int(binary.LittleEndian.Uint64(buf[ix+12:]))
+		m := int(binary.LittleEndian.Uint32(buf[ix+4:]))
+		if d := binary.LittleEndian.Uint32(e[12:16]); int(d) != m {
+			t.Errorf("dwDuration = %d, want %d", d, m)
+		}
+		for j := range m {
+			se := buf[ix+24+8*j:]
+			off := base + int(binary.LittleEndian.Uint32(se[0:4]))
+			size := binary.LittleEndian.Uint32(se[4:8])
+			keys = append(keys, size&(1<<31) == 0)
+			size &^= 1 << 31
+			got = append(got, string(buf[off:off+int(size)]))
+		}
+	}
+	if !slices.Equal(got, frames) {
+		t.Errorf("indexed frames = %q, want %q", got, frames)
+	}
+	if want := []bool{true, false, false, false, true, false, false, false}; !slices.Equal(keys, want) {
+		t.Errorf("keyframes = %v, want %v", keys, want)
+	}
+}
+
+func TestReader(t *testing.T) {
+	for _, odml := range []bool{false, true} {
+		var writeAt bytesWriterAt
+		w, err := NewWriter(&writeAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if odml {
+			w.limit = headerSize + 64
+		}
+		video := NewStreamID(0, StreamTypeDC)
+		audio := NewStreamID(1, StreamTypeWB)
+		var want []Chunk
+		for i := range 6 {
+			var flags uint32
+			if i%3 == 0 {
+				flags = AVIIF_KEYFRAME
+			}
+			if err := w.WriteData(video, []byte{'v', byte(i)}, flags); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.WriteData(audio, []byte{'a', byte(i), 0}, AVIIF_KEYFRAME); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, Chunk{Stream: 0, Keyframe: i%3 == 0, Data: []byte{'v', byte(i)}}, Chunk{Stream: 1, Keyframe: true, Data: []byte{'a', byte(i), 0}})
+		}
+		var vh, ah StreamHeader
+		vh.SetType(StreamTypeVIDS)
+		vh.SetScale(1)
+		vh.SetRate(25)
+		ah.SetType(StreamTypeAUDS)
+		var af WaveFormatEx
+		af.SetFormatTag(WAVE_FORMAT_PCM)
+		if err := w.WriteHeader(Stream{Header: vh, Format: make([]byte, 40), Name: "video"}, Stream{Header: ah, Format: af[:]}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := NewReader(bytes.NewReader(writeAt.buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Streams) != 2 || r.Streams[0].Name != "video" || r.Streams[1].Header.Type() != StreamTypeAUDS {
+			t.Fatalf("OpenDML %v: Streams = %+v", odml, r.Streams)
+		}
+		var got []Chunk
+		for {
+			c, err := r.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, c)
+		}
+		if !slices.EqualFunc(got, want, func(a, b Chunk) bool {
+			return a.Stream == b.Stream && a.Keyframe == b.Keyframe && bytes.Equal(a.Data, b.Data)
+		}) {
+			t.Errorf("OpenDML %v: chunks = %v, want %v", odml, got, want)
+		}
+	}
+}
+
+func TestReaderDamaged(t *testing.T) {
+	// allocated returns the number of octets allocated by fn.
+	allocated := func(fn func()) uint64 {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		fn()
+		runtime.ReadMemStats(&after)
+		return after.TotalAlloc - before.TotalAlloc
+	}
+	// chunk returns a chunk header with the given size followed by data.
+	chunk := func(id string, size uint32, data string) []byte {
+		return append(binary.LittleEndian.AppendUint32([]byte(id), size), data...)
+	}
+	riffAVI := func(b []byte) []byte {
+		return append(chunk("RIFF", uint32(4+len(b)), "AVI "), b...)
+	}
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{name: "LIST smaller than its type", b: riffAVI(chunk("LIST", 2, "hdrl"))},
+		{name: "idx1 past the end of RIFF", b: riffAVI(chunk("idx1", 0xfffffff0, "0000"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			n := allocated(func() {
+				_, err = NewReader(bytes.NewReader(tt.b))
+			})
+			if err == nil {
+				t.Errorf("NewReader() should fail")
+			}
+			if n > 1<<20 {
+				t.Errorf("NewReader() allocated %d octets", n)
+			}
+		})
+	}
+
+	t.Run("chunk past the end of movi", func(t *testing.T) {
+		var writeAt bytesWriterAt
+		w, err := NewWriter(&writeAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		video := NewStreamID(0, StreamTypeDC)
+		if err := w.WriteData(video, []byte("frame"), AVIIF_KEYFRAME); err != nil {
+			t.Fatal(err)
+		}
+		var vh StreamHeader
+		vh.SetType(StreamTypeVIDS)
+		if err := w.WriteHeader(Stream{Header: vh, Format: make([]byte, 40)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		b := writeAt.buf
+		i := bytes.Index(b, []byte("movi00dc"))
+		if i < 0 {
+			t.Fatal("missing data chunk")
+		}
+		binary.LittleEndian.PutUint32(b[i+8:], 0xfffffff0)
+		r, err := NewReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := allocated(func() {
+			_, err = r.Next()
+		})
+		if err == nil || err == io.EOF {
+			t.Errorf("Next() = %v, want an error", err)
+		}
+		if n > 1<<20 {
+			t.Errorf("Next() allocated %d octets", n)
+		}
+	})
+}
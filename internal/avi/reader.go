@@ -0,0 +1,316 @@
+package avi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/coding-socks/matroska/internal/riff"
+	"io"
+)
+
+var ListREC = riff.FourCC{'r', 'e', 'c', ' '}
+
+// Chunk is a data chunk of a stream read by Reader.
+type Chunk struct {
+	// Stream is the index of the stream in Reader.Streams.
+	Stream   int
+	Keyframe bool
+	Data     []byte
+}
+
+// moviList is the data range of a movi list.
+type moviList struct {
+	start, end int64
+}
+
+// Reader reads the data chunks of an AVI file in file order. Files with
+// AVIX RIFF chunks written according to the OpenDML extension are
+// supported.
+//
+// Keyframes are taken from the ix## standard indexes referenced by the
+// indx super indexes or, when they are missing, from the idx1 index.
+// Without any index, every chunk is reported as a keyframe.
+type Reader struct {
+	r io.ReadSeeker
+	// size is the size of the file.
+	size int64
+
+	Header  MainHeader
+	Streams []Stream
+
+	movi []moviList
+	// keyframes maps the absolute offset of the data of a chunk to its
+	// keyframe flag.
+	keyframes map[int64]bool
+	super     [][]superEntry
+
+	cur int
+	pos int64
+}
+
+// NewReader reads the headers and the indexes of the AVI file.
+func NewReader(r io.ReadSeeker) (*Reader, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	ar := &Reader{r: r, size: size, keyframes: make(map[int64]bool)}
+	var idx1 []byte
+	for riffStart := int64(0); ; {
+		if _, err := r.Seek(riffStart, io.SeekStart); err != nil {
+			return nil, err
+		}
+		var buf [12]byte
+		if _, err := io.ReadFull(r, buf[:]); err == io.EOF && riffStart > 0 {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("avi: could not read RIFF header: %w", err)
+		}
+		fileType := riff.FourCC(buf[8:12])
+		if string(buf[0:4]) != "RIFF" || (riffStart == 0 && fileType != AVI) || (riffStart > 0 && fileType != RIFFAVIX) {
+			if riffStart == 0 {
+				return nil, errors.New("avi: not an AVI file")
+			}
+			break // trailing garbage
+		}
+		riffEnd := riffStart + 8 + int64(binary.LittleEndian.Uint32(buf[4:8]))
+		err := ar.readChunks(riffStart+12, riffEnd, func(id riff.FourCC, listType riff.FourCC, start int64, size uint32) error {
+			switch {
+			case id == riff.LIST && listType == ListHRDL:
+				b, err := ar.readAt(start+4, size-4)
+				if err != nil {
+					return err
+				}
+				return ar.parseHRDL(b)
+			case id == riff.LIST && listType == ListMOVI:
+				ar.movi = append(ar.movi, moviList{start: start + 4, end: start + int64(size)})
+			case id == ChunkIDX1 && riffStart == 0:
+				b, err := ar.readAt(start, size)
+				if err != nil {
+					return err
+				}
+				idx1 = b
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		riffStart = riffEnd + riffEnd&1
+	}
+	if len(ar.Streams) == 0 {
+		return nil, errors.New("avi: missing stream headers")
+	}
+	if len(ar.movi) == 0 {
+		return nil, errors.New("avi: missing movi list")
+	}
+	if err := ar.readIndexes(idx1); err != nil {
+		return nil, err
+	}
+	ar.pos = ar.movi[0].start
+	return ar, nil
+}
+
+// readChunks calls fn for every chunk between start and end. For LIST
+// chunks listType is set, and size includes the list type. Chunks which
+// do not fit between start and end are rejected.
+func (r *Reader) readChunks(start, end int64, fn func(id, listType riff.FourCC, start int64, size uint32) error) error {
+	for pos := start; pos+8 <= end; {
+		b, err := r.readAt(pos, 12)
+		if err != nil && !(errors.Is(err, io.ErrUnexpectedEOF) && len(b) >= 8) {
+			return fmt.Errorf("avi: could not read chunk header: %w", err)
+		}
+		id := riff.FourCC(b[0:4])
+		size := binary.LittleEndian.Uint32(b[4:8])
+		var listType riff.FourCC
+		if id == riff.LIST && len(b) >= 12 {
+			listType = riff.FourCC(b[8:12])
+		}
+		if id == riff.LIST && size < 4 {
+			return fmt.Errorf("avi: invalid LIST size %d at %d", size, pos)
+		}
+		if pos+8+int64(size) > end {
+			return fmt.Errorf("avi: chunk size %d at %d exceeds the end of its parent", size, pos)
+		}
+		if err := fn(id, listType, pos+8, size); err != nil {
+			return err
+		}
+		pos += 8 + int64(size) + int64(size&1)
+	}
+	return nil
+}
+
+// readAt reads size octets at pos. The size is clamped to the end of the
+// file before allocating, so a damaged size returns io.ErrUnexpectedEOF
+// instead of allocating memory for data which does not exist.
+func (r *Reader) readAt(pos int64, size uint32) ([]byte, error) {
+	if _, err := r.r.Seek(pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	b := make([]byte, min(int64(size), max(r.size-pos, 0)))
+	n, err := io.ReadFull(r.r, b)
+	if err == nil && n < int(size) {
+		err = io.ErrUnexpectedEOF
+		if n == 0 {
+			err = io.EOF
+		}
+	}
+	return b[:n], err
+}
+
+// parseHRDL parses the content of the hdrl list.
+func (r *Reader) parseHRDL(b []byte) error {
+	for id, data := range chunks(b) {
+		switch {
+		case id == ChunkAVIH:
+			copy(r.Header[:], data)
+		case id == riff.LIST && len(data) >= 4 && riff.FourCC(data[0:4]) == ListSTRL:
+			var st Stream
+			var super []superEntry
+			for id, data := range chunks(data[4:]) {
+				switch id {
+				case ChunkSTRH:
+					copy(st.Header[:], data)
+				case ChunkSTRF:
+					st.Format = data
+				case ChunkSTRN:
+					st.Name = string(bytes.TrimRight(data, "\x00"))
+				case ChunkINDX:
+					super = parseSuperIndex(data)
+				}
+			}
+			r.Streams = append(r.Streams, st)
+			r.super = append(r.super, super)
+		}
+	}
+	return nil
+}
+
+// chunks returns the chunks of the content of a list.
+func chunks(b []byte) func(yield func(riff.FourCC, []byte) bool) {
+	return func(yield func(riff.FourCC, []byte) bool) {
+		for len(b) >= 8 {
+			id := riff.FourCC(b[0:4])
+			size := int(binary.LittleEndian.Uint32(b[4:8]))
+			b = b[8:]
+			if size > len(b) {
+				size = len(b)
+			}
+			if !yield(id, b[:size]) {
+				return
+			}
+			b = b[min(size+size&1, len(b)):]
+		}
+	}
+}
+
+func parseSuperIndex(b []byte) []superEntry {
+	if len(b) < 24 || b[3] != AVI_INDEX_OF_INDEXES {
+		return nil
+	}
+	n := int(binary.LittleEndian.Uint32(b[4:8]))
+	var entries []superEntry
+	for i := range n {
+		e := b[24+16*i:]
+		if len(e) < 16 {
+			break
+		}
+		entries = append(entries, superEntry{
+			offset: int64(binary.LittleEndian.Uint64(e[0:8])),
+			size:   binary.LittleEndian.Uint32(e[8:12]),
+		})
+	}
+	return entries
+}
+
+// readIndexes fills keyframes from the standard indexes or from idx1.
+func (r *Reader) readIndexes(idx1 []byte) error {
+	for _, super := range r.super {
+		for _, e := range super {
+			b, err := r.readAt(e.offset, e.size)
+			if err != nil {
+				return fmt.Errorf("avi: could not read standard index: %w", err)
+			}
+			if len(b) < 8+24 || b[8+3] != AVI_INDEX_OF_CHUNKS {
+				return errors.New("avi: invalid standard index")
+			}
+			b = b[8:]
+			n := int(binary.LittleEndian.Uint32(b[4:8]))
+			base := int64(binary.LittleEndian.Uint64(b[12:20]))
+			for i := range n {
+				se := b[24+8*i:]
+				if len(se) < 8 {
+					break
+				}
+				off := base + int64(binary.LittleEndian.Uint32(se[0:4]))
+				r.keyframes[off] = binary.LittleEndian.Uint32(se[4:8])&(1<<31) == 0
+			}
+		}
+	}
+	if len(r.keyframes) > 0 || len(idx1) < 16 {
+		return nil
+	}
+	// idx1 offsets are either relative to the movi list type or absolute.
+	base := r.movi[0].start - 4
+	if first := int64(binary.LittleEndian.Uint32(idx1[8:12])); first >= base {
+		base = 0
+	}
+	for ; len(idx1) >= 16; idx1 = idx1[16:] {
+		flags := binary.LittleEndian.Uint32(idx1[4:8])
+		off := base + int64(binary.LittleEndian.Uint32(idx1[8:12])) + 8
+		r.keyframes[off] = flags&AVIIF_KEYFRAME != 0
+	}
+	return nil
+}
+
+// Next returns the next data chunk. It returns io.EOF after the last
+// chunk of the last movi list.
+func (r *Reader) Next() (Chunk, error) {
+	for r.cur < len(r.movi) {
+		m := r.movi[r.cur]
+		if r.pos+8 > m.end {
+			if r.cur++; r.cur < len(r.movi) {
+				r.pos = r.movi[r.cur].start
+			}
+			continue
+		}
+		b, err := r.readAt(r.pos, 12)
+		if len(b) < 8 {
+			return Chunk{}, fmt.Errorf("avi: could not read chunk header: %w", err)
+		}
+		id := riff.FourCC(b[0:4])
+		size := binary.LittleEndian.Uint32(b[4:8])
+		if id == riff.LIST && len(b) == 12 && riff.FourCC(b[8:12]) == ListREC {
+			r.pos += 12 // the chunks of a rec list are read one by one
+			continue
+		}
+		start := r.pos + 8
+		if start+int64(size) > m.end {
+			return Chunk{}, fmt.Errorf("avi: chunk size %d at %d exceeds the end of the movi list", size, r.pos)
+		}
+		r.pos = start + int64(size) + int64(size&1)
+		stream, ok := streamIndex(id)
+		if !ok || stream >= len(r.Streams) {
+			continue // JUNK, ix## and unknown chunks
+		}
+		data, err := r.readAt(start, size)
+		if err != nil {
+			return Chunk{}, fmt.Errorf("avi: could not read chunk: %w", err)
+		}
+		key, ok := r.keyframes[start]
+		if !ok {
+			key = len(r.keyframes) == 0
+		}
+		return Chunk{Stream: stream, Keyframe: key, Data: data}, nil
+	}
+	return Chunk{}, io.EOF
+}
+
+// streamIndex returns the stream number of a data chunk ID like 00dc.
+func streamIndex(id riff.FourCC) (int, bool) {
+	if id[0] < '0' || id[0] > '9' || id[1] < '0' || id[1] > '9' {
+		return 0, false
+	}
+	return int(id[0]-'0')*10 + int(id[1]-'0'), true
+}
@@ -0,0 +1,88 @@
+// Package bitstream implements a reader of the most significant bit
+// first bitstreams used by the H.264, HEVC and AV1 syntax structures.
+package bitstream
+
+import (
+	"io"
+)
+
+// Reader reads fixed and variable length codes from a byte slice.
+//
+// Errors are sticky: after reading past the end of the data, every read
+// returns 0 and Err returns io.ErrUnexpectedEOF. This way, a syntax
+// structure can be read without checking every field.
+type Reader struct {
+	b   []byte
+	pos int
+	err error
+}
+
+func NewReader(b []byte) *Reader {
+	return &Reader{b: b}
+}
+
+// Err returns the first error encountered by the Reader.
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Pos returns the number of bits read.
+func (r *Reader) Pos() int {
+	return r.pos
+}
+
+// ReadBits reads an n-bit unsigned integer, u(n). n must be at most 64.
+func (r *Reader) ReadBits(n int) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos+n > len(r.b)*8 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	var v uint64
+	for range n {
+		v = v<<1 | uint64(r.b[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+	return v
+}
+
+// ReadFlag reads a single bit.
+func (r *Reader) ReadFlag() bool {
+	return r.ReadBits(1) == 1
+}
+
+// Skip skips n bits.
+func (r *Reader) Skip(n int) {
+	for n > 64 {
+		r.ReadBits(64)
+		n -= 64
+	}
+	r.ReadBits(n)
+}
+
+// ReadUE reads an unsigned Exp-Golomb code, ue(v).
+func (r *Reader) ReadUE() uint64 {
+	zeros := 0
+	for !r.ReadFlag() {
+		if r.err != nil {
+			return 0
+		}
+		zeros++
+		if zeros > 32 {
+			r.err = io.ErrUnexpectedEOF
+			return 0
+		}
+	}
+	return 1<<zeros - 1 + r.ReadBits(zeros)
+}
+
+// ReadSE reads a signed Exp-Golomb code, se(v).
+func (r *Reader) ReadSE() int64 {
+	v := r.ReadUE()
+	if v%2 == 1 {
+		return int64(v+1) / 2
+	}
+	return -int64(v / 2)
+}
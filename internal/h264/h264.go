@@ -0,0 +1,329 @@
+// Package h264 implements the parsing of the H.264 syntax structures
+// needed to store an Annex B byte stream in a container.
+// See: https://www.itu.int/rec/T-REC-H.264
+package h264
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/coding-socks/matroska/internal/annexb"
+	"github.com/coding-socks/matroska/internal/bitstream"
+)
+
+// NAL unit types of Table 7-1.
+const (
+	NALSlice       = 1
+	NALIDR         = 5
+	NALSEI         = 6
+	NALSPS         = 7
+	NALPPS         = 8
+	NALAUD         = 9
+	NALEndOfSeq    = 10
+	NALEndOfStream = 11
+	NALFiller      = 12
+	NALSPSExt      = 13
+	NALPrefix      = 14
+	NALSubsetSPS   = 15
+)
+
+// NALType returns the nal_unit_type of a NAL unit.
+func NALType(nal []byte) int {
+	if len(nal) == 0 {
+		return 0
+	}
+	return int(nal[0] & 0x1F)
+}
+
+// SPS is a sequence parameter set.
+type SPS struct {
+	ProfileIDC         uint8
+	ConstraintFlags    uint8
+	LevelIDC           uint8
+	ID                 uint
+	ChromaFormatIDC    uint
+	SeparateColorPlane bool
+	BitDepthLuma       uint
+	BitDepthChroma     uint
+
+	Log2MaxFrameNum       uint
+	PicOrderCntType       uint
+	Log2MaxPicOrderCntLsb uint
+	FrameMbsOnly          bool
+
+	// Width and Height are the dimensions of the cropped frame.
+	Width, Height uint
+	// SARWidth and SARHeight are the sample aspect ratio. They are 0
+	// when it is unspecified.
+	SARWidth, SARHeight uint
+	// NumUnitsInTick and TimeScale are the timing information of the
+	// VUI. A frame lasts 2*NumUnitsInTick/TimeScale seconds. They are 0
+	// when the timing information is not present.
+	NumUnitsInTick, TimeScale uint32
+}
+
+// sampleAspectRatios is Table E-1 indexed by aspect_ratio_idc.
+var sampleAspectRatios = [...][2]uint{
+	{0, 0}, {1, 1}, {12, 11}, {10, 11}, {16, 11}, {40, 33}, {24, 11}, {20, 11},
+	{32, 11}, {80, 33}, {18, 11}, {15, 11}, {64, 33}, {160, 99}, {4, 3}, {3, 2}, {2, 1},
+}
+
+// SampleAspectRatio returns the sample aspect ratio of an
+// aspect_ratio_idc. The H.264 and HEVC tables are the same.
+func SampleAspectRatio(idc int) (w, h uint) {
+	if idc < len(sampleAspectRatios) {
+		return sampleAspectRatios[idc][0], sampleAspectRatios[idc][1]
+	}
+	return 0, 0
+}
+
+// ParseSPS parses the seq_parameter_set_rbsp of Section 7.3.2.1.1 and
+// the timing information of the VUI.
+func ParseSPS(nal []byte) (SPS, error) {
+	if NALType(nal) != NALSPS {
+		return SPS{}, fmt.Errorf("h264: invalid SPS NAL unit type: %d", NALType(nal))
+	}
+	r := bitstream.NewReader(annexb.Unescape(nal[1:]))
+	var s SPS
+	s.ProfileIDC = uint8(r.ReadBits(8))
+	s.ConstraintFlags = uint8(r.ReadBits(8))
+	s.LevelIDC = uint8(r.ReadBits(8))
+	s.ID = uint(r.ReadUE())
+	s.ChromaFormatIDC = 1
+	s.BitDepthLuma, s.BitDepthChroma = 8, 8
+	if HighProfile(s.ProfileIDC) {
+		s.ChromaFormatIDC = uint(r.ReadUE())
+		if s.ChromaFormatIDC == 3 {
+			s.SeparateColorPlane = r.ReadFlag()
+		}
+		s.BitDepthLuma = uint(r.ReadUE()) + 8
+		s.BitDepthChroma = uint(r.ReadUE()) + 8
+		r.Skip(1)         // qpprime_y_zero_transform_bypass_flag
+		if r.ReadFlag() { // seq_scaling_matrix_present_flag
+			n := 8
+			if s.ChromaFormatIDC == 3 {
+				n = 12
+			}
+			for i := range n {
+				if !r.ReadFlag() { // seq_scaling_list_present_flag
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				skipScalingList(r, size)
+			}
+		}
+	}
+	s.Log2MaxFrameNum = uint(r.ReadUE()) + 4
+	s.PicOrderCntType = uint(r.ReadUE())
+	switch s.PicOrderCntType {
+	case 0:
+		s.Log2MaxPicOrderCntLsb = uint(r.ReadUE()) + 4
+	case 1:
+		r.Skip(1)  // delta_pic_order_always_zero_flag
+		r.ReadSE() // offset_for_non_ref_pic
+		r.ReadSE() // offset_for_top_to_bottom_field
+		n := r.ReadUE()
+		for i := uint64(0); i < n && r.Err() == nil; i++ {
+			r.ReadSE() // offset_for_ref_frame
+		}
+	}
+	r.ReadUE() // max_num_ref_frames
+	r.Skip(1)  // gaps_in_frame_num_value_allowed_flag
+	widthInMbs := uint(r.ReadUE()) + 1
+	heightInMapUnits := uint(r.ReadUE()) + 1
+	s.FrameMbsOnly = r.ReadFlag()
+	if !s.FrameMbsOnly {
+		r.Skip(1) // mb_adaptive_frame_field_flag
+	}
+	r.Skip(1) // direct_8x8_inference_flag
+	frameHeightFactor := uint(2)
+	if s.FrameMbsOnly {
+		frameHeightFactor = 1
+	}
+	s.Width = widthInMbs * 16
+	s.Height = frameHeightFactor * heightInMapUnits * 16
+	if r.ReadFlag() { // frame_cropping_flag
+		cropUnitX, cropUnitY := uint(1), frameHeightFactor
+		if s.ChromaFormatIDC != 0 && !s.SeparateColorPlane {
+			if s.ChromaFormatIDC < 3 {
+				cropUnitX = 2
+			}
+			if s.ChromaFormatIDC == 1 {
+				cropUnitY *= 2
+			}
+		}
+		left, right := uint(r.ReadUE()), uint(r.ReadUE())
+		top, bottom := uint(r.ReadUE()), uint(r.ReadUE())
+		s.Width -= cropUnitX * (left + right)
+		s.Height -= cropUnitY * (top + bottom)
+	}
+	if r.ReadFlag() { // vui_parameters_present_flag
+		s.SARWidth, s.SARHeight = parseVUI(r, &s)
+	}
+	if err := r.Err(); err != nil {
+		return SPS{}, fmt.Errorf("h264: could not parse SPS: %w", err)
+	}
+	return s, nil
+}
+
+// HighProfile reports whether the SPS of a profile contains the chroma
+// format and the bit depth.
+func HighProfile(profileIDC uint8) bool {
+	switch profileIDC {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		return true
+	}
+	return false
+}
+
+func skipScalingList(r *bitstream.Reader, size int) {
+	last, next := int64(8), int64(8)
+	for range size {
+		if next != 0 {
+			next = (last + r.ReadSE() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// parseVUI parses the vui_parameters of Section E.1.1 up to the timing
+// information and returns the sample aspect ratio.
+func parseVUI(r *bitstream.Reader, s *SPS) (sarWidth, sarHeight uint) {
+	if r.ReadFlag() { // aspect_ratio_info_present_flag
+		idc := int(r.ReadBits(8))
+		if idc == 255 { // Extended_SAR
+			sarWidth, sarHeight = uint(r.ReadBits(16)), uint(r.ReadBits(16))
+		} else {
+			sarWidth, sarHeight = SampleAspectRatio(idc)
+		}
+	}
+	if r.ReadFlag() { // overscan_info_present_flag
+		r.Skip(1) // overscan_appropriate_flag
+	}
+	if r.ReadFlag() { // video_signal_type_present_flag
+		r.Skip(3)         // video_format
+		r.Skip(1)         // video_full_range_flag
+		if r.ReadFlag() { // colour_description_present_flag
+			r.Skip(24)
+		}
+	}
+	if r.ReadFlag() { // chroma_loc_info_present_flag
+		r.ReadUE()
+		r.ReadUE()
+	}
+	if r.ReadFlag() { // timing_info_present_flag
+		s.NumUnitsInTick = uint32(r.ReadBits(32))
+		s.TimeScale = uint32(r.ReadBits(32))
+	}
+	return sarWidth, sarHeight
+}
+
+// PPS is the beginning of a picture parameter set.
+type PPS struct {
+	ID    uint
+	SPSID uint
+}
+
+// ParsePPS parses the identifiers of the pic_parameter_set_rbsp of
+// Section 7.3.2.2.
+func ParsePPS(nal []byte) (PPS, error) {
+	if NALType(nal) != NALPPS {
+		return PPS{}, fmt.Errorf("h264: invalid PPS NAL unit type: %d", NALType(nal))
+	}
+	r := bitstream.NewReader(annexb.Unescape(nal[1:]))
+	p := PPS{ID: uint(r.ReadUE()), SPSID: uint(r.ReadUE())}
+	if err := r.Err(); err != nil {
+		return PPS{}, fmt.Errorf("h264: could not parse PPS: %w", err)
+	}
+	return p, nil
+}
+
+// SliceHeader is the beginning of a slice header.
+type SliceHeader struct {
+	FirstMbInSlice uint
+	SliceType      uint
+	PPSID          uint
+	FrameNum       uint
+	FieldPic       bool
+	BottomField    bool
+	// PicOrderCntLsb is only present when PicOrderCntType is 0.
+	PicOrderCntLsb uint
+}
+
+// ParseSliceHeader parses the slice_header of Section 7.3.3 up to
+// pic_order_cnt_lsb. The parameter sets are looked up by identifier.
+func ParseSliceHeader(nal []byte, sps map[uint]SPS, pps map[uint]PPS) (SliceHeader, error) {
+	if t := NALType(nal); t != NALSlice && t != NALIDR {
+		return SliceHeader{}, fmt.Errorf("h264: invalid slice NAL unit type: %d", t)
+	}
+	// The slice header is short, so only its beginning is unescaped.
+	r := bitstream.NewReader(annexb.Unescape(nal[1:min(len(nal), 64)]))
+	var h SliceHeader
+	h.FirstMbInSlice = uint(r.ReadUE())
+	h.SliceType = uint(r.ReadUE())
+	h.PPSID = uint(r.ReadUE())
+	if err := r.Err(); err != nil {
+		return SliceHeader{}, fmt.Errorf("h264: could not parse slice header: %w", err)
+	}
+	p, ok := pps[h.PPSID]
+	if !ok {
+		return SliceHeader{}, fmt.Errorf("h264: missing PPS %d", h.PPSID)
+	}
+	s, ok := sps[p.SPSID]
+	if !ok {
+		return SliceHeader{}, fmt.Errorf("h264: missing SPS %d", p.SPSID)
+	}
+	if s.SeparateColorPlane {
+		r.Skip(2) // colour_plane_id
+	}
+	h.FrameNum = uint(r.ReadBits(int(s.Log2MaxFrameNum)))
+	if !s.FrameMbsOnly {
+		h.FieldPic = r.ReadFlag()
+		if h.FieldPic {
+			h.BottomField = r.ReadFlag()
+		}
+	}
+	if NALType(nal) == NALIDR {
+		r.ReadUE() // idr_pic_id
+	}
+	if s.PicOrderCntType == 0 {
+		h.PicOrderCntLsb = uint(r.ReadBits(int(s.Log2MaxPicOrderCntLsb)))
+	}
+	if err := r.Err(); err != nil {
+		return SliceHeader{}, fmt.Errorf("h264: could not parse slice header: %w", err)
+	}
+	return h, nil
+}
+
+// DecoderConfigurationRecord returns the AVCDecoderConfigurationRecord
+// of ISO/IEC 14496-15 with 4-octet NAL unit lengths.
+func DecoderConfigurationRecord(sps, pps [][]byte) ([]byte, error) {
+	if len(sps) == 0 || len(pps) == 0 {
+		return nil, errors.New("h264: decoder configuration requires an SPS and a PPS")
+	}
+	s, err := ParseSPS(sps[0])
+	if err != nil {
+		return nil, err
+	}
+	b := []byte{1, s.ProfileIDC, s.ConstraintFlags, s.LevelIDC, 0xFC | 3, 0xE0 | byte(len(sps))}
+	for _, nal := range sps {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(nal)))
+		b = append(b, nal...)
+	}
+	b = append(b, byte(len(pps)))
+	for _, nal := range pps {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(nal)))
+		b = append(b, nal...)
+	}
+	switch s.ProfileIDC {
+	case 66, 77, 88:
+	default:
+		b = append(b, 0xFC|byte(s.ChromaFormatIDC), 0xF8|byte(s.BitDepthLuma-8), 0xF8|byte(s.BitDepthChroma-8), 0)
+	}
+	return b, nil
+}
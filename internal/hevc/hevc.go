@@ -0,0 +1,427 @@
+// Package hevc implements the parsing of the HEVC syntax structures
+// needed to store an Annex B byte stream in a container.
+// See: https://www.itu.int/rec/T-REC-H.265
+package hevc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/coding-socks/matroska/internal/annexb"
+	"github.com/coding-socks/matroska/internal/bitstream"
+	"github.com/coding-socks/matroska/internal/h264"
+)
+
+// NAL unit types of Table 7-1.
+const (
+	NALRADL_N      = 6
+	NALRADL_R      = 7
+	NALRASL_N      = 8
+	NALRASL_R      = 9
+	NALBLA_W_LP    = 16
+	NALBLA_N_LP    = 18
+	NALIDR_W_RADL  = 19
+	NALIDR_N_LP    = 20
+	NALCRA         = 21
+	NALVPS         = 32
+	NALSPS         = 33
+	NALPPS         = 34
+	NALAUD         = 35
+	NALEndOfSeq    = 36
+	NALEndOfStream = 37
+	NALFiller      = 38
+	NALPrefixSEI   = 39
+	NALSuffixSEI   = 40
+)
+
+// NALType returns the nal_unit_type of a NAL unit.
+func NALType(nal []byte) int {
+	if len(nal) == 0 {
+		return 0
+	}
+	return int(nal[0]>>1) & 0x3F
+}
+
+// TemporalID returns the TemporalId of a NAL unit.
+func TemporalID(nal []byte) int {
+	if len(nal) < 2 {
+		return 0
+	}
+	return int(nal[1]&0x07) - 1
+}
+
+// IsVCL reports whether a NAL unit type is a VCL NAL unit type.
+func IsVCL(t int) bool {
+	return t < 32
+}
+
+// IsIRAP reports whether a NAL unit type is an intra random access
+// point picture.
+func IsIRAP(t int) bool {
+	return t >= NALBLA_W_LP && t <= 23
+}
+
+// IsIDR reports whether a NAL unit type is an IDR picture.
+func IsIDR(t int) bool {
+	return t == NALIDR_W_RADL || t == NALIDR_N_LP
+}
+
+// IsSubLayerNonReference reports whether a NAL unit type is a sub-layer
+// non-reference picture.
+func IsSubLayerNonReference(t int) bool {
+	return t <= 14 && t%2 == 0
+}
+
+// SPS is a sequence parameter set.
+type SPS struct {
+	ID                 uint
+	MaxSubLayers       uint
+	TemporalIDNesting  bool
+	ChromaFormatIDC    uint
+	SeparateColorPlane bool
+	BitDepthLuma       uint
+	BitDepthChroma     uint
+
+	// ProfileTierLevel is the general profile, tier and level of the
+	// profile_tier_level structure: general_profile_space,
+	// general_tier_flag, general_profile_idc, the 32
+	// general_profile_compatibility_flags, the 48 bits of constraint
+	// flags and general_level_idc.
+	ProfileTierLevel [12]byte
+
+	Log2MaxPicOrderCntLsb uint
+
+	// Width and Height are the dimensions of the cropped picture.
+	Width, Height uint
+	// SARWidth and SARHeight are the sample aspect ratio. They are 0
+	// when it is unspecified.
+	SARWidth, SARHeight uint
+	// NumUnitsInTick and TimeScale are the timing information of the
+	// VUI. A picture lasts NumUnitsInTick/TimeScale seconds. They are 0
+	// when the timing information is not present.
+	NumUnitsInTick, TimeScale uint32
+}
+
+// ParseSPS parses the seq_parameter_set_rbsp of Section 7.3.2.2.1 and
+// the timing information of the VUI.
+func ParseSPS(nal []byte) (SPS, error) {
+	if NALType(nal) != NALSPS || len(nal) < 2 {
+		return SPS{}, fmt.Errorf("hevc: invalid SPS NAL unit type: %d", NALType(nal))
+	}
+	rbsp := annexb.Unescape(nal[2:])
+	r := bitstream.NewReader(rbsp)
+	var s SPS
+	r.Skip(4) // sps_video_parameter_set_id
+	s.MaxSubLayers = uint(r.ReadBits(3)) + 1
+	s.TemporalIDNesting = r.ReadFlag()
+	// The general part of profile_tier_level is octet aligned.
+	if len(rbsp) < 13 {
+		return SPS{}, fmt.Errorf("hevc: could not parse SPS: %w", errors.New("short profile_tier_level"))
+	}
+	copy(s.ProfileTierLevel[:], rbsp[1:13])
+	r.Skip(96)
+	subLayerProfile := make([]bool, s.MaxSubLayers-1)
+	subLayerLevel := make([]bool, s.MaxSubLayers-1)
+	for i := range subLayerProfile {
+		subLayerProfile[i] = r.ReadFlag()
+		subLayerLevel[i] = r.ReadFlag()
+	}
+	if s.MaxSubLayers > 1 {
+		r.Skip(2 * (9 - int(s.MaxSubLayers))) // reserved_zero_2bits
+	}
+	for i := range subLayerProfile {
+		if subLayerProfile[i] {
+			r.Skip(88)
+		}
+		if subLayerLevel[i] {
+			r.Skip(8)
+		}
+	}
+	s.ID = uint(r.ReadUE())
+	s.ChromaFormatIDC = uint(r.ReadUE())
+	if s.ChromaFormatIDC == 3 {
+		s.SeparateColorPlane = r.ReadFlag()
+	}
+	s.Width = uint(r.ReadUE())
+	s.Height = uint(r.ReadUE())
+	if r.ReadFlag() { // conformance_window_flag
+		subWidth, subHeight := uint(1), uint(1)
+		if !s.SeparateColorPlane {
+			if s.ChromaFormatIDC == 1 || s.ChromaFormatIDC == 2 {
+				subWidth = 2
+			}
+			if s.ChromaFormatIDC == 1 {
+				subHeight = 2
+			}
+		}
+		left, right := uint(r.ReadUE()), uint(r.ReadUE())
+		top, bottom := uint(r.ReadUE()), uint(r.ReadUE())
+		s.Width -= subWidth * (left + right)
+		s.Height -= subHeight * (top + bottom)
+	}
+	s.BitDepthLuma = uint(r.ReadUE()) + 8
+	s.BitDepthChroma = uint(r.ReadUE()) + 8
+	s.Log2MaxPicOrderCntLsb = uint(r.ReadUE()) + 4
+	start := s.MaxSubLayers - 1
+	if r.ReadFlag() { // sps_sub_layer_ordering_info_present_flag
+		start = 0
+	}
+	for i := start; i < s.MaxSubLayers; i++ {
+		r.ReadUE() // sps_max_dec_pic_buffering_minus1
+		r.ReadUE() // sps_max_num_reorder_pics
+		r.ReadUE() // sps_max_latency_increase_plus1
+	}
+	r.ReadUE() // log2_min_luma_coding_block_size_minus3
+	r.ReadUE() // log2_diff_max_min_luma_coding_block_size
+	r.ReadUE() // log2_min_luma_transform_block_size_minus2
+	r.ReadUE() // log2_diff_max_min_luma_transform_block_size
+	r.ReadUE() // max_transform_hierarchy_depth_inter
+	r.ReadUE() // max_transform_hierarchy_depth_intra
+
+	if r.ReadFlag() { // scaling_list_enabled_flag
+		if r.ReadFlag() { // sps_scaling_list_data_present_flag
+			skipScalingListData(r)
+		}
+	}
+	r.Skip(2) // amp_enabled_flag, sample_adaptive_offset_enabled_flag
+
+	if r.ReadFlag() { // pcm_enabled_flag
+		r.Skip(8)
+		r.ReadUE()
+		r.ReadUE()
+		r.Skip(1)
+	}
+	sets := int(r.ReadUE()) // num_short_term_ref_pic_sets
+	if sets > 64 {
+		return SPS{}, fmt.Errorf("hevc: invalid num_short_term_ref_pic_sets: %d", sets)
+	}
+	numDeltaPocs := make([]int, sets)
+	for i := range sets {
+		numDeltaPocs[i] = parseShortTermRefPicSet(r, i, numDeltaPocs)
+	}
+	if r.ReadFlag() { // long_term_ref_pics_present_flag
+		n := r.ReadUE()
+		for j := uint64(0); j < n && r.Err() == nil; j++ {
+			r.Skip(int(s.Log2MaxPicOrderCntLsb) + 1)
+		}
+	}
+	r.Skip(2) // sps_temporal_mvp_enabled_flag, strong_intra_smoothing_enabled_flag
+
+	if r.ReadFlag() { // vui_parameters_present_flag
+		parseVUI(r, &s)
+	}
+	if err := r.Err(); err != nil {
+		return SPS{}, fmt.Errorf("hevc: could not parse SPS: %w", err)
+	}
+	return s, nil
+}
+
+func skipScalingListData(r *bitstream.Reader) {
+	for sizeID := range 4 {
+		step := 1
+		if sizeID == 3 {
+			step = 3
+		}
+		for matrixID := 0; matrixID < 6; matrixID += step {
+			if !r.ReadFlag() { // scaling_list_pred_mode_flag
+				r.ReadUE() // scaling_list_pred_matrix_id_delta
+				continue
+			}
+			coefs := min(64, 1<<(4+(sizeID<<1)))
+			if sizeID > 1 {
+				r.ReadSE() // scaling_list_dc_coef_minus8
+			}
+			for range coefs {
+				r.ReadSE() // scaling_list_delta_coef
+			}
+		}
+	}
+}
+
+// parseShortTermRefPicSet parses the st_ref_pic_set of Section 7.3.7
+// of a sequence parameter set and returns its NumDeltaPocs.
+func parseShortTermRefPicSet(r *bitstream.Reader, idx int, numDeltaPocs []int) int {
+	if idx != 0 && r.ReadFlag() { // inter_ref_pic_set_prediction_flag
+		r.Skip(1)  // delta_rps_sign
+		r.ReadUE() // abs_delta_rps_minus1
+		n := 0
+		for j := 0; j <= numDeltaPocs[idx-1] && r.Err() == nil; j++ {
+			used := r.ReadFlag() // used_by_curr_pic_flag
+			if used || r.ReadFlag() /* use_delta_flag */ {
+				n++
+			}
+		}
+		return n
+	}
+	negative := int(r.ReadUE())
+	positive := int(r.ReadUE())
+	for i := 0; i < negative+positive && r.Err() == nil; i++ {
+		r.ReadUE() // delta_poc_s0_minus1 or delta_poc_s1_minus1
+		r.Skip(1)  // used_by_curr_pic_s0_flag or used_by_curr_pic_s1_flag
+	}
+	return negative + positive
+}
+
+// parseVUI parses the vui_parameters of Section E.2.1 up to the timing
+// information.
+func parseVUI(r *bitstream.Reader, s *SPS) {
+	if r.ReadFlag() { // aspect_ratio_info_present_flag
+		idc := int(r.ReadBits(8))
+		if idc == 255 { // EXTENDED_SAR
+			s.SARWidth, s.SARHeight = uint(r.ReadBits(16)), uint(r.ReadBits(16))
+		} else {
+			s.SARWidth, s.SARHeight = h264.SampleAspectRatio(idc)
+		}
+	}
+	if r.ReadFlag() { // overscan_info_present_flag
+		r.Skip(1) // overscan_appropriate_flag
+	}
+	if r.ReadFlag() { // video_signal_type_present_flag
+		r.Skip(3)         // video_format
+		r.Skip(1)         // video_full_range_flag
+		if r.ReadFlag() { // colour_description_present_flag
+			r.Skip(24)
+		}
+	}
+	if r.ReadFlag() { // chroma_loc_info_present_flag
+		r.ReadUE()
+		r.ReadUE()
+	}
+	r.Skip(3) // neutral_chroma_indication_flag, field_seq_flag, frame_field_info_present_flag
+
+	if r.ReadFlag() { // default_display_window_flag
+		r.ReadUE()
+		r.ReadUE()
+		r.ReadUE()
+		r.ReadUE()
+	}
+	if r.ReadFlag() { // vui_timing_info_present_flag
+		s.NumUnitsInTick = uint32(r.ReadBits(32))
+		s.TimeScale = uint32(r.ReadBits(32))
+	}
+}
+
+// PPS is the beginning of a picture parameter set.
+type PPS struct {
+	ID                            uint
+	SPSID                         uint
+	DependentSliceSegmentsEnabled bool
+	OutputFlagPresent             bool
+	NumExtraSliceHeaderBits       uint
+}
+
+// ParsePPS parses the pic_parameter_set_rbsp of Section 7.3.2.3.1 up
+// to num_extra_slice_header_bits.
+func ParsePPS(nal []byte) (PPS, error) {
+	if NALType(nal) != NALPPS || len(nal) < 2 {
+		return PPS{}, fmt.Errorf("hevc: invalid PPS NAL unit type: %d", NALType(nal))
+	}
+	r := bitstream.NewReader(annexb.Unescape(nal[2:]))
+	p := PPS{
+		ID:                            uint(r.ReadUE()),
+		SPSID:                         uint(r.ReadUE()),
+		DependentSliceSegmentsEnabled: r.ReadFlag(),
+		OutputFlagPresent:             r.ReadFlag(),
+		NumExtraSliceHeaderBits:       uint(r.ReadBits(3)),
+	}
+	if err := r.Err(); err != nil {
+		return PPS{}, fmt.Errorf("hevc: could not parse PPS: %w", err)
+	}
+	return p, nil
+}
+
+// SliceHeader is the beginning of a slice segment header.
+type SliceHeader struct {
+	FirstSliceSegmentInPic bool
+	PPSID                  uint
+	// SliceType and PicOrderCntLsb are only parsed for the first slice
+	// segment of a picture. PicOrderCntLsb is 0 for IDR pictures.
+	SliceType      uint
+	PicOrderCntLsb uint
+}
+
+// ParseSliceHeader parses the slice_segment_header of Section 7.3.6.1
+// up to slice_pic_order_cnt_lsb. The parameter sets are looked up by
+// identifier.
+func ParseSliceHeader(nal []byte, sps map[uint]SPS, pps map[uint]PPS) (SliceHeader, error) {
+	t := NALType(nal)
+	if !IsVCL(t) || len(nal) < 3 {
+		return SliceHeader{}, fmt.Errorf("hevc: invalid slice NAL unit type: %d", t)
+	}
+	// The slice header is short, so only its beginning is unescaped.
+	r := bitstream.NewReader(annexb.Unescape(nal[2:min(len(nal), 64)]))
+	var h SliceHeader
+	h.FirstSliceSegmentInPic = r.ReadFlag()
+	if IsIRAP(t) {
+		r.Skip(1) // no_output_of_prior_pics_flag
+	}
+	h.PPSID = uint(r.ReadUE())
+	if err := r.Err(); err != nil {
+		return SliceHeader{}, fmt.Errorf("hevc: could not parse slice header: %w", err)
+	}
+	if !h.FirstSliceSegmentInPic {
+		return h, nil
+	}
+	p, ok := pps[h.PPSID]
+	if !ok {
+		return SliceHeader{}, fmt.Errorf("hevc: missing PPS %d", h.PPSID)
+	}
+	s, ok := sps[p.SPSID]
+	if !ok {
+		return SliceHeader{}, fmt.Errorf("hevc: missing SPS %d", p.SPSID)
+	}
+	r.Skip(int(p.NumExtraSliceHeaderBits)) // slice_reserved_flag
+	h.SliceType = uint(r.ReadUE())
+	if p.OutputFlagPresent {
+		r.Skip(1) // pic_output_flag
+	}
+	if s.SeparateColorPlane {
+		r.Skip(2) // colour_plane_id
+	}
+	if !IsIDR(t) {
+		h.PicOrderCntLsb = uint(r.ReadBits(int(s.Log2MaxPicOrderCntLsb)))
+	}
+	if err := r.Err(); err != nil {
+		return SliceHeader{}, fmt.Errorf("hevc: could not parse slice header: %w", err)
+	}
+	return h, nil
+}
+
+// DecoderConfigurationRecord returns the HEVCDecoderConfigurationRecord
+// of ISO/IEC 14496-15 with 4-octet NAL unit lengths.
+func DecoderConfigurationRecord(vps, sps, pps [][]byte) ([]byte, error) {
+	if len(vps) == 0 || len(sps) == 0 || len(pps) == 0 {
+		return nil, errors.New("hevc: decoder configuration requires a VPS, an SPS and a PPS")
+	}
+	s, err := ParseSPS(sps[0])
+	if err != nil {
+		return nil, err
+	}
+	b := []byte{1}
+	b = append(b, s.ProfileTierLevel[:]...)
+	b = append(b,
+		0xF0, 0x00, // min_spatial_segmentation_idc
+		0xFC, // parallelismType
+		0xFC|byte(s.ChromaFormatIDC),
+		0xF8|byte(s.BitDepthLuma-8),
+		0xF8|byte(s.BitDepthChroma-8),
+		0, 0, // avgFrameRate
+	)
+	var nesting byte
+	if s.TemporalIDNesting {
+		nesting = 1
+	}
+	// constantFrameRate, numTemporalLayers, temporalIdNested and
+	// lengthSizeMinusOne
+	b = append(b, byte(s.MaxSubLayers)<<3|nesting<<2|3)
+	b = append(b, 3)
+	for i, nals := range [][][]byte{vps, sps, pps} {
+		b = append(b, 0x80|byte(NALVPS+i))
+		b = binary.BigEndian.AppendUint16(b, uint16(len(nals)))
+		for _, nal := range nals {
+			b = binary.BigEndian.AppendUint16(b, uint16(len(nal)))
+			b = append(b, nal...)
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,81 @@
+// Package ivf implements a reader of the IVF files written by libvpx
+// and libaom.
+package ivf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var signature = []byte("DKIF")
+
+// Header is the file header of an IVF file.
+type Header struct {
+	FourCC [4]byte
+	Width  uint16
+	Height uint16
+	// The timestamps of the frames count TimebaseNum/TimebaseDen
+	// seconds.
+	TimebaseDen uint32
+	TimebaseNum uint32
+	Frames      uint32
+}
+
+// Frame is a frame of an IVF file.
+type Frame struct {
+	Timestamp uint64
+	Data      []byte
+}
+
+// Reader reads the frames of an IVF file.
+type Reader struct {
+	r      io.Reader
+	Header Header
+}
+
+// NewReader reads the file header of an IVF file.
+func NewReader(r io.Reader) (*Reader, error) {
+	var b [32]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return nil, fmt.Errorf("ivf: could not read file header: %w", err)
+	}
+	if string(b[0:4]) != string(signature) {
+		return nil, fmt.Errorf("ivf: invalid signature: %q", b[0:4])
+	}
+	size := binary.LittleEndian.Uint16(b[6:8])
+	if size < 32 {
+		return nil, fmt.Errorf("ivf: invalid header size: %d", size)
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(size)-32); err != nil {
+		return nil, fmt.Errorf("ivf: could not read file header: %w", err)
+	}
+	ir := &Reader{r: r}
+	copy(ir.Header.FourCC[:], b[8:12])
+	ir.Header.Width = binary.LittleEndian.Uint16(b[12:14])
+	ir.Header.Height = binary.LittleEndian.Uint16(b[14:16])
+	ir.Header.TimebaseDen = binary.LittleEndian.Uint32(b[16:20])
+	ir.Header.TimebaseNum = binary.LittleEndian.Uint32(b[20:24])
+	ir.Header.Frames = binary.LittleEndian.Uint32(b[24:28])
+	return ir, nil
+}
+
+// ReadFrame returns the next frame. It returns io.EOF when there are no
+// more frames.
+func (r *Reader) ReadFrame() (Frame, error) {
+	var b [12]byte
+	if _, err := io.ReadFull(r.r, b[:]); err == io.EOF {
+		return Frame{}, io.EOF
+	} else if err != nil {
+		return Frame{}, fmt.Errorf("ivf: could not read frame header: %w", err)
+	}
+	f := Frame{
+		Timestamp: binary.LittleEndian.Uint64(b[4:12]),
+		Data:      make([]byte, binary.LittleEndian.Uint32(b[0:4])),
+	}
+	if _, err := io.ReadFull(r.r, f.Data); err != nil {
+		return Frame{}, fmt.Errorf("ivf: could not read frame: %w", errors.Join(err, io.ErrUnexpectedEOF))
+	}
+	return f, nil
+}
@@ -0,0 +1,123 @@
+package ogg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Packet is a packet of a logical bitstream reassembled from the
+// segments of one or more pages.
+type Packet struct {
+	SerialNum uint32
+	Data      []byte
+	// GranulePosition is the granule position of the page on which the
+	// packet ends when it is the last packet finished on that page.
+	// Otherwise, it is -1.
+	GranulePosition int64
+	// BOS is set for packets of the first page of a logical bitstream.
+	BOS bool
+	// EOS is set for the last packet of the last page of a logical
+	// bitstream.
+	EOS bool
+}
+
+// PacketReader reads the packets of a physical bitstream in the order
+// they finish. Multiplexed logical bitstreams are supported.
+type PacketReader struct {
+	r      io.Reader
+	header [27 + 255]byte
+
+	partial map[uint32][]byte
+	packets []Packet
+}
+
+func NewPacketReader(r io.Reader) *PacketReader {
+	return &PacketReader{r: r, partial: make(map[uint32][]byte)}
+}
+
+// ReadPacket returns the next packet. It returns io.EOF when there are
+// no more pages. Packets left unfinished at the end are dropped.
+func (r *PacketReader) ReadPacket() (Packet, error) {
+	for len(r.packets) == 0 {
+		if err := r.readPage(); err != nil {
+			return Packet{}, err
+		}
+	}
+	p := r.packets[0]
+	r.packets = r.packets[1:]
+	return p, nil
+}
+
+func (r *PacketReader) readPage() error {
+	b := r.header[:27]
+	if _, err := io.ReadFull(r.r, b); err == io.EOF {
+		return io.EOF
+	} else if err != nil {
+		return fmt.Errorf("ogg: could not read page header: %w", err)
+	}
+	if string(b[0:4]) != string(magicNumber) {
+		return fmt.Errorf("ogg: invalid magic number: %q", b[0:4])
+	}
+	if b[4] != Version {
+		return fmt.Errorf("ogg: invalid version: %d", b[4])
+	}
+	headerType := b[5]
+	granule := int64(binary.LittleEndian.Uint64(b[6:14]))
+	serial := binary.LittleEndian.Uint32(b[14:18])
+	checksum := binary.LittleEndian.Uint32(b[22:26])
+	lacing := r.header[27 : 27+int(b[26])]
+	if _, err := io.ReadFull(r.r, lacing); err != nil {
+		return fmt.Errorf("ogg: could not read segment table: %w", errors.Join(err, io.ErrUnexpectedEOF))
+	}
+	size := 0
+	for _, l := range lacing {
+		size += int(l)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return fmt.Errorf("ogg: could not read page data: %w", errors.Join(err, io.ErrUnexpectedEOF))
+	}
+	clear(r.header[22:26])
+	crc := update(0, crcTable, r.header[:27+len(lacing)])
+	if update(crc, crcTable, data) != checksum {
+		return fmt.Errorf("ogg: invalid CRC32 checksum: %x", checksum)
+	}
+
+	var packet []byte
+	keep := true
+	if headerType&HeaderTypeContinuedPacket != 0 {
+		// The beginning of the packet is lost when it was on a page
+		// which was not read.
+		packet, keep = r.partial[serial]
+	}
+	// A packet which does not continue on this page is lost.
+	delete(r.partial, serial)
+	first := len(r.packets)
+	start, end := 0, 0
+	for _, l := range lacing {
+		end += int(l)
+		if l == 255 {
+			continue
+		}
+		if keep {
+			r.packets = append(r.packets, Packet{
+				SerialNum:       serial,
+				Data:            append(packet, data[start:end]...),
+				GranulePosition: -1,
+				BOS:             headerType&HeaderTypeFirstPage != 0,
+			})
+		}
+		packet, keep = nil, true
+		start = end
+	}
+	if len(lacing) > 0 && lacing[len(lacing)-1] == 255 && keep {
+		r.partial[serial] = append(packet, data[start:]...)
+	}
+	if n := len(r.packets); n > first {
+		r.packets[n-1].GranulePosition = granule
+		r.packets[n-1].EOS = headerType&HeaderTypeLastPage != 0
+	}
+	return nil
+}
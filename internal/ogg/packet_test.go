@@ -0,0 +1,66 @@
+package ogg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// page returns an Ogg page with the given segment table.
+func page(headerType byte, granule int64, serial uint32, lacing []byte, data []byte) []byte {
+	b := make([]byte, 27, 27+len(lacing)+len(data))
+	copy(b, magicNumber)
+	b[5] = headerType
+	binary.LittleEndian.PutUint64(b[6:14], uint64(granule))
+	binary.LittleEndian.PutUint32(b[14:18], serial)
+	b[26] = byte(len(lacing))
+	b = append(b, lacing...)
+	b = append(b, data...)
+	binary.LittleEndian.PutUint32(b[22:26], CRC32Checksum(b))
+	return b
+}
+
+func TestPacketReader(t *testing.T) {
+	long := bytes.Repeat([]byte{'x'}, 300)
+	var buf bytes.Buffer
+	buf.Write(page(HeaderTypeFirstPage, 0, 1, []byte{3}, []byte("bos")))
+	buf.Write(page(HeaderTypeFirstPage, 0, 2, []byte{4}, []byte("bos2")))
+	// The second packet of serial 1 continues on the next page.
+	buf.Write(page(0, -1, 1, []byte{1, 255}, append([]byte("a"), long[:255]...)))
+	buf.Write(page(0, 10, 2, []byte{1}, []byte("b")))
+	buf.Write(page(HeaderTypeContinuedPacket|HeaderTypeLastPage, 20, 1, []byte{45, 1}, append(long[255:], 'c')))
+	// A continued page without its beginning.
+	buf.Write(page(HeaderTypeContinuedPacket|HeaderTypeLastPage, 30, 2, []byte{2, 1}, []byte("zzd")))
+
+	type packet struct {
+		serial  uint32
+		data    string
+		granule int64
+		bos     bool
+		eos     bool
+	}
+	want := []packet{
+		{1, "bos", 0, true, false},
+		{2, "bos2", 0, true, false},
+		{1, "a", -1, false, false},
+		{2, "b", 10, false, false},
+		{1, string(long), -1, false, false},
+		{1, "c", 20, false, true},
+		{2, "d", 30, false, true},
+	}
+	r := NewPacketReader(&buf)
+	for i, w := range want {
+		p, err := r.ReadPacket()
+		if err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		got := packet{p.SerialNum, string(p.Data), p.GranulePosition, p.BOS, p.EOS}
+		if got != w {
+			t.Errorf("packet %d = %+v, want %+v", i, got, w)
+		}
+	}
+	if _, err := r.ReadPacket(); err != io.EOF {
+		t.Errorf("ReadPacket() error = %v, want io.EOF", err)
+	}
+}
@@ -24,6 +24,8 @@ var (
 	errStaleReader         = errors.New("riff: stale reader")
 	errStaleWriter         = errors.New("riff: stale writer")
 	errInvalidOffset       = errors.New("riff: invalid offset")
+	errMissingDS64         = errors.New("riff: missing ds64 chunk")
+	errInvalidDS64         = errors.New("riff: invalid ds64 chunk")
 )
 
 // FourCC is a four character code.
@@ -33,6 +35,8 @@ var (
 	// LIST is the "LIST" FourCC.
 	LIST = FourCC{'L', 'I', 'S', 'T'}
 	JUNK = FourCC{'J', 'U', 'N', 'K'}
+	// DS64 is the first chunk of an RF64 file.
+	DS64 = FourCC{'d', 's', '6', '4'}
 )
 
 // NewReader returns the initial RIFF list reader as a *Reader.
@@ -44,10 +48,50 @@ func NewReader(r io.Reader) (FourCC, *Reader, error) {
 		}
 		return FourCC{}, nil, err
 	}
-	if buf[0] != 'R' || buf[1] != 'I' || buf[2] != 'F' || buf[3] != 'F' {
-		return FourCC{}, nil, ErrMissingRIFFMagicNumber
+	switch string(buf[:4]) {
+	case "RIFF":
+		return NewListReader(binary.LittleEndian.Uint32(buf[4:]), r)
+	case "RF64", "BW64":
+		return newRF64Reader(r)
 	}
-	return NewListReader(binary.LittleEndian.Uint32(buf[4:]), r)
+	return FourCC{}, nil, ErrMissingRIFFMagicNumber
+}
+
+// newRF64Reader returns the list reader of an RF64 file, which is a
+// RIFF file whose sizes above 4 GiB are stored in the ds64 chunk.
+//
+// See: https://tech.ebu.ch/docs/tech/tech3306v1_1.pdf
+func newRF64Reader(r io.Reader) (FourCC, *Reader, error) {
+	fileType, lr, err := NewListReader(math.MaxUint32, r)
+	if err != nil {
+		return FourCC{}, nil, err
+	}
+	id, l, cr, err := lr.Next()
+	if err != nil {
+		return FourCC{}, nil, err
+	}
+	if id != DS64 || l < 28 {
+		return FourCC{}, nil, errMissingDS64
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(cr, b); err != nil {
+		return FourCC{}, nil, errors.Join(io.ErrUnexpectedEOF, errShortChunk)
+	}
+	// The RIFF size includes the list type, the ds64 chunk and its
+	// padding.
+	riffSize := binary.LittleEndian.Uint64(b[0:8])
+	consumed := uint64(4+8+l) + uint64(l&1)
+	if riffSize < consumed || riffSize-consumed > math.MaxInt64 {
+		return FourCC{}, nil, errInvalidDS64
+	}
+	lr.r.N = int64(riffSize - consumed)
+	lr.sizes = map[FourCC]uint64{{'d', 'a', 't', 'a'}: binary.LittleEndian.Uint64(b[8:16])}
+	table := b[28:]
+	for n := binary.LittleEndian.Uint32(b[24:28]); n > 0 && len(table) >= 12; n-- {
+		lr.sizes[FourCC(table[0:4])] = binary.LittleEndian.Uint64(table[4:12])
+		table = table[12:]
+	}
+	return fileType, lr, nil
 }
 
 // NewListReader returns a LIST list reader as a *Reader.
@@ -75,6 +119,9 @@ type Reader struct {
 	len    uint32
 	padded bool
 	chunk  *ChunkReader
+	// sizes are the sizes of the chunks of an RF64 file whose size is
+	// 0xFFFFFFFF.
+	sizes map[FourCC]uint64
 }
 
 type ChunkReader struct {
@@ -92,6 +139,9 @@ func (c *ChunkReader) Read(p []byte) (n int, err error) {
 // Next returns the next chunk. The io.Reader of the element returned becomes
 // stale after the next Next call, and should no longer be used.
 //
+// The length of a chunk of an RF64 file which is larger than 4 GiB is
+// 0xFFFFFFFF, and its reader returns all of its data.
+//
 // When Next encounters io.EOF or end of the chunk, it returns io.EOF.
 func (r *Reader) Next() (FourCC, uint32, *ChunkReader, error) {
 	if r.err != nil {
@@ -123,8 +173,12 @@ func (r *Reader) Next() (FourCC, uint32, *ChunkReader, error) {
 		r.err = errListSubchunkTooLong
 		return FourCC{}, 0, nil, r.err
 	}
-	r.padded = l&1 == 1
-	cr := ChunkReader{listr: r, r: &io.LimitedReader{R: r.r, N: int64(l)}}
+	n := uint64(l)
+	if size, ok := r.sizes[id]; ok && l == math.MaxUint32 {
+		n = min(size, math.MaxInt64)
+	}
+	r.padded = n&1 == 1
+	cr := ChunkReader{listr: r, r: &io.LimitedReader{R: r.r, N: int64(n)}}
 	r.chunk = &cr
 	return id, l, &cr, nil
 }
@@ -2,6 +2,7 @@ package riff
 
 import (
 	"bytes"
+	"encoding/binary"
 	"io"
 	"testing"
 )
@@ -159,3 +160,40 @@ func (b *bytesWriterAt) WriteAt(p []byte, off int64) (n int, err error) {
 	copy(b.buf[off:off+int64(len(p))], p)
 	return len(p), nil
 }
+
+func TestRF64(t *testing.T) {
+	var b []byte
+	b = append(b, "RF64\xFF\xFF\xFF\xFFTEST"...)
+	b = append(b, "ds64\x1C\x00\x00\x00"...)
+	b = binary.LittleEndian.AppendUint64(b, 4+8+28+8+5+1+8+2)
+	b = binary.LittleEndian.AppendUint64(b, 5)
+	b = binary.LittleEndian.AppendUint64(b, 0)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	b = append(b, "data\xFF\xFF\xFF\xFFHello\x00"...)
+	b = append(b, "ck01\x02\x00\x00\x00hi"...)
+	b = append(b, "trailing garbage"...)
+
+	id, lr, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := id, (FourCC{'T', 'E', 'S', 'T'}); got != want {
+		t.Errorf("NewReader() got %v, want %v", got, want)
+	}
+	for _, want := range []string{"Hello", "hi"} {
+		_, _, r, err := lr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("Read() got %s, want %s", got, want)
+		}
+	}
+	if _, _, _, err := lr.Next(); err != io.EOF {
+		t.Errorf("Next() got %v, want EOF", err)
+	}
+}
@@ -39,7 +39,7 @@ func ParseIdentificationHeader(b [30]byte) (IdentificationHeader, error) {
 	h.Blocksize0 = 1 << (b[28] & 0x0F)
 	h.Blocksize1 = 1 << (b[28] >> 4)
 	if b[29] != 1 {
-		return IdentificationHeader{}, fmt.Errorf("vorbis: invalid header framing: %s", b[29])
+		return IdentificationHeader{}, fmt.Errorf("vorbis: invalid header framing: %d", b[29])
 	}
 	return h, nil
 }
@@ -78,12 +78,84 @@ func ParseCommentHeader(b []byte) (CommentHeader, error) {
 	}
 
 	if b[0] != 1 {
-		return CommentHeader{}, fmt.Errorf("vorbis: invalid header framing: %s", b[0])
+		return CommentHeader{}, fmt.Errorf("vorbis: invalid header framing: %d", b[0])
 	}
 
 	return h, nil
 }
 
+// ParseSetupModes returns the block flag of each mode of a setup header
+// described in Section 4.2.4 of Vorbis I specification.
+//
+// The modes are at the end of the header after variable-sized codebook,
+// floor, residue and mapping configurations. Instead of decoding those,
+// the header is read backward until the mode count matches the number of
+// modes read, the same way FFmpeg and liboggz do.
+func ParseSetupModes(b []byte) ([]bool, error) {
+	if len(b) < 7 || b[0] != 5 {
+		return nil, fmt.Errorf("vorbis: invalid setup header type: %d", b[0])
+	}
+	if string(b[1:7]) != "vorbis" {
+		return nil, fmt.Errorf("vorbis: invalid header: %s", b[1:7])
+	}
+	// pos is the index of the next bit to read backward. Vorbis packs
+	// values starting from the least significant bit, so reading
+	// backward starts with the most significant bit of each field.
+	pos := len(b)*8 - 1
+	bit := func() uint32 {
+		v := uint32(b[pos/8]>>(pos%8)) & 1
+		pos--
+		return v
+	}
+	read := func(n int) uint32 {
+		var v uint32
+		for range n {
+			v = v<<1 | bit()
+		}
+		return v
+	}
+	// The framing bit follows the modes.
+	framing := false
+	for pos >= 97 {
+		if bit() == 1 {
+			framing = true
+			break
+		}
+	}
+	if !framing {
+		return nil, fmt.Errorf("vorbis: missing setup header framing bit")
+	}
+	start := pos
+	count, modes := 0, 0
+	for pos+1 >= 97 {
+		// vorbis_mode_mapping, vorbis_mode_transformtype and
+		// vorbis_mode_windowtype
+		if read(8) > 63 || read(16) != 0 || read(16) != 0 {
+			break
+		}
+		read(1) // vorbis_mode_blockflag
+		count++
+		if count > 64 {
+			break
+		}
+		p := pos
+		if int(read(6))+1 == count { // vorbis_mode_count
+			modes = count
+		}
+		pos = p
+	}
+	if modes == 0 {
+		return nil, fmt.Errorf("vorbis: could not find setup header modes")
+	}
+	pos = start
+	flags := make([]bool, modes)
+	for i := modes - 1; i >= 0; i-- {
+		pos -= 40
+		flags[i] = bit() == 1
+	}
+	return flags, nil
+}
+
 func NewSerial() int32 {
 	return rand.Int32()
 }
@@ -0,0 +1,147 @@
+// Package wav implements the WAVE file format and its RF64 extension.
+//
+// See: https://learn.microsoft.com/en-us/windows/win32/api/mmreg/ns-mmreg-waveformatextensible
+package wav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/coding-socks/matroska/internal/riff"
+	"io"
+)
+
+var (
+	WAVE = riff.FourCC{'W', 'A', 'V', 'E'}
+
+	ChunkFMT  = riff.FourCC{'f', 'm', 't', ' '}
+	ChunkDATA = riff.FourCC{'d', 'a', 't', 'a'}
+)
+
+// Format tags of the fmt chunk.
+const (
+	FormatPCM        uint16 = 0x0001
+	FormatIEEEFloat  uint16 = 0x0003
+	FormatExtensible uint16 = 0xFFFE
+)
+
+// Speaker positions of the channel mask.
+const (
+	SpeakerFrontLeft uint32 = 1 << iota
+	SpeakerFrontRight
+	SpeakerFrontCenter
+	SpeakerLowFrequency
+	SpeakerBackLeft
+	SpeakerBackRight
+	SpeakerFrontLeftOfCenter
+	SpeakerFrontRightOfCenter
+	SpeakerBackCenter
+	SpeakerSideLeft
+	SpeakerSideRight
+	SpeakerTopCenter
+	SpeakerTopFrontLeft
+	SpeakerTopFrontCenter
+	SpeakerTopFrontRight
+	SpeakerTopBackLeft
+	SpeakerTopBackCenter
+	SpeakerTopBackRight
+)
+
+// subFormatGUID is the KSDATAFORMAT_SUBTYPE GUID of a format tag without
+// its first two octets.
+var subFormatGUID = []byte{0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71}
+
+// Format is the content of the fmt chunk.
+type Format struct {
+	// FormatTag is the format tag of the SubFormat of a
+	// WAVE_FORMAT_EXTENSIBLE format when it has one.
+	FormatTag      uint16
+	Channels       uint16
+	SamplesPerSec  uint32
+	AvgBytesPerSec uint32
+	BlockAlign     uint16
+	BitsPerSample  uint16
+
+	// ValidBitsPerSample and ChannelMask are only set by a
+	// WAVE_FORMAT_EXTENSIBLE format.
+	ValidBitsPerSample uint16
+	ChannelMask        uint32
+}
+
+// Reader reads the samples of the data chunk.
+type Reader struct {
+	Format Format
+	data   io.Reader
+}
+
+// NewReader reads the chunks of a WAVE or an RF64 file until the data
+// chunk.
+func NewReader(r io.Reader) (*Reader, error) {
+	fileType, lr, err := riff.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if fileType != WAVE {
+		return nil, fmt.Errorf("wav: unexpected file type: %q", fileType[:])
+	}
+	wr := &Reader{}
+	hasFormat := false
+	for {
+		id, l, cr, err := lr.Next()
+		if err == io.EOF {
+			return nil, errors.New("wav: missing data chunk")
+		} else if err != nil {
+			return nil, err
+		}
+		switch id {
+		case ChunkFMT:
+			b := make([]byte, l)
+			if _, err := io.ReadFull(cr, b); err != nil {
+				return nil, fmt.Errorf("wav: could not read fmt chunk: %w", err)
+			}
+			if wr.Format, err = parseFormat(b); err != nil {
+				return nil, err
+			}
+			hasFormat = true
+		case ChunkDATA:
+			if !hasFormat {
+				return nil, errors.New("wav: missing fmt chunk")
+			}
+			wr.data = cr
+			return wr, nil
+		}
+	}
+}
+
+func parseFormat(b []byte) (Format, error) {
+	if len(b) < 16 {
+		return Format{}, errors.New("wav: fmt chunk too short")
+	}
+	f := Format{
+		FormatTag:      binary.LittleEndian.Uint16(b[0:2]),
+		Channels:       binary.LittleEndian.Uint16(b[2:4]),
+		SamplesPerSec:  binary.LittleEndian.Uint32(b[4:8]),
+		AvgBytesPerSec: binary.LittleEndian.Uint32(b[8:12]),
+		BlockAlign:     binary.LittleEndian.Uint16(b[12:14]),
+		BitsPerSample:  binary.LittleEndian.Uint16(b[14:16]),
+	}
+	if f.FormatTag != FormatExtensible {
+		return f, nil
+	}
+	// cbSize, Samples, dwChannelMask and SubFormat
+	if len(b) < 40 || binary.LittleEndian.Uint16(b[16:18]) < 22 {
+		return Format{}, errors.New("wav: WAVE_FORMAT_EXTENSIBLE fmt chunk too short")
+	}
+	f.ValidBitsPerSample = binary.LittleEndian.Uint16(b[18:20])
+	f.ChannelMask = binary.LittleEndian.Uint32(b[20:24])
+	if bytes.Equal(b[26:40], subFormatGUID) {
+		f.FormatTag = binary.LittleEndian.Uint16(b[24:26])
+	}
+	return f, nil
+}
+
+// Read reads the data chunk.
+func (r *Reader) Read(p []byte) (int, error) {
+	return r.data.Read(p)
+}
@@ -134,7 +134,7 @@ func writeStruct(w io.Writer, node *schema.TreeNode) {
 	})
 	fmt.Fprintf(w, "type %s struct {", node.El.Name)
 	if node.El.Recursive {
-		fmt.Fprintf(w, "\n\t%[1]s *%[1]s", node.El.Name)
+		fmt.Fprintf(w, "\n\t%[1]s []%[1]s", node.El.Name)
 	}
 	node.VisitAll(func(n *schema.TreeNode) {
 		if n.El.MaxOccurs.Unbounded() || n.El.MaxOccurs.Val() > 1 {
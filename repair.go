@@ -0,0 +1,358 @@
+package matroska
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/coding-socks/ebml"
+	"github.com/coding-socks/ebml/schema"
+	"io"
+	"time"
+)
+
+// RepairReport describes what Repair recovered from a damaged file.
+type RepairReport struct {
+	// Elements are the top level elements other than Clusters copied from
+	// the damaged file in the order they were found.
+	Elements []schema.ElementID
+	// Clusters is the number of Clusters copied, and Blocks is the number
+	// of SimpleBlocks and BlockGroups inside them.
+	Clusters int
+	Blocks   int
+	// DroppedClusters is the number of truncated or damaged Clusters.
+	DroppedClusters int
+	// Skipped is the number of octets skipped while searching for the
+	// next Cluster, not counting the dropped Clusters.
+	Skipped int64
+	// CuePoints is the number of the regenerated CuePoints.
+	CuePoints int
+	// Duration is the regenerated Duration.
+	Duration time.Duration
+}
+
+// repairCluster is the position of a complete Cluster in the damaged file.
+type repairCluster struct {
+	timestamp  int64
+	start, end int64
+}
+
+type repairer struct {
+	r      *cachedReaderAt
+	def    *ebml.Def
+	end    int64
+	report *RepairReport
+
+	segment, cluster schema.Element
+
+	clusters []repairCluster
+	data     *dataDecoder
+	// elements are the data of the top level elements by ID.
+	elements map[schema.ElementID][][]byte
+}
+
+// Repair copies what can be recovered from the damaged Matroska file r
+// into w, and reports what it recovered.
+//
+// Repair is meant for files of crashed recorders, which miss their Cues
+// or have a Segment of unknown size, and truncated files. It reads the
+// top level elements one by one, and when it finds damaged data, it
+// searches for the next Cluster. Clusters which are truncated or contain
+// damaged elements are dropped. The blocks of the other Clusters are
+// copied as they are into Clusters of known size. Info, Tracks, Chapters,
+// Tags and Attachments are copied when they are complete, and the
+// SeekHead, the Cues and the Duration are regenerated. The DocType
+// defaults to the DocType of r.
+func Repair(w io.WriteSeeker, r io.ReadSeeker, opts WriterOptions) (*RepairReport, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("matroska: could not seek: %w", err)
+	}
+	c := &cachedReaderAt{r: r}
+	h, err := ebml.NewDecoder(io.NewSectionReader(c, 0, size)).DecodeHeader()
+	if err != nil {
+		return nil, fmt.Errorf("matroska: could not decode header: %w", err)
+	}
+	if h.DocType != DocType && h.DocType != "webm" {
+		return nil, fmt.Errorf("matroska: cannot decode DocType: %v", h.DocType)
+	}
+	if opts.DocType == "" {
+		opts.DocType = h.DocType
+	}
+	def := validation().def
+	rp := &repairer{
+		r:        c,
+		def:      def,
+		end:      size,
+		report:   &RepairReport{},
+		elements: make(map[schema.ElementID][][]byte),
+	}
+	rp.segment, _ = def.Get(IDSegment)
+	rp.cluster, _ = def.Get(IDCluster)
+	if err := rp.scan(); err != nil {
+		return nil, err
+	}
+
+	var info Info
+	if err := rp.decode(IDInfo, &info); err != nil {
+		return nil, err
+	}
+	var tracks Tracks
+	if err := rp.decode(IDTracks, &tracks); err != nil {
+		return nil, err
+	}
+	mw, err := NewWriter(w, info, tracks, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, cl := range rp.clusters {
+		data, err := c.readAt(cl.start, int(cl.end-cl.start))
+		if err != nil {
+			return nil, err
+		}
+		if err := mw.writeCluster(cl.timestamp, data); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := rp.elements[IDChapters]; ok {
+		var chapters Chapters
+		if err := rp.decode(IDChapters, &chapters); err != nil {
+			return nil, err
+		}
+		mw.SetChapters(&chapters)
+	}
+	if _, ok := rp.elements[IDAttachments]; ok {
+		var attachments Attachments
+		if err := rp.decode(IDAttachments, &attachments); err != nil {
+			return nil, err
+		}
+		mw.SetAttachments(&attachments)
+	}
+	if all, ok := rp.elements[IDTags]; ok {
+		var merged Tags
+		for _, data := range all {
+			var tags Tags
+			if err := rp.decodeData(IDTags, data, &tags); err != nil {
+				return nil, err
+			}
+			merged.Tag = append(merged.Tag, tags.Tag...)
+		}
+		mw.SetTags(&merged)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	rp.report.CuePoints = len(mw.cues.CuePoint)
+	rp.report.Duration = mw.end
+	return rp.report, nil
+}
+
+// scan finds the Segment, and the complete top level elements inside it.
+func (rp *repairer) scan() error {
+	var pos int64
+	for {
+		id, hl, ds, err := rp.header(pos)
+		if err != nil {
+			return err
+		}
+		if id == 0 || ds == -1 && id != IDSegment {
+			return errors.New("matroska: could not find Segment")
+		}
+		if id == IDSegment {
+			pos += int64(hl)
+			if ds != -1 {
+				rp.end = min(rp.end, pos+ds)
+			}
+			break
+		}
+		pos += int64(hl) + ds
+	}
+
+	for pos < rp.end {
+		id, hl, ds, err := rp.header(pos)
+		if err != nil {
+			return err
+		}
+		el, known := rp.def.Get(id)
+		switch {
+		case id == 0 || !known || !allowedIn(el, rp.segment):
+			// Damaged data.
+		case id == IDCluster:
+			b, err := rp.r.readAt(pos, clusterStartSize)
+			if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return err
+			}
+			if !isClusterStart(b) {
+				break
+			}
+			end, ok, err := rp.scanCluster(pos, hl, ds)
+			if err != nil {
+				return err
+			}
+			if ok {
+				pos = end
+				continue
+			}
+			rp.report.DroppedClusters++
+			next, err := rp.findCluster(pos + 1)
+			if err != nil {
+				return err
+			}
+			pos = next
+			continue
+		case ds != -1 && pos+int64(hl)+ds <= rp.end:
+			switch id {
+			case IDInfo, IDTracks, IDChapters, IDAttachments, IDTags:
+				if _, ok := rp.elements[id]; ok && id != IDTags {
+					break
+				}
+				data, err := rp.r.readAt(pos+int64(hl), int(ds))
+				if err != nil {
+					return err
+				}
+				rp.elements[id] = append(rp.elements[id], data)
+				rp.report.Elements = append(rp.report.Elements, id)
+			}
+			pos += int64(hl) + ds
+			continue
+		}
+		next, err := rp.findCluster(pos + 1)
+		if err != nil {
+			return err
+		}
+		rp.report.Skipped += next - pos
+		pos = next
+	}
+	return nil
+}
+
+// scanCluster checks the Cluster at pos, and records it when it is
+// complete. It returns the end of the Cluster. A Cluster of unknown size
+// ends at the first element which is not its child.
+func (rp *repairer) scanCluster(pos int64, hl int, ds int64) (int64, bool, error) {
+	start := pos + int64(hl)
+	end := rp.end
+	if ds != -1 {
+		if start+ds > rp.end {
+			return 0, false, nil
+		}
+		end = start + ds
+	}
+	var (
+		timestamp *int64
+		blocks    int
+	)
+	p := start
+	for p < end {
+		id, chl, cds, err := rp.header(p)
+		if err != nil {
+			return 0, false, err
+		}
+		el, known := rp.def.Get(id)
+		if id == 0 || !known || !allowedIn(el, rp.cluster) {
+			if ds == -1 {
+				break
+			}
+			return 0, false, nil
+		}
+		if cds == -1 || p+int64(chl)+cds > end {
+			return 0, false, nil
+		}
+		switch id {
+		case IDTimestamp, IDSimpleBlock, IDBlockGroup:
+			if cds > 8 && id == IDTimestamp {
+				return 0, false, nil
+			}
+			data, err := rp.r.readAt(p+int64(chl), int(cds))
+			if err != nil {
+				return 0, false, err
+			}
+			if id == IDTimestamp {
+				var ts int64
+				for _, c := range data {
+					ts = ts<<8 | int64(c)
+				}
+				timestamp = &ts
+				break
+			}
+			if _, ok := parseRawBlock(id, data); !ok {
+				return 0, false, nil
+			}
+			blocks++
+		}
+		p += int64(chl) + cds
+	}
+	if timestamp == nil {
+		return 0, false, nil
+	}
+	rp.clusters = append(rp.clusters, repairCluster{timestamp: *timestamp, start: start, end: p})
+	rp.report.Clusters++
+	rp.report.Blocks += blocks
+	return p, true, nil
+}
+
+// header reads the header of the element at pos. It returns an ID of zero
+// when the header is damaged.
+func (rp *repairer) header(pos int64) (schema.ElementID, int, int64, error) {
+	b, err := rp.r.readAt(pos, 12)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, 0, 0, err
+	}
+	id, hl, ds, ok := parseElementHeader(b)
+	if !ok {
+		return 0, 0, 0, nil
+	}
+	return id, hl, ds, nil
+}
+
+// findCluster returns the position of the first Cluster at or after pos,
+// or the end of the Segment when there is none.
+func (rp *repairer) findCluster(pos int64) (int64, error) {
+	id := appendElementID(nil, IDCluster)
+	for pos < rp.end {
+		b, err := rp.r.readAt(pos, int(min(cachedReaderAtSize, rp.end-pos)))
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, err
+		}
+		i := bytes.Index(b, id)
+		if i == -1 {
+			if len(b) < len(id) {
+				break
+			}
+			pos += int64(len(b) - len(id) + 1)
+			continue
+		}
+		b, err = rp.r.readAt(pos+int64(i), clusterStartSize)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, err
+		}
+		if isClusterStart(b) {
+			return pos + int64(i), nil
+		}
+		pos += int64(i) + 1
+	}
+	return rp.end, nil
+}
+
+// decode decodes the first element id found by scan into v.
+func (rp *repairer) decode(id schema.ElementID, v any) error {
+	all, ok := rp.elements[id]
+	if !ok {
+		return fmt.Errorf("matroska: could not find a complete %s element", elementName(id))
+	}
+	return rp.decodeData(id, all[0], v)
+}
+
+func (rp *repairer) decodeData(id schema.ElementID, data []byte, v any) error {
+	if rp.data == nil {
+		var err error
+		if rp.data, err = newDataDecoder(DocType); err != nil {
+			return err
+		}
+	}
+	el, _ := rp.def.Get(id)
+	if err := rp.data.decode(ebml.Element{ID: id, DataSize: int64(len(data)), Schema: el}, data, v); err != nil {
+		return fmt.Errorf("matroska: could not decode %v: %w", id, err)
+	}
+	dropPrepopulated(v)
+	return nil
+}
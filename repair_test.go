@@ -0,0 +1,148 @@
+package matroska
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testFile returns a file written with opts that has 100 frames of 100 ms
+// each, and the positions of its top level elements. With a ClusterDuration
+// of one second it has 10 Clusters of 10 frames each.
+func testFile(t *testing.T, opts WriterOptions) ([]byte, []int) {
+	t.Helper()
+	var ws writeSeeker
+	video := NewTrackEntry(1, TrackTypeVideo, VideoCodecMSCOMP)
+	video.Video = &Video{PixelWidth: 320, PixelHeight: 240}
+	w, err := NewWriter(&ws, Info{}, Tracks{TrackEntry: []TrackEntry{video}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetChapters(testChapters())
+	w.SetTags(testTags())
+	for i := range 100 {
+		f := Frame{TrackNumber: 1, Timestamp: time.Duration(i) * 100 * time.Millisecond, Keyframe: i%10 == 0, Data: fmt.Appendf(nil, "frame%02d", i)}
+		if err := w.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var positions []int
+	for pos := int(w.segmentStart); pos < len(ws.buf); {
+		_, hl, ds, _ := parseElementHeader(ws.buf[pos:])
+		positions = append(positions, pos)
+		pos += hl + int(ds)
+	}
+	return ws.buf, positions
+}
+
+func TestRepair(t *testing.T) {
+	// repair repairs b and checks that the result is valid.
+	repair := func(t *testing.T, b []byte) (*RepairReport, *MatroskaDemuxer) {
+		t.Helper()
+		var ws writeSeeker
+		report, err := Repair(&ws, bytes.NewReader(b), WriterOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := Validate(bytes.NewReader(ws.buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !v.Valid() {
+			t.Errorf("Validate() = %v", v.Findings)
+		}
+		d, err := NewMatroskaDemuxer(bytes.NewReader(ws.buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report, d
+	}
+	clusters := func(b []byte, positions []int) []int {
+		var cl []int
+		for _, pos := range positions {
+			if id, _, _, _ := parseElementHeader(b[pos:]); id == IDCluster {
+				cl = append(cl, pos)
+			}
+		}
+		return cl
+	}
+
+	t.Run("Truncated", func(t *testing.T) {
+		b, positions := testFile(t, WriterOptions{ClusterDuration: time.Second})
+		last := clusters(b, positions)[9]
+		report, d := repair(t, b[:last+20])
+		if report.Clusters != 9 || report.Blocks != 90 || report.DroppedClusters != 1 {
+			t.Errorf("report = %+v", report)
+		}
+		if report.Duration != 8900*time.Millisecond || report.CuePoints != 9 {
+			t.Errorf("Duration = %v, CuePoints = %d", report.Duration, report.CuePoints)
+		}
+		if got := len(readFrames(t, d)); got != 90 {
+			t.Errorf("got %d frames, want 90", got)
+		}
+		if d.Scanner().Chapters() != nil {
+			t.Error("got Chapters written after the Clusters")
+		}
+	})
+	t.Run("UnknownSize", func(t *testing.T) {
+		// A crashed recorder leaves elements of unknown size, and no Cues
+		// and SeekHead.
+		b, positions := testFile(t, WriterOptions{ClusterDuration: time.Second})
+		// The Segment header has an 8 octets long size.
+		damaged := appendElementHeader(bytes.Clone(b[:positions[0]-12]), IDSegment, -1)
+		for _, pos := range positions {
+			id, hl, ds, _ := parseElementHeader(b[pos:])
+			switch id {
+			case IDInfo, IDTracks, IDChapters, IDTags:
+				damaged = append(damaged, b[pos:pos+hl+int(ds)]...)
+			}
+		}
+		for _, pos := range clusters(b, positions) {
+			_, hl, ds, _ := parseElementHeader(b[pos:])
+			damaged = appendElementHeader(damaged, IDCluster, -1)
+			damaged = append(damaged, b[pos+hl:pos+hl+int(ds)]...)
+		}
+		// The last Cluster is cut in the middle of a block.
+		damaged = damaged[:len(damaged)-3]
+		report, d := repair(t, damaged)
+		if report.Clusters != 9 || report.DroppedClusters != 1 || report.Skipped != 0 {
+			t.Errorf("report = %+v", report)
+		}
+		if report.CuePoints != 9 {
+			t.Errorf("CuePoints = %d", report.CuePoints)
+		}
+		if got := len(readFrames(t, d)); got != 90 {
+			t.Errorf("got %d frames, want 90", got)
+		}
+	})
+	t.Run("Garbage", func(t *testing.T) {
+		b, positions := testFile(t, WriterOptions{ClusterDuration: time.Second})
+		cl := clusters(b, positions)
+		// Damage the header of the fourth block in the third Cluster.
+		damaged := bytes.Clone(b)
+		pos := bytes.Index(damaged, []byte("frame23"))
+		copy(damaged[pos-8:], bytes.Repeat([]byte{0xff}, 8))
+		// Put garbage between two Clusters.
+		garbage := bytes.Repeat([]byte{0x1f, 0x43, 0xb6, 0x75}, 10)
+		damaged = append(damaged[:cl[5]:cl[5]], append(garbage, damaged[cl[5]:]...)...)
+		size := appendDataSize(nil, int64(len(damaged)-positions[0]), 8)
+		copy(damaged[positions[0]-len(size):], size)
+		report, d := repair(t, damaged)
+		if report.Clusters != 9 || report.DroppedClusters != 1 || report.Skipped != int64(len(garbage)) {
+			t.Errorf("report = %+v", report)
+		}
+		if len(report.Elements) != 4 {
+			t.Errorf("Elements = %v", report.Elements)
+		}
+		if got := len(readFrames(t, d)); got != 90 {
+			t.Errorf("got %d frames, want 90", got)
+		}
+		if d.Scanner().Chapters() == nil || d.Scanner().Tags() == nil {
+			t.Error("lost Chapters or Tags")
+		}
+	})
+}
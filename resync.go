@@ -0,0 +1,200 @@
+package matroska
+
+import (
+	"errors"
+	"github.com/coding-socks/ebml"
+	"io"
+	"math/bits"
+	"time"
+)
+
+// SkippedRange is damaged data skipped by a resilient Scanner.
+type SkippedRange struct {
+	// Offset is the position of the first skipped octet. Without an
+	// io.Seeker it is -1, and Length only counts the octets skipped while
+	// searching for the next Cluster. When the damaged data ends a Cluster
+	// of unknown size, the first octets of it can be missing from the range
+	// because the decoder already read them.
+	Offset int64
+	Length int64
+}
+
+// SetResilient makes the Scanner skip damaged data instead of failing.
+//
+// When Next finds data which is not a top level element, or a Cluster
+// which cannot be decoded, it searches for the next Cluster ID, and
+// accepts a candidate when its header is followed by a Timestamp element.
+// The blocks of a Cluster of unknown size before the damaged data are
+// kept, other damaged Clusters are skipped as a whole.
+//
+// skipped is called with every skipped range, and it can be nil.
+func (s *Scanner) SetResilient(skipped func(r SkippedRange)) {
+	s.resilient = true
+	s.skipped = skipped
+}
+
+// position returns the read position, or -1 when the io.Reader is not an
+// io.Seeker.
+func (s *Scanner) position() int64 {
+	ss, ok := s.decoder.AsSeeker()
+	if !ok {
+		return -1
+	}
+	pos, err := ss.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	return pos
+}
+
+// positionBefore returns the position n octets before the read position,
+// or -1 when it is unknown.
+func (s *Scanner) positionBefore(n int) int64 {
+	pos := s.position()
+	if pos == -1 {
+		return -1
+	}
+	return pos - int64(n)
+}
+
+// damaged reports whether el cannot be a top level element.
+func (s *Scanner) damaged(el ebml.Element) bool {
+	segment, _ := validation().def.Get(IDSegment)
+	return el.Schema.Name == ebml.UnknownSchema.Name || !allowedIn(el.Schema, segment)
+}
+
+// resync searches for the next Cluster after the damaged data starting at
+// start, and stores it in clusterEl. It reads one octet at a time, so it
+// does not read any octet of the Cluster after its Timestamp.
+func (s *Scanner) resync(start int64) bool {
+	var (
+		buf     []byte
+		skipped int64
+		octet   [1]byte
+	)
+	for {
+		n, ts, ok, more := clusterStart(buf)
+		if more {
+			err := s.readOctets(octet[:])
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				skipped += int64(len(buf))
+				s.reportSkipped(start, skipped, s.position())
+				return false
+			} else if err != nil {
+				s.err = err
+				return false
+			}
+			buf = append(buf, octet[0])
+			continue
+		}
+		if !ok {
+			buf = buf[1:]
+			skipped++
+			continue
+		}
+		pos := s.positionBefore(n)
+		s.reportSkipped(start, skipped, pos)
+
+		_, hl, ds, _ := parseElementHeader(buf)
+		clusterSchema, _ := validation().def.Get(IDCluster)
+		el := ebml.Element{ID: IDCluster, DataSize: -1, Schema: clusterSchema}
+		if ds != -1 {
+			el.DataSize = ds - int64(n-hl)
+		}
+		timestamp := time.Duration(ts)
+		s.clusterEl, s.clusterStart, s.clusterTimestamp = &el, pos, &timestamp
+		return true
+	}
+}
+
+// reportSkipped reports the damaged data from start to end. skipped is the
+// length of the data when the positions are unknown.
+func (s *Scanner) reportSkipped(start, skipped, end int64) {
+	if s.skipped == nil {
+		return
+	}
+	r := SkippedRange{Offset: start, Length: skipped}
+	if start != -1 && end != -1 {
+		r.Length = end - start
+	}
+	if r.Length > 0 {
+		s.skipped(r)
+	}
+}
+
+// clusterStartSize is the largest size of the elements checked by
+// clusterStart.
+const clusterStartSize = 4 + 8 + crc32ElementSize + 1 + 8 + 8
+
+// clusterStart checks whether b starts with a Cluster header followed by
+// a Timestamp element, which can be preceded by a CRC-32 element. Every
+// known muxer writes the Timestamp first, so it filters out the Cluster
+// IDs found in damaged or in binary data. It returns the length of these
+// elements and the Timestamp. more is true when b is too short to tell.
+func clusterStart(b []byte) (n int, timestamp uint64, ok, more bool) {
+	id := appendElementID(nil, IDCluster)
+	for i := range min(len(b), len(id)) {
+		if b[i] != id[i] {
+			return 0, 0, false, false
+		}
+	}
+	// header checks the header at p, and returns its length.
+	header := func(p int) (int, int64, bool, bool) {
+		if len(b) < p+2 {
+			return 0, 0, false, true
+		}
+		idLen := bits.LeadingZeros8(b[p]) + 1
+		if idLen > 4 {
+			return 0, 0, false, false
+		}
+		if len(b) < p+idLen+1 {
+			return 0, 0, false, true
+		}
+		if b[p+idLen] == 0 {
+			return 0, 0, false, false
+		}
+		if len(b) < p+idLen+bits.LeadingZeros8(b[p+idLen])+1 {
+			return 0, 0, false, true
+		}
+		_, hl, ds, ok := parseElementHeader(b[p:])
+		return hl, ds, ok, false
+	}
+	hl, size, ok, more := header(0)
+	if !ok || more {
+		return 0, 0, false, more
+	}
+	p := hl
+	for first := true; ; first = false {
+		chl, ds, ok, more := header(p)
+		if !ok || more {
+			return 0, 0, false, more
+		}
+		childID, _, _, _ := parseElementHeader(b[p:])
+		switch {
+		case childID == ebml.IDCRC32 && first && ds == 4:
+			p += chl + int(ds)
+			continue
+		case childID == IDTimestamp && ds >= 0 && ds <= 8:
+		default:
+			return 0, 0, false, false
+		}
+		n = p + chl + int(ds)
+		if size != -1 && int64(n-hl) > size {
+			return 0, 0, false, false
+		}
+		if len(b) < n {
+			return 0, 0, false, true
+		}
+		for _, c := range b[p+chl : n] {
+			timestamp = timestamp<<8 | uint64(c)
+		}
+		return n, timestamp, true, false
+	}
+}
+
+// isClusterStart reports whether b starts with a Cluster header followed
+// by a Timestamp element as described by clusterStart.
+func isClusterStart(b []byte) bool {
+	_, _, ok, _ := clusterStart(b)
+	return ok
+}
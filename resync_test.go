@@ -0,0 +1,134 @@
+package matroska
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestScanner_SetResilient(t *testing.T) {
+	// scan reads every Cluster of r and returns the number of blocks and
+	// the skipped ranges. The Clusters must start at every second except
+	// at missing.
+	scan := func(t *testing.T, r io.Reader, missing int) (int, []SkippedRange) {
+		t.Helper()
+		s := NewScanner(r)
+		var skipped []SkippedRange
+		s.SetResilient(func(r SkippedRange) {
+			skipped = append(skipped, r)
+		})
+		if err := s.Init(); err != nil {
+			t.Fatal(err)
+		}
+		n, i := 0, 0
+		for s.Next() {
+			if i == missing {
+				i++
+			}
+			cl := s.Cluster()
+			if want := int64(i * 1000); int64(cl.Timestamp) != want {
+				t.Errorf("Timestamp = %d, want %d", cl.Timestamp, want)
+			}
+			n += len(cl.SimpleBlock)
+			i++
+		}
+		if err := s.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return n, skipped
+	}
+	clusters := func(b []byte, positions []int) []int {
+		var cl []int
+		for _, pos := range positions {
+			if id, _, _, _ := parseElementHeader(b[pos:]); id == IDCluster {
+				cl = append(cl, pos)
+			}
+		}
+		return cl
+	}
+	// insert inserts garbage at pos, and updates the size of the Segment.
+	insert := func(b []byte, positions []int, pos int, garbage []byte) []byte {
+		b = append(b[:pos:pos], append(garbage, b[pos:]...)...)
+		size := appendDataSize(nil, int64(len(b)-positions[0]), 8)
+		copy(b[positions[0]-len(size):], size)
+		return b
+	}
+
+	t.Run("Garbage", func(t *testing.T) {
+		b, positions := testFile(t, WriterOptions{ClusterDuration: time.Second})
+		cl := clusters(b, positions)
+		// Cluster IDs without a Timestamp are garbage too.
+		garbage := append(bytes.Repeat([]byte{0xff}, 7), bytes.Repeat([]byte{0x1f, 0x43, 0xb6, 0x75}, 10)...)
+		damaged := insert(b, positions, cl[5], garbage)
+		n, skipped := scan(t, bytes.NewReader(damaged), -1)
+		if n != 100 {
+			t.Errorf("got %d blocks, want 100", n)
+		}
+		want := []SkippedRange{{Offset: int64(cl[5]), Length: int64(len(garbage))}}
+		if len(skipped) != 1 || skipped[0] != want[0] {
+			t.Errorf("skipped = %v, want %v", skipped, want)
+		}
+	})
+
+	b, positions := testFile(t, WriterOptions{ClusterDuration: time.Second})
+	cl := clusters(b, positions)
+	// Damage the header of the fourth Cluster.
+	damaged := bytes.Clone(b)
+	copy(damaged[cl[3]:], []byte{0x00, 0x00})
+	t.Run("DamagedCluster", func(t *testing.T) {
+		n, skipped := scan(t, bytes.NewReader(damaged), 3)
+		if n != 90 {
+			t.Errorf("got %d blocks, want 90", n)
+		}
+		want := SkippedRange{Offset: int64(cl[3]), Length: int64(cl[4] - cl[3])}
+		if len(skipped) != 1 || skipped[0] != want {
+			t.Errorf("skipped = %v, want %v", skipped, want)
+		}
+	})
+	t.Run("NotSeekable", func(t *testing.T) {
+		n, skipped := scan(t, struct{ io.Reader }{bytes.NewReader(damaged)}, 3)
+		if n != 90 {
+			t.Errorf("got %d blocks, want 90", n)
+		}
+		if len(skipped) != 1 || skipped[0].Offset != -1 || skipped[0].Length <= 0 {
+			t.Errorf("skipped = %v", skipped)
+		}
+	})
+	t.Run("UnknownSize", func(t *testing.T) {
+		// The Segment header has an 8 octets long size.
+		damaged := appendElementHeader(bytes.Clone(b[:positions[0]-12]), IDSegment, -1)
+		for _, pos := range positions {
+			id, hl, ds, _ := parseElementHeader(b[pos:])
+			switch id {
+			case IDInfo, IDTracks:
+				damaged = append(damaged, b[pos:pos+hl+int(ds)]...)
+			}
+		}
+		var want []SkippedRange
+		for i, pos := range cl {
+			_, hl, ds, _ := parseElementHeader(b[pos:])
+			if i == 2 || i == 6 {
+				want = append(want, SkippedRange{Offset: int64(len(damaged)), Length: 5})
+				damaged = append(damaged, 0xff, 0x00, 0x1f, 0x43, 0xb6)
+			}
+			damaged = appendElementHeader(damaged, IDCluster, -1)
+			damaged = append(damaged, b[pos+hl:pos+hl+int(ds)]...)
+		}
+		n, skipped := scan(t, bytes.NewReader(damaged), -1)
+		if n != 100 {
+			t.Errorf("got %d blocks, want 100", n)
+		}
+		if len(skipped) != len(want) {
+			t.Fatalf("skipped = %v, want %v", skipped, want)
+		}
+		for i := range want {
+			// The decoder reads a part of the damaged data ending a
+			// Cluster of unknown size.
+			r := skipped[i]
+			if r.Offset < want[i].Offset || r.Offset+r.Length != want[i].Offset+want[i].Length {
+				t.Errorf("skipped = %v, want %v", skipped, want)
+			}
+		}
+	})
+}
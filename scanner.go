@@ -6,6 +6,7 @@ import (
 	"github.com/coding-socks/ebml"
 	"github.com/coding-socks/ebml/schema"
 	"io"
+	"time"
 )
 
 // ErrUnexpectedClusterElement means that Cluster was encountered before
@@ -23,27 +24,92 @@ type Scanner struct {
 	segmentEl    ebml.Element
 	segmentStart int64
 
-	info     *Info
-	tracks   *Tracks
-	seekHead *SeekHead
+	info        *Info
+	tracks      *Tracks
+	seekHead    *SeekHead
+	attachments *Attachments
+	chapters    *Chapters
+	tags        *Tags
+	// tagsComplete signals that tags contains every Tags element.
+	tagsComplete bool
 
 	// fSeekHead is an attempt to recreate SeekHead in case it is missing.
 	fSeekHead *SeekHead
 
-	offset       int64
-	cluster      Cluster
-	firstCluster *Cluster
-	err          error
+	offset  int64
+	cluster Cluster
+	err     error
+
+	// clusterEl is the Cluster whose header is read by nextCluster, and
+	// clusterStart is its position. clusterTimestamp is its Timestamp when
+	// resync already read it.
+	clusterEl        *ebml.Element
+	clusterStart     int64
+	clusterTimestamp *time.Duration
+
+	// block is the latest block read by NextBlock from blockCluster, and
+	// blockOffset is the length of its data read so far.
+	block        ClusterBlock
+	blockCluster *ebml.Element
+	blockOffset  int64
+	// live tells that the Clusters are read by NextBlock, which makes
+	// nextOf read the element headers one octet at a time when needed.
+	// pendingEl is the element which ended an element of unknown size, and
+	// decoderPending tells that the decoder keeps such an element instead.
+	live           bool
+	pendingEl      *pendingElement
+	decoderPending bool
+	// src is the io.Reader of the decoder. buffered is false when the
+	// decoder does not hold any data read from src, so NextBlock can read
+	// from src directly.
+	src      *sourceReader
+	buffered bool
+	// blockBuf is the reusable buffer of the SimpleBlocks, and keepBlock
+	// filters them by track number.
+	blockBuf  []byte
+	keepBlock func(trackNumber uint) bool
+	// scratch holds the element headers read by readHeader.
+	scratch [12]byte
+
+	crc32Policy CRC32Policy
+	crc32Report func(err *CRC32Error)
+	// data decodes the elements whose CRC-32 is verified.
+	data *dataDecoder
+
+	resilient bool
+	skipped   func(r SkippedRange)
+	// resyncAt is the position of the damaged data which ended the
+	// previous Cluster, and resyncPending tells whether it is set.
+	resyncPending bool
+	resyncAt      int64
 }
 
 func NewScanner(r io.Reader) *Scanner {
-	d := ebml.NewDecoder(r)
+	src := &sourceReader{r: r}
+	var dr io.Reader = src
+	if ss, ok := r.(io.Seeker); ok {
+		dr = sourceReadSeeker{sourceReader: src, s: ss}
+	}
+	d := ebml.NewDecoder(dr)
 	s := Scanner{
-		decoder: d,
+		decoder:  d,
+		src:      src,
+		buffered: true,
 	}
 	return &s
 }
 
+// SetCRC32Policy sets how the CRC-32 elements of the decoded master
+// elements are handled. The elements inside them are verified as well.
+// With CRC32Report, report is called with every mismatch. It has to be
+// called before Init to cover the Info and the Tracks elements.
+//
+// Elements of unknown size, like live streamed Clusters, are not verified.
+func (s *Scanner) SetCRC32Policy(policy CRC32Policy, report func(err *CRC32Error)) {
+	s.crc32Policy = policy
+	s.crc32Report = report
+}
+
 func (s *Scanner) Decoder() *ebml.Decoder {
 	return s.decoder
 }
@@ -100,6 +166,87 @@ func (s *Scanner) SeekHead() (*SeekHead, bool) {
 	return s.seekHead, true
 }
 
+// Attachments returns the Attachments element of the matroska document.
+//
+// When the element has not been read yet, Attachments tries to locate it
+// with the help of the SeekHead which requires the io.Reader to implement
+// io.Seeker. It returns nil when the document does not have attachments.
+func (s *Scanner) Attachments() *Attachments {
+	s.err = s.Init()
+	if s.err != nil || s.attachments != nil {
+		return s.attachments
+	}
+	var attachments Attachments
+	found, err := s.decodeTopLevel(IDAttachments, 0, &attachments)
+	if err != nil {
+		s.err = err
+		return nil
+	}
+	if found {
+		s.attachments = &attachments
+	}
+	return s.attachments
+}
+
+// Chapters returns the Chapters element of the matroska document.
+//
+// Chapters locates the element the same way as Attachments. It returns
+// nil when the document does not have chapters.
+func (s *Scanner) Chapters() *Chapters {
+	s.err = s.Init()
+	if s.err != nil || s.chapters != nil {
+		return s.chapters
+	}
+	var chapters Chapters
+	found, err := s.decodeTopLevel(IDChapters, 0, &chapters)
+	if err != nil {
+		s.err = err
+		return nil
+	}
+	if found {
+		s.chapters = &chapters
+	}
+	return s.chapters
+}
+
+// Tags returns the Tag elements of every Tags element of the matroska
+// document merged into a single Tags.
+//
+// Tags locates the elements the same way as Attachments. Without a
+// SeekHead element, it only returns the tags read by Next so far.
+// It returns nil when the document does not have tags.
+func (s *Scanner) Tags() *Tags {
+	s.err = s.Init()
+	if s.err != nil || s.tagsComplete {
+		return s.tags
+	}
+	if _, ok := s.SeekHead(); !ok {
+		// A constructed SeekHead only knows about the elements which
+		// were already read, and those are part of tags.
+		return s.tags
+	}
+	var merged Tags
+	found := false
+	for n := 0; ; n++ {
+		var tags Tags
+		ok, err := s.decodeTopLevel(IDTags, n, &tags)
+		if err != nil {
+			s.err = err
+			return nil
+		}
+		if !ok {
+			break
+		}
+		found = true
+		merged.Tag = append(merged.Tag, tags.Tag...)
+	}
+	if found {
+		s.tags = &merged
+		s.tagsComplete = true
+	}
+	return s.tags
+}
+
 // Next reads the next Cluster struct from the io.Reader.
 //
 // The cluster is accessible by calling Cluster.
@@ -108,23 +255,76 @@ func (s *Scanner) Next() bool {
 	if s.err != nil {
 		return false
 	}
-	if s.firstCluster != nil {
-		s.cluster = *s.firstCluster
-		s.firstCluster = nil
-		return true
-	}
+	s.live = false
 	return s.next()
 }
 
 func (s *Scanner) next() bool {
+	// Skip the rest of the Cluster read by NextBlock.
+	for s.blockCluster != nil && s.readBlock() {
+	}
+	if s.err != nil {
+		return false
+	}
+	for {
+		if s.clusterEl == nil && !s.nextCluster() {
+			return false
+		}
+		el, start, ts := *s.clusterEl, s.clusterStart, s.clusterTimestamp
+		s.clusterEl, s.clusterTimestamp = nil, nil
+		var cl Cluster
+		var err error
+		if ts != nil {
+			// resync already read the header and the Timestamp.
+			s.buffered = true
+			err = s.decoder.Decode(el, &cl)
+			cl.Timestamp = *ts
+		} else {
+			err = s.decode(el, &cl)
+		}
+		if el.DataSize == -1 {
+			s.decoderPending = true
+		}
+		if err != nil {
+			var crcErr *CRC32Error
+			if s.resilient && !errors.As(err, &crcErr) && !errors.Is(err, ebml.ErrElementOverflow) {
+				if el.DataSize != -1 || len(cl.SimpleBlock)+len(cl.BlockGroup) == 0 {
+					if !s.resync(start) {
+						return false
+					}
+					continue
+				}
+				// Damaged data ends a Cluster of unknown size, so the
+				// blocks before it are kept.
+				s.resyncPending, s.resyncAt = true, s.position()
+			} else if !errors.Is(err, ebml.ErrElementOverflow) {
+				s.err = fmt.Errorf("matroska: could not decode %v: %w", el.ID, err)
+				return false
+			}
+		}
+		s.cluster = cl
+		return true
+	}
+}
+
+// nextCluster reads the top level elements until the header of the next
+// Cluster, and stores it in clusterEl.
+func (s *Scanner) nextCluster() bool {
 	d := s.decoder
 	segmentEl := s.segmentEl
 	var offset int64 = 0
+	if s.resyncPending {
+		s.resyncPending = false
+		return s.resync(s.resyncAt)
+	}
 	for {
-		el, n, err := d.NextOf(segmentEl, offset)
+		el, n, err := s.nextOf(segmentEl, offset)
 		if segmentEl.DataSize != -1 {
 			offset += int64(n)
 		}
+		if s.resilient && err != nil && err != io.EOF && !errors.Is(err, ebml.ErrElementOverflow) {
+			return s.resync(s.positionBefore(n))
+		}
 		if errors.Is(err, ebml.ErrInvalidVINTLength) {
 			_ = d.SkipByte()
 			offset += 1
@@ -135,11 +335,23 @@ func (s *Scanner) next() bool {
 				el.DataSize = segmentEl.DataSize - offset
 			}
 		} else if err == io.EOF {
+			if s.resilient && segmentEl.DataSize == -1 {
+				// Damaged data ends a Segment of unknown size as well as
+				// the beginning of a new EBML document.
+				el, _, err := s.nextOf(ebml.RootEl, 0)
+				if err == nil && el.ID != ebml.IDEBML && el.ID != IDSegment {
+					// The header is already read.
+					return s.resync(s.positionBefore(len(appendElementHeader(nil, el.ID, el.DataSize))))
+				}
+			}
 			return false
 		} else if err != nil {
 			s.err = err
 			return false
 		}
+		if s.resilient && s.damaged(el) {
+			return s.resync(s.positionBefore(n))
+		}
 		if segmentEl.DataSize != -1 {
 			offset += el.DataSize
 		}
@@ -149,56 +361,65 @@ func (s *Scanner) next() bool {
 				s.err = fmt.Errorf("matroska: could not skip %v: %w", el.ID, err)
 				return false
 			}
-		case IDChapters: // TODO: populate chapters
-			if err := s.updateFSeek(el); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		case IDChapters:
+			if err := s.updateFSeek(el, n); err != nil && !errors.Is(err, errors.ErrUnsupported) {
 				s.err = err
 				return false
 			}
 			var chapters Chapters
-			if err := s.decoder.Decode(el, &chapters); err != nil {
+			if err := s.decode(el, &chapters); err != nil {
 				s.err = fmt.Errorf("matroska: could not decode %v: %w", el.ID, err)
 				return false
 			}
+			dropPrepopulated(&chapters)
+			if s.chapters == nil {
+				s.chapters = &chapters
+			}
 		case IDCues: // TODO: populate cues
-			if err := s.updateFSeek(el); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			if err := s.updateFSeek(el, n); err != nil && !errors.Is(err, errors.ErrUnsupported) {
 				s.err = err
 				return false
 			}
 			var cues Cues
-			if err := s.decoder.Decode(el, &cues); err != nil {
+			if err := s.decode(el, &cues); err != nil {
 				s.err = fmt.Errorf("matroska: could not decode %v: %w", el.ID, err)
 				return false
 			}
-		case IDAttachments: // TODO: populate attachments
-			if err := s.updateFSeek(el); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		case IDAttachments:
+			if err := s.updateFSeek(el, n); err != nil && !errors.Is(err, errors.ErrUnsupported) {
 				s.err = err
 				return false
 			}
 			var attachments Attachments
-			if err := s.decoder.Decode(el, &attachments); err != nil {
+			if err := s.decode(el, &attachments); err != nil {
 				s.err = fmt.Errorf("matroska: could not decode %v: %w", el.ID, err)
 				return false
 			}
-		case IDTags: // TODO: populate tags
-			if err := s.updateFSeek(el); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			if s.attachments == nil {
+				s.attachments = &attachments
+			}
+		case IDTags:
+			if err := s.updateFSeek(el, n); err != nil && !errors.Is(err, errors.ErrUnsupported) {
 				s.err = err
 				return false
 			}
 			var tags Tags
-			if err := s.decoder.Decode(el, &tags); err != nil {
+			if err := s.decode(el, &tags); err != nil {
 				s.err = fmt.Errorf("matroska: could not decode %v: %w", el.ID, err)
 				return false
 			}
-
-		case IDCluster:
-			var cl Cluster
-			if err := d.Decode(el, &cl); err != nil {
-				if !errors.Is(err, ebml.ErrElementOverflow) {
-					s.err = fmt.Errorf("matroska: could not decode %v: %w", el.ID, err)
-					return false
+			dropPrepopulated(&tags)
+			// Tags found by Tags already contain every Tags element.
+			if !s.tagsComplete {
+				if s.tags == nil {
+					s.tags = &Tags{}
 				}
+				s.tags.Tag = append(s.tags.Tag, tags.Tag...)
 			}
-			s.cluster = cl
+
+		case IDCluster:
+			s.clusterEl = &el
+			s.clusterStart = s.positionBefore(n)
 			return true
 		}
 	}
@@ -258,7 +479,7 @@ segment:
 			}
 			offset += el.DataSize
 		}
-		if err := s.updateFSeek(el); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		if err := s.updateFSeek(el, n); err != nil && !errors.Is(err, errors.ErrUnsupported) {
 			return err
 		}
 		switch el.ID {
@@ -269,7 +490,7 @@ segment:
 			continue
 		case IDSeekHead:
 			sh := &SeekHead{}
-			if err := s.decoder.Decode(el, sh); err != nil {
+			if err := s.decode(el, sh); err != nil {
 				return fmt.Errorf("matroska: could not decode %v: %w", el.ID, err)
 			}
 			// There could be a second SeekHead element according to Section 6.3.
@@ -284,12 +505,12 @@ segment:
 			}
 		case IDInfo:
 			s.info = &Info{}
-			if err := s.decoder.Decode(el, s.info); err != nil {
+			if err := s.decode(el, s.info); err != nil {
 				return fmt.Errorf("matroska: could not decode %v: %w", el.ID, err)
 			}
 		case IDTracks:
 			s.tracks = &Tracks{}
-			if err := s.decoder.Decode(el, s.tracks); err != nil {
+			if err := s.decode(el, s.tracks); err != nil {
 				return fmt.Errorf("matroska: could not decode %v: %w", el.ID, err)
 			}
 		case IDCluster:
@@ -323,9 +544,7 @@ segment:
 
 	s.offset = offset
 	// find cluster element
-	_ = s.next()
-	cluster := s.Cluster()
-	s.firstCluster = &cluster
+	_ = s.nextCluster()
 	return s.err
 }
 
@@ -344,14 +563,134 @@ func (s *Scanner) seekTo(seekID schema.ElementID, n int) (int64, bool) {
 				i++
 				continue
 			}
-			ss.Seek(s.segmentStart+int64(seek.SeekPosition), io.SeekStart)
+			seekStart(ss, s.segmentStart+int64(seek.SeekPosition))
 			return int64(seek.SeekPosition), true
 		}
 	}
 	return 0, false
 }
 
-func (s *Scanner) updateFSeek(el ebml.Element) error {
+// decodeTopLevel decodes the nth top level element referenced by the
+// SeekHead with the given id into v. The read position is restored
+// afterward, so it can be used between two Next calls.
+func (s *Scanner) decodeTopLevel(id schema.ElementID, n int, v any) (bool, error) {
+	sh, _ := s.SeekHead()
+	if sh == nil {
+		return false, nil
+	}
+	ss, ok := s.decoder.AsSeeker()
+	if !ok {
+		return false, nil
+	}
+	i := -1
+	for j, seek := range sh.Seek {
+		if seek.SeekID != id {
+			continue
+		}
+		if n == 0 {
+			i = j
+			break
+		}
+		n--
+	}
+	if i == -1 {
+		return false, nil
+	}
+	pos, err := ss.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, fmt.Errorf("matroska: could not read position: %w", err)
+	}
+	defer seekStart(ss, pos)
+
+	if err := seekStart(ss, s.segmentStart+int64(sh.Seek[i].SeekPosition)); err != nil {
+		return false, fmt.Errorf("matroska: could not seek to %v: %w", id, err)
+	}
+	s.buffered = true
+	el, _, err := s.decoder.NextOf(s.segmentEl, 0)
+	if err != nil && !errors.Is(err, ebml.ErrElementOverflow) {
+		return false, fmt.Errorf("matroska: could not decode element: %w", err)
+	}
+	if el.ID != id {
+		return false, fmt.Errorf("matroska: SeekHead points to %v instead of %v", el.ID, id)
+	}
+	if err := s.decode(el, v); err != nil {
+		return false, fmt.Errorf("matroska: could not decode %v: %w", el.ID, err)
+	}
+	dropPrepopulated(v)
+	return true, nil
+}
+
+// decode decodes the master element el into v, and verifies its CRC-32
+// elements according to the CRC32Policy.
+func (s *Scanner) decode(el ebml.Element, v any) error {
+	if s.crc32Policy == CRC32Ignore || el.DataSize == -1 {
+		s.buffered = true
+		return s.decoder.Decode(el, v)
+	}
+	offset := int64(-1)
+	if ss, ok := s.decoder.AsSeeker(); ok {
+		if pos, err := ss.Seek(0, io.SeekCurrent); err == nil {
+			offset = pos
+		}
+	}
+	raw := el
+	raw.Schema.Type = ebml.TypeBinary
+	var data []byte
+	if err := s.decoder.Decode(raw, &data); err != nil {
+		return err
+	}
+	err := verifyCRC32(el.ID, offset, data, func(err *CRC32Error) error {
+		if s.crc32Policy == CRC32Fail {
+			return err
+		}
+		if s.crc32Report != nil {
+			s.crc32Report(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.decodeData(el, data, v)
+}
+
+// decodeData decodes data, which is already read, as the data of el into v.
+func (s *Scanner) decodeData(el ebml.Element, data []byte, v any) error {
+	if s.data == nil {
+		var err error
+		if s.data, err = newDataDecoder(s.header.DocType); err != nil {
+			return err
+		}
+	}
+	return s.data.decode(el, data, v)
+}
+
+// dropPrepopulated removes the default values the decoder prepopulates the
+// elements of v with which can occur multiple times.
+func dropPrepopulated(v any) {
+	switch v := v.(type) {
+	case *Chapters:
+		dropDefaultChapLanguage(v)
+	case *Tags:
+		dropDefaultTargetUIDs(v)
+	}
+}
+
+// seekStart seeks ss to the absolute position pos. The decoder keeps a
+// read error, such as io.EOF, after an absolute seek while its buffer is
+// empty, but it drops the error on a relative seek, so pos is reached
+// relative to the start of the file.
+func seekStart(ss io.Seeker, pos int64) error {
+	if _, err := ss.Seek(0, io.SeekStart); err != nil || pos == 0 {
+		return err
+	}
+	_, err := ss.Seek(pos, io.SeekCurrent)
+	return err
+}
+
+// updateFSeek records the position of el in fSeekHead. The headerSize is
+// used to point to the beginning of the element instead of its data.
+func (s *Scanner) updateFSeek(el ebml.Element, headerSize int) error {
 	if s.seekHead == nil && el.ID != IDSeekHead && el.ID != ebml.IDVoid && el.ID != ebml.IDCRC32 {
 		ss, ok := s.decoder.AsSeeker()
 		if !ok {
@@ -363,7 +702,7 @@ func (s *Scanner) updateFSeek(el ebml.Element) error {
 		}
 		s.fSeekHead.Seek = append(s.fSeekHead.Seek, Seek{
 			SeekID:       el.ID,
-			SeekPosition: uint(pos - s.segmentStart),
+			SeekPosition: uint(pos - int64(headerSize) - s.segmentStart),
 		})
 	}
 	return nil
@@ -0,0 +1,394 @@
+package matroska
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// SplitPart is a time range of a file. An End of zero means the end of
+// the file.
+type SplitPart struct {
+	Start, End time.Duration
+}
+
+// SplitOptions configures Split. Exactly one of Duration, Size,
+// Timestamps, Parts and Chapters selects where the parts start.
+type SplitOptions struct {
+	// Duration starts a new part when a part is at least Duration long.
+	Duration time.Duration
+	// Size starts a new part when a part holds at least Size octets of
+	// frames.
+	Size int64
+	// Timestamps starts a new part at each timestamp.
+	Timestamps []time.Duration
+	// Parts writes each time range into its own part and drops the
+	// frames outside of them. The ranges must be in ascending order and
+	// must not overlap.
+	Parts []SplitPart
+	// Chapters starts a new part at each top level chapter of the
+	// default edition.
+	Chapters bool
+	// Link sets the PrevUUID and the NextUUID of the parts to link them
+	// to each other.
+	Link bool
+
+	WriterOptions WriterOptions
+}
+
+// splitKeyframe is a keyframe of the track used to split a file, and the
+// number of octets of the frames preceding it.
+type splitKeyframe struct {
+	timestamp time.Duration
+	offset    int64
+}
+
+// Split writes the parts of the Matroska file r into the writers returned
+// by create, and returns the number of parts. create is called with the
+// index of a part before its first frame is written. A writer which
+// implements io.Closer is closed after its part is written.
+//
+// A part starts at the first keyframe of the first video track, or of the
+// first track when there is no video track, at or after the requested
+// position. The timestamps of each part are rebased to its start. Each
+// part gets a new SegmentUUID, its own Duration and Cues, and the chapters
+// overlapping it clipped to its range. Tags and attachments are copied
+// into every part.
+func Split(r io.ReadSeeker, create func(part int) (io.WriteSeeker, error), opts SplitOptions) (int, error) {
+	d, err := NewMatroskaDemuxer(r)
+	if err != nil {
+		return 0, err
+	}
+	tracks := d.Tracks()
+	if len(tracks) == 0 {
+		return 0, errors.New("matroska: missing TrackEntry element")
+	}
+	ref := tracks[0].TrackNumber
+	if i := slices.IndexFunc(tracks, func(t TrackEntry) bool { return t.TrackType == TrackTypeVideo }); i >= 0 {
+		ref = tracks[i].TrackNumber
+	}
+	// The first pass collects the positions where a part can start.
+	var (
+		keyframes []splitKeyframe
+		size      int64
+	)
+	for {
+		f, err := d.ReadFrame()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return 0, fmt.Errorf("matroska: could not read frame: %w", err)
+		}
+		if f.TrackNumber == ref && f.Keyframe {
+			keyframes = append(keyframes, splitKeyframe{timestamp: f.Timestamp, offset: size})
+		}
+		size += int64(len(f.Data))
+	}
+	s := d.Scanner()
+	info, chapters, tags, attachments := *s.Info(), s.Chapters(), s.Tags(), s.Attachments()
+	parts, err := splitParts(keyframes, chapters, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("matroska: could not seek to the beginning: %w", err)
+	}
+	if d, err = NewMatroskaDemuxer(r); err != nil {
+		return 0, err
+	}
+	uuids := make([][]byte, len(parts))
+	for i := range uuids {
+		uuids[i] = newSegmentUUID()
+	}
+	var (
+		writers = make([]*Writer, len(parts))
+		files   = make([]io.WriteSeeker, len(parts))
+		closed  = make([]bool, len(parts))
+		created int
+	)
+	open := func(i int) (*Writer, error) {
+		if writers[i] != nil || closed[i] {
+			return writers[i], nil
+		}
+		w, err := create(i)
+		if err != nil {
+			return nil, err
+		}
+		pi := info
+		pi.Duration, pi.SegmentFilename, pi.PrevFilename, pi.NextFilename = nil, nil, nil, nil
+		pi.MuxingApp, pi.WritingApp = "", ""
+		pi.SegmentUUID = &uuids[i]
+		if i > 0 {
+			pi.PrevUUID = nil
+			if opts.Link {
+				pi.PrevUUID = &uuids[i-1]
+			}
+		}
+		if i < len(parts)-1 {
+			pi.NextUUID = nil
+			if opts.Link {
+				pi.NextUUID = &uuids[i+1]
+			}
+		}
+		mw, err := NewWriter(w, pi, Tracks{TrackEntry: tracks}, opts.WriterOptions)
+		if err != nil {
+			return nil, err
+		}
+		if c := clipChapters(chapters, parts[i].Start, parts[i].End); c != nil {
+			mw.SetChapters(c)
+		}
+		if tags != nil {
+			mw.SetTags(tags)
+		}
+		if attachments != nil {
+			mw.SetAttachments(attachments)
+		}
+		writers[i], files[i] = mw, w
+		created++
+		return mw, nil
+	}
+	closePart := func(i int) error {
+		if closed[i] {
+			return nil
+		}
+		closed[i] = true
+		if writers[i] == nil {
+			return nil
+		}
+		if err := writers[i].Close(); err != nil {
+			return err
+		}
+		writers[i] = nil
+		if c, ok := files[i].(io.Closer); ok {
+			return c.Close()
+		}
+		return nil
+	}
+	defer func() {
+		for i := range parts {
+			closePart(i)
+		}
+	}()
+
+	// The frames of the reference track follow its keyframes in decoding
+	// order, the frames of the other tracks are placed by timestamp.
+	cur, next := 0, 1
+	if len(opts.Parts) > 0 {
+		cur, next = -1, 0
+	}
+	partAt := func(ts time.Duration) int {
+		if ts < 0 && len(opts.Parts) == 0 {
+			return 0
+		}
+		for i, p := range parts {
+			if ts >= p.Start && (p.End == 0 || ts < p.End) {
+				return i
+			}
+		}
+		return -1
+	}
+	for {
+		f, err := d.ReadFrame()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return created, fmt.Errorf("matroska: could not read frame: %w", err)
+		}
+		var part int
+		if f.TrackNumber == ref {
+			if f.Keyframe {
+				if cur >= 0 && parts[cur].End != 0 && f.Timestamp >= parts[cur].End {
+					cur = -1
+				}
+				for next < len(parts) && f.Timestamp >= parts[next].Start {
+					cur, next = next, next+1
+				}
+				// Parts before the previous one cannot receive more frames.
+				for i := range cur - 1 {
+					if err := closePart(i); err != nil {
+						return created, err
+					}
+				}
+			}
+			part = cur
+		} else {
+			part = partAt(f.Timestamp)
+		}
+		if part < 0 || closed[part] {
+			continue
+		}
+		w, err := open(part)
+		if err != nil {
+			return created, err
+		}
+		f.Timestamp -= parts[part].Start
+		if err := w.WriteFrame(f); err != nil {
+			return created, err
+		}
+	}
+	for i := range parts {
+		if err := closePart(i); err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// splitParts returns the time ranges of the parts of a file. Every part
+// starts at a keyframe.
+func splitParts(keyframes []splitKeyframe, chapters *Chapters, opts SplitOptions) ([]SplitPart, error) {
+	modes := 0
+	for _, set := range []bool{opts.Duration != 0, opts.Size != 0, len(opts.Timestamps) > 0, len(opts.Parts) > 0, opts.Chapters} {
+		if set {
+			modes++
+		}
+	}
+	if modes != 1 {
+		return nil, errors.New("matroska: exactly one split mode must be set")
+	}
+	// keyframeAt returns the timestamp of the first keyframe at or after
+	// ts.
+	keyframeAt := func(ts time.Duration) (time.Duration, bool) {
+		i, _ := slices.BinarySearchFunc(keyframes, ts, func(k splitKeyframe, ts time.Duration) int {
+			return cmp.Compare(k.timestamp, ts)
+		})
+		if i == len(keyframes) {
+			return 0, false
+		}
+		return keyframes[i].timestamp, true
+	}
+
+	if len(opts.Parts) > 0 {
+		var parts []SplitPart
+		for i, p := range opts.Parts {
+			if p.Start < 0 || p.End != 0 && p.End <= p.Start {
+				return nil, fmt.Errorf("matroska: invalid part %v-%v", p.Start, p.End)
+			}
+			if i > 0 && (opts.Parts[i-1].End == 0 || p.Start < opts.Parts[i-1].End) {
+				return nil, fmt.Errorf("matroska: part %v-%v overlaps the previous part", p.Start, p.End)
+			}
+			start, ok := keyframeAt(p.Start)
+			if !ok {
+				continue
+			}
+			var end time.Duration
+			if p.End != 0 {
+				end, _ = keyframeAt(p.End)
+			}
+			if end != 0 && end <= start {
+				continue
+			}
+			parts = append(parts, SplitPart{Start: start, End: end})
+		}
+		if len(parts) == 0 {
+			return nil, errors.New("matroska: no keyframe in the parts")
+		}
+		return parts, nil
+	}
+
+	var cuts []time.Duration
+	cut := func(ts time.Duration) {
+		if ts > 0 && (len(cuts) == 0 || ts > cuts[len(cuts)-1]) {
+			cuts = append(cuts, ts)
+		}
+	}
+	switch {
+	case opts.Duration != 0:
+		if opts.Duration < 0 {
+			return nil, fmt.Errorf("matroska: invalid split duration %v", opts.Duration)
+		}
+		var start time.Duration
+		for _, k := range keyframes {
+			if k.timestamp-start >= opts.Duration {
+				cut(k.timestamp)
+				start = k.timestamp
+			}
+		}
+	case opts.Size != 0:
+		if opts.Size < 0 {
+			return nil, fmt.Errorf("matroska: invalid split size %d", opts.Size)
+		}
+		var start int64
+		for _, k := range keyframes {
+			if k.offset-start >= opts.Size {
+				cut(k.timestamp)
+				start = k.offset
+			}
+		}
+	default:
+		timestamps := slices.Clone(opts.Timestamps)
+		if opts.Chapters {
+			e, ok := DefaultEdition(chapters)
+			if !ok {
+				return nil, errors.New("matroska: missing Chapters element")
+			}
+			for _, a := range e.ChapterAtom {
+				timestamps = append(timestamps, time.Duration(a.ChapterTimeStart))
+			}
+		}
+		slices.Sort(timestamps)
+		for _, ts := range timestamps {
+			if k, ok := keyframeAt(ts); ok {
+				cut(k)
+			}
+		}
+	}
+	parts := []SplitPart{{}}
+	for _, c := range cuts {
+		parts[len(parts)-1].End = c
+		parts = append(parts, SplitPart{Start: c})
+	}
+	return parts, nil
+}
+
+// clipChapters returns the chapters overlapping the range from start to
+// end, with their timestamps clipped to the range and rebased to start.
+// An end of zero means the end of the file. A chapter without a
+// ChapterTimeEnd lasts until the next chapter of its level. Editions
+// without chapters are dropped, and nil is returned when no edition
+// remains.
+func clipChapters(c *Chapters, start, end time.Duration) *Chapters {
+	if c == nil {
+		return nil
+	}
+	var clip func(atoms []ChapterAtom, parentEnd time.Duration) []ChapterAtom
+	clip = func(atoms []ChapterAtom, parentEnd time.Duration) []ChapterAtom {
+		var clipped []ChapterAtom
+		for i, a := range atoms {
+			s, e := time.Duration(a.ChapterTimeStart), parentEnd
+			if a.ChapterTimeEnd != nil {
+				e = time.Duration(*a.ChapterTimeEnd)
+			} else if i+1 < len(atoms) {
+				e = time.Duration(atoms[i+1].ChapterTimeStart)
+			}
+			if end != 0 && s >= end || e != 0 && e <= start {
+				continue
+			}
+			a.ChapterAtom = clip(a.ChapterAtom, e)
+			a.ChapterTimeStart = uint(max(s, start) - start)
+			if a.ChapterTimeEnd != nil {
+				if end != 0 {
+					e = min(e, end)
+				}
+				timeEnd := uint(e - start)
+				a.ChapterTimeEnd = &timeEnd
+			}
+			clipped = append(clipped, a)
+		}
+		return clipped
+	}
+	var clipped Chapters
+	for _, e := range c.EditionEntry {
+		if e.ChapterAtom = clip(e.ChapterAtom, 0); len(e.ChapterAtom) > 0 {
+			clipped.EditionEntry = append(clipped.EditionEntry, e)
+		}
+	}
+	if len(clipped.EditionEntry) == 0 {
+		return nil
+	}
+	return &clipped
+}
@@ -0,0 +1,142 @@
+package matroska
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSplit(t *testing.T) {
+	second := uint(2500 * time.Millisecond)
+	chapters := &Chapters{EditionEntry: []EditionEntry{{
+		EditionFlagDefault: 1,
+		ChapterAtom: []ChapterAtom{
+			{ChapterUID: 1, ChapterTimeStart: 0, ChapterFlagEnabled: 1},
+			{ChapterUID: 2, ChapterTimeStart: second, ChapterFlagEnabled: 1},
+		},
+	}}}
+	// 5 seconds of video with a keyframe every second, and audio.
+	file := testAVFile(t, 125, 100, chapters)
+
+	split := func(t *testing.T, opts SplitOptions) []*MatroskaDemuxer {
+		t.Helper()
+		var parts []*writeSeeker
+		n, err := Split(bytes.NewReader(file), func(part int) (io.WriteSeeker, error) {
+			if part != len(parts) {
+				t.Errorf("part = %d, want %d", part, len(parts))
+			}
+			parts = append(parts, &writeSeeker{})
+			return parts[part], nil
+		}, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(parts) {
+			t.Errorf("Split returned %d, want %d", n, len(parts))
+		}
+		var ds []*MatroskaDemuxer
+		for _, p := range parts {
+			d, err := NewMatroskaDemuxer(bytes.NewReader(p.buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ds = append(ds, d)
+		}
+		return ds
+	}
+	// first returns the timestamp of the first video frame and the number
+	// of frames.
+	first := func(t *testing.T, d *MatroskaDemuxer) (time.Duration, int) {
+		t.Helper()
+		frames := readFrames(t, d)
+		for _, f := range frames {
+			if f.TrackNumber == 1 {
+				if !f.Keyframe {
+					t.Error("part does not start with a keyframe")
+				}
+				return f.Timestamp, len(frames)
+			}
+		}
+		t.Fatal("no video frame")
+		return 0, 0
+	}
+
+	t.Run("Duration", func(t *testing.T) {
+		parts := split(t, SplitOptions{Duration: 2 * time.Second, Link: true})
+		if len(parts) != 3 {
+			t.Fatalf("got %d parts, want 3", len(parts))
+		}
+		for i, d := range parts {
+			ts, n := first(t, d)
+			if ts != 0 {
+				t.Errorf("part %d starts at %v, want 0", i, ts)
+			}
+			if want := []int{100, 100, 50}[i]; n != want {
+				t.Errorf("part %d has %d frames, want %d", i, n, want)
+			}
+			info := d.Scanner().Info()
+			if want := []float64{1960, 1960, 960}[i]; *info.Duration != want {
+				t.Errorf("part %d Duration = %v, want %v", i, *info.Duration, want)
+			}
+			if i > 0 && (info.PrevUUID == nil || !bytes.Equal(*info.PrevUUID, *parts[i-1].Scanner().Info().SegmentUUID)) {
+				t.Errorf("part %d PrevUUID = %v, want the SegmentUUID of part %d", i, info.PrevUUID, i-1)
+			}
+			if i < len(parts)-1 && info.NextUUID == nil {
+				t.Errorf("part %d NextUUID = nil", i)
+			}
+		}
+		if bytes.Equal(*parts[0].Scanner().Info().SegmentUUID, *parts[1].Scanner().Info().SegmentUUID) {
+			t.Error("parts have the same SegmentUUID")
+		}
+		c := parts[1].Scanner().Chapters()
+		if c == nil {
+			t.Fatal("part 1 has no chapters")
+		}
+		atoms := c.EditionEntry[0].ChapterAtom
+		if len(atoms) != 2 || atoms[0].ChapterTimeStart != 0 || atoms[1].ChapterTimeStart != uint(500*time.Millisecond) {
+			t.Errorf("part 1 chapters = %+v", atoms)
+		}
+	})
+	t.Run("Size", func(t *testing.T) {
+		if parts := split(t, SplitOptions{Size: 2000}); len(parts) != 5 {
+			t.Errorf("got %d parts, want 5", len(parts))
+		}
+	})
+	t.Run("Timestamps", func(t *testing.T) {
+		if parts := split(t, SplitOptions{Timestamps: []time.Duration{500 * time.Millisecond, 4 * time.Second}}); len(parts) != 3 {
+			t.Errorf("got %d parts, want 3", len(parts))
+		}
+	})
+	t.Run("Parts", func(t *testing.T) {
+		parts := split(t, SplitOptions{Parts: []SplitPart{{Start: 1500 * time.Millisecond, End: 3500 * time.Millisecond}}})
+		if len(parts) != 1 {
+			t.Fatalf("got %d parts, want 1", len(parts))
+		}
+		if _, n := first(t, parts[0]); n != 100 {
+			t.Errorf("got %d frames, want 100", n)
+		}
+		if parts[0].Scanner().Info().NextUUID != nil || parts[0].Scanner().Info().PrevUUID != nil {
+			t.Error("unlinked part has PrevUUID or NextUUID")
+		}
+		atoms := parts[0].Scanner().Chapters().EditionEntry[0].ChapterAtom
+		if len(atoms) != 2 || atoms[1].ChapterTimeStart != uint(500*time.Millisecond) {
+			t.Errorf("chapters = %+v", atoms)
+		}
+	})
+	t.Run("Chapters", func(t *testing.T) {
+		parts := split(t, SplitOptions{Chapters: true})
+		if len(parts) != 2 {
+			t.Fatalf("got %d parts, want 2", len(parts))
+		}
+		atoms := parts[1].Scanner().Chapters().EditionEntry[0].ChapterAtom
+		if len(atoms) != 1 || atoms[0].ChapterUID != 2 || atoms[0].ChapterTimeStart != 0 {
+			t.Errorf("part 1 chapters = %+v", atoms)
+		}
+	})
+	t.Run("Modes", func(t *testing.T) {
+		if _, err := Split(bytes.NewReader(file), nil, SplitOptions{Duration: time.Second, Chapters: true}); err == nil {
+			t.Error("expected an error for two split modes")
+		}
+	})
+}
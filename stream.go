@@ -0,0 +1,415 @@
+package matroska
+
+import (
+	"errors"
+	"fmt"
+	"github.com/coding-socks/ebml"
+	"github.com/coding-socks/ebml/ebmltext"
+	"github.com/coding-socks/ebml/schema"
+	"io"
+	"math/bits"
+	"slices"
+	"time"
+)
+
+// ClusterBlock is a SimpleBlock or a BlockGroup read by NextBlock.
+type ClusterBlock struct {
+	// TrackNumber is the track number of the block.
+	TrackNumber uint
+	// ClusterTimestamp is the Timestamp of the Cluster of the block, which
+	// is the tsoffset of ReadSimpleBlock and ReadBlock.
+	ClusterTimestamp time.Duration
+	// SimpleBlock is the data of a SimpleBlock. It is nil for a BlockGroup.
+	// It is only valid until the next call of NextBlock, because its
+	// buffer is reused.
+	SimpleBlock []byte
+	// BlockGroup is nil for a SimpleBlock.
+	BlockGroup *BlockGroup
+}
+
+// NextBlock reads the next SimpleBlock or BlockGroup from the io.Reader
+// without reading the rest of its Cluster.
+//
+// It allows reading live streams, where the Segment and the Clusters have
+// an unknown size, with a low latency. A Cluster of unknown size ends at
+// the next top level element, and the io.Reader does not need to be an
+// io.Seeker. The CRC-32 elements of the Clusters are not verified.
+//
+// NextBlock reads the blocks into a reusable buffer instead of the
+// allocation window of the decoder, and the blocks of the tracks filtered
+// out by SetBlockFilter are skipped without copying their payload. With
+// an io.Seeker, the payload is not read at all.
+//
+// The block is accessible by calling Block. Next skips the rest of the
+// Cluster read by NextBlock.
+func (s *Scanner) NextBlock() bool {
+	s.err = s.Init()
+	if s.err != nil {
+		return false
+	}
+	s.live = true
+	for {
+		if s.blockCluster == nil {
+			if s.clusterEl == nil && !s.nextCluster() {
+				return false
+			}
+			s.blockCluster, s.blockOffset = s.clusterEl, 0
+			s.block = ClusterBlock{}
+			if s.clusterTimestamp != nil {
+				s.block.ClusterTimestamp = *s.clusterTimestamp
+			}
+			s.clusterEl, s.clusterTimestamp = nil, nil
+		}
+		if s.readBlock() {
+			return true
+		}
+		if s.err != nil {
+			return false
+		}
+	}
+}
+
+// SetBlockFilter makes NextBlock skip the blocks of the tracks for which
+// keep returns false. A nil keep reads every block.
+func (s *Scanner) SetBlockFilter(keep func(trackNumber uint) bool) {
+	s.keepBlock = keep
+}
+
+// Block returns the latest block read by NextBlock.
+func (s *Scanner) Block() ClusterBlock {
+	return s.block
+}
+
+// readBlock reads the children of blockCluster until the next block. It
+// returns false at the end of the Cluster, and when it fails.
+func (s *Scanner) readBlock() bool {
+	cluster := *s.blockCluster
+	for {
+		el, n, err := s.nextOf(cluster, s.blockOffset)
+		if err == io.EOF {
+			s.blockCluster = nil
+			return false
+		} else if errors.Is(err, ebml.ErrElementOverflow) {
+			el.DataSize = cluster.DataSize - s.blockOffset - int64(n)
+		} else if err != nil {
+			s.blockCluster = nil
+			if s.resilient {
+				_ = s.resync(s.positionBefore(n))
+				return false
+			}
+			s.err = fmt.Errorf("matroska: could not decode element: %w", err)
+			return false
+		}
+		start := s.positionBefore(n)
+		s.blockOffset += int64(n) + el.DataSize
+		switch el.ID {
+		default:
+			err = s.skip(el.DataSize)
+		case IDTimestamp:
+			var ts uint64
+			if ts, err = s.readUint(el); err == nil {
+				s.block.ClusterTimestamp = time.Duration(ts)
+			}
+		case IDSimpleBlock, IDBlockGroup:
+			var ok bool
+			if ok, err = s.readClusterBlock(el); ok && err == nil {
+				return true
+			}
+		}
+		if err != nil {
+			s.blockCluster = nil
+			if s.resilient {
+				_ = s.resync(start)
+				return false
+			}
+			s.err = fmt.Errorf("matroska: could not decode %v: %w", el.ID, err)
+			return false
+		}
+	}
+}
+
+// blockChunkSize is the size of the chunks in which readClusterBlock reads
+// the payload of a block.
+const blockChunkSize = 1 << 16
+
+// readClusterBlock reads the SimpleBlock or the BlockGroup el into block.
+// It returns false when the block is skipped by the filter of
+// SetBlockFilter, which is applied before the payload is read.
+func (s *Scanner) readClusterBlock(el ebml.Element) (bool, error) {
+	buf := s.blockBuf[:0]
+	remaining := el.DataSize
+	if el.ID == IDBlockGroup {
+		// Muxers write the Block first, whose track number is checked
+		// before the rest of the group is read.
+		child, n, err := s.readHeader()
+		if err != nil {
+			return false, err
+		}
+		if child.DataSize == -1 || int64(n)+child.DataSize > remaining {
+			return false, errors.New("matroska: invalid BlockGroup")
+		}
+		buf = appendElementHeader(buf, child.ID, child.DataSize)
+		remaining -= int64(n)
+		if child.ID != IDBlock {
+			// The track number is checked after the group is read.
+			el.ID = 0
+		}
+	}
+	// The track number is a VINT at the beginning of the block.
+	var track uint64
+	if el.ID != 0 {
+		i := len(buf)
+		buf = append(buf, 0)
+		if err := s.readFull(buf[i:]); err != nil {
+			return false, err
+		}
+		w := bits.LeadingZeros8(buf[i]) + 1
+		if w > 8 || int64(w) > remaining {
+			return false, errors.New("matroska: invalid track number")
+		}
+		buf = append(buf, make([]byte, w-1)...)
+		if err := s.readFull(buf[i+1:]); err != nil {
+			return false, err
+		}
+		track, _, _ = ebmltext.ReadVintData(buf[i:])
+		remaining -= int64(w)
+		if s.keepBlock != nil && !s.keepBlock(uint(track)) {
+			s.blockBuf = buf
+			return false, s.skip(remaining)
+		}
+	}
+	// The payload is read in chunks, so a damaged size only grows the
+	// buffer with the octets which actually arrive.
+	for remaining > 0 {
+		i, n := len(buf), int(min(remaining, blockChunkSize))
+		buf = slices.Grow(buf, n)[:i+n]
+		if err := s.readFull(buf[i:]); err != nil {
+			s.blockBuf = buf
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return false, err
+		}
+		remaining -= int64(n)
+	}
+	s.blockBuf = buf
+	s.block.SimpleBlock, s.block.BlockGroup = nil, nil
+	if el.ID == IDSimpleBlock {
+		s.block.TrackNumber, s.block.SimpleBlock = uint(track), buf
+		return true, nil
+	}
+	var g BlockGroup
+	if err := s.decodeData(ebml.Element{ID: IDBlockGroup, Schema: el.Schema}, buf, &g); err != nil {
+		return false, err
+	}
+	if el.ID == 0 {
+		t, _, err := ebmltext.ReadVintData(g.Block)
+		if err != nil {
+			return false, err
+		}
+		if s.keepBlock != nil && !s.keepBlock(uint(t)) {
+			return false, nil
+		}
+		track = t
+	}
+	s.block.TrackNumber, s.block.BlockGroup = uint(track), &g
+	return true, nil
+}
+
+// readUint reads the unsigned integer element el.
+func (s *Scanner) readUint(el ebml.Element) (uint64, error) {
+	if el.DataSize > 8 {
+		return 0, fmt.Errorf("matroska: invalid size %d of %v", el.DataSize, el.ID)
+	}
+	b := s.scratch[:el.DataSize]
+	if err := s.readFull(b); err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// readFull fills b. It reads from src directly when the decoder does not
+// hold any data, which keeps the payloads out of the allocation window of
+// the decoder. With an io.Seeker, the data held by the decoder is dropped
+// by seeking to the read position.
+func (s *Scanner) readFull(b []byte) error {
+	if ss, ok := s.decoder.AsSeeker(); ok && s.buffered && len(b) > 0 {
+		pos, err := ss.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if _, err := ss.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		s.buffered = false
+	}
+	for len(b) > 0 && s.buffered {
+		n := min(len(b), 8)
+		if err := s.readOctets(b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	if len(b) == 0 {
+		return nil
+	}
+	_, err := io.ReadFull(s.src, b)
+	return err
+}
+
+// skip skips n octets. It seeks when the io.Reader is an io.Seeker.
+func (s *Scanner) skip(n int64) error {
+	if ss, ok := s.decoder.AsSeeker(); ok {
+		_, err := ss.Seek(n, io.SeekCurrent)
+		return err
+	}
+	reads := s.src.reads
+	err := s.decoder.Skip(ebml.Element{DataSize: n})
+	if s.src.reads != reads {
+		s.buffered = false
+	}
+	return err
+}
+
+// pendingElement is an element whose header is read by nextOf.
+type pendingElement struct {
+	el ebml.Element
+	n  int
+}
+
+// nextOf is ebml.Decoder.NextOf, except that NextBlock reads the element
+// headers one octet at a time in elements of unknown size, and when the
+// io.Reader is not an io.Seeker. The decoder reads ahead while it reads a
+// header, which would wait for the next block of a live stream.
+func (s *Scanner) nextOf(parent ebml.Element, offset int64) (ebml.Element, int, error) {
+	d := s.decoder
+	if s.decoderPending || (s.pendingEl == nil && !s.octetHeaders(parent)) {
+		s.buffered = true
+		el, n, err := d.NextOf(parent, offset)
+		// The decoder keeps the element which ended parent.
+		s.decoderPending = err == io.EOF && parent.DataSize == -1
+		return el, n, err
+	}
+	if d.EndOfKnownDataSize(parent, offset) {
+		return ebml.Element{}, 0, io.EOF
+	}
+	var (
+		el  ebml.Element
+		n   int
+		err error
+	)
+	if s.pendingEl != nil {
+		el, n = s.pendingEl.el, s.pendingEl.n
+		s.pendingEl = nil
+	} else if el, n, err = s.readHeader(); err != nil {
+		return ebml.Element{}, n, err
+	}
+	if parent.DataSize != -1 && offset+el.DataSize > parent.DataSize {
+		err = ebml.ErrElementOverflow
+	}
+	if d.EndOfUnknownDataSize(parent, el) {
+		s.pendingEl = &pendingElement{el: el, n: n}
+		return ebml.Element{}, 0, io.EOF
+	}
+	return el, n, err
+}
+
+// octetHeaders reports whether nextOf reads the headers of the children
+// of parent one octet at a time.
+func (s *Scanner) octetHeaders(parent ebml.Element) bool {
+	if !s.live {
+		return false
+	}
+	_, seekable := s.decoder.AsSeeker()
+	return parent.DataSize == -1 || !seekable
+}
+
+// readHeader reads an element header one octet at a time.
+func (s *Scanner) readHeader() (ebml.Element, int, error) {
+	b := s.scratch[:]
+	n := 0
+	// read reads the VINT starting at b[n].
+	read := func() error {
+		if err := s.readFull(b[n : n+1]); err != nil {
+			return err
+		}
+		w := bits.LeadingZeros8(b[n]) + 1
+		if w > 8 || n+w > len(b) {
+			return ebml.ErrInvalidVINTLength
+		}
+		if err := s.readFull(b[n+1 : n+w]); err != nil {
+			return err
+		}
+		n += w
+		return nil
+	}
+	if err := read(); err != nil {
+		return ebml.Element{}, n, err
+	}
+	if n > 4 {
+		return ebml.Element{}, n, ebml.ErrInvalidVINTLength
+	}
+	if err := read(); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return ebml.Element{}, n, err
+	}
+	id, _, ds, ok := parseElementHeader(b[:n])
+	if !ok {
+		return ebml.Element{}, n, ebml.ErrInvalidVINTLength
+	}
+	el := ebml.Element{ID: id, DataSize: ds, Schema: ebml.UnknownSchema}
+	if sch, ok := validation().def.Get(id); ok {
+		el.Schema = sch
+	}
+	return el, n, nil
+}
+
+// readOctets fills b, which is at most 8 octets long, through the decoder.
+// Decoding into an ElementID does not use up the allocation window of the
+// decoder. The decoder reads from src after it runs out of data.
+func (s *Scanner) readOctets(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	var v schema.ElementID
+	reads := s.src.reads
+	err := s.decoder.Decode(ebml.Element{DataSize: int64(len(b)), Schema: schema.Element{Type: ebml.TypeBinary}}, &v)
+	if s.src.reads != reads {
+		s.buffered = false
+	}
+	if err != nil {
+		return err
+	}
+	for i := range b {
+		b[len(b)-1-i] = byte(v >> (8 * i))
+	}
+	return nil
+}
+
+// sourceReader is the io.Reader of the decoder of a Scanner. It counts the
+// reads, which tells when the decoder runs out of data.
+type sourceReader struct {
+	r     io.Reader
+	reads int
+}
+
+func (r *sourceReader) Read(p []byte) (int, error) {
+	r.reads++
+	return r.r.Read(p)
+}
+
+// sourceReadSeeker is a sourceReader of an io.ReadSeeker.
+type sourceReadSeeker struct {
+	*sourceReader
+	s io.Seeker
+}
+
+func (r sourceReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.s.Seek(offset, whence)
+}
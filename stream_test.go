@@ -0,0 +1,358 @@
+package matroska
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// testLiveFile returns the file of testFile as a live stream where the
+// Segment and the Clusters have an unknown size, and the end of each
+// SimpleBlock in it.
+func testLiveFile(t *testing.T) ([]byte, []int) {
+	t.Helper()
+	b, positions := testFile(t, WriterOptions{ClusterDuration: time.Second})
+	// The Segment header has an 8 octets long size.
+	live := appendElementHeader(bytes.Clone(b[:positions[0]-12]), IDSegment, -1)
+	var ends []int
+	for _, pos := range positions {
+		id, hl, ds, _ := parseElementHeader(b[pos:])
+		switch id {
+		case IDInfo, IDTracks:
+			live = append(live, b[pos:pos+hl+int(ds)]...)
+		case IDCluster:
+			live = appendElementHeader(live, IDCluster, -1)
+			for p := pos + hl; p < pos+hl+int(ds); {
+				id, chl, cds, _ := parseElementHeader(b[p:])
+				live = append(live, b[p:p+chl+int(cds)]...)
+				if id == IDSimpleBlock {
+					ends = append(ends, len(live))
+				}
+				p += chl + int(cds)
+			}
+		}
+	}
+	return live, ends
+}
+
+func TestScanner_NextBlock(t *testing.T) {
+	// check checks that the ith block is the ith frame of testFile.
+	check := func(t *testing.T, s *Scanner, i int) {
+		t.Helper()
+		block := s.Block()
+		if block.SimpleBlock == nil {
+			t.Fatalf("block %d is not a SimpleBlock", i)
+		}
+		b, err := ReadSimpleBlock(block.SimpleBlock, block.ClusterTimestamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := b.Timestamp(time.Millisecond), time.Duration(i)*100*time.Millisecond; got != want {
+			t.Errorf("block %d Timestamp = %v, want %v", i, got, want)
+		}
+		if got, want := b.Frames()[0], fmt.Appendf(nil, "frame%02d", i); !bytes.Equal(got, want) {
+			t.Errorf("block %d = %q, want %q", i, got, want)
+		}
+	}
+
+	t.Run("KnownSize", func(t *testing.T) {
+		b, _ := testFile(t, WriterOptions{ClusterDuration: time.Second})
+		s := NewScanner(bytes.NewReader(b))
+		n := 0
+		for ; s.NextBlock(); n++ {
+			check(t, s, n)
+		}
+		if err := s.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if n != 100 {
+			t.Errorf("got %d blocks, want 100", n)
+		}
+	})
+	t.Run("Live", func(t *testing.T) {
+		live, ends := testLiveFile(t)
+		pr, pw := io.Pipe()
+		read := make(chan struct{}, 1)
+		go func() {
+			// Each block is written only after the previous one is read.
+			prev := 0
+			for _, end := range ends {
+				if _, err := pw.Write(live[prev:end]); err != nil {
+					return
+				}
+				prev = end
+				select {
+				case <-read:
+				case <-time.After(5 * time.Second):
+					pw.CloseWithError(errors.New("timeout waiting for a block"))
+					return
+				}
+			}
+			pw.Close()
+		}()
+		s := NewScanner(pr)
+		n := 0
+		for ; s.NextBlock(); n++ {
+			check(t, s, n)
+			read <- struct{}{}
+		}
+		if err := s.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if n != 100 {
+			t.Errorf("got %d blocks, want 100", n)
+		}
+	})
+	t.Run("Next", func(t *testing.T) {
+		live, _ := testLiveFile(t)
+		s := NewScanner(struct{ io.Reader }{bytes.NewReader(live)})
+		for i := range 3 {
+			if !s.NextBlock() {
+				t.Fatal(s.Err())
+			}
+			check(t, s, i)
+		}
+		// Next skips the rest of the first Cluster.
+		if !s.Next() {
+			t.Fatal(s.Err())
+		}
+		if cl := s.Cluster(); cl.Timestamp != 1000 || len(cl.SimpleBlock) != 10 {
+			t.Errorf("got Cluster at %d with %d blocks", cl.Timestamp, len(cl.SimpleBlock))
+		}
+		if !s.NextBlock() {
+			t.Fatal(s.Err())
+		}
+		check(t, s, 20)
+	})
+	t.Run("BlockGroup", func(t *testing.T) {
+		var ws writeSeeker
+		sub := NewTrackEntry(1, TrackTypeSubtitle, SubtitleCodecTEXTUTF8)
+		w, err := NewWriter(&ws, Info{}, Tracks{TrackEntry: []TrackEntry{sub}}, WriterOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range 3 {
+			f := Frame{TrackNumber: 1, Timestamp: time.Duration(i) * time.Second, Duration: time.Second, Keyframe: true, Data: fmt.Appendf(nil, "line%d", i)}
+			if err := w.WriteFrame(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		s := NewScanner(struct{ io.Reader }{bytes.NewReader(ws.buf)})
+		n := 0
+		for ; s.NextBlock(); n++ {
+			g := s.Block().BlockGroup
+			if g == nil || g.BlockDuration == nil || *g.BlockDuration != 1000 {
+				t.Fatalf("block %d = %+v", n, s.Block())
+			}
+			b, err := ReadBlock(g.Block, s.Block().ClusterTimestamp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := b.Frames()[0], fmt.Appendf(nil, "line%d", n); !bytes.Equal(got, want) {
+				t.Errorf("block %d = %q, want %q", n, got, want)
+			}
+		}
+		if err := s.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if n != 3 {
+			t.Errorf("got %d blocks, want 3", n)
+		}
+	})
+	t.Run("Oversized", func(t *testing.T) {
+		live, _ := testLiveFile(t)
+		// Replace the blocks of the first Cluster with a SimpleBlock which
+		// claims to be much larger than the stream.
+		header := appendElementHeader(nil, IDCluster, -1)
+		p := bytes.Index(live, header)
+		_, hl, ds, _ := parseElementHeader(live[p+len(header):])
+		timestamp := live[p+len(header) : p+len(header)+hl+int(ds)]
+		block := append([]byte{0x81, 0, 0, SimpleBlockFlagKeyframe}, make([]byte, 100)...)
+		oversized := func(clusterSize int64) []byte {
+			b := appendElementHeader(bytes.Clone(live[:p]), IDCluster, clusterSize)
+			b = append(b, timestamp...)
+			b = appendElementHeader(b, IDSimpleBlock, 1<<40)
+			return append(b, block...)
+		}
+		tests := []struct {
+			name string
+			b    []byte
+		}{
+			{name: "UnknownSize", b: oversized(-1)},
+			{name: "KnownSize", b: oversized(1 << 41)},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				s := NewScanner(struct{ io.Reader }{bytes.NewReader(tt.b)})
+				if s.NextBlock() {
+					t.Fatalf("NextBlock() = true")
+				}
+				if !errors.Is(s.Err(), io.ErrUnexpectedEOF) {
+					t.Errorf("Err() = %v, want %v", s.Err(), io.ErrUnexpectedEOF)
+				}
+			})
+		}
+	})
+	t.Run("Resilient", func(t *testing.T) {
+		live, ends := testLiveFile(t)
+		// Damage the header of the sixth block.
+		damaged := bytes.Clone(live)
+		copy(damaged[ends[4]:], []byte{0xff, 0x00})
+		s := NewScanner(bytes.NewReader(damaged))
+		var skipped []SkippedRange
+		s.SetResilient(func(r SkippedRange) {
+			skipped = append(skipped, r)
+		})
+		n := 0
+		for s.NextBlock() {
+			n++
+		}
+		if err := s.Err(); err != nil {
+			t.Fatal(err)
+		}
+		// The rest of the first Cluster is lost.
+		if n != 95 || len(skipped) != 1 {
+			t.Errorf("got %d blocks, skipped = %v", n, skipped)
+		}
+	})
+}
+
+// testAVFile returns a file with the given chapters, a video track of frames of
+// videoSize bytes with a keyframe every second, and an audio track of small
+// frames. Both tracks have a frame every 40 ms.
+func testAVFile(tb testing.TB, frames, videoSize int, chapters *Chapters) []byte {
+	tb.Helper()
+	var ws writeSeeker
+	video := NewTrackEntry(1, TrackTypeVideo, VideoCodecMSCOMP)
+	video.Video = &Video{PixelWidth: 3840, PixelHeight: 2160}
+	audio := NewTrackEntry(2, TrackTypeAudio, AudioCodecOPUS)
+	audio.Audio = &Audio{SamplingFrequency: 48000, Channels: 2}
+	w, err := NewWriter(&ws, Info{}, Tracks{TrackEntry: []TrackEntry{video, audio}}, WriterOptions{ClusterDuration: time.Second})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	w.SetChapters(chapters)
+	data := bytes.Repeat([]byte{0xaa}, videoSize)
+	for i := range frames {
+		ts := time.Duration(i) * 40 * time.Millisecond
+		if err := w.WriteFrame(Frame{TrackNumber: 1, Timestamp: ts, Keyframe: i%25 == 0, Data: data}); err != nil {
+			tb.Fatal(err)
+		}
+		if err := w.WriteFrame(Frame{TrackNumber: 2, Timestamp: ts, Keyframe: true, Data: fmt.Appendf(nil, "audio%03d", i)}); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return ws.buf
+}
+
+func TestScanner_SetBlockFilter(t *testing.T) {
+	b := testAVFile(t, 100, 4096, nil)
+	tests := []struct {
+		name string
+		r    io.Reader
+	}{
+		{name: "Seekable", r: bytes.NewReader(b)},
+		{name: "NotSeekable", r: struct{ io.Reader }{bytes.NewReader(b)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScanner(tt.r)
+			s.SetBlockFilter(func(trackNumber uint) bool {
+				return trackNumber == 2
+			})
+			n := 0
+			var prev []byte
+			for ; s.NextBlock(); n++ {
+				block := s.Block()
+				if block.TrackNumber != 2 {
+					t.Fatalf("got block of track %d", block.TrackNumber)
+				}
+				sb, err := ReadSimpleBlock(block.SimpleBlock, block.ClusterTimestamp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := sb.Frames()[0], fmt.Appendf(nil, "audio%03d", n); !bytes.Equal(got, want) {
+					t.Errorf("block %d = %q, want %q", n, got, want)
+				}
+				if got, want := sb.Timestamp(time.Millisecond), time.Duration(n)*40*time.Millisecond; got != want {
+					t.Errorf("block %d Timestamp = %v, want %v", n, got, want)
+				}
+				// The buffer is reused.
+				if prev != nil && &prev[0] != &block.SimpleBlock[0] {
+					t.Errorf("block %d is not in the reused buffer", n)
+				}
+				prev = block.SimpleBlock
+			}
+			if err := s.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if n != 100 {
+				t.Errorf("got %d blocks, want 100", n)
+			}
+		})
+	}
+}
+
+func BenchmarkScanner(b *testing.B) {
+	file := testAVFile(b, 250, 256<<10, nil)
+	b.Run("Next", func(b *testing.B) {
+		b.SetBytes(int64(len(file)))
+		b.ReportAllocs()
+		for range b.N {
+			s := NewScanner(bytes.NewReader(file))
+			for s.Next() {
+			}
+			if err := s.Err(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("NextBlock", func(b *testing.B) {
+		b.SetBytes(int64(len(file)))
+		b.ReportAllocs()
+		for range b.N {
+			s := NewScanner(bytes.NewReader(file))
+			for s.NextBlock() {
+			}
+			if err := s.Err(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("NextBlockNotSeekable", func(b *testing.B) {
+		b.SetBytes(int64(len(file)))
+		b.ReportAllocs()
+		for range b.N {
+			s := NewScanner(struct{ io.Reader }{bytes.NewReader(file)})
+			for s.NextBlock() {
+			}
+			if err := s.Err(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("NextBlockFilter", func(b *testing.B) {
+		b.SetBytes(int64(len(file)))
+		b.ReportAllocs()
+		for range b.N {
+			s := NewScanner(bytes.NewReader(file))
+			s.SetBlockFilter(func(trackNumber uint) bool {
+				return trackNumber == 2
+			})
+			for s.NextBlock() {
+			}
+			if err := s.Err(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,429 @@
+package matroska
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TargetTypeValue levels according to Section 5.1.8.1.1.1 of rfc9559.
+// https://datatracker.ietf.org/doc/html/rfc9559#name-targettypevalue-element
+const (
+	TargetTypeValueShot       = 10
+	TargetTypeValueSubtrack   = 20 // SUBTRACK / MOVEMENT / SCENE
+	TargetTypeValueTrack      = 30 // TRACK / SONG / CHAPTER
+	TargetTypeValuePart       = 40 // PART / SESSION
+	TargetTypeValueAlbum      = 50 // ALBUM / OPERA / CONCERT / MOVIE / EPISODE
+	TargetTypeValueEdition    = 60 // EDITION / ISSUE / VOLUME / OPUS / SEASON / SEQUEL
+	TargetTypeValueCollection = 70
+
+	TargetTypeValueMovement = TargetTypeValueSubtrack
+	TargetTypeValueScene    = TargetTypeValueSubtrack
+	TargetTypeValueSong     = TargetTypeValueTrack
+	TargetTypeValueChapter  = TargetTypeValueTrack
+	TargetTypeValueSession  = TargetTypeValuePart
+	TargetTypeValueOpera    = TargetTypeValueAlbum
+	TargetTypeValueConcert  = TargetTypeValueAlbum
+	TargetTypeValueMovie    = TargetTypeValueAlbum
+	TargetTypeValueEpisode  = TargetTypeValueAlbum
+	TargetTypeValueIssue    = TargetTypeValueEdition
+	TargetTypeValueVolume   = TargetTypeValueEdition
+	TargetTypeValueOpus     = TargetTypeValueEdition
+	TargetTypeValueSeason   = TargetTypeValueEdition
+	TargetTypeValueSequel   = TargetTypeValueEdition
+)
+
+// Official tag names. This is a subset of the list maintained by Matroska.
+// https://www.matroska.org/technical/tagging.html
+const (
+	TagNameTitle           = "TITLE"
+	TagNameSubtitle        = "SUBTITLE"
+	TagNameArtist          = "ARTIST"
+	TagNameDirector        = "DIRECTOR"
+	TagNameComposer        = "COMPOSER"
+	TagNameGenre           = "GENRE"
+	TagNameDescription     = "DESCRIPTION"
+	TagNameComment         = "COMMENT"
+	TagNamePartNumber      = "PART_NUMBER"
+	TagNameTotalParts      = "TOTAL_PARTS"
+	TagNameDateReleased    = "DATE_RELEASED"
+	TagNameDateRecorded    = "DATE_RECORDED"
+	TagNameDateEncoded     = "DATE_ENCODED"
+	TagNameEncoder         = "ENCODER"
+	TagNameEncoderSettings = "ENCODER_SETTINGS"
+	TagNameSortWith        = "SORT_WITH"
+	TagNameURL             = "URL"
+	TagNameBPS             = "BPS"
+	TagNameDuration        = "DURATION"
+	TagNameNumberOfFrames  = "NUMBER_OF_FRAMES"
+	TagNameNumberOfBytes   = "NUMBER_OF_BYTES"
+)
+
+// dropDefaultTargetUIDs removes the default UID 0 which the decoder
+// prepopulates the UIDs of every Targets element with. The decoded UIDs
+// are appended after it. The default is never kept, because a missing UID
+// means that the Tag applies to the whole Segment, while the UID 0 means
+// that it applies to every element of that kind.
+func dropDefaultTargetUIDs(t *Tags) {
+	drop := func(uids []uint) []uint {
+		if len(uids) <= 1 {
+			return nil
+		}
+		return uids[1:]
+	}
+	for i := range t.Tag {
+		tt := &t.Tag[i].Targets
+		tt.TagTrackUID = drop(tt.TagTrackUID)
+		tt.TagEditionUID = drop(tt.TagEditionUID)
+		tt.TagChapterUID = drop(tt.TagChapterUID)
+		tt.TagAttachmentUID = drop(tt.TagAttachmentUID)
+	}
+}
+
+// TagTarget is the resolved Targets element of a Tag.
+//
+// Without any UID the Tag applies to the whole Segment at the given level.
+// A UID with the value 0 also means that the Tag applies to every element
+// of that kind.
+type TagTarget struct {
+	// TargetTypeValue is the logical level of the target.
+	TargetTypeValue uint
+	// TargetType is an informational string of the level, like "MOVIE".
+	TargetType string
+
+	Tracks      []*TrackEntry
+	Editions    []*EditionEntry
+	Chapters    []*ChapterAtom
+	Attachments []*AttachedFile
+}
+
+// Segment reports whether the Tag applies to the whole Segment.
+func (t TagTarget) Segment() bool {
+	return len(t.Tracks) == 0 && len(t.Editions) == 0 && len(t.Chapters) == 0 && len(t.Attachments) == 0
+}
+
+// ResolvedTag is a Tag with its Targets resolved to the elements of the
+// Segment it applies to.
+type ResolvedTag struct {
+	Tag    *Tag
+	Target TagTarget
+}
+
+// ResolveTags resolves the Targets of every Tag. Any of the arguments
+// except tags can be nil, and UIDs without a matching element are ignored.
+func ResolveTags(tags *Tags, tracks *Tracks, chapters *Chapters, attachments *Attachments) []ResolvedTag {
+	if tags == nil {
+		return nil
+	}
+	resolved := make([]ResolvedTag, len(tags.Tag))
+	for i := range tags.Tag {
+		tag := &tags.Tag[i]
+		tt := tag.Targets
+		target := TagTarget{TargetTypeValue: tt.TargetTypeValue}
+		if tt.TargetType != nil {
+			target.TargetType = *tt.TargetType
+		}
+		if tracks != nil {
+			for _, uid := range tt.TagTrackUID {
+				for j := range tracks.TrackEntry {
+					if uid == 0 || tracks.TrackEntry[j].TrackUID == uid {
+						target.Tracks = append(target.Tracks, &tracks.TrackEntry[j])
+					}
+				}
+			}
+		}
+		if chapters != nil {
+			for _, uid := range tt.TagEditionUID {
+				for j := range chapters.EditionEntry {
+					e := &chapters.EditionEntry[j]
+					if uid == 0 || (e.EditionUID != nil && *e.EditionUID == uid) {
+						target.Editions = append(target.Editions, e)
+					}
+				}
+			}
+			for _, uid := range tt.TagChapterUID {
+				for j := range chapters.EditionEntry {
+					target.Chapters = appendChapterAtoms(target.Chapters, chapters.EditionEntry[j].ChapterAtom, uid)
+				}
+			}
+		}
+		if attachments != nil {
+			for _, uid := range tt.TagAttachmentUID {
+				for j := range attachments.AttachedFile {
+					if uid == 0 || attachments.AttachedFile[j].FileUID == uid {
+						target.Attachments = append(target.Attachments, &attachments.AttachedFile[j])
+					}
+				}
+			}
+		}
+		resolved[i] = ResolvedTag{Tag: tag, Target: target}
+	}
+	return resolved
+}
+
+func appendChapterAtoms(dst []*ChapterAtom, atoms []ChapterAtom, uid uint) []*ChapterAtom {
+	for i := range atoms {
+		if uid == 0 || atoms[i].ChapterUID == uid {
+			dst = append(dst, &atoms[i])
+		}
+		dst = appendChapterAtoms(dst, atoms[i].ChapterAtom, uid)
+	}
+	return dst
+}
+
+// Find returns the SimpleTag found by following the names through nested
+// SimpleTag elements, for example "ARTIST", "SORT_WITH".
+//
+// When several SimpleTag elements share a name, the one matching language
+// by TagLanguageBCP47 or TagLanguage is preferred, followed by the one with
+// TagDefault set, followed by the first one. An empty language skips the
+// first step.
+func (t ResolvedTag) Find(language string, names ...string) (*SimpleTag, bool) {
+	if t.Tag == nil || len(names) == 0 {
+		return nil, false
+	}
+	tags := t.Tag.SimpleTag
+	var st *SimpleTag
+	for _, name := range names {
+		st = findSimpleTag(tags, name, language)
+		if st == nil {
+			return nil, false
+		}
+		tags = st.SimpleTag
+	}
+	return st, true
+}
+
+func findSimpleTag(tags []SimpleTag, name, language string) *SimpleTag {
+	var def, first *SimpleTag
+	for i := range tags {
+		st := &tags[i]
+		if !strings.EqualFold(st.TagName, name) {
+			continue
+		}
+		if language != "" && (st.TagLanguage == language || (st.TagLanguageBCP47 != nil && *st.TagLanguageBCP47 == language)) {
+			return st
+		}
+		if def == nil && st.TagDefault == 1 {
+			def = st
+		}
+		if first == nil {
+			first = st
+		}
+	}
+	if def != nil {
+		return def
+	}
+	return first
+}
+
+// String returns the TagString of the SimpleTag found by Find.
+func (t ResolvedTag) String(language string, names ...string) (string, bool) {
+	st, ok := t.Find(language, names...)
+	if !ok || st.TagString == nil {
+		return "", false
+	}
+	return *st.TagString, true
+}
+
+// Title returns the TITLE of the target.
+func (t ResolvedTag) Title(language string) (string, bool) {
+	return t.String(language, TagNameTitle)
+}
+
+// Artist returns the ARTIST of the target.
+func (t ResolvedTag) Artist(language string) (string, bool) {
+	return t.String(language, TagNameArtist)
+}
+
+// Encoder returns the ENCODER of the target.
+func (t ResolvedTag) Encoder() (string, bool) {
+	return t.String("", TagNameEncoder)
+}
+
+// DateReleased returns the DATE_RELEASED of the target. Dates use the
+// ISO 8601 subset allowed by the Matroska tagging specification, so
+// "2006", "2006-03", "2006-03-21" and "2006-03-21 14:30:00" are valid.
+func (t ResolvedTag) DateReleased() (time.Time, bool) {
+	s, ok := t.String("", TagNameDateReleased)
+	if !ok {
+		return time.Time{}, false
+	}
+	return parseTagDate(s)
+}
+
+// PartNumber returns the PART_NUMBER of the target.
+func (t ResolvedTag) PartNumber() (int, bool) {
+	s, ok := t.String("", TagNamePartNumber)
+	if !ok {
+		return 0, false
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(s))
+	return i, err == nil
+}
+
+var tagDateLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+func parseTagDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range tagDateLayouts {
+		if d, err := time.Parse(layout, s); err == nil {
+			return d, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// tagsXML types mirror the XML format used by mkvextract and mkvmerge.
+// See: https://mkvtoolnix.download/doc/mkvmerge.html#mkvmerge.tags
+type tagsXML struct {
+	XMLName xml.Name `xml:"Tags"`
+	Tag     []tagXML `xml:"Tag"`
+}
+
+type tagXML struct {
+	Targets targetsXML     `xml:"Targets"`
+	Simple  []simpleTagXML `xml:"Simple"`
+}
+
+type targetsXML struct {
+	TargetTypeValue uint    `xml:"TargetTypeValue,omitempty"`
+	TargetType      *string `xml:"TargetType,omitempty"`
+	TrackUID        []uint  `xml:"TrackUID"`
+	EditionUID      []uint  `xml:"EditionUID"`
+	ChapterUID      []uint  `xml:"ChapterUID"`
+	AttachmentUID   []uint  `xml:"AttachmentUID"`
+}
+
+type simpleTagXML struct {
+	Name            string         `xml:"Name"`
+	String          *string        `xml:"String,omitempty"`
+	Binary          *binaryXML     `xml:"Binary,omitempty"`
+	TagLanguage     string         `xml:"TagLanguage,omitempty"`
+	TagLanguageIETF *string        `xml:"TagLanguageIETF,omitempty"`
+	DefaultLanguage uint           `xml:"DefaultLanguage"`
+	Simple          []simpleTagXML `xml:"Simple"`
+}
+
+func newTagsXML(t *Tags) tagsXML {
+	var tx tagsXML
+	for _, tag := range t.Tag {
+		x := tagXML{Targets: targetsXML{
+			TargetTypeValue: tag.Targets.TargetTypeValue,
+			TargetType:      tag.Targets.TargetType,
+			TrackUID:        tag.Targets.TagTrackUID,
+			EditionUID:      tag.Targets.TagEditionUID,
+			ChapterUID:      tag.Targets.TagChapterUID,
+			AttachmentUID:   tag.Targets.TagAttachmentUID,
+		}}
+		for _, st := range tag.SimpleTag {
+			x.Simple = append(x.Simple, newSimpleTagXML(st))
+		}
+		tx.Tag = append(tx.Tag, x)
+	}
+	return tx
+}
+
+func newSimpleTagXML(st SimpleTag) simpleTagXML {
+	x := simpleTagXML{
+		Name:            st.TagName,
+		String:          st.TagString,
+		TagLanguage:     st.TagLanguage,
+		TagLanguageIETF: st.TagLanguageBCP47,
+		DefaultLanguage: st.TagDefault,
+	}
+	if st.TagBinary != nil {
+		x.Binary = newBinaryXML(*st.TagBinary)
+	}
+	for _, child := range st.SimpleTag {
+		x.Simple = append(x.Simple, newSimpleTagXML(child))
+	}
+	return x
+}
+
+func (tx tagsXML) tags() (*Tags, error) {
+	t := &Tags{}
+	for _, x := range tx.Tag {
+		tag := Tag{Targets: Targets{
+			TargetTypeValue:  x.Targets.TargetTypeValue,
+			TargetType:       x.Targets.TargetType,
+			TagTrackUID:      x.Targets.TrackUID,
+			TagEditionUID:    x.Targets.EditionUID,
+			TagChapterUID:    x.Targets.ChapterUID,
+			TagAttachmentUID: x.Targets.AttachmentUID,
+		}}
+		if tag.Targets.TargetTypeValue == 0 {
+			tag.Targets.TargetTypeValue = TargetTypeValueAlbum
+		}
+		for _, sx := range x.Simple {
+			st, err := sx.simpleTag()
+			if err != nil {
+				return nil, err
+			}
+			tag.SimpleTag = append(tag.SimpleTag, st)
+		}
+		t.Tag = append(t.Tag, tag)
+	}
+	return t, nil
+}
+
+func (x simpleTagXML) simpleTag() (SimpleTag, error) {
+	st := SimpleTag{
+		TagName:          x.Name,
+		TagString:        x.String,
+		TagLanguage:      x.TagLanguage,
+		TagLanguageBCP47: x.TagLanguageIETF,
+		TagDefault:       x.DefaultLanguage,
+	}
+	if st.TagLanguage == "" {
+		st.TagLanguage = "und"
+	}
+	if x.Binary != nil {
+		b, err := x.Binary.bytes()
+		if err != nil {
+			return SimpleTag{}, fmt.Errorf("matroska: invalid Binary of %s: %w", x.Name, err)
+		}
+		st.TagBinary = &b
+	}
+	for _, child := range x.Simple {
+		c, err := child.simpleTag()
+		if err != nil {
+			return SimpleTag{}, err
+		}
+		st.SimpleTag = append(st.SimpleTag, c)
+	}
+	return st, nil
+}
+
+// ReadTagsXML reads tags in the XML format used by mkvmerge.
+func ReadTagsXML(r io.Reader) (*Tags, error) {
+	var tx tagsXML
+	if err := xml.NewDecoder(r).Decode(&tx); err != nil {
+		return nil, fmt.Errorf("matroska: could not decode tags: %w", err)
+	}
+	return tx.tags()
+}
+
+// WriteTagsXML writes t in the XML format used by mkvextract.
+func WriteTagsXML(w io.Writer, t *Tags) error {
+	if _, err := io.WriteString(w, xml.Header+"<!-- <!DOCTYPE Tags SYSTEM \"matroskatags.dtd\"> -->\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(newTagsXML(t)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
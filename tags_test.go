@@ -0,0 +1,164 @@
+package matroska
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testTags() *Tags {
+	str := func(s string) *string { return &s }
+	return &Tags{Tag: []Tag{
+		{
+			Targets: Targets{TargetTypeValue: TargetTypeValueMovie, TargetType: str("MOVIE")},
+			SimpleTag: []SimpleTag{
+				{TagName: TagNameTitle, TagLanguage: "ger", TagLanguageBCP47: str("de"), TagDefault: 0, TagString: str("Der Film")},
+				{TagName: TagNameTitle, TagLanguage: "eng", TagDefault: 1, TagString: str("The Movie & more")},
+				{TagName: TagNameArtist, TagLanguage: "und", TagDefault: 1, TagString: str("Someone"), SimpleTag: []SimpleTag{
+					{TagName: TagNameSortWith, TagLanguage: "und", TagDefault: 1, TagString: str("One, Some")},
+				}},
+				{TagName: TagNameDateReleased, TagLanguage: "und", TagDefault: 1, TagString: str("2006-03")},
+				{TagName: "COVER_HASH", TagLanguage: "und", TagDefault: 1, TagBinary: &[]byte{0xde, 0xad}},
+			},
+		},
+		{
+			Targets: Targets{TargetTypeValue: TargetTypeValueTrack, TagTrackUID: []uint{2}},
+			SimpleTag: []SimpleTag{
+				{TagName: TagNameEncoder, TagLanguage: "und", TagDefault: 1, TagString: str("libfoo 1.0")},
+			},
+		},
+		{
+			Targets: Targets{TargetTypeValue: TargetTypeValueChapter, TagChapterUID: []uint{11}, TagAttachmentUID: []uint{0}},
+			SimpleTag: []SimpleTag{
+				{TagName: TagNameTitle, TagLanguage: "und", TagDefault: 1, TagString: str("Part 2")},
+			},
+		},
+	}}
+}
+
+func TestResolveTags(t *testing.T) {
+	tracks := &Tracks{TrackEntry: []TrackEntry{{TrackNumber: 1, TrackUID: 1}, {TrackNumber: 2, TrackUID: 2}}}
+	attachments := &Attachments{AttachedFile: []AttachedFile{{FileUID: 5}, {FileUID: 6}}}
+	resolved := ResolveTags(testTags(), tracks, testChapters(), attachments)
+	if got, want := len(resolved), 3; got != want {
+		t.Fatalf("len(ResolveTags()) = %d, want %d", got, want)
+	}
+
+	movie := resolved[0]
+	if !movie.Target.Segment() {
+		t.Errorf("Segment() = false, want true")
+	}
+	for _, tt := range []struct{ language, want string }{
+		{"", "The Movie & more"},
+		{"de", "Der Film"},
+		{"ger", "Der Film"},
+		{"fre", "The Movie & more"},
+	} {
+		if got, _ := movie.Title(tt.language); got != tt.want {
+			t.Errorf("Title(%q) = %q, want %q", tt.language, got, tt.want)
+		}
+	}
+	if got, _ := movie.String("", TagNameArtist, TagNameSortWith); got != "One, Some" {
+		t.Errorf("String(ARTIST, SORT_WITH) = %q, want %q", got, "One, Some")
+	}
+	if got, ok := movie.DateReleased(); !ok || !got.Equal(time.Date(2006, time.March, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("DateReleased() = %v, %v", got, ok)
+	}
+	if _, ok := movie.Encoder(); ok {
+		t.Errorf("Encoder() found a value, want none")
+	}
+
+	track := resolved[1]
+	if len(track.Target.Tracks) != 1 || track.Target.Tracks[0].TrackNumber != 2 {
+		t.Errorf("Tracks = %v, want track 2", track.Target.Tracks)
+	}
+	if got, _ := track.Encoder(); got != "libfoo 1.0" {
+		t.Errorf("Encoder() = %q, want %q", got, "libfoo 1.0")
+	}
+
+	chapter := resolved[2]
+	if len(chapter.Target.Chapters) != 1 || chapter.Target.Chapters[0].ChapterUID != 11 {
+		t.Errorf("Chapters = %v, want nested chapter 11", chapter.Target.Chapters)
+	}
+	if got := len(chapter.Target.Attachments); got != 2 {
+		t.Errorf("len(Attachments) = %d, want 2", got)
+	}
+}
+
+func TestScanner_Tags(t *testing.T) {
+	// The Tag of the track targets the TrackUID 2.
+	track := NewTrackEntry(1, TrackTypeAudio, AudioCodecMP3)
+	track.TrackUID = 2
+	s := NewScanner(bytes.NewReader(matroskaFile(t, track, nil, testTags())))
+	resolved := ResolveTags(s.Tags(), s.Tracks(), nil, nil)
+	if got, want := len(resolved), 3; got != want {
+		t.Fatalf("len(ResolveTags()) = %d, want %d", got, want)
+	}
+	if target := resolved[0].Target; !target.Segment() {
+		t.Errorf("Segment() = false, want true: %+v", resolved[0].Tag.Targets)
+	}
+	if tracks := resolved[1].Target.Tracks; len(tracks) != 1 || tracks[0].TrackUID != 2 {
+		t.Errorf("Tracks = %+v, want the track once: %+v", tracks, resolved[1].Tag.Targets)
+	}
+	// The stored UID 0 is kept.
+	if got := resolved[2].Tag.Targets.TagAttachmentUID; !reflect.DeepEqual(got, []uint{0}) {
+		t.Errorf("TagAttachmentUID = %v, want [0]", got)
+	}
+}
+
+func TestParseTagDate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+		ok   bool
+	}{
+		{"2006", time.Date(2006, time.January, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2006-03-21", time.Date(2006, time.March, 21, 0, 0, 0, 0, time.UTC), true},
+		{"2006-03-21 14:30:05", time.Date(2006, time.March, 21, 14, 30, 5, 0, time.UTC), true},
+		{"21/03/2006", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseTagDate(tt.in)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("parseTagDate(%q) = %v, %v, want %v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestWriteTagsXML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteTagsXML(&buf, testTags()); err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "tags.xml.golden")
+	if *flagUpdate {
+		if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestReadTagsXML(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "tags.xml.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := ReadTagsXML(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testTags(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadTagsXML() = %+v, want %+v", got, want)
+	}
+}
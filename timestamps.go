@@ -0,0 +1,129 @@
+package matroska
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TimestampFormat is the format of a timestamp file as used by mkvmerge
+// and mkvextract.
+type TimestampFormat int
+
+const (
+	// TimestampFormatV2 lists one timestamp per frame in milliseconds.
+	TimestampFormatV2 TimestampFormat = 2
+	// TimestampFormatV4 has the same layout as TimestampFormatV2, only the
+	// header line differs.
+	TimestampFormatV4 TimestampFormat = 4
+)
+
+// TrackTimestamps reads the remaining clusters of s and returns the
+// presentation timestamp of every frame of track t in display order.
+//
+// The timestamp of a block is the sum of the Cluster Timestamp and the
+// relative timestamp of the block, multiplied by TimestampScale and
+// TrackTimestampScale, minus CodecDelay. The frames of a laced block
+// follow each other by DefaultDuration, or by the BlockDuration divided
+// between them when DefaultDuration is missing.
+//
+// Blocks are stored in decoding order, so tracks with B-frames have
+// timestamps which are not monotonic. Sorting them restores the display
+// order.
+func TrackTimestamps(s *Scanner, t TrackEntry) ([]time.Duration, error) {
+	if err := s.Init(); err != nil {
+		return nil, err
+	}
+	scale := s.Info().TimestampScale
+	var ts []time.Duration
+	for s.Next() {
+		var err error
+		if ts, err = appendClusterTimestamps(ts, s.Cluster(), t, scale); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	slices.Sort(ts)
+	return ts, nil
+}
+
+// appendClusterTimestamps appends the timestamps of the frames of track t
+// found in c to ts in decoding order.
+func appendClusterTimestamps(ts []time.Duration, c Cluster, t TrackEntry, scale time.Duration) ([]time.Duration, error) {
+	trackScale := t.TrackTimestampScale
+	if trackScale == 0 {
+		trackScale = 1
+	}
+	ticks := func(d time.Duration) time.Duration {
+		return time.Duration(float64(d) * float64(scale) * trackScale)
+	}
+	codecDelay := time.Duration(t.CodecDelay)
+	appendFrames := func(start time.Duration, frames int, duration *uint) {
+		var step time.Duration
+		if t.DefaultDuration != nil {
+			step = time.Duration(*t.DefaultDuration)
+		} else if duration != nil {
+			step = ticks(time.Duration(*duration)) / time.Duration(frames)
+		}
+		for i := range frames {
+			ts = append(ts, start+time.Duration(i)*step-codecDelay)
+		}
+	}
+	for i := range c.SimpleBlock {
+		block, err := ReadSimpleBlock(c.SimpleBlock[i], c.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("matroska: could not create block struct: %w", err)
+		}
+		if block.TrackNumber() != t.TrackNumber {
+			continue
+		}
+		appendFrames(ticks(block.timestamp), len(block.Frames()), nil)
+	}
+	for _, group := range c.BlockGroup {
+		block, err := ReadBlock(group.Block, c.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("matroska: could not create block struct: %w", err)
+		}
+		if block.TrackNumber() != t.TrackNumber {
+			continue
+		}
+		appendFrames(ticks(block.timestamp), len(block.Frames()), group.BlockDuration)
+	}
+	return ts, nil
+}
+
+// WriteTimestamps writes ts as a timestamp file of the given format.
+// Timestamps are written in the order of ts, in milliseconds with up to
+// nanosecond precision.
+func WriteTimestamps(w io.Writer, ts []time.Duration, format TimestampFormat) error {
+	switch format {
+	case TimestampFormatV2, TimestampFormatV4:
+	default:
+		return fmt.Errorf("matroska: unsupported timestamp format v%d", format)
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# timestamp format v%d\n", format)
+	for _, t := range ts {
+		bw.WriteString(timestampMillis(t))
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// timestampMillis formats d in milliseconds without trailing zeros.
+func timestampMillis(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	ms, ns := d/time.Millisecond, d%time.Millisecond
+	if ns == 0 {
+		return fmt.Sprintf("%s%d", sign, ms)
+	}
+	return fmt.Sprintf("%s%d.%s", sign, ms, strings.TrimRight(fmt.Sprintf("%06d", ns), "0"))
+}
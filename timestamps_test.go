@@ -0,0 +1,107 @@
+package matroska
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAppendClusterTimestamps(t *testing.T) {
+	ptr := func(v uint) *uint { return &v }
+	// block builds a block of track 1 with a relative timestamp and n frames
+	// of fixed-size lacing.
+	block := func(rel int16, flags uint8, n int) []byte {
+		b := []byte{0x81, byte(uint16(rel) >> 8), byte(rel), flags}
+		if n > 1 {
+			b[3] |= LacingFlagFixedSize
+			b = append(b, byte(n-1))
+		}
+		return append(b, make([]byte, n*8)...)
+	}
+	tests := []struct {
+		name  string
+		track TrackEntry
+		c     Cluster
+		want  []time.Duration
+	}{
+		{
+			name:  "B-frames",
+			track: TrackEntry{TrackNumber: 1, TrackTimestampScale: 1},
+			c: Cluster{Timestamp: 1000, SimpleBlock: [][]byte{
+				block(0, SimpleBlockFlagKeyframe, 1),
+				block(80, 0, 1),
+				block(40, 0, 1),
+				{0x82, 0, 0, 0, 0, 0, 0, 0, 0},
+			}},
+			// The timestamps are in decoding order.
+			want: []time.Duration{1000 * time.Millisecond, 1080 * time.Millisecond, 1040 * time.Millisecond},
+		},
+		{
+			name:  "CodecDelay and lacing with DefaultDuration",
+			track: TrackEntry{TrackNumber: 1, TrackTimestampScale: 1, CodecDelay: uint(6500 * time.Microsecond), DefaultDuration: ptr(uint(20 * time.Millisecond))},
+			c:     Cluster{Timestamp: 0, SimpleBlock: [][]byte{block(-10, 0, 3)}},
+			want:  []time.Duration{-16500 * time.Microsecond, 3500 * time.Microsecond, 23500 * time.Microsecond},
+		},
+		{
+			name:  "TrackTimestampScale and lacing with BlockDuration",
+			track: TrackEntry{TrackNumber: 1, TrackTimestampScale: 0.5},
+			c:     Cluster{Timestamp: 100, BlockGroup: []BlockGroup{{Block: block(0, 0, 2), BlockDuration: ptr(40)}}},
+			want:  []time.Duration{50 * time.Millisecond, 60 * time.Millisecond},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := appendClusterTimestamps(nil, tt.c, tt.track, time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("appendClusterTimestamps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackTimestamps(t *testing.T) {
+	var ws writeSeeker
+	video := NewTrackEntry(1, TrackTypeVideo, VideoCodecMSCOMP)
+	video.Video = &Video{PixelWidth: 320, PixelHeight: 240}
+	w, err := NewWriter(&ws, Info{}, Tracks{TrackEntry: []TrackEntry{video}}, WriterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The frames are written in decoding order with B-frames.
+	for _, ms := range []int{0, 80, 40, 160, 120} {
+		f := Frame{TrackNumber: 1, Timestamp: time.Duration(ms) * time.Millisecond, Keyframe: ms == 0, Data: []byte{0}}
+		if err := w.WriteFrame(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := TrackTimestamps(NewScanner(bytes.NewReader(ws.buf)), video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{0, 40 * time.Millisecond, 80 * time.Millisecond, 120 * time.Millisecond, 160 * time.Millisecond}
+	if !slices.Equal(got, want) {
+		t.Errorf("TrackTimestamps() = %v, want %v", got, want)
+	}
+}
+
+func TestWriteTimestamps(t *testing.T) {
+	ts := []time.Duration{-6500 * time.Microsecond, 0, 41708333, 2 * time.Second}
+	var buf bytes.Buffer
+	if err := WriteTimestamps(&buf, ts, TimestampFormatV2); err != nil {
+		t.Fatal(err)
+	}
+	want := "# timestamp format v2\n-6.5\n0\n41.708333\n2000\n"
+	if got := buf.String(); got != want {
+		t.Errorf("WriteTimestamps() = %q, want %q", got, want)
+	}
+	if err := WriteTimestamps(&buf, ts, 3); err == nil {
+		t.Errorf("WriteTimestamps() with format v3 should fail")
+	}
+}
@@ -0,0 +1,654 @@
+package matroska
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/coding-socks/ebml"
+	"github.com/coding-socks/ebml/schema"
+	"hash/crc32"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Severity tells how serious a Finding is.
+type Severity string
+
+const (
+	// SeverityError marks a violation of a requirement of RFC 9559 or
+	// RFC 8794.
+	SeverityError Severity = "error"
+	// SeverityWarning marks a violation of a recommendation, or content
+	// which is likely to cause problems.
+	SeverityWarning Severity = "warning"
+)
+
+// Rules reported by Validate.
+const (
+	RuleHeader            = "header"
+	RuleDocType           = "docType"
+	RuleUnknownElement    = "unknownElement"
+	RuleUnexpected        = "unexpectedElement"
+	RuleUnknownSize       = "unknownSize"
+	RuleOverflow          = "overflow"
+	RuleMinOccurs         = "minOccurs"
+	RuleMaxOccurs         = "maxOccurs"
+	RuleRange             = "range"
+	RuleLength            = "length"
+	RuleSize              = "size"
+	RuleString            = "string"
+	RuleCRC32             = "crc32"
+	RuleSeekHead          = "seekHead"
+	RuleSeekPosition      = "seekPosition"
+	RuleUnexpectedCluster = "unexpectedCluster"
+	RuleClusterTimestamp  = "clusterTimestamp"
+	RuleCuePosition       = "cuePosition"
+	RuleTrackNumber       = "trackNumber"
+)
+
+// Finding is a problem found by Validate.
+type Finding struct {
+	Severity Severity `json:"severity"`
+	// Rule identifies the kind of the problem. It is one of the Rule
+	// constants.
+	Rule string `json:"rule"`
+	// Offset is the position of the element in the file.
+	Offset int64 `json:"offset"`
+	// Path is the path of the element, like \Segment\Info\Duration.
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s at %d: %s", f.Severity, f.Path, f.Offset, f.Message)
+}
+
+// ValidationReport is the result of Validate. The findings are sorted by
+// their offset.
+type ValidationReport struct {
+	Findings []Finding `json:"findings"`
+}
+
+// Valid reports whether the report does not contain any error.
+func (r *ValidationReport) Valid() bool {
+	return !slices.ContainsFunc(r.Findings, func(f Finding) bool { return f.Severity == SeverityError })
+}
+
+// Validate checks the structure of a Matroska file against RFC 9559.
+//
+// Every element is checked against the constraints of the Matroska
+// schema: the place of the element, minOccurs, maxOccurs, range and
+// length, where a missing mandatory element with a default value is
+// accepted. On top of that Validate verifies CRC-32 elements, the SeekHead
+// rules of Section 6.3, the order of Cluster timestamps, the Cluster
+// positions of the Cues and the uniqueness of TrackNumbers.
+//
+// The returned error is only set when r cannot be read.
+func Validate(r io.ReadSeeker) (*ValidationReport, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("matroska: could not seek: %w", err)
+	}
+	v := &validator{r: &cachedReaderAt{r: r}, report: &ValidationReport{Findings: []Finding{}}}
+	v.master(schema.Element{Type: ebml.TypeMaster}, "", 0, 0, size, false)
+	if v.err != nil {
+		return nil, v.err
+	}
+	slices.SortStableFunc(v.report.Findings, func(a, b Finding) int { return cmp.Compare(a.Offset, b.Offset) })
+	return v.report, nil
+}
+
+type validationSchema struct {
+	def *ebml.Def
+	// children are the non-global children of the elements by path.
+	children map[string][]schema.Element
+}
+
+var validation = sync.OnceValue(func() validationSchema {
+	def, err := ebml.Definition(DocType)
+	if err != nil {
+		panic("matroska: " + err.Error())
+	}
+	s := validationSchema{def: def, children: make(map[string][]schema.Element)}
+	for el := range def.All() {
+		if isGlobal(el) {
+			continue
+		}
+		parent := el.Path[:strings.LastIndex(el.Path, `\`)]
+		s.children[parent] = append(s.children[parent], el)
+	}
+	return s
+})
+
+// elementName returns the name of the element with id, or the ID itself
+// when it is unknown.
+func elementName(id schema.ElementID) string {
+	if el, ok := validation().def.Get(id); ok {
+		return el.Name
+	}
+	return id.String()
+}
+
+func isGlobal(el schema.Element) bool {
+	return strings.HasPrefix(el.Path, `\(`)
+}
+
+// allowedIn reports whether el can be a child of parent.
+func allowedIn(el, parent schema.Element) bool {
+	if isGlobal(el) {
+		// CRC-32 is defined from the first level.
+		return el.ID != ebml.IDCRC32 || parent.Path != ""
+	}
+	if el.Path[:strings.LastIndex(el.Path, `\`)] == parent.Path {
+		return true
+	}
+	return el.Recursive && el.ID == parent.ID
+}
+
+// validatorSeek is a Seek element with the offset of the Seek.
+type validatorSeek struct {
+	Seek
+	offset int64
+}
+
+type validatorSeekHead struct {
+	pos   int64
+	seeks []validatorSeek
+}
+
+type validatorValue struct {
+	value  uint
+	offset int64
+}
+
+type validator struct {
+	r      *cachedReaderAt
+	report *ValidationReport
+	err    error
+
+	// The state of the current Segment.
+	segmentStart int64
+	topLevel     map[int64]schema.ElementID
+	firstTop     schema.ElementID
+	firstCluster int64
+	info, tracks int64
+	seekHeads    []validatorSeekHead
+	seek         validatorSeek
+	trackNumbers []validatorValue
+	cuePositions []validatorValue
+	clusterTS    *uint
+}
+
+func (v *validator) add(severity Severity, rule string, offset int64, path string, format string, args ...any) {
+	v.report.Findings = append(v.report.Findings, Finding{
+		Severity: severity,
+		Rule:     rule,
+		Offset:   offset,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// master validates the children of parent at offset from start to end. A
+// parent of unknown size ends at the first element which cannot be its
+// child, or at end. It returns the end of parent.
+func (v *validator) master(parent schema.Element, path string, offset, start, end int64, unknownSize bool) int64 {
+	counts := make(map[schema.ElementID]int)
+	pos := start
+	for first := true; pos < end && v.err == nil; first = false {
+		b, err := v.r.readAt(pos, 12)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			v.err = err
+			return end
+		}
+		id, hl, ds, ok := parseElementHeader(b)
+		if !ok {
+			v.add(SeverityError, RuleHeader, pos, path, "invalid element header")
+			return end
+		}
+		el, known := validation().def.Get(id)
+		if unknownSize && known && !allowedIn(el, parent) {
+			break
+		}
+		if !known {
+			v.add(SeverityWarning, RuleUnknownElement, pos, path, "unknown element %v", id)
+			if ds == -1 {
+				v.add(SeverityError, RuleUnknownSize, pos, path, "unknown element %v has an unknown size", id)
+				return end
+			}
+			pos = min(pos+int64(hl)+ds, end)
+			continue
+		}
+		elPath := path + `\` + el.Name
+		if !allowedIn(el, parent) {
+			v.add(SeverityError, RuleUnexpected, pos, elPath, "%s is not allowed in %s", el.Name, parent.Name)
+		}
+		counts[id]++
+		dataStart := pos + int64(hl)
+		elEnd := end
+		if ds == -1 {
+			if !el.UnknownSizeAllowed {
+				v.add(SeverityError, RuleUnknownSize, pos, elPath, "%s must not have an unknown size", el.Name)
+			}
+			if el.Type != ebml.TypeMaster {
+				return end
+			}
+		} else if dataStart+ds > end {
+			v.add(SeverityError, RuleOverflow, pos, elPath, "%s ends %d octets after its parent", el.Name, dataStart+ds-end)
+		} else {
+			elEnd = dataStart + ds
+		}
+		if parent.ID == IDSegment {
+			v.topLevelElement(el, pos)
+		}
+		if el.Type == ebml.TypeMaster {
+			v.enter(el, pos)
+			elEnd = v.master(el, elPath, pos, dataStart, elEnd, ds == -1)
+			v.exit(el, pos)
+		} else {
+			v.leaf(el, elPath, pos, dataStart, elEnd-dataStart)
+		}
+		if id == ebml.IDCRC32 {
+			if !first {
+				v.add(SeverityError, RuleCRC32, pos, elPath, "CRC-32 is not the first child of %s", parent.Name)
+			} else if !unknownSize {
+				v.checkCRC32(elPath, pos, dataStart, elEnd, end)
+			}
+		}
+		pos = elEnd
+	}
+	for _, c := range validation().children[parent.Path] {
+		n := counts[c.ID]
+		if !c.MaxOccurs.Unbounded() && n > c.MaxOccurs.Val() {
+			v.add(SeverityError, RuleMaxOccurs, offset, path, "%s occurs %d times, at most %d allowed", c.Name, n, c.MaxOccurs.Val())
+		}
+		if n < c.MinOccurs && c.Default == nil {
+			v.add(SeverityError, RuleMinOccurs, offset, path, "%s occurs %d times, at least %d required", c.Name, n, c.MinOccurs)
+		}
+	}
+	return pos
+}
+
+// leaf validates the value of a non-master element.
+func (v *validator) leaf(el schema.Element, path string, pos, dataStart, size int64) {
+	if el.Length != "" {
+		if ok, err := inRange(el.Length, float64(size)); err == nil && !ok {
+			v.add(SeverityError, RuleLength, pos, path, "length %d is not %s", size, el.Length)
+		}
+	}
+	switch el.Type {
+	case ebml.TypeBinary:
+		if el.ID != IDSeekID {
+			return
+		}
+	case ebml.TypeInteger, ebml.TypeUinteger:
+		if size > 8 {
+			v.add(SeverityError, RuleSize, pos, path, "integer of %d octets", size)
+			return
+		}
+	case ebml.TypeFloat:
+		if size != 0 && size != 4 && size != 8 {
+			v.add(SeverityError, RuleSize, pos, path, "float of %d octets", size)
+			return
+		}
+	case ebml.TypeDate:
+		if size != 0 && size != 8 {
+			v.add(SeverityError, RuleSize, pos, path, "date of %d octets", size)
+		}
+		return
+	}
+	b, err := v.r.readAt(dataStart, int(size))
+	if err != nil {
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			v.err = err
+		}
+		return
+	}
+	var number float64
+	switch el.Type {
+	case ebml.TypeUinteger:
+		var u uint64
+		for _, c := range b {
+			u = u<<8 | uint64(c)
+		}
+		number = float64(u)
+		v.value(el, path, pos, uint(u))
+	case ebml.TypeInteger:
+		var i int64
+		if len(b) > 0 && b[0]&0x80 != 0 {
+			i = -1
+		}
+		for _, c := range b {
+			i = i<<8 | int64(c)
+		}
+		number = float64(i)
+	case ebml.TypeFloat:
+		switch len(b) {
+		case 4:
+			number = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+		case 8:
+			number = math.Float64frombits(binary.BigEndian.Uint64(b))
+		}
+	case ebml.TypeString:
+		s := strings.TrimRight(string(b), "\x00")
+		if strings.ContainsFunc(s, func(r rune) bool { return r < 0x20 || r > 0x7E }) {
+			v.add(SeverityError, RuleString, pos, path, "string contains characters other than printable ASCII")
+		}
+		if el.ID == ebml.IDDocType && s != DocType && s != "webm" {
+			v.add(SeverityError, RuleDocType, pos, path, "unknown DocType %q", s)
+		}
+		return
+	case ebml.TypeUTF8:
+		if !utf8.Valid(b) {
+			v.add(SeverityError, RuleString, pos, path, "invalid UTF-8 string")
+		}
+		return
+	case ebml.TypeBinary:
+		var id uint64
+		for _, c := range b {
+			id = id<<8 | uint64(c)
+		}
+		v.value(el, path, pos, uint(id))
+		return
+	}
+	if el.Range == "" {
+		return
+	}
+	if ok, err := inRange(el.Range, number); err == nil && !ok {
+		v.add(SeverityError, RuleRange, pos, path, "value %v is not in range %s", number, el.Range)
+	}
+}
+
+// checkCRC32 verifies the CRC-32 element at pos against the data following
+// it up to the end of its parent.
+func (v *validator) checkCRC32(path string, pos, dataStart, dataEnd, end int64) {
+	if dataEnd-dataStart != 4 {
+		return
+	}
+	b, err := v.r.readAt(dataStart, 4)
+	if err != nil {
+		v.err = err
+		return
+	}
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, io.NewSectionReader(v.r, dataEnd, end-dataEnd)); err != nil {
+		v.err = err
+		return
+	}
+	if want, got := binary.LittleEndian.Uint32(b), h.Sum32(); want != got {
+		v.add(SeverityError, RuleCRC32, pos, path, "CRC-32 is %08x, the data has %08x", want, got)
+	}
+}
+
+func (v *validator) topLevelElement(el schema.Element, pos int64) {
+	rel := pos - v.segmentStart
+	v.topLevel[rel] = el.ID
+	if v.firstTop == 0 && el.ID != ebml.IDCRC32 {
+		v.firstTop = el.ID
+	}
+	switch el.ID {
+	case IDInfo:
+		if v.info == -1 {
+			v.info = rel
+		}
+	case IDTracks:
+		if v.tracks == -1 {
+			v.tracks = rel
+		}
+	case IDCluster:
+		if v.firstCluster == -1 {
+			v.firstCluster = rel
+		}
+	}
+}
+
+func (v *validator) enter(el schema.Element, pos int64) {
+	switch el.ID {
+	case IDSegment:
+		b, _ := v.r.readAt(pos, 12)
+		_, hl, _, _ := parseElementHeader(b)
+		v.segmentStart = pos + int64(hl)
+		v.topLevel = make(map[int64]schema.ElementID)
+		v.firstTop, v.firstCluster, v.info, v.tracks = 0, -1, -1, -1
+		v.seekHeads, v.trackNumbers, v.cuePositions, v.clusterTS = nil, nil, nil, nil
+	case IDSeekHead:
+		v.seekHeads = append(v.seekHeads, validatorSeekHead{pos: pos - v.segmentStart})
+	case IDSeek:
+		v.seek = validatorSeek{offset: pos}
+	}
+}
+
+func (v *validator) exit(el schema.Element, pos int64) {
+	switch el.ID {
+	case IDSegment:
+		v.checkSegment(pos)
+	case IDSeek:
+		if n := len(v.seekHeads); n > 0 {
+			v.seekHeads[n-1].seeks = append(v.seekHeads[n-1].seeks, v.seek)
+		}
+	}
+}
+
+// value records the values used by the checks of the whole Segment.
+func (v *validator) value(el schema.Element, path string, pos int64, u uint) {
+	if path != el.Path {
+		// Misplaced elements are already reported.
+		return
+	}
+	switch el.ID {
+	case IDSeekID:
+		v.seek.SeekID = schema.ElementID(u)
+	case IDSeekPosition:
+		v.seek.SeekPosition = u
+	case IDTrackNumber:
+		v.trackNumbers = append(v.trackNumbers, validatorValue{u, pos})
+	case IDCueClusterPosition:
+		v.cuePositions = append(v.cuePositions, validatorValue{u, pos})
+	case IDTimestamp:
+		if v.clusterTS != nil && u < *v.clusterTS {
+			v.add(SeverityError, RuleClusterTimestamp, pos, `\Segment\Cluster\Timestamp`, "Cluster Timestamp %d is lower than the previous %d", u, *v.clusterTS)
+		}
+		v.clusterTS = &u
+	}
+}
+
+// checkSegment runs the checks which need the whole Segment.
+func (v *validator) checkSegment(pos int64) {
+	const segmentPath = `\Segment`
+	referenced := make(map[int64]bool)
+	for i, sh := range v.seekHeads {
+		path := segmentPath + `\SeekHead`
+		for _, s := range sh.seeks {
+			if id, ok := v.topLevel[int64(s.SeekPosition)]; !ok || id != s.SeekID {
+				v.add(SeverityError, RuleSeekPosition, s.offset, path+`\Seek`, "SeekPosition %d does not point to %s", s.SeekPosition, elementName(s.SeekID))
+				continue
+			}
+			referenced[int64(s.SeekPosition)] = true
+		}
+		if i == 0 {
+			continue
+		}
+		// Section 6.3: the first SeekHead references the second one, and
+		// the second one only references Clusters and elements which are
+		// not in the first one.
+		first := v.seekHeads[0]
+		if !slices.ContainsFunc(first.seeks, func(s validatorSeek) bool { return int64(s.SeekPosition) == sh.pos }) {
+			v.add(SeverityError, RuleSeekHead, v.segmentStart+sh.pos, path, "the second SeekHead is not referenced by the first one")
+		}
+		for _, s := range sh.seeks {
+			if s.SeekID == IDCluster {
+				continue
+			}
+			if slices.ContainsFunc(first.seeks, func(f validatorSeek) bool { return f.SeekID == s.SeekID && f.SeekPosition == s.SeekPosition }) {
+				v.add(SeverityError, RuleSeekHead, s.offset, path+`\Seek`, "the second SeekHead references %s which is in the first one", elementName(s.SeekID))
+			}
+		}
+	}
+	if len(v.seekHeads) > 0 {
+		if v.firstTop != IDSeekHead {
+			v.add(SeverityWarning, RuleSeekHead, v.segmentStart+v.seekHeads[0].pos, segmentPath+`\SeekHead`, "the first SeekHead is not the first element of the Segment")
+		}
+		ignored := []schema.ElementID{IDCluster, IDSeekHead, ebml.IDVoid, ebml.IDCRC32}
+		for rel, id := range v.topLevel {
+			if !referenced[rel] && !slices.Contains(ignored, id) {
+				v.add(SeverityWarning, RuleSeekHead, v.segmentStart+rel, segmentPath, "%s is not referenced by a SeekHead", elementName(id))
+			}
+		}
+	}
+	if fc := v.firstCluster; fc != -1 {
+		before := func(p int64) bool { return p != -1 && p < fc }
+		if !before(v.info) || !before(v.tracks) {
+			// Both must be referenced by a SeekHead before the Cluster.
+			found := func(id schema.ElementID) bool {
+				for _, sh := range v.seekHeads {
+					if sh.pos < fc && slices.ContainsFunc(sh.seeks, func(s validatorSeek) bool { return s.SeekID == id && referenced[int64(s.SeekPosition)] }) {
+						return true
+					}
+				}
+				return false
+			}
+			if !found(IDInfo) || !found(IDTracks) {
+				v.add(SeverityError, RuleUnexpectedCluster, v.segmentStart+fc, segmentPath+`\Cluster`, "%s: Info and Tracks are neither before the first Cluster nor referenced by a SeekHead before it", ErrUnexpectedClusterElement)
+			}
+		}
+	}
+	seen := make(map[uint]bool)
+	for _, n := range v.trackNumbers {
+		if seen[n.value] {
+			v.add(SeverityError, RuleTrackNumber, n.offset, segmentPath+`\Tracks\TrackEntry\TrackNumber`, "TrackNumber %d is not unique", n.value)
+		}
+		seen[n.value] = true
+	}
+	for _, p := range v.cuePositions {
+		if v.topLevel[int64(p.value)] != IDCluster {
+			v.add(SeverityError, RuleCuePosition, p.offset, segmentPath+`\Cues\CuePoint\CueTrackPositions\CueClusterPosition`, "CueClusterPosition %d does not point to a Cluster", p.value)
+		}
+	}
+}
+
+// inRange reports whether v satisfies the range of RFC 8794 Section
+// 11.1.6.7, like "not 0", "1-8", ">= 0x0p+0" or ">= -0x5Ap+0, <= 0x5Ap+0".
+func inRange(rng string, v float64) (bool, error) {
+	for _, cond := range strings.Split(rng, ",") {
+		cond = strings.TrimSpace(cond)
+		parse := func(s string) (float64, error) {
+			return strconv.ParseFloat(strings.TrimSpace(s), 64)
+		}
+		var ok bool
+		switch {
+		case strings.HasPrefix(cond, "not "):
+			x, err := parse(cond[4:])
+			if err != nil {
+				return false, err
+			}
+			ok = v != x
+		case strings.HasPrefix(cond, ">="), strings.HasPrefix(cond, "<="):
+			x, err := parse(cond[2:])
+			if err != nil {
+				return false, err
+			}
+			ok = (cond[0] == '>' && v >= x) || (cond[0] == '<' && v <= x)
+		case strings.HasPrefix(cond, ">"), strings.HasPrefix(cond, "<"):
+			x, err := parse(cond[1:])
+			if err != nil {
+				return false, err
+			}
+			ok = (cond[0] == '>' && v > x) || (cond[0] == '<' && v < x)
+		default:
+			// A minus sign inside the range separates the bounds unless it
+			// is the sign of an exponent.
+			i := strings.IndexFunc(cond[1:], func(r rune) bool { return r == '-' }) + 1
+			for i > 0 && strings.ContainsRune("pPeE", rune(cond[i-1])) {
+				j := strings.IndexRune(cond[i+1:], '-')
+				if j == -1 {
+					i = 0
+					break
+				}
+				i += j + 1
+			}
+			if i <= 0 {
+				x, err := parse(cond)
+				if err != nil {
+					return false, err
+				}
+				ok = v == x
+				break
+			}
+			lo, err := parse(cond[:i])
+			if err != nil {
+				return false, err
+			}
+			hi, err := parse(cond[i+1:])
+			if err != nil {
+				return false, err
+			}
+			ok = lo <= v && v <= hi
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// cachedReaderAt implements io.ReaderAt on an io.ReadSeeker, and keeps a
+// window of the file to make reading the element headers cheap.
+type cachedReaderAt struct {
+	r   io.ReadSeeker
+	buf []byte
+	off int64
+}
+
+const cachedReaderAtSize = 64 << 10
+
+func (c *cachedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= c.off && off+int64(len(p)) <= c.off+int64(len(c.buf)) {
+		return copy(p, c.buf[off-c.off:]), nil
+	}
+	if _, err := c.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if len(p) >= cachedReaderAtSize {
+		n, err := io.ReadFull(c.r, p)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = io.EOF
+		}
+		return n, err
+	}
+	if c.buf == nil {
+		c.buf = make([]byte, cachedReaderAtSize)
+	}
+	n, err := io.ReadFull(c.r, c.buf[:cap(c.buf)])
+	c.buf, c.off = c.buf[:n], off
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		err = nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n = copy(p, c.buf)
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readAt reads n octets at off. It returns io.ErrUnexpectedEOF when the
+// file ends before.
+func (c *cachedReaderAt) readAt(off int64, n int) ([]byte, error) {
+	b := make([]byte, n)
+	m, err := c.ReadAt(b, off)
+	if err == io.EOF {
+		if m < n {
+			return b[:m], io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return b[:m], err
+}
@@ -0,0 +1,148 @@
+package matroska
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/coding-socks/ebml"
+	"github.com/coding-socks/ebml/schema"
+	"hash/crc32"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	element := func(id schema.ElementID, children ...[]byte) []byte {
+		data := bytes.Join(children, nil)
+		return append(appendElementHeader(nil, id, int64(len(data))), data...)
+	}
+	marshal := func(id schema.ElementID, v any) []byte {
+		b, err := MarshalElement(id, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	crc := func(children ...[]byte) []byte {
+		sum := crc32.ChecksumIEEE(bytes.Join(children, nil))
+		return element(ebml.IDCRC32, binary.LittleEndian.AppendUint32(nil, sum))
+	}
+	data := func(b []byte) []byte {
+		_, hl, _, _ := parseElementHeader(b)
+		return b[hl:]
+	}
+	cluster := func(ts uint64) []byte {
+		return element(IDCluster, appendUintElement(nil, IDTimestamp, ts))
+	}
+	info := marshal(IDInfo, Info{TimestampScale: 1000000, MuxingApp: "test", WritingApp: "test"})
+	tracks := marshal(IDTracks, Tracks{TrackEntry: []TrackEntry{
+		NewTrackEntry(1, TrackTypeVideo, VideoCodecMSCOMP),
+		NewTrackEntry(2, TrackTypeAudio, AudioCodecMP3),
+	}})
+	cues := func(pos uint) []byte {
+		return marshal(IDCues, Cues{CuePoint: []CuePoint{{
+			CueTrackPositions: []CueTrackPositions{{CueTrack: 1, CueClusterPosition: pos}},
+		}}})
+	}
+	clusterPos := uint(len(info) + len(tracks))
+
+	tests := []struct {
+		name    string
+		segment [][]byte
+		want    []string
+	}{
+		{"Valid", [][]byte{info, tracks, cluster(0), cluster(1000), cues(clusterPos)}, nil},
+		{"CRC32", [][]byte{element(IDInfo, crc(data(info)), data(info)), tracks, cluster(0)}, nil},
+		{"InvalidCRC32", [][]byte{element(IDInfo, crc(info), data(info)), tracks, cluster(0)}, []string{RuleCRC32}},
+		{"MisplacedCRC32", [][]byte{element(IDInfo, data(info), crc(data(info))), tracks, cluster(0)}, []string{RuleCRC32}},
+		{"MissingInfo", [][]byte{tracks, cluster(0)}, []string{RuleMinOccurs, RuleUnexpectedCluster}},
+		{"MissingMuxingApp", [][]byte{element(IDInfo, appendUintElement(nil, IDTimestampScale, 1000000)), tracks, cluster(0)}, []string{RuleMinOccurs, RuleMinOccurs}},
+		{"Range", [][]byte{marshal(IDInfo, Info{TimestampScale: 0, MuxingApp: "test", WritingApp: "test"}), tracks, cluster(0)}, []string{RuleRange}},
+		{"UnexpectedElement", [][]byte{info, tracks, appendUintElement(nil, IDTrackNumber, 1), cluster(0)}, []string{RuleUnexpected}},
+		{"UnexpectedCluster", [][]byte{info, cluster(0), tracks}, []string{RuleUnexpectedCluster}},
+		{"ClusterTimestamp", [][]byte{info, tracks, cluster(1000), cluster(0)}, []string{RuleClusterTimestamp}},
+		{"CuePosition", [][]byte{info, tracks, cluster(0), cues(clusterPos + 1)}, []string{RuleCuePosition}},
+		{"TrackNumber", [][]byte{info, marshal(IDTracks, Tracks{TrackEntry: []TrackEntry{
+			NewTrackEntry(1, TrackTypeVideo, VideoCodecMSCOMP),
+			NewTrackEntry(1, TrackTypeAudio, AudioCodecMP3),
+		}}), cluster(0)}, []string{RuleTrackNumber}},
+		{"SeekPosition", [][]byte{marshal(IDSeekHead, SeekHead{Seek: []Seek{{SeekID: IDTracks, SeekPosition: 1}}}), info, tracks, cluster(0)}, []string{RuleSeekPosition}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := appendHeader(nil, DocType)
+			b = append(b, element(IDSegment, tt.segment...)...)
+			report, err := Validate(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range report.Findings {
+				if f.Severity == SeverityError {
+					got = append(got, f.Rule)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Validate() = %v, want rules %v", report.Findings, tt.want)
+			}
+			if report.Valid() != (len(tt.want) == 0) {
+				t.Errorf("Valid() = %v", report.Valid())
+			}
+		})
+	}
+
+	t.Run("Writer", func(t *testing.T) {
+		var ws writeSeeker
+		w, err := NewWriter(&ws, Info{}, Tracks{TrackEntry: []TrackEntry{NewTrackEntry(1, TrackTypeAudio, AudioCodecMP3)}}, WriterOptions{ClusterDuration: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.SetChapters(testChapters())
+		w.SetTags(testTags())
+		for i := range 100 {
+			if err := w.WriteFrame(Frame{TrackNumber: 1, Timestamp: time.Duration(i) * 100 * time.Millisecond, Keyframe: true, Data: []byte{byte(i)}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		report, err := Validate(bytes.NewReader(ws.buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Findings) != 0 {
+			t.Errorf("Validate() = %v", report.Findings)
+		}
+	})
+}
+
+func TestInRange(t *testing.T) {
+	tests := []struct {
+		rng  string
+		v    float64
+		want bool
+	}{
+		{"not 0", 0, false},
+		{"not 0", 1, true},
+		{"0-1", 1, true},
+		{"0-1", 2, false},
+		{"1-8", 0, false},
+		{"4", 4, true},
+		{"4", 5, false},
+		{">=2", 2, true},
+		{"> 0x0p+0", 0, false},
+		{"0x0p+0-0x1p+0", 0.5, true},
+		{">= -0xB4p+0, <= 0xB4p+0", -180, true},
+		{">= -0xB4p+0, <= 0xB4p+0", 181, false},
+	}
+	for _, tt := range tests {
+		got, err := inRange(tt.rng, tt.v)
+		if err != nil {
+			t.Errorf("inRange(%q, %v) error = %v", tt.rng, tt.v, err)
+		}
+		if got != tt.want {
+			t.Errorf("inRange(%q, %v) = %v, want %v", tt.rng, tt.v, got, tt.want)
+		}
+	}
+}
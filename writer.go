@@ -0,0 +1,582 @@
+package matroska
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/coding-socks/ebml"
+	"github.com/coding-socks/ebml/ebmltext"
+	"github.com/coding-socks/ebml/schema"
+	"io"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	// seekHeadReserve is the space reserved for the SeekHead after the
+	// Segment header. The SeekHead is written at Close and the rest of
+	// the space is filled with a Void element.
+	seekHeadReserve = 256
+
+	defaultClusterDuration = 5 * time.Second
+	defaultClusterSize     = 5 << 20
+)
+
+// WriterOptions configures a Writer.
+type WriterOptions struct {
+	// DocType is written into the EBML header. It defaults to DocType.
+	DocType string
+	// ClusterDuration is the duration after which a new Cluster is
+	// started at the next keyframe. It defaults to 5 seconds.
+	ClusterDuration time.Duration
+	// ClusterSize is the size in octets after which a new Cluster is
+	// started. It defaults to 5 MiB.
+	ClusterSize int
+	// CRC32 adds a CRC-32 element to every top level element, so damaged
+	// data can be detected.
+	CRC32 bool
+}
+
+// Frame is a single frame of a track passed to Writer.WriteFrame.
+type Frame struct {
+	TrackNumber uint
+	Timestamp   time.Duration
+	// Duration is written as BlockDuration when it is not zero.
+	Duration    time.Duration
+	Keyframe    bool
+	Discardable bool
+	// DiscardPadding is written as DiscardPadding when it is not zero.
+	DiscardPadding time.Duration
+	// BlockAdditional is written as a BlockAdditional with BlockAddID 1
+	// when it is not empty.
+	BlockAdditional []byte
+	Data            []byte
+}
+
+// Writer writes a Matroska file.
+//
+// The Info and Tracks elements are written by NewWriter. Frames are
+// collected into Clusters of known size, and Cues, Chapters, Tags and
+// Attachments are written by Close. Close also writes the SeekHead into
+// the space reserved at the beginning of the Segment, updates the
+// Duration and the size of the Segment.
+type Writer struct {
+	w    io.WriteSeeker
+	opts WriterOptions
+	err  error
+
+	info   Info
+	tracks map[uint]TrackEntry
+	video  bool
+
+	offset         int64 // current position in w
+	segmentSizePos int64
+	segmentStart   int64
+	seekHeadPos    int64
+	infoPos        int64
+	seeks          []Seek
+
+	cluster     []byte
+	clusterTS   int64
+	clusterOpen bool
+	clusterCues []CuePoint
+	// clusterTracks contains the tracks which have a frame in the current
+	// Cluster.
+	clusterTracks map[uint]bool
+	lastTS        map[uint]int64
+	cues          Cues
+	end           time.Duration
+
+	chapters    *Chapters
+	tags        *Tags
+	attachments *Attachments
+}
+
+// NewTrackEntry returns a TrackEntry with the mandatory elements set to
+// their default values and a random TrackUID.
+func NewTrackEntry(number uint, trackType uint, codecID string) TrackEntry {
+	var t TrackEntry
+	setDefaults(&t)
+	t.TrackNumber = number
+	t.TrackUID = newUID()
+	t.TrackType = trackType
+	t.CodecID = codecID
+	t.Language = "und"
+	return t
+}
+
+// NewWriter writes the EBML header, the Info and the Tracks element into
+// w and returns a Writer ready to accept frames.
+//
+// TimestampScale, SegmentUUID, MuxingApp and WritingApp are set when info
+// does not contain them.
+func NewWriter(w io.WriteSeeker, info Info, tracks Tracks, opts WriterOptions) (*Writer, error) {
+	if opts.DocType == "" {
+		opts.DocType = DocType
+	}
+	if opts.ClusterDuration <= 0 {
+		opts.ClusterDuration = defaultClusterDuration
+	}
+	if opts.ClusterSize <= 0 {
+		opts.ClusterSize = defaultClusterSize
+	}
+	if info.TimestampScale <= 0 {
+		info.TimestampScale = time.Millisecond
+	}
+	if info.SegmentUUID == nil {
+		uuid := newSegmentUUID()
+		info.SegmentUUID = &uuid
+	}
+	if info.MuxingApp == "" {
+		info.MuxingApp = "github.com/coding-socks/matroska"
+	}
+	if info.WritingApp == "" {
+		info.WritingApp = info.MuxingApp
+	}
+	// The Duration always occupies 8 octets, so it can be updated by Close.
+	duration := 0.0
+	info.Duration = &duration
+
+	mw := &Writer{
+		w:             w,
+		opts:          opts,
+		info:          info,
+		tracks:        make(map[uint]TrackEntry),
+		clusterTracks: make(map[uint]bool),
+		lastTS:        make(map[uint]int64),
+	}
+	for _, t := range tracks.TrackEntry {
+		if t.TrackNumber == 0 {
+			return nil, fmt.Errorf("matroska: invalid track number 0")
+		}
+		if _, ok := mw.tracks[t.TrackNumber]; ok {
+			return nil, fmt.Errorf("matroska: duplicate track number %d", t.TrackNumber)
+		}
+		mw.tracks[t.TrackNumber] = t
+		mw.video = mw.video || t.TrackType == TrackTypeVideo
+	}
+	pos, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("matroska: could not read position: %w", err)
+	}
+	mw.offset = pos
+
+	var b []byte
+	b = appendHeader(b, opts.DocType)
+	b = appendElementID(b, IDSegment)
+	mw.segmentSizePos = pos + int64(len(b))
+	b = appendDataSize(b, -1, 8)
+	mw.segmentStart = pos + int64(len(b))
+	mw.seekHeadPos = mw.segmentStart
+	b = appendVoid(b, seekHeadReserve)
+	if err := mw.write(b); err != nil {
+		return nil, err
+	}
+	mw.infoPos = mw.offset
+	if err := mw.writeElement(IDInfo, mw.info); err != nil {
+		return nil, err
+	}
+	if err := mw.writeElement(IDTracks, tracks); err != nil {
+		return nil, err
+	}
+	return mw, nil
+}
+
+// appendHeader appends an EBML header with every element written
+// explicitly.
+func appendHeader(b []byte, docType string) []byte {
+	var data []byte
+	data = appendUintElement(data, ebml.IDEBMLVersion, 1)
+	data = appendUintElement(data, ebml.IDEBMLReadVersion, 1)
+	data = appendUintElement(data, ebml.IDEBMLMaxIDLength, 4)
+	data = appendUintElement(data, ebml.IDEBMLMaxSizeLength, 8)
+	data = appendElementHeader(data, ebml.IDDocType, int64(len(docType)))
+	data = append(data, docType...)
+	data = appendUintElement(data, ebml.IDDocTypeVersion, 4)
+	data = appendUintElement(data, ebml.IDDocTypeReadVersion, 2)
+	b = appendElementHeader(b, ebml.IDEBML, int64(len(data)))
+	return append(b, data...)
+}
+
+func appendUintElement(b []byte, id schema.ElementID, v uint64) []byte {
+	data := appendUint(nil, v)
+	b = appendElementHeader(b, id, int64(len(data)))
+	return append(b, data...)
+}
+
+// SetChapters sets the Chapters element written by Close.
+func (w *Writer) SetChapters(c *Chapters) { w.chapters = c }
+
+// SetTags sets the Tags element written by Close.
+func (w *Writer) SetTags(t *Tags) { w.tags = t }
+
+// SetAttachments sets the Attachments element written by Close.
+func (w *Writer) SetAttachments(a *Attachments) { w.attachments = a }
+
+// WriteFrame adds f to the current Cluster.
+//
+// A SimpleBlock is used unless the frame has a Duration, DiscardPadding
+// or BlockAdditional in which case a BlockGroup is used. Frames of a track
+// must be written in decoding order, and frames of different tracks should
+// be interleaved by timestamp.
+func (w *Writer) WriteFrame(f Frame) error {
+	if w.err != nil {
+		return w.err
+	}
+	t, ok := w.tracks[f.TrackNumber]
+	if !ok {
+		return fmt.Errorf("matroska: unknown track number %d", f.TrackNumber)
+	}
+	scale := int64(w.info.TimestampScale)
+	ts := int64(f.Timestamp) / scale
+	if w.clusterOpen {
+		rel := ts - w.clusterTS
+		keyframe := f.Keyframe && (t.TrackType == TrackTypeVideo || !w.video)
+		switch {
+		case rel > math.MaxInt16 || rel < math.MinInt16,
+			len(w.cluster) >= w.opts.ClusterSize,
+			keyframe && time.Duration(rel*scale) >= w.opts.ClusterDuration:
+			if err := w.flushCluster(); err != nil {
+				return err
+			}
+		}
+	}
+	if !w.clusterOpen {
+		w.clusterOpen = true
+		w.clusterTS = max(ts, 0)
+		w.cluster = appendUintElement(w.cluster[:0], IDTimestamp, uint64(w.clusterTS))
+		clear(w.clusterTracks)
+	}
+	rel := ts - w.clusterTS
+	if rel > math.MaxInt16 || rel < math.MinInt16 {
+		return fmt.Errorf("matroska: timestamp %v of track %d out of range", f.Timestamp, f.TrackNumber)
+	}
+
+	w.cue(t, ts, f.Keyframe)
+
+	block := appendDataSize(nil, int64(f.TrackNumber), 0)
+	block = binary.BigEndian.AppendUint16(block, uint16(int16(rel)))
+	if f.Duration == 0 && f.DiscardPadding == 0 && len(f.BlockAdditional) == 0 {
+		var flags uint8
+		if f.Keyframe {
+			flags |= SimpleBlockFlagKeyframe
+		}
+		if f.Discardable {
+			flags |= SimpleBlockFlagDiscardable
+		}
+		block = append(block, flags)
+		block = append(block, f.Data...)
+		w.cluster = appendElementHeader(w.cluster, IDSimpleBlock, int64(len(block)))
+		w.cluster = append(w.cluster, block...)
+	} else {
+		block = append(block, 0)
+		block = append(block, f.Data...)
+		group := BlockGroup{Block: block}
+		if f.Duration != 0 {
+			d := uint(int64(f.Duration) / scale)
+			group.BlockDuration = &d
+		}
+		if last, ok := w.lastTS[f.TrackNumber]; ok && !f.Keyframe {
+			group.ReferenceBlock = []int{int(last - ts)}
+		}
+		if f.DiscardPadding != 0 {
+			d := int(f.DiscardPadding)
+			group.DiscardPadding = &d
+		}
+		if len(f.BlockAdditional) > 0 {
+			group.BlockAdditions = &BlockAdditions{BlockMore: []BlockMore{{BlockAdditional: f.BlockAdditional, BlockAddID: 1}}}
+		}
+		b, err := MarshalElement(IDBlockGroup, group)
+		if err != nil {
+			return err
+		}
+		w.cluster = append(w.cluster, b...)
+	}
+	w.clusterTracks[f.TrackNumber] = true
+	w.lastTS[f.TrackNumber] = ts
+
+	end := f.Timestamp + f.Duration
+	if f.Duration == 0 && t.DefaultDuration != nil {
+		end += time.Duration(*t.DefaultDuration)
+	}
+	w.end = max(w.end, end)
+	return nil
+}
+
+// cue adds a CuePoint for the block of track t at timestamp ts which is
+// appended next to the current Cluster, when the block needs one.
+func (w *Writer) cue(t TrackEntry, ts int64, keyframe bool) {
+	cue := false
+	switch t.TrackType {
+	case TrackTypeVideo:
+		cue = keyframe
+	case TrackTypeSubtitle:
+		cue = true
+	default:
+		cue = !w.video && keyframe && !w.clusterTracks[t.TrackNumber]
+	}
+	if !cue {
+		return
+	}
+	rel := uint(len(w.cluster))
+	if w.opts.CRC32 {
+		// The CRC-32 element is inserted before the data.
+		rel += crc32ElementSize
+	}
+	w.clusterCues = append(w.clusterCues, CuePoint{
+		CueTime: uint(max(ts, 0)),
+		CueTrackPositions: []CueTrackPositions{{
+			CueTrack:            t.TrackNumber,
+			CueRelativePosition: &rel,
+		}},
+	})
+}
+
+// writeCluster writes a Cluster with Timestamp ts, and copies the blocks
+// of data, which is the data of an existing Cluster, into it. The
+// elements describing the position of the original Cluster are dropped.
+// CuePoints are added the same way as by WriteFrame.
+func (w *Writer) writeCluster(ts int64, data []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.flushCluster(); err != nil {
+		return err
+	}
+	w.clusterOpen = true
+	w.clusterTS = ts
+	w.cluster = appendUintElement(w.cluster[:0], IDTimestamp, uint64(ts))
+	clear(w.clusterTracks)
+	scale := int64(w.info.TimestampScale)
+	for pos := 0; pos < len(data); {
+		id, hl, ds, ok := parseElementHeader(data[pos:])
+		if !ok || ds == -1 || int64(len(data)-pos-hl) < ds {
+			return fmt.Errorf("matroska: invalid element in Cluster at %d", pos)
+		}
+		el := data[pos : pos+hl+int(ds)]
+		pos += len(el)
+		switch id {
+		case IDTimestamp, IDPosition, IDPrevSize, ebml.IDCRC32, ebml.IDVoid:
+			continue
+		case IDSimpleBlock, IDBlockGroup:
+			b, ok := parseRawBlock(id, el[hl:])
+			if !ok {
+				return fmt.Errorf("matroska: invalid %v in Cluster", id)
+			}
+			bts := ts + int64(b.rel)
+			if t, ok := w.tracks[b.track]; ok {
+				w.cue(t, bts, b.keyframe)
+				end := time.Duration((bts + b.duration) * scale)
+				if b.duration == 0 && t.DefaultDuration != nil {
+					end += time.Duration(*t.DefaultDuration)
+				}
+				w.end = max(w.end, end)
+				w.clusterTracks[t.TrackNumber] = true
+			}
+		}
+		w.cluster = append(w.cluster, el...)
+	}
+	return w.flushCluster()
+}
+
+// rawBlock describes a SimpleBlock or a BlockGroup of an existing Cluster.
+type rawBlock struct {
+	track    uint
+	rel      int16
+	keyframe bool
+	// duration is the BlockDuration, or zero when it is missing.
+	duration int64
+}
+
+// parseRawBlock parses the data of a SimpleBlock or a BlockGroup.
+func parseRawBlock(id schema.ElementID, data []byte) (rawBlock, bool) {
+	var (
+		b     = rawBlock{keyframe: true}
+		block []byte
+	)
+	switch id {
+	case IDSimpleBlock:
+		block = data
+	case IDBlockGroup:
+		for pos := 0; pos < len(data); {
+			id, hl, ds, ok := parseElementHeader(data[pos:])
+			if !ok || ds == -1 || int64(len(data)-pos-hl) < ds {
+				return rawBlock{}, false
+			}
+			v := data[pos+hl : pos+hl+int(ds)]
+			switch id {
+			case IDBlock:
+				block = v
+			case IDReferenceBlock:
+				b.keyframe = false
+			case IDBlockDuration:
+				for _, c := range v {
+					b.duration = b.duration<<8 | int64(c)
+				}
+			}
+			pos += hl + int(ds)
+		}
+	}
+	track, n, err := ebmltext.ReadVintData(block)
+	if err != nil || len(block) < n+3 {
+		return rawBlock{}, false
+	}
+	b.track = uint(track)
+	b.rel = int16(binary.BigEndian.Uint16(block[n:]))
+	if id == IDSimpleBlock {
+		b.keyframe = block[n+2]&SimpleBlockFlagKeyframe != 0
+	}
+	return b, true
+}
+
+func (w *Writer) flushCluster() error {
+	if !w.clusterOpen {
+		return nil
+	}
+	w.clusterOpen = false
+	pos := uint(w.offset - w.segmentStart)
+	for _, cp := range w.clusterCues {
+		for i := range cp.CueTrackPositions {
+			cp.CueTrackPositions[i].CueClusterPosition = pos
+		}
+		// Cue points of the same time are merged.
+		if n := len(w.cues.CuePoint); n > 0 && w.cues.CuePoint[n-1].CueTime == cp.CueTime {
+			w.cues.CuePoint[n-1].CueTrackPositions = append(w.cues.CuePoint[n-1].CueTrackPositions, cp.CueTrackPositions...)
+			continue
+		}
+		w.cues.CuePoint = append(w.cues.CuePoint, cp)
+	}
+	w.clusterCues = w.clusterCues[:0]
+	b := appendElementHeader(nil, IDCluster, int64(len(w.cluster)))
+	if w.opts.CRC32 {
+		return w.write(appendCRC32(nil, append(b, w.cluster...)))
+	}
+	if err := w.write(b); err != nil {
+		return err
+	}
+	return w.write(w.cluster)
+}
+
+// Close flushes the last Cluster, writes the elements following the
+// Clusters and updates the beginning of the file. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.flushCluster(); err != nil {
+		return err
+	}
+	if len(w.cues.CuePoint) > 0 {
+		if err := w.writeElement(IDCues, w.cues); err != nil {
+			return err
+		}
+	}
+	if w.chapters != nil {
+		if err := w.writeElement(IDChapters, w.chapters); err != nil {
+			return err
+		}
+	}
+	if w.tags != nil {
+		if err := w.writeElement(IDTags, w.tags); err != nil {
+			return err
+		}
+	}
+	if w.attachments != nil {
+		if err := w.writeElement(IDAttachments, w.attachments); err != nil {
+			return err
+		}
+	}
+	end := w.offset
+
+	seekHead, err := w.marshal(IDSeekHead, SeekHead{Seek: w.seeks})
+	if err != nil {
+		return err
+	}
+	if n := seekHeadReserve - len(seekHead); n < 2 {
+		return errors.New("matroska: SeekHead does not fit into the reserved space")
+	} else {
+		seekHead = appendVoid(seekHead, n)
+	}
+	if err := w.writeAt(seekHead, w.seekHeadPos); err != nil {
+		return err
+	}
+
+	duration := float64(w.end) / float64(w.info.TimestampScale)
+	w.info.Duration = &duration
+	info, err := w.marshal(IDInfo, w.info)
+	if err != nil {
+		return err
+	}
+	if err := w.writeAt(info, w.infoPos); err != nil {
+		return err
+	}
+	size := appendDataSize(nil, end-w.segmentStart, 8)
+	if err := w.writeAt(size, w.segmentSizePos); err != nil {
+		return err
+	}
+	if _, err := w.w.Seek(end, io.SeekStart); err != nil {
+		return fmt.Errorf("matroska: could not seek: %w", err)
+	}
+	w.err = errors.New("matroska: writer is closed")
+	return nil
+}
+
+// writeElement writes v as the top level element id and records its
+// position for the SeekHead.
+func (w *Writer) writeElement(id schema.ElementID, v any) error {
+	b, err := w.marshal(id, v)
+	if err != nil {
+		return err
+	}
+	w.seeks = append(w.seeks, Seek{SeekID: id, SeekPosition: uint(w.offset - w.segmentStart)})
+	return w.write(b)
+}
+
+// marshal marshals the top level element id with a CRC-32 element when
+// the options ask for it.
+func (w *Writer) marshal(id schema.ElementID, v any) ([]byte, error) {
+	b, err := MarshalElement(id, v)
+	if err != nil || !w.opts.CRC32 {
+		return b, err
+	}
+	return appendCRC32(nil, b), nil
+}
+
+func (w *Writer) write(b []byte) error {
+	n, err := w.w.Write(b)
+	w.offset += int64(n)
+	if err != nil {
+		w.err = fmt.Errorf("matroska: could not write: %w", err)
+	}
+	return w.err
+}
+
+func (w *Writer) writeAt(b []byte, pos int64) error {
+	if _, err := w.w.Seek(pos, io.SeekStart); err != nil {
+		w.err = fmt.Errorf("matroska: could not seek: %w", err)
+		return w.err
+	}
+	if _, err := w.w.Write(b); err != nil {
+		w.err = fmt.Errorf("matroska: could not write: %w", err)
+	}
+	return w.err
+}
+
+// newSegmentUUID returns a random SegmentUUID.
+func newSegmentUUID() []byte {
+	uuid := make([]byte, 16)
+	binary.BigEndian.PutUint64(uuid[:8], rand.Uint64())
+	binary.BigEndian.PutUint64(uuid[8:], rand.Uint64())
+	return uuid
+}
+
+// newUID returns a random non-zero unique identifier.
+func newUID() uint {
+	for {
+		if uid := uint(rand.Uint64()); uid != 0 {
+			return uid
+		}
+	}
+}
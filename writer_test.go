@@ -0,0 +1,133 @@
+package matroska
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeSeeker is an in-memory io.WriteSeeker.
+type writeSeeker struct {
+	buf []byte
+	pos int64
+}
+
+func (w *writeSeeker) Write(p []byte) (int, error) {
+	if n := w.pos + int64(len(p)); n > int64(len(w.buf)) {
+		w.buf = append(w.buf, make([]byte, n-int64(len(w.buf)))...)
+	}
+	copy(w.buf[w.pos:], p)
+	w.pos += int64(len(p))
+	return len(p), nil
+}
+
+func (w *writeSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += w.pos
+	case io.SeekEnd:
+		offset += int64(len(w.buf))
+	}
+	w.pos = offset
+	return offset, nil
+}
+
+func TestWriter(t *testing.T) {
+	video := NewTrackEntry(1, TrackTypeVideo, VideoCodecMSCOMP)
+	video.Video = &Video{PixelWidth: 320, PixelHeight: 240}
+	audio := NewTrackEntry(2, TrackTypeAudio, AudioCodecMP3)
+	audio.Audio = &Audio{SamplingFrequency: 48000, Channels: 2}
+	title := "Writer test"
+
+	var ws writeSeeker
+	w, err := NewWriter(&ws, Info{Title: &title}, Tracks{TrackEntry: []TrackEntry{video, audio}}, WriterOptions{ClusterDuration: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SetChapters(&Chapters{EditionEntry: []EditionEntry{{ChapterAtom: []ChapterAtom{{ChapterUID: 1, ChapterTimeStart: 0}}}}})
+	for i := range 50 {
+		ts := time.Duration(i) * 40 * time.Millisecond
+		frames := []Frame{
+			{TrackNumber: 1, Timestamp: ts, Keyframe: i%25 == 0, Data: []byte{byte(i)}},
+			{TrackNumber: 2, Timestamp: ts, Duration: 40 * time.Millisecond, Keyframe: true, Data: []byte{byte(i)}},
+		}
+		for _, f := range frames {
+			if err := w.WriteFrame(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.WriteFrame(Frame{TrackNumber: 3}); err == nil {
+		t.Error("WriteFrame() with an unknown track should fail")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewScanner(bytes.NewReader(ws.buf))
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.SeekHead(); !ok {
+		t.Error("SeekHead() is constructed, want written")
+	}
+	info := s.Info()
+	if info.Title == nil || *info.Title != title {
+		t.Errorf("Title = %v, want %q", info.Title, title)
+	}
+	if info.Duration == nil || *info.Duration != 2000 {
+		t.Errorf("Duration = %v, want 2000", info.Duration)
+	}
+	tracks := s.Tracks().TrackEntry
+	if len(tracks) != 2 || tracks[0].TrackUID != video.TrackUID || tracks[1].Audio.Channels != 2 {
+		t.Errorf("Tracks() = %+v", tracks)
+	}
+	if c := s.Chapters(); c == nil || len(c.EditionEntry) != 1 {
+		t.Errorf("Chapters() = %+v", c)
+	}
+
+	var clusters []time.Duration
+	frames := 0
+	for s.Next() {
+		c := s.Cluster()
+		clusters = append(clusters, c.Timestamp)
+		for _, b := range c.SimpleBlock {
+			block, err := ReadSimpleBlock(b, c.Timestamp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if block.TrackNumber() != 1 {
+				t.Errorf("SimpleBlock of track %d", block.TrackNumber())
+			}
+			frames++
+		}
+		for _, g := range c.BlockGroup {
+			if g.BlockDuration == nil || *g.BlockDuration != 40 {
+				t.Errorf("BlockDuration = %v, want 40", g.BlockDuration)
+			}
+			frames++
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []time.Duration{0, 1000}; len(clusters) != len(want) || clusters[0] != want[0] || clusters[1] != want[1] {
+		t.Errorf("cluster timestamps = %v, want %v", clusters, want)
+	}
+	if frames != 100 {
+		t.Errorf("got %d frames, want 100", frames)
+	}
+}
+
+func TestWriterMetadata(t *testing.T) {
+	b := matroskaFile(t, NewTrackEntry(1, TrackTypeAudio, AudioCodecMP3), testChapters(), testTags())
+	s := NewScanner(bytes.NewReader(b))
+	if got, want := s.Chapters(), testChapters(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Chapters() = %+v, want %+v", got, want)
+	}
+	if got, want := s.Tags(), testTags(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Tags() = %+v, want %+v", got, want)
+	}
+}